@@ -26,21 +26,45 @@ type Collector struct {
 	storage         storage.Storage
 	lastUploadedSet pxc.GTIDSet // last uploaded binary logs set
 	hosts           []string
-	user            string // user for connection to the MySQL
-	pass            string // password for connection to the MySQL
+	user            string        // user for connection to the MySQL
+	pass            string        // password for connection to the MySQL
+	hostTimeout     time.Duration // timeout for evaluating a single host
+	hostConcurrency int           // number of hosts evaluated at the same time
+	hostAttempts    int           // number of scans for healthy cluster members
+	hostInterval    time.Duration // base delay between scans for healthy cluster members
+	hostCache       *pxc.HostInfoCache
+	memberAddresses pxc.MemberAddresses      // addresses of members in hosts by MEMBER_HOST
+	hostRecovering  bool                     // RECOVERING members are healthy
+	gtidSetManifest bool                     // record gtid sets of uploaded binlogs in the manifest
+	manifest        *storage.GTIDSetManifest // read on the first upload
+	manifestPending int                      // binlogs added to the manifest since the last write
+	manifestWritten time.Time                // time of the last manifest write
 }
 
 type Config struct {
 	Hosts              []string    `env:"HOSTS" yaml:"hosts" validate:"required"` // Only Primary cluster nodes are expected
 	User               string      `env:"USER" yaml:"user" validate:"required"`
 	Pass               string      `env:"PASS" yaml:"pass" validate:"required"`
-	StorageType        string      `env:"STORAGE_TYPE" yaml:"storage_type" validate:"required,oneof=s3 azure"`
+	StorageType        string      `env:"STORAGE_TYPE" yaml:"storage_type" validate:"required,oneof=s3 azure filesystem"`
 	BackupStorageS3    BackupS3    `yaml:"s3" validate:"-"`    // Manually validated based on the StorageType
 	BackupStorageAzure BackupAzure `yaml:"azure" validate:"-"` // Manually validated based on the StorageType
 	BufferSize         int64       `env:"BUFFER_SIZE" yaml:"buffer_size"`
 	CollectSpanSec     float64     `env:"COLLECT_SPAN_SEC" yaml:"collect_span_sec" validate:"required"`
 	VerifyTLS          bool        `env:"VERIFY_TLS" yaml:"verify_tls" validate:"required"`
 	TimeoutSeconds     float64     `env:"TIMEOUT_SECONDS" yaml:"timeout_seconds" validate:"required"`
+	HostTimeoutSeconds float64     `env:"HOST_TIMEOUT_SECONDS" yaml:"host_timeout_seconds"` // Timeout for evaluating a single host, 0 means no timeout
+	HostConcurrency    int         `env:"HOST_CONCURRENCY" yaml:"host_concurrency"`         // Number of hosts evaluated at the same time
+	HostAttempts       int         `env:"HOST_ATTEMPTS" yaml:"host_attempts"`               // Number of scans for healthy cluster members
+	HostIntervalSec    float64     `env:"HOST_INTERVAL_SEC" yaml:"host_interval_sec"`       // Base delay between the scans, it's jittered and doubled after each scan
+	HostCacheTTLSec    float64     `env:"HOST_CACHE_TTL_SEC" yaml:"host_cache_ttl_sec"`     // Time the host evaluation results are reused for, 0 disables the cache
+	GTIDSetManifest    bool        `env:"GTID_SET_MANIFEST" yaml:"gtid_set_manifest"`       // Keep gtid sets of all binlogs in one object, so the recovery doesn't read them one by one
+
+	BackupStorageFilesystem BackupFilesystem `yaml:"filesystem" validate:"-"` // Manually validated based on the StorageType
+
+	// MemberAddresses are "member=address" pairs matching MEMBER_HOST or MEMBER_HOST:MEMBER_PORT
+	// of the group with HOSTS, for members which report hostnames not used in HOSTS
+	MemberAddresses []string `env:"MEMBER_ADDRESSES" yaml:"member_addresses"`
+	HostRecovering  bool     `env:"HOST_RECOVERING" yaml:"host_recovering"` // Treat RECOVERING members as healthy
 }
 
 type BackupS3 struct {
@@ -56,15 +80,29 @@ type BackupAzure struct {
 	ContainerPath string `env:"AZURE_CONTAINER_PATH" yaml:"container_path" validate:"required"`
 	StorageClass  string `env:"AZURE_STORAGE_CLASS" yaml:"storage_class"`
 	AccountName   string `env:"AZURE_STORAGE_ACCOUNT" yaml:"storage_account" validate:"required"`
-	AccountKey    string `env:"AZURE_ACCESS_KEY" yaml:"access_key" validate:"required"`
+	AccountKey    string `env:"AZURE_ACCESS_KEY" yaml:"access_key" validate:"required_without=SASToken"`
+	SASToken      string `env:"AZURE_SAS_TOKEN" yaml:"sas_token" validate:"required_without=AccountKey"`
+}
+
+type BackupFilesystem struct {
+	Path   string `env:"FILESYSTEM_PATH" yaml:"path" validate:"required"` // directory of the objects, e.g. a mounted volume
+	Prefix string `env:"FILESYSTEM_PREFIX" yaml:"prefix"`
 }
 
 const (
 	lastSetFilePrefix string = "last-binlog-set-"   // filename prefix for object where the last binlog set will stored
 	gtidPostfix       string = "-gtid-set"          // filename postfix for files with GTID set
+	timestampPostfix  string = "-last-timestamp"    // filename postfix for files with the last record timestamp
+	versionIDPostfix  string = "-version-id"        // filename postfix for files with the binlog version id in versioned buckets
 	timelinePath      string = "/tmp/pitr-timeline" // path to file with timeline
 )
 
+const (
+	manifestBatch         = 16               // binlogs added to the manifest before it is written
+	manifestFlushInterval = time.Minute      // max time between manifest writes while binlogs are added
+	manifestFlushTimeout  = 30 * time.Second // timeout for writing the manifest after the collection
+)
+
 func New(ctx context.Context, c Config) (*Collector, error) {
 	var s storage.Storage
 	var err error
@@ -85,19 +123,40 @@ func New(ctx context.Context, c Config) (*Collector, error) {
 		if prefix != "" {
 			prefix += "/"
 		}
-		s, err = storage.NewAzure(c.BackupStorageAzure.AccountName, c.BackupStorageAzure.AccountKey, c.BackupStorageAzure.Endpoint, container, prefix)
+		s, err = storage.NewAzure(ctx, c.BackupStorageAzure.AccountName, c.BackupStorageAzure.AccountKey, c.BackupStorageAzure.SASToken, c.BackupStorageAzure.Endpoint, container, prefix)
 		if err != nil {
 			return nil, errors.Wrap(err, "new azure storage")
 		}
+	case "filesystem":
+		s, err = storage.NewFilesystem(c.BackupStorageFilesystem.Path, c.BackupStorageFilesystem.Prefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "new filesystem storage")
+		}
 	default:
 		return nil, errors.New("unknown STORAGE_TYPE")
 	}
+	memberAddresses, err := pxc.ParseMemberAddresses(c.MemberAddresses)
+	if err != nil {
+		return nil, errors.Wrap(err, "MEMBER_ADDRESSES")
+	}
+	if c.HostIntervalSec < 0 {
+		return nil, errors.Errorf("HOST_INTERVAL_SEC %v is negative", c.HostIntervalSec)
+	}
 
 	return &Collector{
 		storage: s,
 		hosts:   c.Hosts,
 		user:    c.User,
 		pass:    c.Pass,
+
+		hostTimeout:     time.Duration(c.HostTimeoutSeconds * float64(time.Second)),
+		hostConcurrency: c.HostConcurrency,
+		hostAttempts:    c.HostAttempts,
+		hostInterval:    time.Duration(c.HostIntervalSec * float64(time.Second)),
+		hostCache:       pxc.NewHostInfoCache(time.Duration(c.HostCacheTTLSec * float64(time.Second))),
+		memberAddresses: memberAddresses,
+		hostRecovering:  c.HostRecovering,
+		gtidSetManifest: c.GTIDSetManifest,
 	}, nil
 }
 
@@ -106,6 +165,10 @@ func (c *Config) SetDefaults() {
 	c.CollectSpanSec = 60
 	c.VerifyTLS = true
 	c.TimeoutSeconds = 60
+	c.HostTimeoutSeconds = 10
+	c.HostConcurrency = pxc.DefaultHostConcurrency
+	c.HostAttempts = 3
+	c.HostIntervalSec = 2
 }
 
 func (c *Collector) Run(ctx context.Context) error {
@@ -120,6 +183,10 @@ func (c *Collector) Run(ctx context.Context) error {
 	c.lastUploadedSet = pxc.NewGTIDSet("")
 
 	err = c.CollectBinLogs(ctx)
+	// write the binlogs uploaded before an error too, the span timeout may have cancelled ctx
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), manifestFlushTimeout)
+	defer cancel()
+	c.flushManifest(flushCtx)
 	if err != nil {
 		return errors.Wrap(err, "collect binlog files")
 	}
@@ -144,12 +211,16 @@ func (c *Collector) lastGTIDSet(ctx context.Context, suffix string) (pxc.GTIDSet
 }
 
 func (c *Collector) newDB(ctx context.Context) error {
-	healthyHosts, err := pxc.FilterHealthyClusterMembers(ctx, c.hosts, c.user, c.pass)
+	checker := pxc.NewHostChecker(c.user, c.pass, c.hostTimeout, c.hostConcurrency).WithRetry(c.hostAttempts, c.hostInterval).WithCache(c.hostCache).
+		WithMemberAddresses(c.memberAddresses).WithRecovering(c.hostRecovering)
+	defer checker.Close()
+
+	healthyHosts, err := checker.FilterHealthyClusterMembers(ctx, c.hosts)
 	if err != nil {
 		return errors.Wrap(err, "filter healthy cluster members")
 	}
 
-	host, err := pxc.GetPXCOldestBinlogHost(ctx, healthyHosts, c.user, c.pass)
+	host, err := checker.OldestBinlogHost(ctx, healthyHosts)
 	if err != nil {
 		return errors.Wrap(err, "get host")
 	}
@@ -158,6 +229,7 @@ func (c *Collector) newDB(ctx context.Context) error {
 
 	c.db, err = pxc.NewPXC(host, c.user, c.pass)
 	if err != nil {
+		c.hostCache.Invalidate(host)
 		return errors.Wrapf(err, "new manager with host %s", host)
 	}
 
@@ -395,14 +467,16 @@ func (c *Collector) CollectBinLogs(ctx context.Context) error {
 	}
 
 	for _, binlog := range list {
-		err = c.manageBinlog(ctx, binlog)
+		// the last timestamp is fetched before the upload, so a failed query
+		// doesn't leave the binlog without its last-timestamp object
+		lastTs, err := c.db.GetBinLogLastTimestamp(ctx, binlog.Name)
 		if err != nil {
-			return errors.Wrap(err, "manage binlog")
+			return errors.Wrapf(err, "get last timestamp for %s", binlog.Name)
 		}
 
-		lastTs, err := c.db.GetBinLogLastTimestamp(ctx, binlog.Name)
+		err = c.manageBinlog(ctx, binlog, lastTs)
 		if err != nil {
-			return errors.Wrap(err, "get last timestamp")
+			return errors.Wrap(err, "manage binlog")
 		}
 
 		if err := updateTimelineFile(lastTs); err != nil {
@@ -423,7 +497,7 @@ func mergeErrors(a, b error) error {
 	return b
 }
 
-func (c *Collector) manageBinlog(ctx context.Context, binlog pxc.Binlog) (err error) {
+func (c *Collector) manageBinlog(ctx context.Context, binlog pxc.Binlog, lastTs string) (err error) {
 	binlogTmstmp, err := c.db.GetBinLogFirstTimestamp(ctx, binlog.Name)
 	if err != nil {
 		return errors.Wrapf(err, "get first timestamp for %s", binlog.Name)
@@ -496,10 +570,31 @@ func (c *Collector) manageBinlog(ctx context.Context, binlog pxc.Binlog) (err er
 		return errors.Wrap(err, "wait mysqlbinlog command error:"+errBuf.String())
 	}
 
+	// the version is recorded, so the recovery uses this upload
+	// even if the binlog is uploaded again to a versioned bucket
+	info, err := c.storage.StatObject(ctx, binlogName)
+	if err != nil {
+		return errors.Wrapf(err, "stat %s object", binlogName)
+	}
+	if info.VersionID != "" {
+		err = c.storage.PutObject(ctx, binlogName+versionIDPostfix, strings.NewReader(info.VersionID), int64(len(info.VersionID)))
+		if err != nil {
+			return errors.Wrap(err, "put version-id object")
+		}
+	}
+
 	err = c.storage.PutObject(ctx, binlogName+gtidPostfix, &setBuffer, int64(setBuffer.Len()))
 	if err != nil {
 		return errors.Wrap(err, "put gtid-set object")
 	}
+	if c.gtidSetManifest {
+		c.addToManifest(ctx, binlogName, binlog.GTIDSet.Raw())
+	}
+
+	err = c.storage.PutObject(ctx, binlogName+timestampPostfix, strings.NewReader(lastTs), int64(len(lastTs)))
+	if err != nil {
+		return errors.Wrap(err, "put last-timestamp object")
+	}
 	for _, gtidSet := range binlog.GTIDSet.List() {
 		// no error handling because WriteString() always return nil error
 		// nolint:errcheck
@@ -521,6 +616,51 @@ func (c *Collector) manageBinlog(ctx context.Context, binlog pxc.Binlog) (err er
 	return nil
 }
 
+// addToManifest records the gtid set of the uploaded binlog and the server uuid of the host in the manifest.
+// The manifest is written every manifestBatch binlogs or manifestFlushInterval, and after the collection.
+// Errors are only logged: the gtid-set object is uploaded anyway, the recovery falls back to it
+// for binlogs missing in the manifest.
+func (c *Collector) addToManifest(ctx context.Context, binlogName, gtidSet string) {
+	if c.manifest == nil {
+		m, err := storage.ReadGTIDSetManifest(ctx, c.storage)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			m = storage.NewGTIDSetManifest()
+		} else if err != nil {
+			// don't overwrite a manifest that couldn't be read, try again with the next binlog
+			log.Printf("WARNING: skip gtid set manifest for %s: read manifest: %v\n", binlogName, err)
+			return
+		}
+		c.manifest = m
+		c.manifestWritten = time.Now()
+	}
+	uuid, err := c.db.GetServerUUID(ctx)
+	if err != nil {
+		log.Printf("WARNING: get server uuid for gtid set manifest: %v\n", err)
+	} else {
+		c.manifest.AddServerUUID(uuid)
+	}
+	c.manifest.Binlogs[binlogName] = gtidSet
+	c.manifestPending++
+	if c.manifestPending >= manifestBatch || time.Since(c.manifestWritten) >= manifestFlushInterval {
+		c.flushManifest(ctx)
+	}
+}
+
+// flushManifest writes the binlogs added to the manifest since the last write.
+// The binlogs stay pending if the write fails.
+func (c *Collector) flushManifest(ctx context.Context) {
+	if c.manifest == nil || c.manifestPending == 0 {
+		return
+	}
+	err := storage.WriteGTIDSetManifest(ctx, c.storage, c.manifest)
+	if err != nil {
+		log.Printf("WARNING: write gtid set manifest with %d pending binlogs: %v\n", c.manifestPending, err)
+		return
+	}
+	c.manifestPending = 0
+	c.manifestWritten = time.Now()
+}
+
 func readBinlog(file *os.File, pipe *io.PipeWriter, errBuf *bytes.Buffer, binlogName string) {
 	b := make([]byte, 10485760) // alloc buffer for 10mb
 
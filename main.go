@@ -10,9 +10,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"text/tabwriter"
 	"time"
+	_ "time/tzdata" // PITR_DATE may name an IANA zone, the image may have no zoneinfo
 
 	"mysql-pitr-helper/collector"
+	"mysql-pitr-helper/pxc"
 	"mysql-pitr-helper/recoverer"
 
 	"github.com/caarlos0/env"
@@ -33,9 +36,13 @@ func main() {
 	case "collect":
 		runCollector(ctx, cfgPath)
 	case "recover":
-		runRecoverer(ctx)
+		runRecoverer(ctx, cfgPath)
+	case "list-binlogs":
+		runListBinlogs(ctx, cfgPath == "--details")
+	case "verify-backups":
+		runVerifyBackups(ctx, cfgPath)
 	default:
-		fmt.Fprintf(os.Stderr, "ERROR: unknown command \"%s\".\nCommands:\n  collect - collect binlogs\n  recover - recover from binlogs\n", command)
+		fmt.Fprintf(os.Stderr, "ERROR: unknown command \"%s\".\nCommands:\n  collect - collect binlogs\n  recover [config] - recover from binlogs\n  list-binlogs [--details] - list binlogs on the server\n  verify-backups [config] - check that stored binlogs cover the cluster\n", command)
 		os.Exit(1)
 	}
 }
@@ -69,8 +76,8 @@ func runCollector(ctx context.Context, cfgPath string) {
 	}
 }
 
-func runRecoverer(ctx context.Context) {
-	config, err := getRecovererConfig()
+func runRecoverer(ctx context.Context, cfgPath string) {
+	config, err := getRecovererConfig(cfgPath)
 	if err != nil {
 		log.Fatalln("ERROR: get recoverer config:", err)
 	}
@@ -85,6 +92,100 @@ func runRecoverer(ctx context.Context) {
 	}
 }
 
+func runVerifyBackups(ctx context.Context, cfgPath string) {
+	config, err := getRecovererConfig(cfgPath)
+	if err != nil {
+		log.Fatalln("ERROR: get recoverer config:", err)
+	}
+	c, err := recoverer.New(ctx, config)
+	if err != nil {
+		log.Fatalln("ERROR: new recoverer controller:", err)
+	}
+	log.Println("run backups verification")
+	err = c.VerifyBackups(ctx)
+	if err != nil {
+		log.Fatalln("ERROR: verify backups:", err)
+	}
+}
+
+type listBinlogsConfig struct {
+	Host    string `env:"HOST,required"`
+	User    string `env:"USER,required"`
+	Pass    string `env:"PASS,required"`
+	KeepUDF bool   `env:"PITR_KEEP_UDF"`
+}
+
+// runListBinlogs prints binary logs which are currently on the server.
+// With details it also prints GTID sets and timestamps, that requires binlog_utils_udf functions.
+func runListBinlogs(ctx context.Context, details bool) {
+	cfg := listBinlogsConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Fatalln("ERROR: get config:", err)
+	}
+	db, err := pxc.NewPXC(cfg.Host, cfg.User, cfg.Pass)
+	if err != nil {
+		log.Fatalf("ERROR: new manager with host %s: %v", cfg.Host, err)
+	}
+	defer db.Close()
+
+	list, err := db.ListBinLogs(ctx)
+	if err != nil {
+		log.Fatalln("ERROR: list binlogs:", err)
+	}
+	if err := printBinlogs(ctx, db, list, details, cfg.KeepUDF); err != nil {
+		log.Fatalln("ERROR: write binlog list:", err)
+	}
+}
+
+// printBinlogs prints the binlogs as a table. The functions created for the details
+// are dropped afterwards as the recoverer does, unless keepUDF is set.
+func printBinlogs(ctx context.Context, db *pxc.PXC, list []pxc.Binlog, details, keepUDF bool) error {
+	if details && !keepUDF {
+		defer func() {
+			if err := db.DropCollectorFunctions(ctx); err != nil {
+				log.Println("ERROR: drop collector functions:", err)
+			}
+		}()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if details {
+		fmt.Fprintln(w, "NAME\tSIZE\tENCRYPTED\tFIRST TIMESTAMP\tLAST TIMESTAMP\tGTID SET")
+	} else {
+		fmt.Fprintln(w, "NAME\tSIZE\tENCRYPTED")
+	}
+	udfMissing := false
+	for _, b := range list {
+		if !details {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", b.Name, b.Size, b.Encrypted)
+			continue
+		}
+		if udfMissing {
+			fmt.Fprintf(w, "%s\t%d\t%s\t\t\t\n", b.Name, b.Size, b.Encrypted)
+			continue
+		}
+		set, err := db.GetGTIDSet(ctx, b.Name)
+		if errors.Is(err, pxc.ErrUDFMissing) {
+			log.Println("WARNING: printing binlogs without details:", err)
+			udfMissing = true
+			fmt.Fprintf(w, "%s\t%d\t%s\t\t\t\n", b.Name, b.Size, b.Encrypted)
+			continue
+		}
+		if err != nil {
+			log.Printf("ERROR: get gtid set for %s: %v", b.Name, err)
+		}
+		first, err := db.GetBinLogFirstTimestamp(ctx, b.Name)
+		if err != nil {
+			log.Printf("ERROR: get first timestamp for %s: %v", b.Name, err)
+		}
+		last, err := db.GetBinLogLastTimestamp(ctx, b.Name)
+		if err != nil {
+			log.Printf("ERROR: get last timestamp for %s: %v", b.Name, err)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", b.Name, b.Size, b.Encrypted, first, last, set)
+	}
+	return w.Flush()
+}
+
 func getCollectorConfig(cfgPath string) (collector.Config, error) {
 	cfg := collector.Config{}
 	cfg.SetDefaults()
@@ -100,6 +201,9 @@ func getCollectorConfig(cfgPath string) (collector.Config, error) {
 		if err := env.Parse(&cfg.BackupStorageAzure); err != nil {
 			return cfg, err
 		}
+		if err := env.Parse(&cfg.BackupStorageFilesystem); err != nil {
+			return cfg, err
+		}
 	} else {
 		// Read from yaml
 		cfgFile, err := os.ReadFile(cfgPath)
@@ -125,27 +229,20 @@ func getCollectorConfig(cfgPath string) (collector.Config, error) {
 			return cfg, err
 		}
 	}
-
-	return cfg, nil
-}
-
-func getRecovererConfig() (recoverer.Config, error) {
-	cfg := recoverer.Config{}
-	if err := env.Parse(&cfg); err != nil {
-		return cfg, err
-	}
-	switch cfg.StorageType {
-	case "s3":
-		if err := env.Parse(&cfg.BinlogStorageS3); err != nil {
+	if cfg.StorageType == "filesystem" {
+		if err := v.Struct(cfg.BackupStorageFilesystem); err != nil {
 			return cfg, err
 		}
-	case "azure":
-		if err := env.Parse(&cfg.BinlogStorageAzure); err != nil {
-			return cfg, err
-		}
-	default:
-		return cfg, errors.New("unknown STORAGE_TYPE")
 	}
 
 	return cfg, nil
 }
+
+// getRecovererConfig reads the config from envs or from the YAML or JSON file
+// with envs taking precedence over it
+func getRecovererConfig(cfgPath string) (recoverer.Config, error) {
+	if len(cfgPath) != 0 {
+		return recoverer.LoadConfigFile(cfgPath)
+	}
+	return recoverer.LoadEnv()
+}
@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	BinlogsApplied     = NewCounter("pitr_binlogs_applied_total", "Number of binlogs applied during recovery.", "")
+	BytesDownloaded    = NewCounter("pitr_bytes_downloaded_total", "Number of bytes downloaded from the binlog storage.", "")
+	CurrentBinlogIndex = NewGauge("pitr_current_binlog_index", "Index of the binlog which is being applied.", "")
+	RecoveryDuration   = NewGauge("pitr_recovery_duration_seconds", "Duration of the recovery in seconds.", "")
+	Recoveries         = NewCounterVec("pitr_recoveries_total", "Number of finished recoveries by result.", "result")
+)
+
+// metric is a single time series in the Prometheus text format
+type metric interface {
+	write(w io.Writer) error
+	desc() (name, help, typ string)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name   string
+	help   string
+	labels string
+	value  atomic.Int64
+}
+
+// NewCounter creates and registers a counter. labels are in the `key="value"` format.
+func NewCounter(name, help, labels string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels}
+	register(c)
+	return c
+}
+
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) desc() (string, string, string) {
+	return c.name, c.help, "counter"
+}
+
+func (c *Counter) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s %d\n", series(c.name, c.labels), c.value.Load())
+	return err
+}
+
+// CounterVec is a set of counters partitioned by the value of a single label
+type CounterVec struct {
+	name     string
+	help     string
+	label    string
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewCounterVec creates and registers a counter vector. Its series are created on first use.
+func NewCounterVec(name, help, label string) *CounterVec {
+	v := &CounterVec{name: name, help: help, label: label, counters: make(map[string]*Counter)}
+	register(v)
+	return v
+}
+
+// With returns the counter for the given label value
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[value]
+	if !ok {
+		c = &Counter{name: v.name, help: v.help, labels: v.label + "=" + strconv.Quote(value)}
+		v.counters[value] = c
+	}
+	return c
+}
+
+func (v *CounterVec) desc() (string, string, string) {
+	return v.name, v.help, "counter"
+}
+
+func (v *CounterVec) write(w io.Writer) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	values := make([]string, 0, len(v.counters))
+	for value := range v.counters {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		if err := v.counters[value].write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	name   string
+	help   string
+	labels string
+	bits   atomic.Uint64
+}
+
+// NewGauge creates and registers a gauge. labels are in the `key="value"` format.
+func NewGauge(name, help, labels string) *Gauge {
+	g := &Gauge{name: name, help: help, labels: labels}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) desc() (string, string, string) {
+	return g.name, g.help, "gauge"
+}
+
+func (g *Gauge) write(w io.Writer) error {
+	v := math.Float64frombits(g.bits.Load())
+	_, err := fmt.Fprintf(w, "%s %s\n", series(g.name, g.labels), strconv.FormatFloat(v, 'g', -1, 64))
+	return err
+}
+
+func series(name, labels string) string {
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+// Write writes all registered metrics in the Prometheus text exposition format
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	described := make(map[string]bool)
+	for _, m := range registry {
+		name, help, typ := m.desc()
+		if !described[name] {
+			described[name] = true
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ); err != nil {
+				return err
+			}
+		}
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serve exposes metrics on addr at /metrics until ctx is done
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := Write(w); err != nil {
+			log.Println("ERROR: write metrics:", err)
+		}
+	})
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		// nolint:errcheck
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return errors.Wrapf(err, "listen on %s", addr)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterVecWrite(t *testing.T) {
+	v := &CounterVec{name: "test_total", help: "Test.", label: "result", counters: make(map[string]*Counter)}
+	v.With("success").Inc()
+	v.With("failure").Add(2)
+	v.With("success").Inc()
+
+	var b strings.Builder
+	if err := v.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test_total{result=\"failure\"} 2\ntest_total{result=\"success\"} 2\n"
+	if b.String() != expected {
+		t.Errorf("expect %q, got %q", expected, b.String())
+	}
+}
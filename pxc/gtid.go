@@ -0,0 +1,360 @@
+package pxc
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// gtidInterval is an inclusive range of transaction numbers
+type gtidInterval struct {
+	start int64
+	end   int64
+}
+
+// gtidIntervals is a parsed GTID set: merged and sorted intervals keyed by
+// source id. Source id is the server UUID optionally followed by ":tag".
+type gtidIntervals map[string][]gtidInterval
+
+// NormalizeGTIDSet returns the canonical form of the GTID set: whitespace trimmed,
+// UUIDs lowercased, intervals merged and sorted, sources sorted.
+// Equivalent sets have the same canonical form.
+func NormalizeGTIDSet(gtidSet string) (string, error) {
+	g, err := parseGTIDIntervals(gtidSet)
+	if err != nil {
+		return "", &GTIDFormatError{Set: gtidSet, Err: err}
+	}
+	return g.String(), nil
+}
+
+// ErrBadGTIDFormat is matched by errors of malformed GTID sets
+var ErrBadGTIDFormat = errors.New("bad GTID format")
+
+// GTIDFormatError is returned by ParseGTIDSet for malformed GTID sets
+type GTIDFormatError struct {
+	Set string
+	Err error
+}
+
+func (e *GTIDFormatError) Error() string {
+	return "parse GTID set '" + e.Set + "': " + e.Err.Error()
+}
+
+func (e *GTIDFormatError) Unwrap() error {
+	return e.Err
+}
+
+func (e *GTIDFormatError) Is(target error) bool {
+	return target == ErrBadGTIDFormat
+}
+
+// ParseGTIDSet parses and validates GTID set in the "uuid:1-5:7,uuid2:1-3" format
+func ParseGTIDSet(gtidSet string) (GTIDSet, error) {
+	if _, err := parseGTIDIntervals(gtidSet); err != nil {
+		return GTIDSet{}, &GTIDFormatError{Set: gtidSet, Err: err}
+	}
+	return NewGTIDSet(gtidSet), nil
+}
+
+func parseGTIDIntervals(gtidSet string) (gtidIntervals, error) {
+	result := make(gtidIntervals)
+	for _, part := range strings.Split(gtidSet, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 {
+			return nil, errors.Errorf("bad gtid format '%s'", part)
+		}
+		sourceID := strings.ToLower(strings.TrimSpace(fields[0]))
+		if sourceID == "" {
+			return nil, errors.Errorf("empty source id in '%s'", part)
+		}
+		tag := ""
+		for _, field := range fields[1:] {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				return nil, errors.Errorf("empty interval in '%s'", part)
+			}
+			if !isDigit(field[0]) {
+				// MySQL 8.3+ tagged GTID: uuid:tag:1-5
+				tag = strings.ToLower(field)
+				continue
+			}
+			in, err := parseGTIDInterval(field)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse '%s'", part)
+			}
+			key := sourceID
+			if tag != "" {
+				key += ":" + tag
+			}
+			result[key] = append(result[key], in)
+		}
+	}
+	for k, v := range result {
+		result[k] = mergeGTIDIntervals(v)
+	}
+	return result, nil
+}
+
+func parseGTIDInterval(s string) (gtidInterval, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return gtidInterval{}, errors.Wrapf(err, "bad interval start '%s'", s)
+	}
+	end := start
+	if isRange {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil {
+			return gtidInterval{}, errors.Wrapf(err, "bad interval end '%s'", s)
+		}
+	}
+	if start < 1 || end < start {
+		return gtidInterval{}, errors.Errorf("bad interval '%s'", s)
+	}
+	return gtidInterval{start: start, end: end}, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// mergeGTIDIntervals sorts intervals and merges overlapping and adjacent ones
+func mergeGTIDIntervals(list []gtidInterval) []gtidInterval {
+	if len(list) == 0 {
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].start < list[j].start })
+	merged := []gtidInterval{list[0]}
+	for _, in := range list[1:] {
+		last := &merged[len(merged)-1]
+		if in.start <= last.end+1 {
+			if in.end > last.end {
+				last.end = in.end
+			}
+			continue
+		}
+		merged = append(merged, in)
+	}
+	return merged
+}
+
+// subtractGTIDIntervals returns intervals of a which are not in b. Both lists must be merged.
+func subtractGTIDIntervals(a, b []gtidInterval) []gtidInterval {
+	var result []gtidInterval
+	for _, in := range a {
+		cur := in
+		empty := false
+		for _, sub := range b {
+			if sub.end < cur.start || sub.start > cur.end {
+				continue
+			}
+			if sub.start > cur.start {
+				result = append(result, gtidInterval{start: cur.start, end: sub.start - 1})
+			}
+			if sub.end >= cur.end {
+				empty = true
+				break
+			}
+			cur.start = sub.end + 1
+		}
+		if !empty {
+			result = append(result, cur)
+		}
+	}
+	return result
+}
+
+func (g gtidIntervals) String() string {
+	keys := make([]string, 0, len(g))
+	for k, v := range g {
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		var sb strings.Builder
+		sb.WriteString(k)
+		for _, in := range g[k] {
+			sb.WriteString(":")
+			sb.WriteString(strconv.FormatInt(in.start, 10))
+			if in.end != in.start {
+				sb.WriteString("-")
+				sb.WriteString(strconv.FormatInt(in.end, 10))
+			}
+		}
+		parts = append(parts, sb.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// intervals returns parsed set, malformed sets are treated as empty
+func (s *GTIDSet) intervals() gtidIntervals {
+	g, err := parseGTIDIntervals(s.gtidSet)
+	if err != nil {
+		return gtidIntervals{}
+	}
+	return g
+}
+
+// parse returns parsed set, GTIDFormatError is returned for malformed sets
+func (s *GTIDSet) parse() (gtidIntervals, error) {
+	g, err := parseGTIDIntervals(s.gtidSet)
+	if err != nil {
+		return nil, &GTIDFormatError{Set: s.gtidSet, Err: err}
+	}
+	return g, nil
+}
+
+// Contains reports whether every transaction of other is in s.
+// GTIDFormatError is returned if either set is malformed, a malformed set
+// isn't empty and contains unknown transactions.
+func (s *GTIDSet) Contains(other GTIDSet) (bool, error) {
+	a, err := s.parse()
+	if err != nil {
+		return false, err
+	}
+	b, err := other.parse()
+	if err != nil {
+		return false, err
+	}
+	for k, v := range b {
+		if len(subtractGTIDIntervals(v, a[k])) != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Subtract returns transactions of s which are not in other
+func (s *GTIDSet) Subtract(other GTIDSet) GTIDSet {
+	a := s.intervals()
+	b := other.intervals()
+	result := make(gtidIntervals)
+	for k, v := range a {
+		result[k] = subtractGTIDIntervals(v, b[k])
+	}
+	return NewGTIDSet(result.String())
+}
+
+// Union returns transactions which are either in s or in other
+func (s *GTIDSet) Union(other GTIDSet) GTIDSet {
+	result := s.intervals()
+	for k, v := range other.intervals() {
+		result[k] = mergeGTIDIntervals(append(result[k], v...))
+	}
+	return NewGTIDSet(result.String())
+}
+
+// Equal reports whether s and other contain the same transactions
+func (s *GTIDSet) Equal(other GTIDSet) bool {
+	return s.intervals().String() == other.intervals().String()
+}
+
+// From returns transactions of s starting from the first transaction
+// of the same source in other. Sources which aren't in other are omitted.
+func (s *GTIDSet) From(other GTIDSet) GTIDSet {
+	b := other.intervals()
+	result := make(gtidIntervals)
+	for k, v := range s.intervals() {
+		if len(b[k]) == 0 {
+			continue
+		}
+		first := b[k][0].start
+		if first == 1 {
+			result[k] = v
+			continue
+		}
+		result[k] = subtractGTIDIntervals(v, []gtidInterval{{start: 1, end: first - 1}})
+	}
+	return NewGTIDSet(result.String())
+}
+
+// Gaps returns transactions missing in s between the first transaction
+// of each source and the last one of the same source
+func (s *GTIDSet) Gaps() GTIDSet {
+	result := make(gtidIntervals)
+	for k, v := range s.intervals() {
+		for i := 1; i < len(v); i++ {
+			result[k] = append(result[k], gtidInterval{start: v[i-1].end + 1, end: v[i].start - 1})
+		}
+	}
+	return NewGTIDSet(result.String())
+}
+
+// Leading returns transactions missing in s before the first transaction of each source
+func (s *GTIDSet) Leading() GTIDSet {
+	result := make(gtidIntervals)
+	for k, v := range s.intervals() {
+		if len(v) > 0 && v[0].start > 1 {
+			result[k] = []gtidInterval{{start: 1, end: v[0].start - 1}}
+		}
+	}
+	return NewGTIDSet(result.String())
+}
+
+// Source returns transactions of s originating from the server with the uuid,
+// tagged transactions of the server are included
+func (s *GTIDSet) Source(uuid string) GTIDSet {
+	uuid = strings.ToLower(strings.TrimSpace(uuid))
+	result := make(gtidIntervals)
+	for k, v := range s.intervals() {
+		if k == uuid || strings.HasPrefix(k, uuid+":") {
+			result[k] = v
+		}
+	}
+	return NewGTIDSet(result.String())
+}
+
+// Last returns the last transaction number of the source, 0 if there are no transactions of it
+func (s *GTIDSet) Last(sourceID string) int64 {
+	v := s.intervals()[strings.ToLower(strings.TrimSpace(sourceID))]
+	if len(v) == 0 {
+		return 0
+	}
+	return v[len(v)-1].end
+}
+
+// UUIDs returns sorted server uuids of the sources of s, tags are omitted
+func (s *GTIDSet) UUIDs() []string {
+	var uuids []string
+	for k := range s.intervals() {
+		uuid, _, _ := strings.Cut(k, ":")
+		if !slices.Contains(uuids, uuid) {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// Primary returns the source with the most transactions, the first
+// of them by name if several sources have the same number of transactions
+func (s *GTIDSet) Primary() string {
+	g := s.intervals()
+	keys := make([]string, 0, len(g))
+	for k := range g {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	primary := ""
+	var most int64
+	for _, k := range keys {
+		var count int64
+		for _, in := range g[k] {
+			count += in.end - in.start + 1
+		}
+		if count > most {
+			primary, most = k, count
+		}
+	}
+	return primary
+}
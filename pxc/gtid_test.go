@@ -0,0 +1,455 @@
+package pxc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	uuidA = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	uuidB = "9f0ab5ee-c7b6-11ee-a1f0-0242ac120002"
+)
+
+func TestParseGTIDSet(t *testing.T) {
+	type testCase struct {
+		set       string
+		expectErr bool
+	}
+	cases := []testCase{
+		{set: ""},
+		{set: uuidA + ":1-5"},
+		{set: uuidA + ":1-5:7:9-10," + uuidB + ":3"},
+		{set: uuidA + ":tag:1-5"},
+		{set: uuidA, expectErr: true},
+		{set: uuidA + ":5-", expectErr: true},
+		{set: uuidA + ":10-2", expectErr: true},
+		{set: uuidA + ":0", expectErr: true},
+		{set: ":1-5", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.set, func(t *testing.T) {
+			_, err := ParseGTIDSet(c.set)
+			if c.expectErr && !errors.Is(err, ErrBadGTIDFormat) {
+				t.Errorf("%s: expected ErrBadGTIDFormat, got %v", c.set, err)
+			}
+			var formatErr *GTIDFormatError
+			if c.expectErr && (!errors.As(err, &formatErr) || formatErr.Set != c.set) {
+				t.Errorf("%s: expected GTIDFormatError, got %v", c.set, err)
+			}
+			if !c.expectErr && err != nil {
+				t.Errorf("%s: %s", c.set, err.Error())
+			}
+		})
+	}
+}
+
+func TestGTIDSetUnion(t *testing.T) {
+	type testCase struct {
+		name     string
+		a, b     string
+		expected string
+	}
+	cases := []testCase{
+		{
+			name:     "empty",
+			a:        "",
+			b:        uuidA + ":1-5",
+			expected: uuidA + ":1-5",
+		},
+		{
+			name:     "overlapping",
+			a:        uuidA + ":1-5",
+			b:        uuidA + ":3-10",
+			expected: uuidA + ":1-10",
+		},
+		{
+			name:     "adjacent",
+			a:        uuidA + ":1-5",
+			b:        uuidA + ":6-10",
+			expected: uuidA + ":1-10",
+		},
+		{
+			name:     "disjoint",
+			a:        uuidA + ":1-5",
+			b:        uuidA + ":8-10," + uuidB + ":1",
+			expected: uuidA + ":1-5:8-10," + uuidB + ":1",
+		},
+		{
+			name:     "unsorted input",
+			a:        uuidB + ":4:1-2",
+			b:        uuidA + ":7:3",
+			expected: uuidA + ":3:7," + uuidB + ":1-2:4",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewGTIDSet(c.a)
+			u := a.Union(NewGTIDSet(c.b))
+			if u.Raw() != c.expected {
+				t.Errorf("%s + %s: expect '%s', got '%s'", c.a, c.b, c.expected, u.Raw())
+			}
+		})
+	}
+}
+
+func TestGTIDSetSubtract(t *testing.T) {
+	type testCase struct {
+		name     string
+		a, b     string
+		expected string
+	}
+	cases := []testCase{
+		{
+			name:     "all",
+			a:        uuidA + ":1-5",
+			b:        uuidA + ":1-10",
+			expected: "",
+		},
+		{
+			name:     "middle",
+			a:        uuidA + ":1-10",
+			b:        uuidA + ":4-6",
+			expected: uuidA + ":1-3:7-10",
+		},
+		{
+			name:     "overlapping edges",
+			a:        uuidA + ":3-10",
+			b:        uuidA + ":1-4:9-20",
+			expected: uuidA + ":5-8",
+		},
+		{
+			name:     "other source",
+			a:        uuidA + ":1-5," + uuidB + ":1-3",
+			b:        uuidB + ":1-3",
+			expected: uuidA + ":1-5",
+		},
+		{
+			name:     "nothing",
+			a:        uuidA + ":1-5",
+			b:        "",
+			expected: uuidA + ":1-5",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewGTIDSet(c.a)
+			d := a.Subtract(NewGTIDSet(c.b))
+			if d.Raw() != c.expected {
+				t.Errorf("%s - %s: expect '%s', got '%s'", c.a, c.b, c.expected, d.Raw())
+			}
+		})
+	}
+}
+
+func TestGTIDSetContains(t *testing.T) {
+	type testCase struct {
+		name      string
+		a, b      string
+		expected  bool
+		expectErr bool
+	}
+	cases := []testCase{
+		{
+			name:     "empty",
+			a:        uuidA + ":1-5",
+			b:        "",
+			expected: true,
+		},
+		{
+			name:      "malformed",
+			a:         uuidA + ":1-5",
+			b:         uuidA + ":5-",
+			expectErr: true,
+		},
+		{
+			name:      "malformed container",
+			a:         uuidA,
+			b:         "",
+			expectErr: true,
+		},
+		{
+			name:     "subset of merged intervals",
+			a:        uuidA + ":1-5:6-10",
+			b:        uuidA + ":4-8",
+			expected: true,
+		},
+		{
+			name:     "gap",
+			a:        uuidA + ":1-5:7-10",
+			b:        uuidA + ":4-8",
+			expected: false,
+		},
+		{
+			name:     "other source",
+			a:        uuidA + ":1-5",
+			b:        uuidB + ":1",
+			expected: false,
+		},
+		{
+			name:     "case insensitive",
+			a:        "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5",
+			b:        uuidA + ":2",
+			expected: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewGTIDSet(c.a)
+			got, err := a.Contains(NewGTIDSet(c.b))
+			if c.expectErr {
+				if !errors.Is(err, ErrBadGTIDFormat) {
+					t.Errorf("%s contains %s: expect format error, got %v", c.a, c.b, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.expected {
+				t.Errorf("%s contains %s: expect %t, got %t", c.a, c.b, c.expected, got)
+			}
+		})
+	}
+}
+
+func TestGTIDSetFrom(t *testing.T) {
+	type testCase struct {
+		name     string
+		a, b     string
+		expected string
+	}
+	cases := []testCase{
+		{
+			name:     "cut before first",
+			a:        uuidA + ":1-20",
+			b:        uuidA + ":5-8:12",
+			expected: uuidA + ":5-20",
+		},
+		{
+			name:     "from the start",
+			a:        uuidA + ":1-20",
+			b:        uuidA + ":1-3",
+			expected: uuidA + ":1-20",
+		},
+		{
+			name:     "other source omitted",
+			a:        uuidA + ":1-5," + uuidB + ":1-10",
+			b:        uuidB + ":4",
+			expected: uuidB + ":4-10",
+		},
+		{
+			name:     "empty",
+			a:        uuidA + ":1-5",
+			b:        "",
+			expected: "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewGTIDSet(c.a)
+			f := a.From(NewGTIDSet(c.b))
+			if f.Raw() != c.expected {
+				t.Errorf("%s from %s: expect '%s', got '%s'", c.a, c.b, c.expected, f.Raw())
+			}
+		})
+	}
+}
+
+func TestNormalizeGTIDSet(t *testing.T) {
+	type testCase struct {
+		name string
+		a, b string
+	}
+	cases := []testCase{
+		{
+			name: "whitespace and newlines",
+			a:    uuidA + ":1-5,\n" + uuidB + ":3",
+			b:    " " + uuidA + ":1-5, " + uuidB + ":3 ",
+		},
+		{
+			name: "source order",
+			a:    uuidB + ":3," + uuidA + ":1-5",
+			b:    uuidA + ":1-5," + uuidB + ":3",
+		},
+		{
+			name: "interval order and merge",
+			a:    uuidA + ":7:1-3:4-5",
+			b:    uuidA + ":1-5:7",
+		},
+		{
+			name: "uuid case",
+			a:    "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5",
+			b:    uuidA + ":1-5",
+		},
+		{
+			name: "empty",
+			a:    " \n",
+			b:    "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			na, err := NormalizeGTIDSet(c.a)
+			if err != nil {
+				t.Fatalf("normalize '%s': %s", c.a, err.Error())
+			}
+			nb, err := NormalizeGTIDSet(c.b)
+			if err != nil {
+				t.Fatalf("normalize '%s': %s", c.b, err.Error())
+			}
+			if na != nb {
+				t.Errorf("expect '%s' and '%s' to be equal, got '%s' and '%s'", c.a, c.b, na, nb)
+			}
+		})
+	}
+
+	if _, err := NormalizeGTIDSet(uuidA + ":5-"); !errors.Is(err, ErrBadGTIDFormat) {
+		t.Errorf("expected ErrBadGTIDFormat, got %v", err)
+	}
+}
+
+func TestGTIDSetGaps(t *testing.T) {
+	type testCase struct {
+		name     string
+		set      string
+		expected string
+	}
+	cases := []testCase{
+		{
+			name:     "no gaps",
+			set:      uuidA + ":1-10," + uuidB + ":5-7",
+			expected: "",
+		},
+		{
+			name:     "gaps",
+			set:      uuidA + ":1-3:6:9-10," + uuidB + ":1:3",
+			expected: uuidA + ":4-5:7-8," + uuidB + ":2",
+		},
+		{
+			name:     "empty",
+			set:      "",
+			expected: "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewGTIDSet(c.set)
+			g := s.Gaps()
+			if g.Raw() != c.expected {
+				t.Errorf("%s: expect '%s', got '%s'", c.set, c.expected, g.Raw())
+			}
+		})
+	}
+}
+
+func TestGTIDSetLeading(t *testing.T) {
+	type testCase struct {
+		name     string
+		set      string
+		expected string
+	}
+	cases := []testCase{
+		{
+			name:     "from the first transaction",
+			set:      uuidA + ":1-10," + uuidB + ":1:3",
+			expected: "",
+		},
+		{
+			name:     "later transactions",
+			set:      uuidA + ":5-10," + uuidB + ":2:4",
+			expected: uuidA + ":1-4," + uuidB + ":1",
+		},
+		{
+			name:     "empty",
+			set:      "",
+			expected: "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewGTIDSet(c.set)
+			l := s.Leading()
+			if l.Raw() != c.expected {
+				t.Errorf("%s: expect '%s', got '%s'", c.set, c.expected, l.Raw())
+			}
+		})
+	}
+}
+
+func TestGTIDSetSource(t *testing.T) {
+	type testCase struct {
+		name     string
+		set      string
+		uuid     string
+		expected string
+	}
+	cases := []testCase{
+		{
+			name:     "one of sources",
+			set:      uuidA + ":1-10," + uuidB + ":5-7",
+			uuid:     uuidB,
+			expected: uuidB + ":5-7",
+		},
+		{
+			name:     "tagged",
+			set:      uuidA + ":1-3:tag:1-2," + uuidB + ":1",
+			uuid:     uuidA,
+			expected: uuidA + ":1-3," + uuidA + ":tag:1-2",
+		},
+		{
+			name:     "uppercase",
+			set:      uuidA + ":1-3",
+			uuid:     strings.ToUpper(uuidA),
+			expected: uuidA + ":1-3",
+		},
+		{
+			name:     "missing source",
+			set:      uuidA + ":1-3",
+			uuid:     uuidB,
+			expected: "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewGTIDSet(c.set)
+			got := s.Source(c.uuid)
+			if got.Raw() != c.expected {
+				t.Errorf("%s: expect '%s', got '%s'", c.set, c.expected, got.Raw())
+			}
+		})
+	}
+}
+
+func TestGTIDSetUUIDs(t *testing.T) {
+	s := NewGTIDSet(uuidB + ":1-3," + uuidA + ":1-5," + uuidA + ":tag:1-2")
+	expected := []string{uuidA, uuidB}
+	if got := s.UUIDs(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expect %v, got %v", expected, got)
+	}
+}
+
+func TestGTIDSetPrimaryAndLast(t *testing.T) {
+	type testCase struct {
+		set             string
+		expectedPrimary string
+		expectedLast    int64
+	}
+	cases := []testCase{
+		{set: uuidA + ":1-10," + uuidB + ":1-3", expectedPrimary: uuidA, expectedLast: 10},
+		{set: uuidA + ":1-2:8-9," + uuidB + ":1-5", expectedPrimary: uuidB, expectedLast: 5},
+		{set: uuidB + ":1-3," + uuidA + ":4-6", expectedPrimary: uuidA, expectedLast: 6},
+		{set: "", expectedPrimary: "", expectedLast: 0},
+	}
+	for _, c := range cases {
+		s := NewGTIDSet(c.set)
+		primary := s.Primary()
+		if primary != c.expectedPrimary {
+			t.Errorf("%s: expect '%s', got '%s'", c.set, c.expectedPrimary, primary)
+		}
+		if last := s.Last(primary); last != c.expectedLast {
+			t.Errorf("%s: expect %d, got %d", c.set, c.expectedLast, last)
+		}
+	}
+}
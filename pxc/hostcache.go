@@ -0,0 +1,137 @@
+package pxc
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultHostInfoCache is used by FilterHealthyClusterMembers and GetPXCOldestBinlogHost.
+// It's disabled until a positive TTL is set with SetTTL.
+var DefaultHostInfoCache = NewHostInfoCache(0)
+
+// HostInfoCache memoizes healthy cluster members and first binlog timestamps
+// per host for a short time, so repeated host evaluations don't query every
+// host again. Only successful results are cached. A nil cache or a cache with
+// not positive TTL caches nothing. It's safe for concurrent use.
+type HostInfoCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	now         func() time.Time
+	members     map[string]hostMembersEntry
+	binlogTimes map[string]hostBinlogTimeEntry
+}
+
+type hostMembersEntry struct {
+	members []string
+	expires time.Time
+}
+
+type hostBinlogTimeEntry struct {
+	ts      int64
+	expires time.Time
+}
+
+// NewHostInfoCache returns a cache which keeps results for ttl
+func NewHostInfoCache(ttl time.Duration) *HostInfoCache {
+	return &HostInfoCache{
+		ttl:         ttl,
+		now:         time.Now,
+		members:     make(map[string]hostMembersEntry),
+		binlogTimes: make(map[string]hostBinlogTimeEntry),
+	}
+}
+
+// SetTTL changes the time results are kept for, not positive ttl disables the cache.
+// Cached results are dropped.
+func (c *HostInfoCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	clear(c.members)
+	clear(c.binlogTimes)
+}
+
+// Invalidate drops cached results of host
+func (c *HostInfoCache) Invalidate(host string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.members, host)
+	delete(c.binlogTimes, host)
+}
+
+// InvalidateAll drops all cached results
+func (c *HostInfoCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.members)
+	clear(c.binlogTimes)
+}
+
+func (c *HostInfoCache) enabled() bool {
+	return c != nil && c.ttl > 0
+}
+
+// healthyClusterMembers returns cached members of host or calls fetch and caches its result
+func (c *HostInfoCache) healthyClusterMembers(ctx context.Context, host string, fetch func() ([]string, error)) ([]string, error) {
+	if !c.enabled() {
+		return fetch()
+	}
+	c.mu.Lock()
+	e, ok := c.members[host]
+	c.mu.Unlock()
+	if ok && !hostCacheBypassed(ctx) && c.now().Before(e.expires) {
+		return slices.Clone(e.members), nil
+	}
+
+	members, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.members[host] = hostMembersEntry{members: slices.Clone(members), expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return members, nil
+}
+
+// binlogTime returns cached first binlog timestamp of host or calls fetch and caches its result
+func (c *HostInfoCache) binlogTime(ctx context.Context, host string, fetch func() (int64, error)) (int64, error) {
+	if !c.enabled() {
+		return fetch()
+	}
+	c.mu.Lock()
+	e, ok := c.binlogTimes[host]
+	c.mu.Unlock()
+	if ok && !hostCacheBypassed(ctx) && c.now().Before(e.expires) {
+		return e.ts, nil
+	}
+
+	ts, err := fetch()
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.binlogTimes[host] = hostBinlogTimeEntry{ts: ts, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return ts, nil
+}
+
+type hostCacheBypassKey struct{}
+
+// BypassHostCache returns a context which makes host evaluations ignore cached results.
+// Fresh results are still stored in the cache.
+func BypassHostCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hostCacheBypassKey{}, true)
+}
+
+func hostCacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(hostCacheBypassKey{}).(bool)
+	return bypass
+}
@@ -0,0 +1,77 @@
+package pxc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostInfoCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewHostInfoCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	calls := 0
+	fetch := func() (int64, error) {
+		calls++
+		return int64(calls), nil
+	}
+	ctx := context.Background()
+
+	type testCase struct {
+		name     string
+		ctx      context.Context
+		before   func()
+		expected int64
+	}
+	cases := []testCase{
+		{name: "first call", ctx: ctx, expected: 1},
+		{name: "cached", ctx: ctx, expected: 1},
+		{name: "bypass", ctx: BypassHostCache(ctx), expected: 2},
+		{name: "cached after bypass", ctx: ctx, expected: 2},
+		{name: "expired", ctx: ctx, before: func() { now = now.Add(time.Minute) }, expected: 3},
+		{name: "invalidated", ctx: ctx, before: func() { c.Invalidate("pxc-0") }, expected: 4},
+		{name: "invalidated all", ctx: ctx, before: c.InvalidateAll, expected: 5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.before != nil {
+				tc.before()
+			}
+			ts, err := c.binlogTime(tc.ctx, "pxc-0", fetch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ts != tc.expected {
+				t.Errorf("expect %d, got %d", tc.expected, ts)
+			}
+		})
+	}
+
+	_, err := c.binlogTime(ctx, "pxc-1", func() (int64, error) { return 0, errors.New("unreachable") })
+	if err == nil {
+		t.Error("expect error for pxc-1")
+	}
+	if ts, _ := c.binlogTime(ctx, "pxc-1", fetch); ts != 6 {
+		t.Errorf("expect errors not to be cached, got %d", ts)
+	}
+
+	members := []string{"pxc-0", "pxc-1"}
+	got, _ := c.healthyClusterMembers(ctx, "pxc-0", func() ([]string, error) { return members, nil })
+	got[0] = "changed"
+	got, _ = c.healthyClusterMembers(ctx, "pxc-0", func() ([]string, error) { return nil, errors.New("unexpected fetch") })
+	if len(got) != 2 || got[0] != "pxc-0" {
+		t.Errorf("expect cached members %v, got %v", members, got)
+	}
+
+	c.SetTTL(0)
+	if ts, _ := c.binlogTime(ctx, "pxc-0", fetch); ts != 7 {
+		t.Errorf("expect disabled cache to fetch, got %d", ts)
+	}
+	var nilCache *HostInfoCache
+	nilCache.Invalidate("pxc-0")
+	if ts, _ := nilCache.binlogTime(ctx, "pxc-0", fetch); ts != 8 {
+		t.Errorf("expect nil cache to fetch, got %d", ts)
+	}
+}
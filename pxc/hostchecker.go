@@ -0,0 +1,306 @@
+package pxc
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultHostConcurrency is a number of hosts evaluated at the same time by default
+const DefaultHostConcurrency = 8
+
+// maxHostBackoff caps the doubled delay between the scans for healthy members
+const maxHostBackoff = 5 * time.Minute
+
+// HostChecker evaluates cluster members concurrently.
+// It keeps a connection handle per host, so the hosts are connected once
+// for all checks. It's safe for concurrent use.
+type HostChecker struct {
+	user        string
+	pass        string
+	timeout     time.Duration // timeout for evaluating a single host
+	concurrency int           // maximum number of hosts evaluated at the same time
+	attempts    int           // number of scans for healthy members
+	interval    time.Duration // base delay between scans, it's doubled after each attempt
+	cache       *HostInfoCache
+	port        int // AdminPort is used if 0
+	addresses   MemberAddresses
+	recovering  bool // RECOVERING members are healthy
+
+	mu    sync.Mutex
+	conns map[string]*PXC
+}
+
+// NewHostChecker returns a checker which evaluates up to concurrency hosts at the same time,
+// each within timeout. DefaultHostConcurrency is used if concurrency is not positive.
+func NewHostChecker(user, pass string, timeout time.Duration, concurrency int) *HostChecker {
+	if concurrency <= 0 {
+		concurrency = DefaultHostConcurrency
+	}
+	return &HostChecker{
+		user:        user,
+		pass:        pass,
+		timeout:     timeout,
+		concurrency: concurrency,
+		attempts:    1,
+		conns:       make(map[string]*PXC),
+	}
+}
+
+// WithRetry makes FilterHealthyClusterMembers scan hosts up to attempts times
+// with jittered exponential backoff starting from interval, so members
+// rejoining during a view change are not missed. Negative interval is treated as 0.
+func (h *HostChecker) WithRetry(attempts int, interval time.Duration) *HostChecker {
+	h.attempts = max(attempts, 1)
+	h.interval = max(interval, 0)
+	return h
+}
+
+// WithCache makes the checker reuse host evaluation results kept in cache
+func (h *HostChecker) WithCache(cache *HostInfoCache) *HostChecker {
+	h.cache = cache
+	return h
+}
+
+// WithPort makes the checker connect to the port instead of AdminPort
+func (h *HostChecker) WithPort(port int) *HostChecker {
+	h.port = port
+	return h
+}
+
+// WithMemberAddresses makes the checker match hosts with members by the mapped addresses
+// instead of MEMBER_HOST
+func (h *HostChecker) WithMemberAddresses(addresses MemberAddresses) *HostChecker {
+	h.addresses = addresses
+	return h
+}
+
+// WithRecovering makes the checker treat RECOVERING members as healthy, they are
+// still catching up with the group, but they have binlogs and accept connections
+func (h *HostChecker) WithRecovering(recovering bool) *HostChecker {
+	h.recovering = recovering
+	return h
+}
+
+// backoff returns the delay before the attempt, it's randomized by ±50%.
+// The doubled interval is capped by maxHostBackoff.
+func (h *HostChecker) backoff(attempt int) time.Duration {
+	if h.interval <= 0 {
+		return 0
+	}
+	d := h.interval
+	for i := 1; i < attempt && d < maxHostBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxHostBackoff)
+	return d/2 + rand.N(d+1)
+}
+
+// conn returns the handle for host creating it on the first use
+func (h *HostChecker) conn(host string) (*PXC, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if db, ok := h.conns[host]; ok {
+		return db, nil
+	}
+	opts := DefaultOptions()
+	opts.DialTimeout = h.timeout
+	opts.Port = h.port
+	// checks of a host are sequential, so a couple of connections is enough
+	opts.MaxOpenConns = 2
+	opts.MaxIdleConns = 2
+	db, err := NewPXCWithOptions(host, h.user, h.pass, opts)
+	if err != nil {
+		return nil, errors.Errorf("creating connection for host %s: %v", host, err)
+	}
+	h.conns[host] = db
+	return db, nil
+}
+
+// Close closes connections to all hosts
+func (h *HostChecker) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var err error
+	for host, db := range h.conns {
+		if cerr := db.Close(); cerr != nil && err == nil {
+			err = errors.Wrapf(cerr, "close connection to %s", host)
+		}
+		delete(h.conns, host)
+	}
+	return err
+}
+
+// forEachHost runs fn for each host with limited concurrency and waits for all of them
+func (h *HostChecker) forEachHost(ctx context.Context, hosts []string, fn func(ctx context.Context, i int, host string)) {
+	g := new(errgroup.Group)
+	g.SetLimit(h.concurrency)
+	for i, host := range hosts {
+		g.Go(func() error {
+			ctx, cancel := withHostTimeout(ctx, h.timeout)
+			defer cancel()
+			fn(ctx, i, host)
+			return nil
+		})
+	}
+	// nolint:errcheck
+	g.Wait()
+}
+
+// FilterHealthyClusterMembers returns hosts which are ONLINE cluster members, or RECOVERING ones
+// with WithRecovering. Members list of the first host in hosts which returns it is used.
+// On failure the error contains errors of each host from the last attempt.
+func (h *HostChecker) FilterHealthyClusterMembers(ctx context.Context, hosts []string) ([]string, error) {
+	var err error
+	for attempt := 1; attempt <= h.attempts; attempt++ {
+		if attempt > 1 {
+			delay := h.backoff(attempt - 1)
+			log.Printf("no healthy cluster members, retrying in %s, attempt %d of %d: %v", delay, attempt, h.attempts, err)
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil, errors.Wrap(ctx.Err(), "filter healthy cluster members")
+			case <-t.C:
+			}
+		}
+		var healthyHosts []string
+		healthyHosts, err = h.filterHealthyClusterMembers(ctx, hosts)
+		if err == nil {
+			return healthyHosts, nil
+		}
+	}
+	return nil, err
+}
+
+func (h *HostChecker) filterHealthyClusterMembers(ctx context.Context, hosts []string) ([]string, error) {
+	results := make([][]string, len(hosts))
+	hostErrs := make([]string, len(hosts))
+	h.forEachHost(ctx, hosts, func(ctx context.Context, i int, host string) {
+		start := time.Now()
+		members, err := h.healthyClusterMembers(ctx, host)
+		log.Printf("evaluated healthy cluster members on host %s in %s", host, time.Since(start))
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			hostErrs[i] = err.Error()
+			return
+		}
+		results[i] = members
+	})
+
+	var healthyMembers []string
+	for _, members := range results {
+		if len(members) != 0 {
+			healthyMembers = members
+			break
+		}
+	}
+	if len(healthyMembers) == 0 {
+		hostErrs = slices.DeleteFunc(hostErrs, func(s string) bool { return s == "" })
+		if len(hostErrs) > 0 {
+			return nil, errors.Errorf("no healthy cluster members detected: %s", strings.Join(hostErrs, "; "))
+		}
+		return nil, errors.New("no healthy cluster members detected")
+	}
+	var healthyHosts []string
+	for _, host := range hosts {
+		if slices.ContainsFunc(healthyMembers, func(member string) bool { return memberHasHost(member, host) }) {
+			healthyHosts = append(healthyHosts, host)
+		}
+	}
+	if len(healthyHosts) == 0 {
+		return nil, errors.New("no healthy cluster members found in provided hosts")
+	}
+	return healthyHosts, nil
+}
+
+func (h *HostChecker) healthyClusterMembers(ctx context.Context, host string) ([]string, error) {
+	return h.cache.healthyClusterMembers(ctx, host, func() ([]string, error) {
+		return h.fetchHealthyClusterMembers(ctx, host)
+	})
+}
+
+func (h *HostChecker) fetchHealthyClusterMembers(ctx context.Context, host string) ([]string, error) {
+	db, err := h.conn(host)
+	if err != nil {
+		return nil, err
+	}
+	members, err := db.GetClusterMembers(ctx)
+	if err != nil {
+		return nil, errors.Errorf("get healthy cluster members for host %s: %v", host, err)
+	}
+
+	return healthyMembers(members, h.addresses, h.recovering), nil
+}
+
+// OldestBinlogHost returns the host with the oldest first binlog timestamp.
+// Hosts are evaluated concurrently, but if several hosts have the same timestamp
+// the first of them in hosts is returned, as if they were evaluated one by one.
+func (h *HostChecker) OldestBinlogHost(ctx context.Context, hosts []string) (string, error) {
+	type result struct {
+		ts  int64
+		err error
+	}
+	results := make([]result, len(hosts))
+	h.forEachHost(ctx, hosts, func(ctx context.Context, i int, host string) {
+		start := time.Now()
+		ts, err := h.binlogTime(ctx, host)
+		log.Printf("evaluated binlog time on host %s in %s", host, time.Since(start))
+		results[i] = result{ts: ts, err: err}
+	})
+
+	byHost := make(map[string]result, len(hosts))
+	for i, host := range hosts {
+		byHost[host] = results[i]
+	}
+	return oldestBinlogHost(hosts, func(host string) (int64, error) {
+		return byHost[host].ts, byHost[host].err
+	})
+}
+
+func (h *HostChecker) binlogTime(ctx context.Context, host string) (int64, error) {
+	return h.cache.binlogTime(ctx, host, func() (int64, error) {
+		return h.fetchBinlogTime(ctx, host)
+	})
+}
+
+func (h *HostChecker) fetchBinlogTime(ctx context.Context, host string) (int64, error) {
+	db, err := h.conn(host)
+	if err != nil {
+		return 0, err
+	}
+	list, err := db.GetBinLogNamesList(ctx)
+	if err != nil {
+		return 0, errors.Errorf("get binlog list for host %s: %v", host, err)
+	}
+	if len(list) == 0 {
+		return 0, errors.Errorf("get binlog list for host %s: no binlogs found", host)
+	}
+	var binlogTime int64
+	for _, binlogName := range list {
+		binlogTime, err = getBinlogTimeByName(ctx, db, binlogName)
+		if errors.Is(err, ErrUDFMissing) {
+			// other binlogs of the host fail the same way
+			return 0, err
+		}
+		if err != nil {
+			log.Printf("ERROR: get binlog timestamp for binlog %s host %s: %v", binlogName, host, err)
+			continue
+		}
+		if binlogTime > 0 {
+			break
+		}
+	}
+	if binlogTime == 0 {
+		return 0, errors.Errorf("get binlog oldest timestamp for host %s: no binlogs timestamp found", host)
+	}
+
+	return binlogTime, nil
+}
@@ -0,0 +1,116 @@
+package pxc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Group replication member states of performance_schema.replication_group_members
+const (
+	MemberStateOnline     = "ONLINE"
+	MemberStateRecovering = "RECOVERING"
+)
+
+// ClusterMember is a member of the group reported by a cluster host
+type ClusterMember struct {
+	Host  string // MEMBER_HOST, it may be unresolvable from the helper
+	Port  int    // MEMBER_PORT, the SQL port of the member, 0 if it's unknown
+	State string
+}
+
+// Addr returns "host:port" of the member, the host if the port is unknown
+func (m ClusterMember) Addr() string {
+	if m.Port == 0 {
+		return m.Host
+	}
+	return net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+}
+
+// MemberAddresses maps MEMBER_HOST or "MEMBER_HOST:MEMBER_PORT" to the address
+// the helper uses for the member, e.g. a pod name instead of a hostname
+// which is resolvable only inside the cluster
+type MemberAddresses map[string]string
+
+// ParseMemberAddresses parses "member=address" pairs
+func ParseMemberAddresses(pairs []string) (MemberAddresses, error) {
+	addresses := make(MemberAddresses, len(pairs))
+	for _, pair := range pairs {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		member, address, ok := strings.Cut(pair, "=")
+		member, address = strings.TrimSpace(member), strings.TrimSpace(address)
+		if !ok || member == "" || address == "" {
+			return nil, errors.Errorf("bad member address %q, expected member=address", pair)
+		}
+		addresses[strings.ToLower(member)] = address
+	}
+	return addresses, nil
+}
+
+// Resolve returns the address of the member mapped by "host:port" or by host,
+// "MEMBER_HOST:MEMBER_PORT" if the member isn't mapped
+func (a MemberAddresses) Resolve(m ClusterMember) string {
+	if address, ok := a[strings.ToLower(m.Addr())]; ok {
+		return address
+	}
+	if address, ok := a[strings.ToLower(m.Host)]; ok {
+		return address
+	}
+	return m.Addr()
+}
+
+// GetClusterMembers returns all members of the group with their states
+func (p *PXC) GetClusterMembers(ctx context.Context) ([]ClusterMember, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT MEMBER_HOST, MEMBER_PORT, MEMBER_STATE FROM performance_schema.replication_group_members")
+	if err != nil {
+		return nil, errors.Wrap(err, "select replication_group_members")
+	}
+	defer rows.Close()
+
+	var members []ClusterMember
+	for rows.Next() {
+		var m ClusterMember
+		var port *int
+		if err = rows.Scan(&m.Host, &port, &m.State); err != nil {
+			return nil, errors.Wrap(err, "scan member")
+		}
+		if port != nil {
+			m.Port = *port
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+// healthyMembers returns addresses of ONLINE members, and of RECOVERING ones
+// if recovering is true, resolved with addresses. Unmapped members are returned
+// as "host:port", so members sharing a host are not merged.
+func healthyMembers(members []ClusterMember, addresses MemberAddresses, recovering bool) []string {
+	var healthy []string
+	for _, m := range members {
+		if m.State == MemberStateOnline || recovering && m.State == MemberStateRecovering {
+			healthy = append(healthy, addresses.Resolve(m))
+		}
+	}
+	return healthy
+}
+
+// memberHasHost reports whether the member address is the host. A host without a port
+// matches the member on any port, since hosts are connected on the admin port
+// and members report the SQL one.
+func memberHasHost(member, host string) bool {
+	if strings.EqualFold(member, host) {
+		return true
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return false
+	}
+	memberHost, _, err := net.SplitHostPort(member)
+	return err == nil && strings.EqualFold(memberHost, host)
+}
@@ -3,11 +3,14 @@ package pxc
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
-	"slices"
+	"net"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
@@ -21,21 +24,77 @@ type PXC struct {
 	host string  // host for connection
 }
 
+// Options is a set of connection pool settings for the pxc handle.
+// Zero values leave the database/sql defaults in place.
+type Options struct {
+	MaxOpenConns    int           // maximum number of open connections
+	MaxIdleConns    int           // maximum number of idle connections
+	ConnMaxLifetime time.Duration // maximum amount of time a connection may be reused
+	ConnMaxIdleTime time.Duration // maximum amount of time a connection may be idle
+	DialTimeout     time.Duration // timeout for establishing a connection
+	Port            int           // AdminPort is used if 0
+
+	// ReconnectAttempts is a number of retries of a connection failed because
+	// the server is unreachable, ServerDownError is returned after them
+	ReconnectAttempts int
+	ReconnectBackoff  time.Duration // delay before the first retry, doubled after each one
+}
+
+// AdminPort is the PXC admin port. Control connections use it by default,
+// so they aren't refused when max_connections is reached.
+const AdminPort = 33062
+
+// DefaultOptions returns pool settings used by NewPXC.
+// Connections are recycled so half-open connections to failed nodes don't linger.
+func DefaultOptions() Options {
+	return Options{
+		ConnMaxLifetime: 3 * time.Minute,
+	}
+}
+
 // NewManager return new manager for work with pxc
 func NewPXC(addr string, user, pass string) (*PXC, error) {
+	return NewPXCWithOptions(addr, user, pass, DefaultOptions())
+}
+
+// NewPXCWithOptions return new manager for work with pxc with the given pool settings
+func NewPXCWithOptions(addr string, user, pass string, opts Options) (*PXC, error) {
 	var pxc PXC
 
 	config := mysql.NewConfig()
 	config.User = user
 	config.Passwd = pass
 	config.Net = "tcp"
-	config.Addr = addr + ":33062"
+	port := opts.Port
+	if port == 0 {
+		port = AdminPort
+	}
+	config.Addr = net.JoinHostPort(addr, strconv.Itoa(port))
 	config.Params = map[string]string{"interpolateParams": "true"}
+	if opts.DialTimeout > 0 {
+		config.Timeout = opts.DialTimeout
+	}
 
-	mysqlDB, err := sql.Open("mysql", config.FormatDSN())
+	connector, err := mysql.NewConnector(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot connect to host")
 	}
+	if opts.ReconnectAttempts > 0 {
+		connector = &reconnector{Connector: connector, host: addr, attempts: opts.ReconnectAttempts, backoff: opts.ReconnectBackoff}
+	}
+	mysqlDB := sql.OpenDB(connector)
+	if opts.MaxOpenConns > 0 {
+		mysqlDB.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		mysqlDB.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		mysqlDB.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		mysqlDB.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
 
 	pxc.db = mysqlDB
 	pxc.host = addr
@@ -53,20 +112,88 @@ func (p *PXC) GetHost() string {
 	return p.host
 }
 
-// GetGTIDSet return GTID set by binary log file name
-func (p *PXC) GetGTIDSet(ctx context.Context, binlogName string) (string, error) {
-	// select name from mysql.func where name='get_gtid_set_by_binlog'
+// ErrUDFMissing is matched by errors returned if binlog_utils_udf isn't installed on the node
+var ErrUDFMissing = errors.New("binlog_utils_udf is missing")
+
+// UDFMissingError is returned if a function of binlog_utils_udf can't be created
+// because the server can't open the plugin library
+type UDFMissingError struct {
+	Host     string
+	Function string
+	Err      error
+}
+
+func (e *UDFMissingError) Error() string {
+	return "create function " + e.Function + " on " + e.Host + ": binlog_utils_udf.so isn't installed, " +
+		"install the binlog_utils_udf plugin into the plugin_dir of the node: " + e.Err.Error()
+}
+
+func (e *UDFMissingError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UDFMissingError) Is(target error) bool {
+	return target == ErrUDFMissing
+}
+
+// mysqlErrCantOpenLibrary is ER_CANT_OPEN_LIBRARY
+const mysqlErrCantOpenLibrary = 1126
+
+// isCantOpenLibrary returns true if err is the server failure to load a shared library
+func isCantOpenLibrary(err error) bool {
+	var mErr *mysql.MySQLError
+	if errors.As(err, &mErr) && mErr.Number == mysqlErrCantOpenLibrary {
+		return true
+	}
+	return strings.Contains(err.Error(), "Can't open shared library")
+}
+
+// mysqlErrMalformedGTIDSet is ER_MALFORMED_GTID_SET_SPECIFICATION
+const mysqlErrMalformedGTIDSet = 1772
+
+// gtidFunctionError returns GTIDFormatError if err is the server failure to parse one of
+// the arguments of a GTID function, err otherwise. The server quotes the malformed set in
+// the message, truncated to 200 characters, so the set is the first argument found in it.
+func gtidFunctionError(err error, sets ...string) error {
+	var mErr *mysql.MySQLError
+	if !errors.As(err, &mErr) || mErr.Number != mysqlErrMalformedGTIDSet {
+		return err
+	}
+	for _, set := range sets {
+		if strings.Contains(mErr.Message, "'"+set[:min(len(set), 200)]) {
+			return &GTIDFormatError{Set: set, Err: err}
+		}
+	}
+	return &GTIDFormatError{Set: strings.Join(sets, "', '"), Err: err}
+}
+
+// ensureFunction creates the binlog_utils_udf function if it doesn't exist.
+// UDFMissingError is returned if the plugin library isn't installed.
+func (p *PXC) ensureFunction(ctx context.Context, name, returns string) error {
 	var existFunc string
-	nameRow := p.db.QueryRowContext(ctx, "select name from mysql.func where name='get_gtid_set_by_binlog'")
+	nameRow := p.db.QueryRowContext(ctx, "select name from mysql.func where name=?", name)
 	err := nameRow.Scan(&existFunc)
 	if err != nil && err != sql.ErrNoRows {
-		return "", errors.Wrap(err, "get udf name")
+		return errors.Wrap(err, "get udf name")
 	}
-	if len(existFunc) == 0 {
-		_, err = p.db.ExecContext(ctx, "CREATE FUNCTION get_gtid_set_by_binlog RETURNS STRING SONAME 'binlog_utils_udf.so'")
-		if err != nil {
-			return "", errors.Wrap(err, "create function")
+	if len(existFunc) > 0 {
+		return nil
+	}
+	_, err = p.db.ExecContext(ctx, "CREATE FUNCTION "+name+" RETURNS "+returns+" SONAME 'binlog_utils_udf.so'")
+	if err != nil {
+		if isCantOpenLibrary(err) {
+			return &UDFMissingError{Host: p.host, Function: name, Err: err}
 		}
+		return errors.Wrap(err, "create function")
+	}
+	return nil
+}
+
+// GetGTIDSet return GTID set by binary log file name
+func (p *PXC) GetGTIDSet(ctx context.Context, binlogName string) (string, error) {
+	err := p.ensureFunction(ctx, "get_gtid_set_by_binlog", "STRING")
+	if err != nil {
+		return "", err
 	}
 	var binlogSet string
 	row := p.db.QueryRowContext(ctx, "SELECT get_gtid_set_by_binlog(?)", binlogName)
@@ -78,6 +205,29 @@ func (p *PXC) GetGTIDSet(ctx context.Context, binlogName string) (string, error)
 	return binlogSet, nil
 }
 
+// ErrBinlogNotFound is returned if no binary log on the server contains the GTID set
+var ErrBinlogNotFound = errors.New("binlog not found")
+
+// GetBinlogByGTID return name of the binary log file which contains the GTID set
+func (p *PXC) GetBinlogByGTID(ctx context.Context, gtid string) (string, error) {
+	err := p.ensureFunction(ctx, "get_binlog_by_gtid_set", "STRING")
+	if err != nil {
+		return "", err
+	}
+	var binlog sql.NullString
+	row := p.db.QueryRowContext(ctx, "SELECT get_binlog_by_gtid_set(?)", gtid)
+	err = row.Scan(&binlog)
+	if err != nil {
+		return "", errors.Wrap(err, "scan binlog")
+	}
+	if !binlog.Valid || binlog.String == "" {
+		return "", errors.Wrapf(ErrBinlogNotFound, "gtid %s", gtid)
+	}
+
+	// the UDF returns the name relative to the data dir, e.g. "./binlog.000002"
+	return path.Base(binlog.String), nil
+}
+
 type Binlog struct {
 	Name      string
 	Size      int64
@@ -110,12 +260,28 @@ func (s *GTIDSet) List() []string {
 	return list
 }
 
-// GetBinLogList return binary log files list
+// GetBinLogList return binary log files list and flushes binary logs
 func (p *PXC) GetBinLogList(ctx context.Context) ([]Binlog, error) {
+	binlogs, err := p.ListBinLogs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.db.ExecContext(ctx, "FLUSH BINARY LOGS")
+	if err != nil {
+		return nil, errors.Wrap(err, "flush binary logs")
+	}
+
+	return binlogs, nil
+}
+
+// ListBinLogs return binary log files list without modifying server state
+func (p *PXC) ListBinLogs(ctx context.Context) ([]Binlog, error) {
 	rows, err := p.db.QueryContext(ctx, "SHOW BINARY LOGS")
 	if err != nil {
 		return nil, errors.Wrap(err, "show binary logs")
 	}
+	defer rows.Close()
 
 	var binlogs []Binlog
 	for rows.Next() {
@@ -125,10 +291,8 @@ func (p *PXC) GetBinLogList(ctx context.Context) ([]Binlog, error) {
 		}
 		binlogs = append(binlogs, b)
 	}
-
-	_, err = p.db.ExecContext(ctx, "FLUSH BINARY LOGS")
-	if err != nil {
-		return nil, errors.Wrap(err, "flush binary logs")
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterate binlogs")
 	}
 
 	return binlogs, nil
@@ -158,7 +322,7 @@ func (p *PXC) GTIDSubset(ctx context.Context, set1, set2 string) (bool, error) {
 	row := p.db.QueryRowContext(ctx, "SELECT GTID_SUBSET(?,?)", set1, set2)
 	var result int
 	if err := row.Scan(&result); err != nil {
-		return false, errors.Wrap(err, "scan result")
+		return false, errors.Wrap(gtidFunctionError(err, set1, set2), "scan result")
 	}
 
 	return result == 1, nil
@@ -166,17 +330,9 @@ func (p *PXC) GTIDSubset(ctx context.Context, set1, set2 string) (bool, error) {
 
 // GetBinLogFirstTimestamp return binary log file first timestamp
 func (p *PXC) GetBinLogFirstTimestamp(ctx context.Context, binlog string) (string, error) {
-	var existFunc string
-	nameRow := p.db.QueryRowContext(ctx, "select name from mysql.func where name='get_first_record_timestamp_by_binlog'")
-	err := nameRow.Scan(&existFunc)
-	if err != nil && err != sql.ErrNoRows {
-		return "", errors.Wrap(err, "get udf name")
-	}
-	if len(existFunc) == 0 {
-		_, err = p.db.ExecContext(ctx, "CREATE FUNCTION get_first_record_timestamp_by_binlog RETURNS INTEGER SONAME 'binlog_utils_udf.so'")
-		if err != nil {
-			return "", errors.Wrap(err, "create function")
-		}
+	err := p.ensureFunction(ctx, "get_first_record_timestamp_by_binlog", "INTEGER")
+	if err != nil {
+		return "", err
 	}
 	var timestamp string
 	row := p.db.QueryRowContext(ctx, "SELECT get_first_record_timestamp_by_binlog(?) DIV 1000000", binlog)
@@ -191,17 +347,9 @@ func (p *PXC) GetBinLogFirstTimestamp(ctx context.Context, binlog string) (strin
 
 // GetBinLogLastTimestamp return binary log file last timestamp
 func (p *PXC) GetBinLogLastTimestamp(ctx context.Context, binlog string) (string, error) {
-	var existFunc string
-	nameRow := p.db.QueryRowContext(ctx, "select name from mysql.func where name='get_last_record_timestamp_by_binlog'")
-	err := nameRow.Scan(&existFunc)
-	if err != nil && err != sql.ErrNoRows {
-		return "", errors.Wrap(err, "get udf name")
-	}
-	if len(existFunc) == 0 {
-		_, err = p.db.ExecContext(ctx, "CREATE FUNCTION get_last_record_timestamp_by_binlog RETURNS INTEGER SONAME 'binlog_utils_udf.so'")
-		if err != nil {
-			return "", errors.Wrap(err, "create function")
-		}
+	err := p.ensureFunction(ctx, "get_last_record_timestamp_by_binlog", "INTEGER")
+	if err != nil {
+		return "", err
 	}
 	var timestamp string
 	row := p.db.QueryRowContext(ctx, "SELECT get_last_record_timestamp_by_binlog(?) DIV 1000000", binlog)
@@ -225,122 +373,148 @@ func (p *PXC) GetCurrentGTIDSet(ctx context.Context) (string, error) {
 	return result, nil
 }
 
-func (p *PXC) SubtractGTIDSet(ctx context.Context, set, subSet string) (string, error) {
+// AddGTIDPurged adds the gtid set to gtid_purged, so its transactions are recorded
+// as executed. The set must not intersect gtid_executed.
+func (p *PXC) AddGTIDPurged(ctx context.Context, set string) error {
+	_, err := p.db.ExecContext(ctx, "SET GLOBAL gtid_purged = ?", "+"+set)
+	if err != nil {
+		return errors.Wrapf(err, "add %s to gtid_purged", set)
+	}
+
+	return nil
+}
+
+// GetServerUUID returns server_uuid of the server
+func (p *PXC) GetServerUUID(ctx context.Context) (string, error) {
 	var result string
-	row := p.db.QueryRowContext(ctx, "SELECT GTID_SUBTRACT(?,?)", set, subSet)
+	row := p.db.QueryRowContext(ctx, "SELECT @@GLOBAL.server_uuid;")
 	err := row.Scan(&result)
 	if err != nil {
-		return "", errors.Wrap(err, "scan gtid subtract result")
+		return "", errors.Wrap(err, "scan server_uuid result")
 	}
 
 	return result, nil
 }
 
-func (p *PXC) GetHealthyClusterMembers(ctx context.Context) ([]string, error) {
-	rows, err := p.db.QueryContext(ctx, "SELECT MEMBER_HOST FROM performance_schema.replication_group_members WHERE MEMBER_STATE = 'ONLINE'")
-	if err != nil {
-		return nil, errors.Wrap(err, "select replication_group_members")
+// IsReadOnly returns read_only and super_read_only global variables
+func (p *PXC) IsReadOnly(ctx context.Context) (readOnly bool, superReadOnly bool, err error) {
+	row := p.db.QueryRowContext(ctx, "SELECT @@GLOBAL.read_only, @@GLOBAL.super_read_only")
+	if err := row.Scan(&readOnly, &superReadOnly); err != nil {
+		return false, false, errors.Wrap(err, "scan read only result")
 	}
-	defer rows.Close()
 
-	var hosts []string
-	for rows.Next() {
-		var host string
-		if err = rows.Scan(&host); err != nil {
-			return nil, errors.Wrap(err, "scan host")
-		}
-		hosts = append(hosts, host)
+	return readOnly, superReadOnly, nil
+}
+
+// DisableReadOnly turns off super_read_only and read_only global variables
+func (p *PXC) DisableReadOnly(ctx context.Context) error {
+	_, err := p.db.ExecContext(ctx, "SET GLOBAL super_read_only = OFF")
+	if err != nil {
+		return errors.Wrap(err, "disable super_read_only")
+	}
+	_, err = p.db.ExecContext(ctx, "SET GLOBAL read_only = OFF")
+	if err != nil {
+		return errors.Wrap(err, "disable read_only")
 	}
 
-	return hosts, nil
+	return nil
 }
 
-func FilterHealthyClusterMembers(ctx context.Context, hosts []string, user, pass string) ([]string, error) {
-	var healthyMembers []string
-	for _, host := range hosts {
-		db, err := NewPXC(host, user, pass)
-		if err != nil {
-			log.Printf("ERROR: creating connection for host %s: %v", host, err)
-			continue
-		}
-		healthyMembers, err = db.GetHealthyClusterMembers(ctx)
-		db.Close()
+// FlushLogs closes and reopens the server logs so replayed transactions are flushed to disk.
+// With engineLogs it also flushes the storage engine logs.
+func (p *PXC) FlushLogs(ctx context.Context, engineLogs bool) error {
+	_, err := p.db.ExecContext(ctx, "FLUSH LOGS")
+	if err != nil {
+		return errors.Wrap(err, "flush logs")
+	}
+	if engineLogs {
+		_, err = p.db.ExecContext(ctx, "FLUSH ENGINE LOGS")
 		if err != nil {
-			log.Printf("ERROR: get healthy cluster members for host %s: %v", host, err)
-			continue
-		}
-		if len(healthyMembers) != 0 {
-			break
+			return errors.Wrap(err, "flush engine logs")
 		}
 	}
-	if len(healthyMembers) == 0 {
-		return nil, errors.New("no healthy cluster members detected")
+
+	return nil
+}
+
+func (p *PXC) SubtractGTIDSet(ctx context.Context, set, subSet string) (string, error) {
+	var result string
+	row := p.db.QueryRowContext(ctx, "SELECT GTID_SUBTRACT(?,?)", set, subSet)
+	err := row.Scan(&result)
+	if err != nil {
+		return "", errors.Wrap(gtidFunctionError(err, set, subSet), "scan gtid subtract result")
 	}
-	var healthyHosts []string
-	for _, host := range hosts {
-		if slices.Contains(healthyMembers, host) {
-			healthyHosts = append(healthyHosts, host)
-		}
+
+	return result, nil
+}
+
+// GetHealthyClusterMembers returns "MEMBER_HOST:MEMBER_PORT" of ONLINE members
+func (p *PXC) GetHealthyClusterMembers(ctx context.Context) ([]string, error) {
+	members, err := p.GetClusterMembers(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if len(healthyHosts) == 0 {
-		return nil, errors.New("no healthy cluster members found in provided hosts")
+	return healthyMembers(members, nil, false), nil
+}
+
+// withHostTimeout returns a context limited by timeout if it's set
+func withHostTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	return healthyHosts, nil
+	return context.WithTimeout(ctx, timeout)
 }
 
-func GetPXCOldestBinlogHost(ctx context.Context, hosts []string, user, pass string) (string, error) {
+// FilterHealthyClusterMembers returns hosts which are ONLINE cluster members.
+// Each host is evaluated within timeout, so an unreachable host is skipped quickly.
+func FilterHealthyClusterMembers(ctx context.Context, hosts []string, user, pass string, timeout time.Duration) ([]string, error) {
+	h := NewHostChecker(user, pass, timeout, DefaultHostConcurrency).WithCache(DefaultHostInfoCache)
+	defer h.Close()
+	return h.FilterHealthyClusterMembers(ctx, hosts)
+}
+
+// GetPXCOldestBinlogHost returns the host with the oldest first binlog timestamp.
+// If several hosts have the same timestamp the first of them in hosts is returned.
+func GetPXCOldestBinlogHost(ctx context.Context, hosts []string, user, pass string, timeout time.Duration) (string, error) {
+	h := NewHostChecker(user, pass, timeout, DefaultHostConcurrency).WithCache(DefaultHostInfoCache)
+	defer h.Close()
+	return h.OldestBinlogHost(ctx, hosts)
+}
+
+// oldestBinlogHost picks the host with the oldest timestamp in order of hosts.
+// If no host has a valid timestamp, the error contains failures of each host.
+func oldestBinlogHost(hosts []string, binlogTime func(host string) (int64, error)) (string, error) {
 	var oldestHost string
 	var oldestTS int64
+	var hostErrs []string
 	for _, host := range hosts {
-		binlogTime, err := getBinlogTime(ctx, host, user, pass)
+		ts, err := binlogTime(host)
 		if err != nil {
 			log.Printf("ERROR: get binlog time %v", err)
+			hostErrs = append(hostErrs, fmt.Sprintf("%s: %v", host, err))
 			continue
 		}
-		if len(oldestHost) == 0 || oldestTS > 0 && binlogTime < oldestTS {
+		if ts <= 0 {
+			log.Printf("ERROR: get binlog time for host %s: invalid timestamp %d", host, ts)
+			hostErrs = append(hostErrs, fmt.Sprintf("%s: invalid timestamp %d", host, ts))
+			continue
+		}
+		if len(oldestHost) == 0 || ts < oldestTS {
 			oldestHost = host
-			oldestTS = binlogTime
+			oldestTS = ts
 		}
 	}
 
 	if len(oldestHost) == 0 {
+		if len(hostErrs) > 0 {
+			return "", errors.Errorf("can't find host: %s", strings.Join(hostErrs, "; "))
+		}
 		return "", errors.New("can't find host")
 	}
 
 	return oldestHost, nil
 }
 
-func getBinlogTime(ctx context.Context, host, user, pass string) (int64, error) {
-	db, err := NewPXC(host, user, pass)
-	if err != nil {
-		return 0, errors.Errorf("creating connection for host %s: %v", host, err)
-	}
-	defer db.Close()
-	list, err := db.GetBinLogNamesList(ctx)
-	if err != nil {
-		return 0, errors.Errorf("get binlog list for host %s: %v", host, err)
-	}
-	if len(list) == 0 {
-		return 0, errors.Errorf("get binlog list for host %s: no binlogs found", host)
-	}
-	var binlogTime int64
-	for _, binlogName := range list {
-		binlogTime, err = getBinlogTimeByName(ctx, db, binlogName)
-		if err != nil {
-			log.Printf("ERROR: get binlog timestamp for binlog %s host %s: %v", binlogName, host, err)
-			continue
-		}
-		if binlogTime > 0 {
-			break
-		}
-	}
-	if binlogTime == 0 {
-		return 0, errors.Errorf("get binlog oldest timestamp for host %s: no binlogs timestamp found", host)
-	}
-
-	return binlogTime, nil
-}
-
 func getBinlogTimeByName(ctx context.Context, db *PXC, binlogName string) (int64, error) {
 	ts, err := db.GetBinLogFirstTimestamp(ctx, binlogName)
 	if err != nil {
@@ -359,6 +533,10 @@ func (p *PXC) DropCollectorFunctions(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "drop get_first_record_timestamp_by_binlog function")
 	}
+	_, err = p.db.ExecContext(ctx, "DROP FUNCTION IF EXISTS get_last_record_timestamp_by_binlog")
+	if err != nil {
+		return errors.Wrap(err, "drop get_last_record_timestamp_by_binlog function")
+	}
 	_, err = p.db.ExecContext(ctx, "DROP FUNCTION IF EXISTS get_binlog_by_gtid_set")
 	if err != nil {
 		return errors.Wrap(err, "drop get_binlog_by_gtid_set function")
@@ -0,0 +1,244 @@
+//go:build integration
+
+package pxc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/go-sql-driver/mysql"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// The tests run against Percona Server, it ships binlog_utils_udf.
+// Run them with: go test -tags integration ./pxc
+const (
+	integrationImage    = "percona/percona-server:8.0"
+	integrationPassword = "root"
+)
+
+// startServer runs a server with GTIDs enabled and returns a connection to it
+func startServer(t *testing.T) *PXC {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+	dsn := func(host string, port nat.Port) string {
+		return fmt.Sprintf("root:%s@tcp(%s:%s)/", integrationPassword, host, port.Port())
+	}
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        integrationImage,
+			Env:          map[string]string{"MYSQL_ROOT_PASSWORD": integrationPassword},
+			Cmd:          []string{"--server-id=1", "--log-bin=binlog", "--gtid-mode=ON", "--enforce-gtid-consistency=ON"},
+			ExposedPorts: []string{"3306/tcp"},
+			WaitingFor:   wait.ForSQL("3306/tcp", "mysql", dsn).WithStartupTimeout(3 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("start %s: %s", integrationImage, err.Error())
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Logf("terminate container: %s", err.Error())
+		}
+	})
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := container.MappedPort(ctx, "3306/tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NewPXC connects to the admin port, the container exposes the regular one
+	config := mysql.NewConfig()
+	config.User = "root"
+	config.Passwd = integrationPassword
+	config.Net = "tcp"
+	config.Addr = host + ":" + port.Port()
+	config.Params = map[string]string{"interpolateParams": "true"}
+	db, err := sql.Open("mysql", config.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PXC{db: db, host: host}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// seed commits a transaction per statement and rotates the binlog after each of them
+func seed(t *testing.T, p *PXC, statements ...string) {
+	t.Helper()
+	ctx := context.Background()
+	for _, s := range statements {
+		if _, err := p.db.ExecContext(ctx, s); err != nil {
+			t.Fatalf("exec '%s': %s", s, err.Error())
+		}
+		if _, err := p.db.ExecContext(ctx, "FLUSH BINARY LOGS"); err != nil {
+			t.Fatalf("flush binary logs: %s", err.Error())
+		}
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	p := startServer(t)
+	ctx := context.Background()
+
+	start := time.Now().Add(-time.Minute).Unix()
+	seed(t, p,
+		"CREATE DATABASE pitr",
+		"CREATE TABLE pitr.t (id INT PRIMARY KEY)",
+		"INSERT INTO pitr.t VALUES (1)",
+		"INSERT INTO pitr.t VALUES (2)",
+	)
+
+	var uuid string
+	if err := p.db.QueryRowContext(ctx, "SELECT @@server_uuid").Scan(&uuid); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GetCurrentGTIDSet", func(t *testing.T) {
+		set, err := p.GetCurrentGTIDSet(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := uuid + ":1-4"
+		if set != expected {
+			t.Errorf("expect '%s', got '%s'", expected, set)
+		}
+	})
+
+	t.Run("SubtractGTIDSet", func(t *testing.T) {
+		set, err := p.SubtractGTIDSet(ctx, uuid+":1-4", uuid+":2-3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := uuid + ":1:4"
+		if set != expected {
+			t.Errorf("expect '%s', got '%s'", expected, set)
+		}
+	})
+
+	t.Run("GTIDSubset", func(t *testing.T) {
+		type testCase struct {
+			set1, set2 string
+			expected   bool
+		}
+		cases := []testCase{
+			{set1: uuid + ":2-3", set2: uuid + ":1-4", expected: true},
+			{set1: uuid + ":1-5", set2: uuid + ":1-4", expected: false},
+			{set1: "", set2: uuid + ":1-4", expected: true},
+		}
+		for _, c := range cases {
+			got, err := p.GTIDSubset(ctx, c.set1, c.set2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.expected {
+				t.Errorf("'%s' subset of '%s': expect %t, got %t", c.set1, c.set2, c.expected, got)
+			}
+		}
+	})
+
+	var binlogs []Binlog
+	t.Run("GetBinLogList", func(t *testing.T) {
+		var err error
+		binlogs, err = p.GetBinLogList(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the initial binlog and one per seeded statement
+		if len(binlogs) < 5 {
+			t.Fatalf("expect at least 5 binlogs, got %d", len(binlogs))
+		}
+		for _, b := range binlogs {
+			if !strings.HasPrefix(b.Name, "binlog.") || b.Size <= 0 {
+				t.Errorf("unexpected binlog %+v", b)
+			}
+		}
+		names, err := p.GetBinLogNamesList(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != len(binlogs)+1 {
+			t.Errorf("expect GetBinLogList to flush binary logs, got %d binlogs after %d", len(names), len(binlogs))
+		}
+	})
+	if len(binlogs) == 0 {
+		return
+	}
+
+	t.Run("GetGTIDSet", func(t *testing.T) {
+		// the binlog with the last seeded statement
+		binlog := binlogs[len(binlogs)-2].Name
+		set, err := p.GetGTIDSet(ctx, binlog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := uuid + ":4"
+		if set != expected {
+			t.Errorf("%s: expect '%s', got '%s'", binlog, expected, set)
+		}
+
+		found, err := p.GetBinlogByGTID(ctx, uuid+":4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != binlog {
+			t.Errorf("expect '%s', got '%s'", binlog, found)
+		}
+	})
+
+	t.Run("timestamps", func(t *testing.T) {
+		binlog := binlogs[len(binlogs)-2].Name
+		first, err := p.GetBinLogFirstTimestamp(ctx, binlog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last, err := p.GetBinLogLastTimestamp(ctx, binlog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		firstTs, err := strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lastTs, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now().Add(time.Minute).Unix()
+		if firstTs < start || firstTs > lastTs || lastTs > now {
+			t.Errorf("expect %d <= first %d <= last %d <= %d", start, firstTs, lastTs, now)
+		}
+
+		binlogTime, err := getBinlogTimeByName(ctx, p, binlog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binlogTime != firstTs {
+			t.Errorf("expect %d, got %d", firstTs, binlogTime)
+		}
+	})
+
+	t.Run("DropCollectorFunctions", func(t *testing.T) {
+		if err := p.DropCollectorFunctions(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// the functions are created again on demand
+		if _, err := p.GetBinLogFirstTimestamp(ctx, binlogs[0].Name); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
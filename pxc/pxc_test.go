@@ -0,0 +1,437 @@
+package pxc
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestOldestBinlogHost(t *testing.T) {
+	type testCase struct {
+		name         string
+		hosts        []string
+		times        map[string]int64
+		expectedHost string
+		expectErr    bool
+		errContains  []string
+	}
+	cases := []testCase{
+		{
+			name:         "single host",
+			hosts:        []string{"pxc-0"},
+			times:        map[string]int64{"pxc-0": 100},
+			expectedHost: "pxc-0",
+		},
+		{
+			name:         "oldest is last",
+			hosts:        []string{"pxc-0", "pxc-1", "pxc-2"},
+			times:        map[string]int64{"pxc-0": 300, "pxc-1": 200, "pxc-2": 100},
+			expectedHost: "pxc-2",
+		},
+		{
+			name:         "equal timestamps",
+			hosts:        []string{"pxc-0", "pxc-1", "pxc-2"},
+			times:        map[string]int64{"pxc-0": 300, "pxc-1": 100, "pxc-2": 100},
+			expectedHost: "pxc-1",
+		},
+		{
+			name:         "failed and zero hosts are skipped",
+			hosts:        []string{"pxc-0", "pxc-1", "pxc-2"},
+			times:        map[string]int64{"pxc-1": 0, "pxc-2": 500},
+			expectedHost: "pxc-2",
+		},
+		{
+			name:        "no hosts",
+			hosts:       []string{"pxc-0"},
+			times:       map[string]int64{},
+			expectErr:   true,
+			errContains: []string{"pxc-0: connection refused"},
+		},
+		{
+			name:        "all hosts fail",
+			hosts:       []string{"pxc-0", "pxc-1"},
+			times:       map[string]int64{"pxc-1": 0},
+			expectErr:   true,
+			errContains: []string{"pxc-0: connection refused", "pxc-1: invalid timestamp 0"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			host, err := oldestBinlogHost(c.hosts, func(host string) (int64, error) {
+				ts, ok := c.times[host]
+				if !ok {
+					return 0, errors.New("connection refused")
+				}
+				return ts, nil
+			})
+			if c.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got host %s", host)
+				}
+				for _, s := range c.errContains {
+					if !strings.Contains(err.Error(), s) {
+						t.Errorf("expect error with '%s', got '%s'", s, err.Error())
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+			if host != c.expectedHost {
+				t.Errorf("host expect '%s', got '%s'", c.expectedHost, host)
+			}
+		})
+	}
+}
+
+func TestIsCantOpenLibrary(t *testing.T) {
+	type testCase struct {
+		name     string
+		err      error
+		expected bool
+	}
+	cases := []testCase{
+		{
+			name:     "mysql error number",
+			err:      &mysql.MySQLError{Number: 1126, Message: "Can't open shared library 'binlog_utils_udf.so' (errno: 2 cannot open shared object file)"},
+			expected: true,
+		},
+		{
+			name:     "message",
+			err:      errors.New("Error: Can't open shared library 'binlog_utils_udf.so'"),
+			expected: true,
+		},
+		{
+			name: "other mysql error",
+			err:  &mysql.MySQLError{Number: 1125, Message: "Function 'get_gtid_set_by_binlog' already exists"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isCantOpenLibrary(c.err); got != c.expected {
+				t.Errorf("expect %t, got %t", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestGTIDFunctionError(t *testing.T) {
+	type testCase struct {
+		name        string
+		err         error
+		expectedSet string // empty if the error isn't GTIDFormatError
+	}
+	const valid = "9b4e1c08-2d8a-11ee-be56-0242ac120002:1-5"
+	cases := []testCase{
+		{
+			name:        "malformed second argument",
+			err:         &mysql.MySQLError{Number: 1772, Message: "Malformed GTID set specification 'garbage'."},
+			expectedSet: "garbage",
+		},
+		{
+			name:        "truncated set",
+			err:         &mysql.MySQLError{Number: 1772, Message: "Malformed GTID set specification '" + strings.Repeat("x", 200) + "'."},
+			expectedSet: strings.Repeat("x", 300),
+		},
+		{
+			name: "other mysql error",
+			err:  &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := gtidFunctionError(c.err, valid, c.expectedSet)
+			var fErr *GTIDFormatError
+			if !errors.As(err, &fErr) {
+				if c.expectedSet != "" {
+					t.Fatalf("expect GTIDFormatError, got '%v'", err)
+				}
+				return
+			}
+			if fErr.Set != c.expectedSet {
+				t.Errorf("expect '%s', got '%s'", c.expectedSet, fErr.Set)
+			}
+			if !errors.Is(err, ErrBadGTIDFormat) {
+				t.Error("expect error to match ErrBadGTIDFormat")
+			}
+		})
+	}
+}
+
+func TestUDFMissingError(t *testing.T) {
+	cause := &mysql.MySQLError{Number: 1126, Message: "Can't open shared library 'binlog_utils_udf.so'"}
+	var err error = &UDFMissingError{Host: "pxc-0", Function: "get_gtid_set_by_binlog", Err: cause}
+	err = errors.Join(errors.New("get gtid set"), err)
+
+	if !errors.Is(err, ErrUDFMissing) {
+		t.Error("expect error to match ErrUDFMissing")
+	}
+	var mErr *mysql.MySQLError
+	if !errors.As(err, &mErr) || mErr.Number != 1126 {
+		t.Error("expect error to wrap the mysql error")
+	}
+	if !strings.Contains(err.Error(), "pxc-0") || !strings.Contains(err.Error(), "binlog_utils_udf") {
+		t.Errorf("expect host and plugin in '%s'", err.Error())
+	}
+}
+
+func TestHealthyMembers(t *testing.T) {
+	members := []ClusterMember{
+		{Host: "pxc-0.pxc.svc.cluster.local", Port: 3306, State: MemberStateOnline},
+		{Host: "pxc-1.pxc.svc.cluster.local", Port: 3306, State: MemberStateRecovering},
+		{Host: "10.0.0.7", Port: 3307, State: MemberStateOnline},
+		{Host: "pxc-3", Port: 3306, State: "UNREACHABLE"},
+	}
+	addresses, err := ParseMemberAddresses([]string{"PXC-0.pxc.svc.cluster.local=pxc-0", " 10.0.0.7:3307 = pxc-2", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type testCase struct {
+		name       string
+		addresses  MemberAddresses
+		recovering bool
+		expected   []string
+	}
+	cases := []testCase{
+		{
+			name:     "member hosts",
+			expected: []string{"pxc-0.pxc.svc.cluster.local:3306", "10.0.0.7:3307"},
+		},
+		{
+			name:      "mapped by host and by host and port",
+			addresses: addresses,
+			expected:  []string{"pxc-0", "pxc-2"},
+		},
+		{
+			name:       "recovering members",
+			addresses:  addresses,
+			recovering: true,
+			expected:   []string{"pxc-0", "pxc-1.pxc.svc.cluster.local:3306", "pxc-2"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			healthy := healthyMembers(members, c.addresses, c.recovering)
+			if strings.Join(healthy, ",") != strings.Join(c.expected, ",") {
+				t.Errorf("expect %v, got %v", c.expected, healthy)
+			}
+		})
+	}
+
+	if _, err := ParseMemberAddresses([]string{"pxc-0"}); err == nil {
+		t.Error("expect error for the pair without address")
+	}
+}
+
+func TestMemberHasHost(t *testing.T) {
+	type testCase struct {
+		member   string
+		host     string
+		expected bool
+	}
+	cases := []testCase{
+		{member: "pxc-0", host: "pxc-0", expected: true},
+		{member: "pxc-0:3306", host: "PXC-0", expected: true},
+		{member: "pxc-0:3306", host: "pxc-0:3306", expected: true},
+		{member: "pxc-0:3306", host: "pxc-0:3307", expected: false},
+		{member: "[::1]:3306", host: "::1", expected: true},
+		{member: "pxc-0:3306", host: "pxc-1", expected: false},
+		{member: "pxc-0", host: "pxc-0:3306", expected: false},
+	}
+	for _, c := range cases {
+		if got := memberHasHost(c.member, c.host); got != c.expected {
+			t.Errorf("member %s, host %s: expect %t, got %t", c.member, c.host, c.expected, got)
+		}
+	}
+}
+
+func TestHostCheckerBackoff(t *testing.T) {
+	h := NewHostChecker("user", "pass", time.Second, 0).WithRetry(100, 2*time.Second)
+	for attempt := 1; attempt < 100; attempt++ {
+		base := min(2*time.Second<<min(attempt-1, 20), maxHostBackoff)
+		d := h.backoff(attempt)
+		if d < base/2 || d > base/2+base {
+			t.Fatalf("attempt %d: expect delay in [%s, %s], got %s", attempt, base/2, base/2+base, d)
+		}
+	}
+
+	h = NewHostChecker("user", "pass", time.Second, 0).WithRetry(3, -time.Second)
+	if d := h.backoff(2); d != 0 {
+		t.Errorf("expect no delay with negative interval, got %s", d)
+	}
+}
+
+func TestHostCheckerRetry(t *testing.T) {
+	// nothing listens on the port, so each scan fails
+	h := NewHostChecker("user", "pass", time.Second, 0).WithPort(1).WithRetry(3, time.Millisecond)
+	defer h.Close()
+	start := time.Now()
+	_, err := h.FilterHealthyClusterMembers(context.Background(), []string{"127.0.0.1"})
+	if err == nil {
+		t.Fatal("expect error without reachable hosts")
+	}
+	if !strings.Contains(err.Error(), "no healthy cluster members detected") {
+		t.Errorf("expect error of the last scan, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 3*time.Millisecond/2 {
+		t.Errorf("expect backoff between the scans, elapsed %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h = NewHostChecker("user", "pass", time.Second, 0).WithPort(1).WithRetry(3, time.Hour)
+	defer h.Close()
+	if _, err := h.FilterHealthyClusterMembers(ctx, []string{"127.0.0.1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect canceled backoff, got %v", err)
+	}
+}
+
+// fakeConnector opens connections which answer queries with query,
+// a nil row is no rows. Executed statements are recorded in execs.
+type fakeConnector struct {
+	query func(q string, args []driver.NamedValue) ([]driver.Value, error)
+	exec  func(q string) error
+	execs []string
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{c: c}, nil }
+func (c *fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	c *fakeConnector
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeConn) QueryContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Rows, error) {
+	row, err := c.c.query(q, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{row: row}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
+	c.c.execs = append(c.c.execs, q)
+	if c.c.exec != nil {
+		if err := c.c.exec(q); err != nil {
+			return nil, err
+		}
+	}
+	return driver.RowsAffected(0), nil
+}
+
+type fakeRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return make([]string, max(len(r.row), 1))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done || r.row == nil {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.row)
+	return nil
+}
+
+func TestGetBinlogByGTID(t *testing.T) {
+	const gtid = "9b4e1c08-2d8a-11ee-be56-0242ac120002:7"
+	type testCase struct {
+		name          string
+		udfExists     bool
+		createErr     error
+		result        driver.Value
+		expected      string
+		expectedExecs int
+		expectErr     error
+	}
+	cases := []testCase{
+		{
+			name:      "relative to the data dir",
+			udfExists: true,
+			result:    "./binlog.000002",
+			expected:  "binlog.000002",
+		},
+		{
+			name:          "function is created",
+			result:        "./binlog.000003",
+			expected:      "binlog.000003",
+			expectedExecs: 1,
+		},
+		{
+			name:      "null",
+			udfExists: true,
+			result:    nil,
+			expectErr: ErrBinlogNotFound,
+		},
+		{
+			name:      "empty",
+			udfExists: true,
+			result:    "",
+			expectErr: ErrBinlogNotFound,
+		},
+		{
+			name:          "udf library is missing",
+			createErr:     &mysql.MySQLError{Number: 1126, Message: "Can't open shared library 'binlog_utils_udf.so'"},
+			expectedExecs: 1,
+			expectErr:     ErrUDFMissing,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := &fakeConnector{
+				query: func(q string, args []driver.NamedValue) ([]driver.Value, error) {
+					switch {
+					case strings.HasPrefix(q, "select name from mysql.func"):
+						if c.udfExists {
+							return []driver.Value{args[0].Value}, nil
+						}
+						return nil, nil
+					case q == "SELECT get_binlog_by_gtid_set(?)":
+						if args[0].Value != gtid {
+							t.Errorf("expect gtid '%s', got '%v'", gtid, args[0].Value)
+						}
+						return []driver.Value{c.result}, nil
+					}
+					return nil, errors.New("unexpected query " + q)
+				},
+				exec: func(q string) error { return c.createErr },
+			}
+			db := sql.OpenDB(conn)
+			defer db.Close()
+			p := &PXC{db: db, host: "pxc-0"}
+
+			binlog, err := p.GetBinlogByGTID(context.Background(), gtid)
+			if c.expectErr != nil {
+				if !errors.Is(err, c.expectErr) {
+					t.Errorf("expect '%v', got '%v'", c.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if binlog != c.expected {
+				t.Errorf("expect '%s', got '%s'", c.expected, binlog)
+			}
+			if len(conn.execs) != c.expectedExecs {
+				t.Errorf("expect %d statements, got %q", c.expectedExecs, conn.execs)
+			}
+		})
+	}
+}
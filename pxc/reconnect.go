@@ -0,0 +1,89 @@
+package pxc
+
+import (
+	"context"
+	"database/sql/driver"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// ErrServerDown is matched by errors of servers which didn't accept connections after all reconnect attempts
+var ErrServerDown = errors.New("server is down")
+
+// ServerDownError is returned by queries if the connection to the server
+// is lost and it can't be established again
+type ServerDownError struct {
+	Host     string
+	Attempts int
+	Err      error
+}
+
+func (e *ServerDownError) Error() string {
+	return "server " + e.Host + " is down, no connection after " + strconv.Itoa(e.Attempts) + " attempts: " + e.Err.Error()
+}
+
+func (e *ServerDownError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ServerDownError) Is(target error) bool {
+	return target == ErrServerDown
+}
+
+// mysqlErrServerShutdown is ER_SERVER_SHUTDOWN returned while the server is stopping
+const mysqlErrServerShutdown = 1053
+
+// isConnectionError returns true if err means the server is unreachable
+// rather than it refused the connection, e.g. because of wrong credentials
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrServerShutdown
+	}
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// reconnector is a driver.Connector which retries failed connections with exponential backoff.
+// database/sql opens a new connection instead of a broken one, so queries wait
+// for a restarted server instead of failing.
+type reconnector struct {
+	driver.Connector
+	host     string
+	attempts int           // retries of a failed connection
+	backoff  time.Duration // delay before the first retry, doubled after each one
+}
+
+func (c *reconnector) Connect(ctx context.Context) (driver.Conn, error) {
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		conn, err := c.Connector.Connect(ctx)
+		if err == nil {
+			if attempt > 0 {
+				log.Printf("reconnected to %s after %d attempts", c.host, attempt+1)
+			}
+			return conn, nil
+		}
+		if !isConnectionError(err) {
+			return nil, err
+		}
+		if attempt >= c.attempts {
+			return nil, &ServerDownError{Host: c.host, Attempts: attempt + 1, Err: err}
+		}
+		log.Printf("WARNING: connection to %s failed, retrying in %s: %v", c.host, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "reconnect to %s: %v", c.host, err)
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,73 @@
+package pxc
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// failingConnector fails the first connections with the errors
+type failingConnector struct {
+	errs  []error
+	calls int
+}
+
+func (c *failingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return nil, c.errs[c.calls-1]
+	}
+	return nil, nil
+}
+
+func (c *failingConnector) Driver() driver.Driver { return nil }
+
+func TestReconnector(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	denied := &mysql.MySQLError{Number: 1045, Message: "Access denied"}
+	type testCase struct {
+		name          string
+		errs          []error
+		expectedCalls int
+		expectedErr   error
+	}
+	cases := []testCase{
+		{
+			name:          "server restarted",
+			errs:          []error{refused, &mysql.MySQLError{Number: mysqlErrServerShutdown}, driver.ErrBadConn},
+			expectedCalls: 4,
+		},
+		{
+			name:          "server is down",
+			errs:          []error{refused, refused, refused, refused, refused},
+			expectedCalls: 4,
+			expectedErr:   ErrServerDown,
+		},
+		{
+			name:          "access denied isn't retried",
+			errs:          []error{denied},
+			expectedCalls: 1,
+			expectedErr:   denied,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			connector := &failingConnector{errs: c.errs}
+			r := &reconnector{Connector: connector, host: "pxc-0", attempts: 3}
+			_, err := r.Connect(context.Background())
+			if c.expectedErr == nil && err != nil {
+				t.Fatalf("connect: %s", err.Error())
+			}
+			if !errors.Is(err, c.expectedErr) {
+				t.Errorf("expect error '%v', got '%v'", c.expectedErr, err)
+			}
+			if connector.calls != c.expectedCalls {
+				t.Errorf("expect %d connections, got %d", c.expectedCalls, connector.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package recoverer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// errBinlogTimeout is returned if decoding of a binlog exceeds PITR_BINLOG_TIMEOUT
+var errBinlogTimeout = errors.New("binlog timeout exceeded")
+
+// flushWriter is a writer which may buffer writes until Flush is called
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+type nopFlusher struct {
+	io.Writer
+}
+
+func (nopFlusher) Flush() error { return nil }
+
+// newPipeWriter returns a writer which buffers up to size bytes written to the mysql
+// stdin pipe, so mysqlbinlog isn't stalled on each write while mysql is busy.
+// Writes aren't buffered if size is not positive.
+func newPipeWriter(w io.Writer, size int) flushWriter {
+	if size <= 0 {
+		return nopFlusher{w}
+	}
+	return bufio.NewWriterSize(w, size)
+}
+
+// applyBinlog decodes the binlog with mysqlbinlog and writes the result to out.
+// If binlogs buffering is enabled, the decoded binlog is stored in a temp file first,
+// so a failed download or decode can be retried without feeding partial data to mysql.
+func (r *Recoverer) applyBinlog(ctx context.Context, binlog string, out io.Writer, prog *progress) error {
+	if !r.bufferBinlogs {
+		return r.decodeBinlog(ctx, binlog, out, prog)
+	}
+
+	var f *os.File
+	var err error
+	for attempt := 0; attempt <= r.binlogRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying %s, attempt %d of %d: %v", binlog, attempt, r.binlogRetries, err)
+		}
+		f, err = r.decodeBinlogToFile(ctx, binlog, prog)
+		// a timed out binlog would time out again, PITR_BINLOG_TIMEOUT_POLICY handles it
+		if err == nil || ctx.Err() != nil || errors.Is(err, errBinlogTimeout) {
+			break
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "decode %s", binlog)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	if _, err := io.Copy(out, f); err != nil {
+		return errors.Wrapf(err, "write decoded %s to mysql", binlog)
+	}
+	return nil
+}
+
+// decodeBinlogToFile decodes the binlog into a temp file and returns it
+// positioned at the start. The file is removed on error.
+func (r *Recoverer) decodeBinlogToFile(ctx context.Context, binlog string, prog *progress) (_ *os.File, err error) {
+	// the object name may contain the PITR_BINLOG_PREFIX directory
+	f, err := os.CreateTemp(r.tmpDir, filepath.Base(binlog)+"-*.sql")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp file")
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	err = r.decodeBinlog(ctx, binlog, f, prog)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrapf(err, "seek %s", f.Name())
+	}
+	return f, nil
+}
+
+// decodeBinlog downloads the binlog and writes mysqlbinlog output to out
+func (r *Recoverer) decodeBinlog(ctx context.Context, binlog string, out io.Writer, prog *progress) (err error) {
+	if r.binlogTimeout > 0 {
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.binlogTimeout)
+		defer cancel()
+		defer func() {
+			if err != nil && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = errors.Wrapf(errBinlogTimeout, "%s after %s: %v", binlog, r.binlogTimeout, err)
+			}
+		}()
+	}
+
+	binlogObj, err := r.binlogReader(ctx, binlog)
+	if err != nil {
+		return err
+	}
+
+	args := r.binlogArgs(binlog)
+	if database := databaseFrom(ctx); database != "" {
+		// the GTIDs are added once after all sessions finish, see applyToSessions
+		args = append([]string{"--database=" + database, "--skip-gtids"}, args...)
+	}
+	var ddl *ddlFilter
+	var tables *tableFilter
+	var decoded *lineFilter
+	if r.skipDDL || len(r.tables) > 0 {
+		w := out
+		if r.skipDDL {
+			ddl = &ddlFilter{w: w}
+			w = ddl
+		}
+		if len(r.tables) > 0 {
+			tables = newTableFilter(w, r.tables)
+			w = tables
+		}
+		decoded = newLineFilter(w)
+		out = decoded
+	}
+	stderr := r.subprocessStderr()
+	spec := CommandSpec{
+		Name:   "mysqlbinlog",
+		Args:   args,
+		Env:    r.mysqlbinlogEnv(),
+		Stdout: out,
+		Stderr: stderr,
+	}
+	var in *countingReader
+	if binlogObj != nil {
+		defer binlogObj.Close()
+		in = prog.reader(binlogObj)
+		spec.Stdin = in
+	}
+	cmd := r.command(ctx, spec)
+	log.Printf("Running %s", cmd.String())
+	err = runCommand(cmd)
+	// nolint:errcheck
+	stderr.Flush()
+	if err != nil {
+		if in != nil {
+			in.discard()
+		}
+		return errors.Wrapf(err, "run mysqlbinlog")
+	}
+	if decoded != nil {
+		err = decoded.Flush()
+		if err == nil && tables != nil {
+			err = tables.Flush()
+		}
+		if err == nil && ddl != nil {
+			err = ddl.Flush()
+		}
+		if err != nil {
+			return errors.Wrap(err, "write mysqlbinlog output")
+		}
+	}
+	if ddl != nil && ddl.dropped > 0 {
+		log.Printf("%d DDL statements of %s are skipped because of PITR_SKIP_DDL", ddl.dropped, binlog)
+	}
+	if tables != nil && tables.dropped > 0 {
+		log.Printf("%d row events of %s are skipped because of PITR_TABLES", tables.dropped, binlog)
+	}
+	return nil
+}
+
+// checkDiskSpace fails if the filesystem of dir has less than required bytes available.
+// Buffered binlogs are decoded and removed one by one, so the required space
+// is based on the largest binlog rather than on the total size.
+func checkDiskSpace(dir string, required int64) error {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return errors.Wrapf(err, "statfs %s", dir)
+	}
+	available := int64(stat.Bavail) * int64(stat.Bsize)
+	if available < required {
+		return errors.Errorf("not enough disk space in %s: %d bytes available, %d bytes required", dir, available, required)
+	}
+	log.Printf("disk space in %s: %d bytes available, %d bytes required", dir, available, required)
+	return nil
+}
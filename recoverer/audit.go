@@ -0,0 +1,70 @@
+package recoverer
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time         time.Time `json:"time"`
+	Binlog       string    `json:"binlog,omitempty"`        // applied binlog
+	GTIDSet      string    `json:"gtid_set,omitempty"`      // gtid set of the applied binlog from its sidecar
+	GTIDExecuted string    `json:"gtid_executed,omitempty"` // gtid_executed after the recovery is finished
+}
+
+// auditLog records applied binlogs as JSON lines, each line is synced
+// to disk so an interrupted recovery still leaves a usable record
+type auditLog struct {
+	f   *os.File
+	now func() time.Time
+}
+
+// openAuditLog opens the audit log for appending, nil is returned if the path is empty.
+// Entries are timestamped with now.
+func openAuditLog(path string, now func() time.Time) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", path)
+	}
+	return &auditLog{f: f, now: now}, nil
+}
+
+func (a *auditLog) binlog(name, gtidSet string) error {
+	return a.write(auditEntry{Binlog: name, GTIDSet: gtidSet})
+}
+
+func (a *auditLog) finished(gtidExecuted string) error {
+	return a.write(auditEntry{GTIDExecuted: gtidExecuted})
+}
+
+func (a *auditLog) write(e auditEntry) error {
+	if a == nil {
+		return nil
+	}
+	e.Time = a.now().UTC()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "marshal audit entry")
+	}
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		return errors.Wrapf(err, "write %s", a.f.Name())
+	}
+	if err := a.f.Sync(); err != nil {
+		return errors.Wrapf(err, "sync %s", a.f.Name())
+	}
+	return nil
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.f.Close()
+}
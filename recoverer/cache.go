@@ -0,0 +1,207 @@
+package recoverer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+)
+
+// archiveCache is a local copy of what recoveries learned about binlogs of the archive,
+// so the next ones don't read their sidecars and stat them again. It's stored in
+// PITR_CACHE_DIR as LZ4 framed JSON, a file per archive.
+//
+// Entries are kept while their binlogs are listed, the file is rewritten only if the
+// listing or the entries change. An entry is used only for the binlog version it was
+// cached for: a binlog uploaded again to a versioned bucket gets a new version id object,
+// so its sidecars and size are fetched again. In a bucket without versioning the name of
+// a binlog has the hash of its gtid set, so an upload with the same name has the same
+// sidecars. The first timestamp of a binlog is in its name, so it isn't cached.
+type archiveCache struct {
+	Listing string                   `json:"listing"` // sha256 of the listed names
+	Binlogs map[string]*cachedBinlog `json:"binlogs"`
+
+	mu      sync.Mutex
+	file    string
+	changed bool
+}
+
+// cachedBinlog has only the fields which are known, nil ones are fetched from the storage
+type cachedBinlog struct {
+	Version string  `json:"version,omitempty"` // version id of the binlog, empty if it's not versioned
+	Size    *int64  `json:"size,omitempty"`
+	GTIDSet *string `json:"gtid_set,omitempty"`
+	LastTS  *int64  `json:"last_ts,omitempty"`
+}
+
+// archiveCacheFile returns the cache file of the archive identified by id in dir
+func archiveCacheFile(dir, id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(dir, "archive-"+hex.EncodeToString(sum[:8])+".json.lz4")
+}
+
+// readArchiveCache reads the cache file, an empty cache is returned if it can't be read
+func readArchiveCache(file string) *archiveCache {
+	c := &archiveCache{file: file}
+	err := c.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("WARNING: ignoring archive cache %s: %v", file, err)
+	}
+	if err != nil || c.Binlogs == nil {
+		c.Listing = ""
+		c.Binlogs = make(map[string]*cachedBinlog)
+	}
+	return c
+}
+
+func (c *archiveCache) read() error {
+	f, err := os.Open(c.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(lz4.NewReader(f))
+	if err != nil {
+		return errors.Wrap(err, "decompress")
+	}
+	return errors.Wrap(json.Unmarshal(data, c), "parse")
+}
+
+// update drops entries of binlogs which aren't in the listing
+func (c *archiveCache) update(list []string) {
+	h := sha256.New()
+	listed := make(map[string]bool, len(list))
+	for _, name := range list {
+		// nolint:errcheck
+		io.WriteString(h, name+"\n")
+		listed[name] = true
+	}
+	listing := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if listing == c.Listing {
+		log.Printf("archive listing is not changed, using %d cached binlogs of %s", len(c.Binlogs), c.file)
+		return
+	}
+	for name := range c.Binlogs {
+		if !listed[name] {
+			delete(c.Binlogs, name)
+		}
+	}
+	log.Printf("archive listing is changed, %d binlogs of %s are still listed", len(c.Binlogs), c.file)
+	c.Listing = listing
+	c.changed = true
+}
+
+// save writes the cache to its file if it's changed
+func (c *archiveCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0o755); err != nil {
+		return errors.Wrap(err, "create directory")
+	}
+	// the file is replaced at once, so a concurrent recovery doesn't read a partial one
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), filepath.Base(c.file)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create file")
+	}
+	defer os.Remove(tmp.Name())
+	w := lz4.NewWriter(tmp)
+	if _, err = w.Write(data); err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "write %s", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), c.file); err != nil {
+		return errors.Wrap(err, "rename file")
+	}
+	c.changed = false
+	return nil
+}
+
+// entry returns a copy of the cached binlog version, false if there is none.
+// A nil cache has no entries.
+func (c *archiveCache) entry(binlog, version string) (cachedBinlog, bool) {
+	if c == nil {
+		return cachedBinlog{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.Binlogs[binlog]
+	if !ok || e.Version != version {
+		return cachedBinlog{}, false
+	}
+	return *e, true
+}
+
+// set updates the cached binlog version with fn, entries of other versions are dropped.
+// A nil cache is not changed.
+func (c *archiveCache) set(binlog, version string, fn func(e *cachedBinlog)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.Binlogs[binlog]
+	if !ok || e.Version != version {
+		e = &cachedBinlog{Version: version}
+		c.Binlogs[binlog] = e
+	}
+	fn(e)
+	c.changed = true
+}
+
+// useArchiveCache reads the cache of PITR_CACHE_DIR for the listing of the storage.
+// Binlogs streamed from a server are never cached.
+func (r *Recoverer) useArchiveCache(list []string) {
+	if r.cacheDir == "" || r.source != nil {
+		return
+	}
+	if r.cache == nil {
+		r.cache = readArchiveCache(archiveCacheFile(r.cacheDir, r.archiveID))
+	}
+	r.cache.update(list)
+}
+
+// saveArchiveCache writes the cache if it's used, an error is only logged
+func (r *Recoverer) saveArchiveCache() {
+	if r.cache == nil {
+		return
+	}
+	if err := r.cache.save(); err != nil {
+		log.Printf("WARNING: save archive cache %s: %v", r.cache.file, err)
+	}
+}
+
+// archiveID identifies the archive of the config, so each archive has a cache file of its own
+func (c Config) archiveID() string {
+	return strings.Join([]string{
+		c.StorageType,
+		c.BinlogStorageS3.Endpoint, c.BinlogStorageS3.BucketURL,
+		c.BinlogStorageAzure.Endpoint, c.BinlogStorageAzure.AccountName, c.BinlogStorageAzure.ContainerPath,
+		c.BinlogStorageFilesystem.Path, c.BinlogStorageFilesystem.Prefix,
+		c.BinlogPrefix,
+	}, "\x00")
+}
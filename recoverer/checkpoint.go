@@ -0,0 +1,82 @@
+package recoverer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// errCheckpointComplete is returned by the binlog selection if the interrupted
+// recovery applied all the binlogs, so there's nothing left to apply
+var errCheckpointComplete = errors.New("all binlogs are applied according to the checkpoint")
+
+// checkpoint is a recovery state persisted after each applied binlog
+type checkpoint struct {
+	Binlog  string `json:"binlog"`   // last successfully applied binlog
+	GTIDSet string `json:"gtid_set"` // gtid_executed after the binlog was applied
+}
+
+// readCheckpoint returns the checkpoint stored in the file.
+// Empty checkpoint is returned if the path is empty or the file doesn't exist.
+func readCheckpoint(path string) (checkpoint, error) {
+	var cp checkpoint
+	if path == "" {
+		return cp, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return cp, errors.Wrapf(err, "read %s", path)
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, errors.Wrapf(err, "unmarshal %s", path)
+	}
+	return cp, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint file
+func writeCheckpoint(path string, cp checkpoint) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "marshal checkpoint")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "write %s", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "sync %s", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "close %s", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrapf(err, "rename %s", tmp.Name())
+	}
+	return nil
+}
+
+// removeCheckpoint removes the checkpoint file after successful recovery
+func removeCheckpoint(path string) error {
+	if path == "" {
+		return nil
+	}
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove %s", path)
+	}
+	return nil
+}
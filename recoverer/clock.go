@@ -0,0 +1,23 @@
+package recoverer
+
+import "time"
+
+// Clock returns the current time, it allows to freeze time in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is a Clock returning the system time
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the current time of the configured clock, RealClock is used if it's not set
+func (r *Recoverer) now() time.Time {
+	if r.clock == nil {
+		return RealClock{}.Now()
+	}
+	return r.clock.Now()
+}
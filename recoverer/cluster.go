@@ -0,0 +1,70 @@
+package recoverer
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"mysql-pitr-helper/pxc"
+
+	"github.com/pkg/errors"
+)
+
+// checkCluster refuses to apply the selected binlogs if none of their sources, and
+// none of the server uuids recorded by the collector, is server_uuid of the target or
+// a source of its gtid_executed. PITR_FORCE, or the narrower PITR_ALLOW_OTHER_CLUSTER,
+// turns the error into a warning.
+// The target with empty gtid_executed isn't checked, it has only its own random server_uuid.
+func (r *Recoverer) checkCluster(ctx context.Context) error {
+	if r.startGTID == "" {
+		return nil
+	}
+	archive := slices.Clone(r.manifestUUIDs)
+	for _, binlog := range r.binlogs {
+		set := pxc.NewGTIDSet(r.binlogSets[binlog])
+		archive = append(archive, set.UUIDs()...)
+	}
+	archive = sortedUUIDs(archive)
+	if len(archive) == 0 {
+		return nil
+	}
+
+	serverUUID, err := r.targetDB().GetServerUUID(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get server uuid")
+	}
+	executed := pxc.NewGTIDSet(r.startGTID)
+	target := sortedUUIDs(append(executed.UUIDs(), serverUUID))
+	for _, uuid := range archive {
+		if slices.Contains(target, uuid) {
+			return nil
+		}
+	}
+
+	err = errors.Wrapf(ErrWrongCluster, "archive uuids %s, target server_uuid and gtid_executed uuids %s",
+		strings.Join(archive, ","), strings.Join(target, ","))
+	switch {
+	case r.allowOtherCluster:
+		log.Printf("WARNING: %v, proceeding because PITR_ALLOW_OTHER_CLUSTER is set", err)
+	case r.force:
+		log.Printf("WARNING: %v, proceeding because PITR_FORCE is set", err)
+	default:
+		return err
+	}
+	return nil
+}
+
+// sortedUUIDs returns lowercase uuids without duplicates and empty ones
+func sortedUUIDs(uuids []string) []string {
+	result := make([]string, 0, len(uuids))
+	for _, uuid := range uuids {
+		uuid = strings.ToLower(strings.TrimSpace(uuid))
+		if uuid != "" {
+			result = append(result, uuid)
+		}
+	}
+	sort.Strings(result)
+	return slices.Compact(result)
+}
@@ -0,0 +1,84 @@
+package recoverer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// CommandSpec describes a process run by the recoverer.
+// Nil Env, Stdin, Stdout and Stderr have the same meaning as in exec.Cmd.
+type CommandSpec struct {
+	Name   string
+	Args   []string
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Command is a process created by CommandRunner
+type Command interface {
+	Start() error
+	// Wait waits for the started process to exit and its output to be copied
+	Wait() error
+	// Kill stops the started process immediately
+	Kill() error
+	String() string
+}
+
+// CommandRunner creates the mysql and mysqlbinlog processes,
+// it allows to replace them in tests
+type CommandRunner interface {
+	Command(ctx context.Context, spec CommandSpec) Command
+}
+
+// ExecRunner is a CommandRunner which runs processes with os/exec.
+// The processes are killed when ctx is done.
+type ExecRunner struct{}
+
+func (ExecRunner) Command(ctx context.Context, spec CommandSpec) Command {
+	cmd := exec.CommandContext(ctx, spec.Name, spec.Args...)
+	cmd.Env = spec.Env
+	cmd.Stdin = spec.Stdin
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+	return execCommand{cmd}
+}
+
+type execCommand struct {
+	*exec.Cmd
+}
+
+func (c execCommand) Kill() error {
+	if c.Process == nil {
+		return errors.New("process is not started")
+	}
+	return c.Process.Kill()
+}
+
+// runCommand starts the command and waits for it to exit
+func runCommand(cmd Command) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// commandOutput runs the command and returns its combined stdout and stderr
+func (r *Recoverer) commandOutput(ctx context.Context, name string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := runCommand(r.command(ctx, CommandSpec{Name: name, Args: args, Stdout: &out, Stderr: &out}))
+	return out.String(), err
+}
+
+// command returns the command created by the configured runner, ExecRunner is used if it's not set
+func (r *Recoverer) command(ctx context.Context, spec CommandSpec) Command {
+	if r.runner == nil {
+		return ExecRunner{}.Command(ctx, spec)
+	}
+	return r.runner.Command(ctx, spec)
+}
@@ -0,0 +1,190 @@
+package recoverer
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfigFile reads the config from a YAML or JSON file.
+// Environment variables which are set and not empty take precedence over the file values,
+// defaults of the env tags are used for values missing in both.
+func LoadConfigFile(path string) (Config, error) {
+	cfg := Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, errors.Wrap(err, "read config file")
+	}
+
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), true); err != nil {
+		return cfg, errors.Wrap(err, "set defaults")
+	}
+	// JSON is valid YAML, so both are parsed the same way
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, errors.Wrapf(err, "parse config file %s", path)
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), false); err != nil {
+		return cfg, errors.Wrap(err, "parse env")
+	}
+	return cfg, checkConfig(&cfg)
+}
+
+// LoadEnv reads the config from the environment. Unlike env.Parse, a storage
+// option required by STORAGE_TYPE may be set with PITR_STORAGE_URL instead.
+func LoadEnv() (Config, error) {
+	cfg := Config{}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), true); err != nil {
+		return cfg, errors.Wrap(err, "set defaults")
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), false); err != nil {
+		return cfg, errors.Wrap(err, "parse env")
+	}
+	return cfg, checkConfig(&cfg)
+}
+
+// checkConfig applies PITR_STORAGE_URL and checks the required options of the config and its storage
+func checkConfig(cfg *Config) error {
+	if err := checkRequired(*cfg); err != nil {
+		return err
+	}
+	if err := cfg.applyStorageURL(); err != nil {
+		return err
+	}
+	var err error
+	switch cfg.StorageType {
+	case "s3":
+		err = checkRequired(cfg.BinlogStorageS3)
+	case "azure":
+		err = checkRequired(cfg.BinlogStorageAzure)
+	case "filesystem":
+		err = checkRequired(cfg.BinlogStorageFilesystem)
+	case "":
+		if cfg.Source != SourceServer {
+			err = errors.New("STORAGE_TYPE is required")
+		}
+	default:
+		err = errors.New("unknown STORAGE_TYPE")
+	}
+	return err
+}
+
+// applyStorageURL sets STORAGE_TYPE inferred from the PITR_STORAGE_URL scheme if it's empty,
+// and the bucket URL, container path or directory of the storage if it isn't set
+func (c *Config) applyStorageURL() error {
+	if c.StorageURL == "" {
+		return nil
+	}
+	if c.StorageType == "" {
+		storageType, err := storageTypeFromURL(c.StorageURL)
+		if err != nil {
+			return errors.Wrap(err, "PITR_STORAGE_URL")
+		}
+		c.StorageType = storageType
+	}
+	switch c.StorageType {
+	case "s3":
+		if c.BinlogStorageS3.BucketURL == "" {
+			c.BinlogStorageS3.BucketURL = c.StorageURL
+		}
+	case "azure":
+		if c.BinlogStorageAzure.ContainerPath == "" {
+			c.BinlogStorageAzure.ContainerPath = c.StorageURL
+		}
+	case "filesystem":
+		if c.BinlogStorageFilesystem.Path == "" {
+			c.BinlogStorageFilesystem.Path = strings.TrimPrefix(c.StorageURL, "file://")
+		}
+	}
+	return nil
+}
+
+// storageTypeFromURL returns the storage type of the URL: s3 for "s3://", "gs://" and AWS hosts,
+// azure for "azure://" and Azure blob hosts, filesystem for "file://" and absolute paths.
+// Google Cloud Storage is used with its S3 compatible API, see gcsEndpoint.
+// Other http endpoints may be S3 compatible or Azure, so STORAGE_TYPE is required for them.
+func storageTypeFromURL(rawURL string) (string, error) {
+	if strings.HasPrefix(rawURL, "/") {
+		return "filesystem", nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "parse url")
+	}
+	switch u.Scheme {
+	case "s3", "gs":
+		return "s3", nil
+	case "azure":
+		return "azure", nil
+	case "file":
+		return "filesystem", nil
+	case "https", "http":
+		host := strings.ToLower(u.Hostname())
+		if awsHostRe.MatchString(host) {
+			return "s3", nil
+		}
+		if strings.HasSuffix(host, azureHostSuffix) {
+			return "azure", nil
+		}
+		return "", errors.Errorf("storage type of %s host %s is ambiguous, set STORAGE_TYPE", u.Scheme, u.Host)
+	case "":
+		return "", errors.Errorf("no scheme in %s, set STORAGE_TYPE or use s3://, gs://, azure://, file:// or https:// URL", rawURL)
+	default:
+		return "", errors.Errorf("unknown scheme %s, supported are s3, gs, azure, file, http and https", u.Scheme)
+	}
+}
+
+// applyEnv sets fields of the struct v and its nested structs from the environment.
+// With defaults only fields which environment variables are empty get their default values,
+// otherwise only fields which environment variables are set are changed.
+func applyEnv(v reflect.Value, defaults bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("env")
+		if tag == "" {
+			if f.Type.Kind() == reflect.Struct {
+				if err := applyEnv(v.Field(i), defaults); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		key, _, _ := strings.Cut(tag, ",")
+		set := os.Getenv(key) != ""
+		if defaults && (set || f.Tag.Get("envDefault") == "") || !defaults && !set {
+			continue
+		}
+		fieldTag := f.Tag
+		if defaults {
+			// without the env key only the default is parsed, even if the variable is set empty
+			fieldTag = reflect.StructTag(`envDefault:` + strconv.Quote(f.Tag.Get("envDefault")) + ` envSeparator:` + strconv.Quote(f.Tag.Get("envSeparator")))
+		}
+		// the field is parsed alone, so env handles its separator and type
+		single := reflect.New(reflect.StructOf([]reflect.StructField{{Name: f.Name, Type: f.Type, Tag: fieldTag}}))
+		if err := env.Parse(single.Interface()); err != nil {
+			return err
+		}
+		v.Field(i).Set(single.Elem().Field(0))
+	}
+	return nil
+}
+
+// checkRequired returns an error if a field of the struct v with the required env option is empty
+func checkRequired(v any) error {
+	rv := reflect.ValueOf(v)
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, opts, _ := strings.Cut(f.Tag.Get("env"), ",")
+		if opts == "required" && rv.Field(i).IsZero() {
+			return errors.Errorf("%s or %s in the config file is required", key, f.Tag.Get("yaml"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package recoverer
+
+import (
+	"context"
+	"log"
+
+	"mysql-pitr-helper/pxc"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	gapPolicyIgnore = "ignore" // gaps between the selected binlogs aren't checked
+	gapPolicyFail   = "fail"   // the recovery doesn't start if there is a gap
+	gapPolicyStop   = "stop"   // the recovery stops before the gap, only with latest recovery type
+)
+
+// gtidGap is a break in continuity of the selected binlogs
+type gtidGap struct {
+	index   int    // index of the binlog after the gap in r.binlogs
+	prev    string // binlog before the gap or "the backup"
+	binlog  string // binlog after the gap
+	missing string // transactions missing between prev and binlog
+}
+
+// firstGap chains gtid sets of the selected binlogs starting from the backup
+// and returns the first binlog which doesn't continue the transactions before it.
+// A source which first appears in the binlogs after its first transaction is a gap too,
+// the transactions before it are neither in the backup nor in the binlogs.
+// nil is returned if there are no gaps.
+func (r *Recoverer) firstGap(ctx context.Context) (*gtidGap, error) {
+	applied := pxc.NewGTIDSet(r.startGTID)
+	prev := "the backup"
+	for i, binlog := range r.binlogs {
+		set := r.binlogSets[binlog]
+		if set == "" {
+			continue
+		}
+		subset, err := r.db.GTIDSubset(ctx, set, applied.Raw())
+		if err != nil {
+			return nil, errors.Wrapf(err, "check if '%s' is a subset of '%s'", set, applied.Raw())
+		}
+		if subset {
+			prev = binlog
+			continue
+		}
+		newSet, err := r.db.SubtractGTIDSet(ctx, set, applied.Raw())
+		if err != nil {
+			return nil, errors.Wrapf(err, "subtract '%s' from '%s'", applied.Raw(), set)
+		}
+		union := applied.Union(pxc.NewGTIDSet(newSet))
+		unionGaps, appliedGaps := missingTransactions(union), missingTransactions(applied)
+		gap := unionGaps.Raw()
+		if !appliedGaps.IsEmpty() && !unionGaps.IsEmpty() {
+			// gaps which are already in the backup are not introduced by the binlogs
+			gap, err = r.db.SubtractGTIDSet(ctx, unionGaps.Raw(), appliedGaps.Raw())
+			if err != nil {
+				return nil, errors.Wrapf(err, "subtract '%s' from '%s'", appliedGaps.Raw(), unionGaps.Raw())
+			}
+		}
+		if gap != "" {
+			return &gtidGap{index: i, prev: prev, binlog: binlog, missing: gap}, nil
+		}
+		applied = union
+		prev = binlog
+	}
+	return nil, nil
+}
+
+// missingTransactions returns transactions missing in the set: gaps between
+// transactions of each source and transactions before the first one of it
+func missingTransactions(set pxc.GTIDSet) pxc.GTIDSet {
+	gaps, leading := set.Gaps(), set.Leading()
+	return gaps.Union(leading)
+}
+
+// trimToConsistent drops the selected binlogs starting from the first one
+// which doesn't chain from the transactions before it, so no gap is applied
+func (r *Recoverer) trimToConsistent(ctx context.Context) error {
+	gap, err := r.firstGap(ctx)
+	if err != nil {
+		return err
+	}
+	if gap == nil {
+		log.Println("no GTID gaps in the binlogs")
+		return nil
+	}
+	log.Printf("GTID gap between %s and %s, missing %s. Recovering up to %s", gap.prev, gap.binlog, gap.missing, gap.prev)
+	if gap.index == 0 {
+		return errors.Wrapf(ErrNoBinlogs, "first binlog %s doesn't chain from the backup, missing %s", gap.binlog, gap.missing)
+	}
+	r.binlogs = r.binlogs[:gap.index]
+	return nil
+}
+
+// checkGaps validates continuity of the selected binlogs according to the gap policy
+func (r *Recoverer) checkGaps(ctx context.Context) error {
+	switch r.gapPolicy {
+	case "", gapPolicyIgnore:
+		return nil
+	case gapPolicyStop:
+		return r.trimToConsistent(ctx)
+	}
+	gap, err := r.firstGap(ctx)
+	if err != nil {
+		return err
+	}
+	if gap == nil {
+		log.Println("no GTID gaps in the binlogs")
+		return nil
+	}
+	return errors.Wrapf(ErrGTIDGap, "between %s and %s, missing %s", gap.prev, gap.binlog, gap.missing)
+}
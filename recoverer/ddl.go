@@ -0,0 +1,104 @@
+package recoverer
+
+import (
+	"bytes"
+	"io"
+)
+
+// ddlStatements are the first keywords of statements dropped by PITR_SKIP_DDL
+var ddlStatements = [][]byte{[]byte("CREATE"), []byte("ALTER"), []byte("DROP"), []byte("TRUNCATE")}
+
+// mysqlbinlog ends each statement with this delimiter
+var statementDelimiter = []byte("/*!*/;")
+
+// emptyTransaction replaces a dropped statement, so the GTID set for it with
+// GTID_NEXT is consumed and the next transaction can set its own
+var emptyTransaction = []byte("BEGIN\n/*!*/;\nCOMMIT\n/*!*/;\n")
+
+// ddlFilter is a writer which drops DDL statements of mysqlbinlog output written to w.
+// It expects a line per write, as lineFilter does.
+//
+// Every statement starting with a DDL keyword is dropped, so are account management
+// statements like CREATE USER or DROP USER and CREATE TEMPORARY TABLE.
+//
+// Limitations: DDL is logged as statements in row-based binlogs too, so it's dropped
+// from both formats, but row events of later transactions aren't changed. They fail
+// if they need a table created or altered by a dropped statement, e.g. rows of
+// CREATE TABLE ... SELECT. Statements run by stored routines, triggers and events
+// aren't seen in the binlog and are replayed as is. A statement dropped inside an open
+// transaction, e.g. CREATE TEMPORARY TABLE logged between BEGIN and COMMIT, is replaced
+// with an empty transaction too: its BEGIN implicitly commits the statements before it,
+// and the rest of the transaction is applied with autocommit.
+type ddlFilter struct {
+	w       io.Writer
+	pending []byte // lines of the current statement
+	done    bool   // the delimiter is restored, the rest is the footer of the output
+	dropped int
+}
+
+func (f *ddlFilter) Write(line []byte) (int, error) {
+	trimmed := bytes.TrimRight(line, "\r\n")
+	if len(f.pending) == 0 {
+		if f.done || bytes.HasPrefix(trimmed, []byte("#")) || len(bytes.TrimSpace(trimmed)) == 0 ||
+			bytes.HasPrefix(trimmed, []byte("DELIMITER ")) || bytes.HasPrefix(trimmed, []byte("/*!")) && bytes.HasSuffix(trimmed, []byte("*/;")) {
+			f.done = f.done || bytes.Equal(trimmed, []byte("DELIMITER ;"))
+			return f.w.Write(line)
+		}
+	}
+	f.pending = append(f.pending, line...)
+	if !bytes.HasSuffix(trimmed, statementDelimiter) {
+		return len(line), nil
+	}
+	stmt := f.pending
+	f.pending = nil
+	if isDDL(stmt) {
+		f.dropped++
+		stmt = emptyTransaction
+	}
+	if _, err := f.w.Write(stmt); err != nil {
+		return 0, err
+	}
+	return len(line), nil
+}
+
+// Flush writes the last statement if it isn't terminated
+func (f *ddlFilter) Flush() error {
+	stmt := f.pending
+	f.pending = nil
+	if len(stmt) == 0 {
+		return nil
+	}
+	_, err := f.w.Write(stmt)
+	return err
+}
+
+// isDDL returns true if the statement starts with a DDL keyword after spaces and comments.
+// Executable comments are a part of the statement, e.g. /*!50001 CREATE ... */ isn't dropped.
+func isDDL(stmt []byte) bool {
+	return startsWithKeyword(stmt, ddlStatements)
+}
+
+// startsWithKeyword returns true if the statement starts with any of keywords after spaces and comments
+func startsWithKeyword(stmt []byte, keywords [][]byte) bool {
+	for {
+		stmt = bytes.TrimLeft(stmt, " \t\r\n")
+		if !bytes.HasPrefix(stmt, []byte("/*")) || bytes.HasPrefix(stmt, []byte("/*!")) {
+			break
+		}
+		end := bytes.Index(stmt, []byte("*/"))
+		if end < 0 {
+			return false
+		}
+		stmt = stmt[end+2:]
+	}
+	for _, kw := range keywords {
+		if len(stmt) > len(kw) && bytes.EqualFold(stmt[:len(kw)], kw) && isSpace(stmt[len(kw)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,36 @@
+package recoverer
+
+import (
+	"mysql-pitr-helper/pxc"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoBinlogs is returned if there are no binlogs to apply
+	ErrNoBinlogs = errors.New("no binlogs to apply")
+	// ErrTargetBeforeBackup is returned if the recovery target is already in the backup
+	ErrTargetBeforeBackup = errors.New("recovery target is before the backup")
+	// ErrBadGTIDFormat is returned if PITR_GTID or a GTID set is malformed
+	ErrBadGTIDFormat = pxc.ErrBadGTIDFormat
+	// ErrGTIDGap is returned if the selected binlogs don't chain and PITR_GAP_POLICY is fail
+	ErrGTIDGap = errors.New("GTID gap in the binlogs")
+	// ErrNonGTIDBinlog is returned if a GTID-based recovery needs a binlog written with gtid_mode=OFF
+	ErrNonGTIDBinlog = errors.New("binlog without GTIDs")
+	// ErrCrossDatabase is returned if a transaction changes a database of PITR_PARALLEL_DATABASES with another one
+	ErrCrossDatabase = errors.New("transaction changes several databases")
+	// ErrUnlistedDatabase is returned if PITR_PARALLEL_DATABASES is set and a transaction changes only other databases
+	ErrUnlistedDatabase = errors.New("transaction changes no database of PITR_PARALLEL_DATABASES")
+	// ErrWrongCluster is returned if no source of the archive is a source of the target's transactions
+	ErrWrongCluster = errors.New("archive does not belong to this cluster")
+	// ErrWrongRecoverType is returned for unknown PITR_RECOVERY_TYPE
+	ErrWrongRecoverType = errors.New("wrong recover type")
+	// ErrBackupNotRestored is returned by from-backup recovery if gtid_executed of the target doesn't contain the backup
+	ErrBackupNotRestored = errors.New("backup is not restored on the target")
+	// ErrFutureDate is returned if PITR_DATE is after the current time and PITR_ALLOW_FUTURE_DATE isn't set
+	ErrFutureDate = errors.New("recovery date is in the future")
+	// ErrStatementBinlog is returned if PITR_TABLES is set and a binlog has DML statements, it requires binlog_format=ROW
+	ErrStatementBinlog = errors.New("statement-based DML in the binlog, PITR_TABLES requires binlog_format=ROW")
+	// ErrCrossTable is returned if row events of a statement change tables of PITR_TABLES with other ones
+	ErrCrossTable = errors.New("statement changes tables of PITR_TABLES and other tables")
+)
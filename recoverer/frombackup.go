@@ -0,0 +1,74 @@
+package recoverer
+
+import (
+	"context"
+	"log"
+
+	"mysql-pitr-helper/storage"
+
+	"github.com/pkg/errors"
+)
+
+// resolveFromBackup reads the backup manifest and replaces FromBackup with the recover type
+// of the target: Date if PITR_DATE is set, Transaction if PITR_GTID is set, Latest otherwise.
+// PITR_BACKUP_GTID is used if there is no manifest in the storage.
+func (r *Recoverer) resolveFromBackup(ctx context.Context) error {
+	m, err := storage.ReadBackupManifest(ctx, r.storage)
+	switch {
+	case err == nil:
+		log.Printf("backup manifest %s: gtid set %s, taken at %s", storage.BackupManifestName, m.GTID, m.Timestamp.UTC().Format(recoverTimeFormats[0]))
+		r.backupGTID = m.GTID
+		r.backupTime = m.Timestamp.UTC()
+	case errors.Is(err, storage.ErrObjectNotFound):
+		if r.backupGTID == "" {
+			return errors.Errorf("no %s in the storage and PITR_BACKUP_GTID is not set", storage.BackupManifestName)
+		}
+		log.Printf("no %s in the storage, using PITR_BACKUP_GTID %s", storage.BackupManifestName, r.backupGTID)
+	default:
+		return errors.Wrap(err, "read backup manifest")
+	}
+	r.fromBackup = true
+
+	r.recoverType = fromBackupType(r.recoverTime, r.gtid)
+	if r.recoverType == Date && !r.backupTime.IsZero() {
+		target, err := parseRecoverTime(r.recoverTime)
+		if err != nil {
+			return errors.Wrap(err, "parse date")
+		}
+		if !target.After(r.backupTime) {
+			return errors.Wrapf(ErrTargetBeforeBackup, "date %s, backup is taken at %s", r.recoverTime, r.backupTime.Format(recoverTimeFormats[0]))
+		}
+	}
+	log.Printf("recovering from the backup with %s recovery type", r.recoverType)
+	return nil
+}
+
+// fromBackupType returns the recover type FromBackup is replaced with
+func fromBackupType(recoverTime, gtid string) RecoverType {
+	switch {
+	case recoverTime != "":
+		return Date
+	case gtid != "":
+		return Transaction
+	default:
+		return Latest
+	}
+}
+
+// checkBackupRestored refuses FromBackup recovery if the backup gtid set isn't a subset
+// of gtid_executed, e.g. the backup isn't restored yet. PITR_FORCE turns the error into a warning.
+func (r *Recoverer) checkBackupRestored(ctx context.Context) error {
+	restored, err := r.db.GTIDSubset(ctx, r.backupGTID, r.startGTID)
+	if err != nil {
+		return errors.Wrapf(err, "check if '%s' is a subset of '%s'", r.backupGTID, r.startGTID)
+	}
+	if restored {
+		return nil
+	}
+	err = errors.Wrapf(ErrBackupNotRestored, "backup gtid set %s, gtid_executed %s", r.backupGTID, r.startGTID)
+	if !r.force {
+		return err
+	}
+	log.Printf("WARNING: %v, proceeding because PITR_FORCE is set", err)
+	return nil
+}
@@ -0,0 +1,62 @@
+package recoverer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// logUploadTimeout limits the upload of the recovery log, so it doesn't delay the exit
+const logUploadTimeout = time.Minute
+
+// logCapture tees output of the standard logger to a buffer
+type logCapture struct {
+	buf  bytes.Buffer
+	prev io.Writer
+}
+
+func captureLogs() *logCapture {
+	c := &logCapture{prev: log.Writer()}
+	// the logger serializes writes, so the buffer isn't written concurrently
+	log.SetOutput(io.MultiWriter(c.prev, &c.buf))
+	return c
+}
+
+// stop restores the logger output and returns the captured log
+func (c *logCapture) stop() []byte {
+	log.SetOutput(c.prev)
+	return c.buf.Bytes()
+}
+
+// runWithLogUpload runs the recovery and uploads its log to the storage, failed upload is only logged
+func (r *Recoverer) runWithLogUpload(ctx context.Context) error {
+	logs := captureLogs()
+	// the captured log is redacted too, it's uploaded to the storage
+	err := r.redactedRun(ctx)
+	data := logs.stop()
+	if err != nil {
+		data = fmt.Appendf(data, "recovery failed: %v\n", err)
+	}
+
+	// the log is uploaded even if the recovery is interrupted
+	uploadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), logUploadTimeout)
+	defer cancel()
+	r.uploadLog(uploadCtx, data)
+	return err
+}
+
+func (r *Recoverer) uploadLog(ctx context.Context, data []byte) {
+	if r.storage == nil {
+		log.Println("WARNING: PITR_LOG_UPLOAD is set, but there is no storage to upload the recovery log to")
+		return
+	}
+	name := "recovery_" + r.now().UTC().Format("20060102T150405Z") + ".log"
+	if err := r.storage.PutObject(ctx, name, bytes.NewReader(data), int64(len(data))); err != nil {
+		log.Printf("WARNING: upload recovery log %s: %v", name, err)
+		return
+	}
+	log.Println("recovery log is uploaded to", name)
+}
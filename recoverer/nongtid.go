@@ -0,0 +1,62 @@
+package recoverer
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// nonGTIDBinlogMinSize is the size above which a binlog without a gtid set is considered
+// to have transactions. A binlog with only the format description, previous gtids and
+// rotate events is smaller unless the cluster had dozens of sources.
+const nonGTIDBinlogMinSize = 1024
+
+// nonGTIDBinlog returns the size of the binlog if it has no gtid set, but has transactions,
+// i.e. it was written with gtid_mode=OFF. Zero is returned for other binlogs.
+func (r *Recoverer) nonGTIDBinlog(ctx context.Context, binlog string) int64 {
+	if r.source != nil {
+		// the server reports gtid sets of its binlogs, it can't have binlogs without them
+		return 0
+	}
+	versionCtx, err := r.withBinlogVersion(ctx, binlog)
+	if err != nil {
+		log.Println("Can't get binlog object version. Name:", binlog, "error", err)
+		return 0
+	}
+	info, err := r.storage.StatObject(versionCtx, binlog)
+	if err != nil {
+		log.Println("Can't get binlog object size. Name:", binlog, "error", err)
+		return 0
+	}
+	if info.Size < nonGTIDBinlogMinSize {
+		return 0
+	}
+	return info.Size
+}
+
+// gtidBasedSelection returns true if the recovery selects transactions by their GTIDs,
+// so anonymous transactions of binlogs written with gtid_mode=OFF can't be handled
+func (r *Recoverer) gtidBasedSelection() bool {
+	switch r.recoverType {
+	case Transaction, Skip, StopBeforeGTID:
+		return true
+	}
+	return r.gtidUUIDFilter != ""
+}
+
+// checkNonGTIDBinlog returns an error if the binlog without GTIDs is needed by a GTID-based recovery,
+// other recovery types apply it in order of binlogs
+func (r *Recoverer) checkNonGTIDBinlog(binlog string, size int64) error {
+	if r.recoverType == Transaction && len(r.gtidSet) == 0 {
+		// the binlog is after the target transaction
+		return nil
+	}
+	if r.gtidBasedSelection() {
+		return errors.Wrapf(ErrNonGTIDBinlog, "binlog %s has %d bytes of transactions without a gtid set, it was probably written with gtid_mode=OFF."+
+			" %s recovery requires binlogs written with gtid_mode=ON", binlog, size, r.recoverType)
+	}
+	log.Printf("WARNING: binlog %s has %d bytes of transactions without a gtid set, it was probably written with gtid_mode=OFF."+
+		" It's applied in order of binlogs", binlog, size)
+	return nil
+}
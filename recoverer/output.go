@@ -0,0 +1,116 @@
+package recoverer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+
+	"mysql-pitr-helper/pxc"
+)
+
+// lineFilter is a writer which drops lines equal to any of skip
+// and writes the rest line by line to w
+type lineFilter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	skip [][]byte
+	buf  []byte
+}
+
+func newLineFilter(w io.Writer, skip ...string) *lineFilter {
+	f := &lineFilter{w: w}
+	for _, s := range skip {
+		f.skip = append(f.skip, []byte(s))
+	}
+	return f
+}
+
+func (f *lineFilter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := f.buf[:i+1]
+		f.buf = f.buf[i+1:]
+		if err := f.writeLine(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes the last line if it doesn't end with a newline
+func (f *lineFilter) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line := f.buf
+	f.buf = nil
+	if len(line) == 0 {
+		return nil
+	}
+	return f.writeLine(line)
+}
+
+func (f *lineFilter) writeLine(line []byte) error {
+	trimmed := bytes.TrimRight(line, "\r\n")
+	for _, s := range f.skip {
+		if bytes.Equal(trimmed, s) {
+			return nil
+		}
+	}
+	_, err := f.w.Write(line)
+	return err
+}
+
+// maxReportedErrors is a number of mysql errors included in the recovery summary
+const maxReportedErrors = 10
+
+// errorCounter counts mysql error lines written to w, the first of them are kept.
+// It expects a line per write, as lineFilter does.
+type errorCounter struct {
+	w      io.Writer
+	count  int
+	first  []string
+	client string // the first error of the mysql client itself, e.g. a lost connection
+}
+
+// clientErrorRe matches errors of the mysql client, their codes are 2000-2999 (CR_*).
+// They aren't failed statements, the rest of the input isn't applied after them.
+var clientErrorRe = regexp.MustCompile(`^ERROR 2\d{3}\b`)
+
+func (c *errorCounter) Write(line []byte) (int, error) {
+	if bytes.HasPrefix(line, []byte("ERROR ")) {
+		c.count++
+		if len(c.first) < maxReportedErrors {
+			c.first = append(c.first, string(bytes.TrimRight(line, "\r\n")))
+		}
+		if c.client == "" && clientErrorRe.Match(line) {
+			c.client = string(bytes.TrimRight(line, "\r\n"))
+		}
+	}
+	return c.w.Write(line)
+}
+
+// subprocessStderr returns the destination of mysql and mysqlbinlog stderr
+// without the warning about the password on the command line and with credentials redacted.
+// Flush must be called after the process is finished.
+func (r *Recoverer) subprocessStderr() *lineFilter {
+	w := r.stderr
+	if w == nil {
+		w = os.Stderr
+	}
+	return newLineFilter(redactWriter(w, r.redactor), pxc.UsingPassErrorMessage)
+}
+
+// subprocessStdout returns the destination of mysql stdout
+func (r *Recoverer) subprocessStdout() io.Writer {
+	if r.stdout == nil {
+		return os.Stdout
+	}
+	return r.stdout
+}
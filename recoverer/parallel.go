@@ -0,0 +1,261 @@
+package recoverer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"mysql-pitr-helper/pxc"
+
+	"github.com/pkg/errors"
+)
+
+type databaseKey struct{}
+
+// withDatabase returns a context which makes mysqlbinlog output only events of the database
+func withDatabase(ctx context.Context, database string) context.Context {
+	return context.WithValue(ctx, databaseKey{}, database)
+}
+
+func databaseFrom(ctx context.Context) string {
+	database, _ := ctx.Value(databaseKey{}).(string)
+	return database
+}
+
+// applyToSessions applies the binlog with each session and returns after all of them got it completely.
+// Sessions of PITR_PARALLEL_DATABASES decode the binlog concurrently, each with --database of its own,
+// so the order of transactions is kept within a database only. They're decoded with --skip-gtids:
+// every session sees every GTID, and the server would skip a transaction in all sessions but
+// the one committing its GTID first. The GTIDs are added by addParallelGTIDs after the replay.
+func (r *Recoverer) applyToSessions(ctx context.Context, binlog string, sessions []*replaySession, prog *progress) error {
+	apply := func(ctx context.Context, s *replaySession, prog *progress) error {
+		if s.database != "" {
+			ctx = withDatabase(ctx, s.database)
+		}
+		err := r.applyBinlog(ctx, binlog, s.out, prog)
+		if err == nil {
+			// the binlog is fed to mysql completely before it's recorded as applied
+			err = s.out.Flush()
+		}
+		if err != nil && s.database != "" {
+			return errors.Wrapf(err, "database %s", s.database)
+		}
+		return err
+	}
+	if len(sessions) == 1 {
+		return apply(ctx, sessions[0], prog)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		// every session reads the whole binlog, it's counted as applied once
+		p := prog
+		if i > 0 {
+			p = newProgress(0, r.now)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = apply(ctx, s, p)
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	gtidNextRe = regexp.MustCompile("^SET @@SESSION.GTID_NEXT= '([^']*)'")
+	tableMapRe = regexp.MustCompile("Table_map: `([^`]+)`\\.`")
+	useRe      = regexp.MustCompile("^use `([^`]+)`")
+)
+
+// queryHeader and queryStart are printed by mysqlbinlog for each query event,
+// the default database is printed between them if it's changed
+var (
+	queryHeader = []byte("\tQuery\tthread_id=")
+	queryStart  = []byte("SET TIMESTAMP=")
+)
+
+// databaseScanner collects databases changed by each transaction of mysqlbinlog output.
+// It expects a line per write, as lineFilter does.
+type databaseScanner struct {
+	databases []string // applied by separate sessions
+	binlog    string
+	gtid      string
+	tables    []string // databases of row events of the transaction
+	used      []string // default databases of query events of the transaction
+	current   string   // default database of the last query event
+	query     bool     // query event header is scanned, but not its statement
+	statement bool     // statement of a query event without the default database is expected
+	noDefault bool     // the transaction has a statement without the default database
+	err       error
+}
+
+func (s *databaseScanner) Write(p []byte) (int, error) {
+	line := bytes.TrimRight(p, "\r\n")
+	if m := gtidNextRe.FindSubmatch(line); m != nil {
+		s.endTransaction()
+		s.gtid = string(m[1])
+	} else if m := tableMapRe.FindSubmatch(line); m != nil {
+		s.tables = appendUnique(s.tables, string(m[1]))
+	} else if bytes.Contains(line, queryHeader) {
+		s.query = true
+	} else if m := useRe.FindSubmatch(line); m != nil {
+		s.current = string(m[1])
+	} else if s.query && bytes.HasPrefix(line, queryStart) {
+		s.query = false
+		if s.current != "" {
+			s.used = appendUnique(s.used, s.current)
+		} else {
+			s.statement = true
+		}
+	} else if s.statement && len(line) > 0 && !bytes.HasPrefix(line, []byte("SET ")) && !bytes.HasPrefix(line, []byte("/*!")) && !bytes.HasPrefix(line, []byte("#")) {
+		s.statement = false
+		switch string(bytes.TrimSuffix(line, []byte("/*!*/;"))) {
+		case "BEGIN", "COMMIT", "ROLLBACK":
+		default:
+			s.noDefault = true
+		}
+	}
+	return len(p), nil
+}
+
+// endTransaction checks the databases of the scanned transaction. The first transaction
+// changing a parallel database with another one is recorded in err, as well as the first one
+// not applied by any session: its GTID would be added to gtid_executed without its changes.
+func (s *databaseScanner) endTransaction() {
+	// row events have the database of each table, the default database of
+	// their BEGIN may be any. It matters only for statements.
+	changed := s.tables
+	if len(changed) == 0 {
+		changed = s.used
+	}
+	parallel := func(db string) bool { return slices.Contains(s.databases, db) }
+	switch {
+	case s.err != nil:
+	case len(changed) > 1 && slices.ContainsFunc(changed, parallel):
+		s.err = errors.Wrapf(ErrCrossDatabase, "transaction %s in %s changes databases %s", s.gtid, s.binlog, strings.Join(changed, ", "))
+	case len(changed) > 0 && !slices.ContainsFunc(changed, parallel):
+		s.err = errors.Wrapf(ErrUnlistedDatabase, "transaction %s in %s changes databases %s", s.gtid, s.binlog, strings.Join(changed, ", "))
+	case len(s.tables) == 0 && s.noDefault:
+		s.err = errors.Wrapf(ErrUnlistedDatabase, "transaction %s in %s has a statement without the default database", s.gtid, s.binlog)
+	}
+	s.gtid = ""
+	s.tables = nil
+	s.used = nil
+	s.statement = false
+	s.noDefault = false
+}
+
+func appendUnique(list []string, s string) []string {
+	if slices.Contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// checkCrossDatabase decodes the selected binlogs and fails if any transaction changes
+// a database of PITR_PARALLEL_DATABASES together with another database,
+// such a transaction would be split between sessions and committed partially.
+// It also fails if a transaction changes only other databases, no session would apply it.
+func (r *Recoverer) checkCrossDatabase(ctx context.Context) error {
+	for _, binlog := range r.binlogs {
+		scanner := &databaseScanner{databases: r.parallelDatabases, binlog: binlog}
+		out := newLineFilter(scanner)
+		if err := r.decodeBinlog(ctx, binlog, out, newProgress(0, r.now)); err != nil {
+			return errors.Wrapf(err, "decode %s", binlog)
+		}
+		// nolint:errcheck
+		out.Flush()
+		scanner.endTransaction()
+		if scanner.err != nil {
+			return scanner.err
+		}
+	}
+	return nil
+}
+
+// addParallelGTIDs adds transactions of the binlogs applied by the sessions of
+// PITR_PARALLEL_DATABASES to gtid_purged, since they're applied with --skip-gtids
+func (r *Recoverer) addParallelGTIDs(ctx context.Context) error {
+	var applied pxc.GTIDSet
+	for _, binlog := range r.appliedBinlogs {
+		set := pxc.NewGTIDSet(r.binlogSets[binlog])
+		if r.gtidUUIDFilter != "" {
+			set = set.Source(r.gtidUUIDFilter)
+		}
+		applied = applied.Union(set)
+	}
+	if applied.IsEmpty() {
+		return nil
+	}
+	currentGTID, err := r.targetDB().GetCurrentGTIDSet(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get current GTID")
+	}
+	added := applied.Raw()
+	if currentGTID != "" {
+		// transactions of the backup are in the binlogs overlapping it
+		added, err = r.db.SubtractGTIDSet(ctx, added, currentGTID)
+		if err != nil {
+			return errors.Wrapf(err, "subtract '%s' from '%s'", currentGTID, added)
+		}
+	}
+	if added == "" {
+		return nil
+	}
+	log.Println("adding gtid set applied by parallel sessions to gtid_purged:", added)
+	return r.targetDB().AddGTIDPurged(ctx, added)
+}
+
+// getParallelDatabases validates PITR_PARALLEL_DATABASES. Options which stop a binlog
+// in the middle, exclude transactions or rename databases aren't supported with it:
+// GTIDs of the whole binlogs are added to gtid_purged after the replay.
+func getParallelDatabases(c Config) ([]string, error) {
+	var databases []string
+	for _, db := range c.ParallelDatabases {
+		db = strings.TrimSpace(db)
+		if db == "" {
+			continue
+		}
+		if slices.Contains(databases, db) {
+			return nil, errors.Errorf("database %s is set twice", db)
+		}
+		databases = append(databases, db)
+	}
+	if len(databases) == 0 {
+		return nil, nil
+	}
+	if c.BinlogTimeoutPolicy == "skip" {
+		return nil, errors.New("can't be used with PITR_BINLOG_TIMEOUT_POLICY=skip, a binlog may be skipped by some of the sessions only")
+	}
+	if len(c.RewriteDB) > 0 {
+		return nil, errors.New("can't be used with PITR_REWRITE_DB")
+	}
+	switch RecoverType(c.RecoverType) {
+	case Latest, LatestConsistent, Count:
+	default:
+		return nil, errors.Errorf("can't be used with %s recovery type, only binlogs applied completely are supported", c.RecoverType)
+	}
+	return databases, nil
+}
@@ -0,0 +1,126 @@
+package recoverer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"mysql-pitr-helper/storage"
+)
+
+// progress tracks the amount of binlog data applied during recovery
+type progress struct {
+	total   int64 // total size of the selected binlog objects in the storage in bytes
+	applied atomic.Int64
+	start   time.Time
+	now     func() time.Time
+
+	// time range of the Date recovery, the progress in time isn't reported if targetEnd is 0
+	targetStart, targetEnd int64
+	covered                atomic.Int64 // unix time of the last applied event
+}
+
+// newProgress returns progress started at now()
+func newProgress(total int64, now func() time.Time) *progress {
+	return &progress{
+		total: total,
+		start: now(),
+		now:   now,
+	}
+}
+
+// reader returns a reader that counts the bytes read from r as applied
+func (p *progress) reader(r io.Reader) *countingReader {
+	return &countingReader{r: r, p: p}
+}
+
+// report logs the progress every interval until ctx is done
+func (p *progress) report(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.log("Recovery progress")
+		}
+	}
+}
+
+// setTarget sets the time range of the Date recovery, start is the time
+// of the first event of the first binlog and end is the recovery time
+func (p *progress) setTarget(start, end int64) {
+	if start <= 0 || end <= start {
+		return
+	}
+	p.targetStart, p.targetEnd = start, end
+	p.covered.Store(start)
+}
+
+// cover records that events up to ts are applied
+func (p *progress) cover(ts int64) {
+	p.covered.Store(min(ts, p.targetEnd))
+}
+
+func (p *progress) log(msg string) {
+	applied := p.applied.Load()
+	elapsed := p.now().Sub(p.start)
+	var throughput float64
+	if elapsed > 0 {
+		throughput = float64(applied) / 1024 / 1024 / elapsed.Seconds()
+	}
+	if p.total > 0 {
+		log.Printf("%s: %d of %d bytes applied (%.1f%%), %.2f MB/s, elapsed %s%s", msg, applied, p.total, float64(applied)*100/float64(p.total), throughput, elapsed.Round(time.Second), p.timeProgress(elapsed))
+		return
+	}
+	log.Printf("%s: %d bytes applied, %.2f MB/s, elapsed %s%s", msg, applied, throughput, elapsed.Round(time.Second), p.timeProgress(elapsed))
+}
+
+// timeProgress returns the time covered by the applied events and the ETA
+// of the Date recovery, it's empty for other recovery types
+func (p *progress) timeProgress(elapsed time.Duration) string {
+	if p.targetEnd == 0 {
+		return ""
+	}
+	covered := p.covered.Load()
+	fraction := float64(covered-p.targetStart) / float64(p.targetEnd-p.targetStart)
+	eta := "unknown"
+	if fraction > 0 {
+		eta = time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second).String()
+	}
+	return fmt.Sprintf(", covering %s of target %s (%.1f%%), ETA %s",
+		time.Unix(covered, 0).UTC().Format(recoverTimeFormats[0]), time.Unix(p.targetEnd, 0).UTC().Format(recoverTimeFormats[0]), fraction*100, eta)
+}
+
+// countingReader counts bytes of the stored object, so compressed binlogs
+// are counted in the same size as progress total
+type countingReader struct {
+	r io.Reader
+	p *progress
+	n int64 // bytes read by this reader
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	read := int64(n)
+	if cr, ok := c.r.(storage.CompressedReader); ok {
+		read = cr.CompressedBytes() - c.n
+	}
+	c.n += read
+	c.p.applied.Add(read)
+	return n, err
+}
+
+// discard removes bytes read by this reader from the applied bytes,
+// it's used when the data read wasn't applied and will be read again
+func (c *countingReader) discard() {
+	c.p.applied.Add(-c.n)
+	c.n = 0
+}
@@ -1,142 +1,643 @@
 package recoverer
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/url"
 	"os"
-	"os/exec"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"mysql-pitr-helper/metrics"
 	"mysql-pitr-helper/pxc"
 	"mysql-pitr-helper/storage"
 
 	"github.com/pkg/errors"
 )
 
+// database is a set of pxc.PXC methods used by the recoverer
+type database interface {
+	GetHost() string
+	GetCurrentGTIDSet(ctx context.Context) (string, error)
+	GetServerUUID(ctx context.Context) (string, error)
+	SubtractGTIDSet(ctx context.Context, set, subSet string) (string, error)
+	GTIDSubset(ctx context.Context, set1, set2 string) (bool, error)
+	AddGTIDPurged(ctx context.Context, set string) error
+	IsReadOnly(ctx context.Context) (bool, bool, error)
+	DisableReadOnly(ctx context.Context) error
+	DropCollectorFunctions(ctx context.Context) error
+	FlushLogs(ctx context.Context, engineLogs bool) error
+	ListBinLogs(ctx context.Context) ([]pxc.Binlog, error)
+	Close() error
+}
+
 type Recoverer struct {
-	db             *pxc.PXC
+	db             database // control connection
+	target         database // connection to the replay target, it's db if the control connection uses the target
 	recoverTime    string
 	storage        storage.Storage
-	host           string
+	host           string   // replay target
+	hosts          []string // cluster members to choose the control connection host from
 	user           string
 	pass           string
 	recoverType    RecoverType
 	binlogs        []string
+	binlogSets     map[string]string // gtid sets of the selected binlogs, keyed by binlog name
+	appliedBinlogs []string          // binlogs actually fed to mysql during recover
+	cutBinlog      string            // applied binlog which may be cut by --stop-datetime
 	gtidSet        string
 	startGTID      string
-	recoverFlag    string
+	selectionGTID  string   // gtid_executed the binlogs are selected against, it's startGTID unless it changed during the selection
+	recoverFlags   []string // mysqlbinlog options of the recover type
 	recoverEndTime time.Time
 	gtid           string
 	verifyTLS      bool
+	rewriteDB      []string // "old->new" database name pairs passed to mysqlbinlog --rewrite-db
+	rewriteDBFlags []string
+	checkpointFile string     // file where recovery checkpoint is stored, no checkpoints if empty
+	checkpoint     checkpoint // checkpoint of the interrupted recovery
+	disableRO      bool       // turn off read_only/super_read_only on the target before recovery
+	bufferBinlogs  bool       // decode each binlog to a temp file before applying it
+	binlogRetries  int        // number of decode retries of a buffered binlog
+	tmpDir         string     // directory for temp files
+	diskHeadroom   float64    // required free space in the temp dir relative to the largest binlog size
+
+	binlogsTotalSize int64  // total size of the selected binlogs
+	binlogPrefix     string // name prefix of binlog objects
+	gtidSetSuffix    string // name suffix of binlog gtid set sidecar objects
+	stopBinlog       string // binlog object to stop at in the Position and StopBeforeGTID modes
+	stopPosition     int64  // position in stopBinlog to stop at
+	binlogCount      int    // binlogs replayed in the Count mode
+
+	progressInterval time.Duration // how often recovery progress is logged
+	metricsAddr      string        // address of the metrics endpoint, metrics are not served if empty
+	timeout          time.Duration // upper bound of the whole recovery, no limit if 0
+	keepUDF          bool          // don't drop collector functions before recovery
+	flushEngineLogs  bool          // run FLUSH ENGINE LOGS after the replay
+	backupGTID       string        // gtid set of the full backup, used by VerifyBackups and FromBackup recovery
+	force            bool          // downgrade safety checks to warnings
+	binlogTimeout    time.Duration // timeout of decoding a single binlog, no limit if 0
+	skipTimedOut     bool          // skip binlogs which are timed out instead of aborting
+	skippedBinlogs   []string      // binlogs skipped because of the timeout
+
+	sidecarConcurrency int    // number of gtid set sidecars fetched at the same time
+	auditLogFile       string // file where applied binlogs are recorded, no audit log if empty
+
+	initSQL          []string      // statements written to the mysql session before the first binlog
+	sourceHost       string        // server to stream binlogs from, binlogs are read from the storage if empty
+	stdout           io.Writer     // destination of mysql stdout, os.Stdout if nil
+	snapshotDir      string        // directory of the pre-recovery snapshot, os.TempDir() if empty
+	snapshotFile     string        // file with the pre-recovery snapshot
+	stderr           io.Writer     // destination of mysql and mysqlbinlog stderr, os.Stderr if nil
+	source           binlogServer  // connection to sourceHost
+	pipeBuffer       int           // size of the buffer between mysqlbinlog and mysql in bytes
+	forceApply       bool          // run mysql with --force, so failed statements are skipped
+	selectionRetries int           // binlog selections repeated in Latest mode if gtid_executed changes meanwhile
+	gtidUUIDFilter   string        // only transactions of the source uuid are replayed in Latest mode
+	listRetries      int           // number of times a failed listing of objects is resumed
+	listBackoff      time.Duration // delay before the first resume, it's doubled after each retry
+	gapPolicy        string        // what to do with gaps between the selected binlogs
+	logUpload        bool          // upload the recovery log to the storage when the recovery ends
+	runner           CommandRunner // creates mysql and mysqlbinlog processes, ExecRunner is used if nil
+	clock            Clock         // source of the current time, RealClock is used if nil
+
+	skipDDL           bool          // DDL statements are dropped from the decoded binlogs
+	controlPort       int           // port of the control connection and the source server, pxc.AdminPort if 0
+	replayPort        int           // port of the mysql client applying the binlogs, pxc.AdminPort if 0
+	lookback          int           // binlogs scanned after the oldest one overlapping startGTID
+	reconnectAttempts int           // retries of a lost connection to the server
+	reconnectBackoff  time.Duration // delay before the first retry, doubled after each one
+
+	parallelDatabases []string // applied by a session each, the whole binlogs are applied by one session if empty
+
+	memberAddresses pxc.MemberAddresses                 // addresses of members in hosts by MEMBER_HOST
+	members         memberFilter                        // filters healthy members of hosts, pxc.HostChecker is used if nil
+	connect         func(host string) (database, error) // opens connections to the servers, pxc.PXC is used if nil
+
+	sink ApplySink // consumer of the decoded binlogs, MySQLSink if nil
+
+	binlogVersions map[string]string // version ids of binlogs in a versioned bucket, empty until fetched
+	versionsMu     sync.Mutex        // guards binlogVersions, sidecars are fetched concurrently
+	manifestSets   map[string]string // gtid sets of binlogs from the gtid set manifest
+	manifestUUIDs  []string          // server uuids of the source cluster from the gtid set manifest
+
+	fromBackup bool      // FromBackup recovery, the target is checked to be restored from the backup
+	backupTime time.Time // time of the backup from the backup manifest, zero if it's unknown
+
+	allowFutureDate   bool // PITR_DATE after the current time is a warning instead of an error
+	allowOtherCluster bool // an archive of another cluster is a warning instead of an error
+
+	tables []string // "db.table" names, row events of other tables are dropped if it's not empty
+
+	redactor *strings.Replacer // replaces credentials in the log and errors, nil if there are none
+
+	cacheDir  string        // directory of the archive cache, binlogs aren't cached if empty
+	archiveID string        // identifies the archive, its cache file is named by it
+	cache     *archiveCache // cache of the archive, nil until the binlogs are listed
+
+	skipMalformedSidecars bool // binlogs with malformed gtid sets are skipped with a warning instead of failing
 }
 
 type Config struct {
-	Host               string `env:"HOST,required"`
-	User               string `env:"USER,required"`
-	Pass               string `env:"PASS,required"`
-	RecoverTime        string `env:"PITR_DATE"`
-	RecoverType        string `env:"PITR_RECOVERY_TYPE,required"`
-	GTID               string `env:"PITR_GTID"`
-	VerifyTLS          bool   `env:"VERIFY_TLS" envDefault:"true"`
-	StorageType        string `env:"STORAGE_TYPE,required"`
-	BinlogStorageS3    BinlogS3
-	BinlogStorageAzure BinlogAzure
+	Host                string        `env:"HOST,required" yaml:"host"`
+	Hosts               []string      `env:"HOSTS" envSeparator:"," yaml:"hosts"` // cluster members to choose the control connection host from
+	User                string        `env:"USER,required" yaml:"user"`
+	Pass                string        `env:"PASS,required" yaml:"pass"`
+	RecoverTime         string        `env:"PITR_DATE" yaml:"recover_time"`
+	RecoverType         string        `env:"PITR_RECOVERY_TYPE" yaml:"recover_type"` // not used by VerifyBackups
+	GTID                string        `env:"PITR_GTID" yaml:"gtid"`                  // with transaction recovery type "+N" or "uuid:+N" is N transactions after gtid_executed
+	RewriteDB           []string      `env:"PITR_REWRITE_DB" envSeparator:"," yaml:"rewrite_db"`
+	CheckpointFile      string        `env:"PITR_CHECKPOINT_FILE" yaml:"checkpoint_file"` // mysql is started for each binlog, so the checkpoint is written after it applied the binlog
+	DisableReadOnly     bool          `env:"PITR_DISABLE_READ_ONLY" yaml:"disable_read_only"`
+	BufferBinlogs       bool          `env:"PITR_BUFFER_BINLOGS" yaml:"buffer_binlogs"`
+	BinlogRetries       int           `env:"PITR_BINLOG_RETRIES" envDefault:"3" yaml:"binlog_retries"` // used only with PITR_BUFFER_BINLOGS, timed out binlogs aren't retried
+	TmpDir              string        `env:"PITR_TMP_DIR" yaml:"tmp_dir"`
+	DiskHeadroom        float64       `env:"PITR_DISK_HEADROOM" envDefault:"3" yaml:"disk_headroom"` // decoded binlog is usually larger than the binary one
+	BinlogPrefix        string        `env:"PITR_BINLOG_PREFIX" envDefault:"binlog_" yaml:"binlog_prefix"`
+	GTIDSetSuffix       string        `env:"PITR_GTID_SET_SUFFIX" envDefault:"-gtid-set" yaml:"gtid_set_suffix"`
+	BinlogFile          string        `env:"PITR_BINLOG_FILE" yaml:"binlog_file"` // binlog object name in the storage
+	BinlogPos           int64         `env:"PITR_BINLOG_POS" yaml:"binlog_pos"`
+	BinlogCount         int           `env:"PITR_BINLOG_COUNT" yaml:"binlog_count"` // binlogs to replay after gtid_executed with count recovery type
+	VerifyTLS           bool          `env:"VERIFY_TLS" envDefault:"true" yaml:"verify_tls"`
+	StorageType         string        `env:"STORAGE_TYPE" yaml:"storage_type"`    // not used with PITR_SOURCE=server
+	StorageURL          string        `env:"PITR_STORAGE_URL" yaml:"storage_url"` // bucket URL, container path or directory of the storage, STORAGE_TYPE is inferred from its scheme if empty
+	ProgressInterval    time.Duration `env:"PITR_PROGRESS_INTERVAL" envDefault:"30s" yaml:"progress_interval"`
+	MaxBytesPerSec      int64         `env:"STORAGE_MAX_BYTES_PER_SEC" yaml:"max_bytes_per_sec"` // download rate limit, no limit if 0
+	MetricsAddr         string        `env:"PITR_METRICS_ADDR" yaml:"metrics_addr"`
+	Timeout             time.Duration `env:"PITR_TIMEOUT" yaml:"timeout"`   // no limit if 0
+	KeepUDF             bool          `env:"PITR_KEEP_UDF" yaml:"keep_udf"` // keep collector functions on the target and the PITR_SOURCE=server host
+	FlushEngineLogs     bool          `env:"PITR_FLUSH_ENGINE_LOGS" yaml:"flush_engine_logs"`
+	BackupGTID          string        `env:"PITR_BACKUP_GTID" yaml:"backup_gtid"`
+	Force               bool          `env:"PITR_FORCE" yaml:"force"`                                                    // allows to restore to a transaction before the backup, without the backup restored or an archive of another cluster
+	BinlogTimeout       time.Duration `env:"PITR_BINLOG_TIMEOUT" yaml:"binlog_timeout"`                                  // no limit if 0
+	BinlogTimeoutPolicy string        `env:"PITR_BINLOG_TIMEOUT_POLICY" envDefault:"abort" yaml:"binlog_timeout_policy"` // abort or skip
+	SidecarConcurrency  int           `env:"PITR_SIDECAR_CONCURRENCY" envDefault:"8" yaml:"sidecar_concurrency"`
+	Compression         string        `env:"PITR_COMPRESSION" yaml:"compression"`                              // objects with the .lz4 suffix are decompressed, lz4 also decompresses LZ4 frames without it, none disables it
+	AuditLog            string        `env:"PITR_AUDIT_LOG" yaml:"audit_log"`                                  // file to record applied binlogs and GTIDs
+	InitSQL             []string      `env:"PITR_INIT_SQL" envSeparator:"\n" yaml:"init_sql"`                  // session statements run before the replay, one per line
+	InitSQLFile         string        `env:"PITR_INIT_SQL_FILE" yaml:"init_sql_file"`                          // file with a statement per line, added to PITR_INIT_SQL
+	Source              string        `env:"PITR_SOURCE" envDefault:"storage" yaml:"source"`                   // storage or server
+	SourceHost          string        `env:"PITR_SOURCE_HOST" yaml:"source_host"`                              // required with PITR_SOURCE=server
+	SnapshotDir         string        `env:"PITR_SNAPSHOT_DIR" yaml:"snapshot_dir"`                            // directory of the pre-recovery snapshot file
+	PipeBuffer          int           `env:"PITR_PIPE_BUFFER" envDefault:"1048576" yaml:"pipe_buffer"`         // bytes buffered between mysqlbinlog and mysql, unbuffered if 0
+	ForceApply          bool          `env:"PITR_FORCE_APPLY" yaml:"force_apply"`                              // continue after failed statements, they are counted in the summary
+	SelectionRetries    int           `env:"PITR_SELECTION_RETRIES" envDefault:"1" yaml:"selection_retries"`   // used only with latest recovery type
+	Lookback            int           `env:"PITR_LOOKBACK" yaml:"lookback"`                                    // older binlogs scanned after the one overlapping gtid_executed, for archives not ordered by GTIDs
+	GTIDUUIDFilter      string        `env:"PITR_GTID_UUID_FILTER" yaml:"gtid_uuid_filter"`                    // source uuid to replay transactions of, used only with latest recovery type
+	ListRetries         int           `env:"PITR_LIST_RETRIES" envDefault:"3" yaml:"list_retries"`             // resumes of a throttled or failed storage listing
+	ListBackoff         time.Duration `env:"PITR_LIST_BACKOFF" envDefault:"1s" yaml:"list_backoff"`            // delay before the first resume, doubled after each one
+	GapPolicy           string        `env:"PITR_GAP_POLICY" envDefault:"ignore" yaml:"gap_policy"`            // ignore, fail or stop (only with latest recovery type)
+	LogUpload           bool          `env:"PITR_LOG_UPLOAD" yaml:"log_upload"`                                // upload the log as recovery_<time>.log next to the binlogs
+	ReconnectAttempts   int           `env:"PITR_RECONNECT_ATTEMPTS" envDefault:"5" yaml:"reconnect_attempts"` // retries of the lost control connection, so a restarted server doesn't fail the recovery
+	ReconnectBackoff    time.Duration `env:"PITR_RECONNECT_BACKOFF" envDefault:"1s" yaml:"reconnect_backoff"`  // delay before the first retry, doubled after each one
+	SkipDDL             bool          `env:"PITR_SKIP_DDL" yaml:"skip_ddl"`                                    // replace CREATE, ALTER, DROP and TRUNCATE statements with empty transactions, see ddlFilter
+	// ControlPort is used by the control connection and the source server, ReplayPort by the mysql client
+	// applying the binlogs, so the data doesn't go through the admin port with its restricted thread pool
+	ControlPort int `env:"PITR_CONTROL_PORT" envDefault:"33062" yaml:"control_port"`
+	ReplayPort  int `env:"PITR_REPLAY_PORT" envDefault:"3306" yaml:"replay_port"`
+	// MemberAddresses are "member=address" pairs matching MEMBER_HOST or MEMBER_HOST:MEMBER_PORT
+	// of the group with HOSTS, for members which report hostnames not used in it
+	MemberAddresses []string `env:"PITR_MEMBER_ADDRESSES" envSeparator:"," yaml:"member_addresses"`
+	// ParallelDatabases are applied by a session each, the recovery is refused if a transaction
+	// changes several databases or only other ones. It's supported with latest, latest-consistent
+	// and count recovery types, GTIDs of the applied binlogs are added to gtid_purged after the replay.
+	ParallelDatabases []string `env:"PITR_PARALLEL_DATABASES" envSeparator:"," yaml:"parallel_databases"`
+	// AllowOtherCluster allows to apply an archive which sources aren't sources of the target, see checkCluster.
+	// Unlike PITR_FORCE it doesn't downgrade other safety checks.
+	AllowOtherCluster bool `env:"PITR_ALLOW_OTHER_CLUSTER" yaml:"allow_other_cluster"`
+	// AllowFutureDate allows PITR_DATE after the current time, all the binlogs are applied then
+	AllowFutureDate bool `env:"PITR_ALLOW_FUTURE_DATE" yaml:"allow_future_date"`
+	// Tables are "db.table" names to recover, row events of other tables are dropped.
+	// It requires binlog_format=ROW, see tableFilter.
+	Tables []string `env:"PITR_TABLES" envSeparator:"," yaml:"tables"`
+	// CacheDir enables the local cache of sizes, gtid sets and last timestamps of the binlogs,
+	// so they're read from the storage only once, see archiveCache
+	CacheDir string `env:"PITR_CACHE_DIR" yaml:"cache_dir"`
+	// SkipMalformedSidecars skips binlogs which gtid sets are rejected by the server as
+	// malformed, e.g. of corrupt sidecars, with a warning. The recovery fails otherwise.
+	SkipMalformedSidecars bool `env:"PITR_SKIP_MALFORMED_SIDECARS" yaml:"skip_malformed_sidecars"`
+	// Stdout and Stderr are destinations of mysql and mysqlbinlog output, os.Stdout
+	// and os.Stderr are used if they're nil. They're written concurrently line by line.
+	Stdout io.Writer `yaml:"-"`
+	Stderr io.Writer `yaml:"-"`
+	// Runner creates mysql and mysqlbinlog processes, ExecRunner is used if it's nil
+	Runner CommandRunner `yaml:"-"`
+	// Sink is mysql to apply the binlogs to the target or stdout to write them decoded,
+	// ApplySink overrides it if it's not nil
+	Sink      string    `env:"PITR_SINK" envDefault:"mysql" yaml:"sink"`
+	ApplySink ApplySink `yaml:"-"`
+	// Clock is a source of the current time, RealClock is used if it's nil
+	Clock Clock `yaml:"-"`
+
+	BinlogStorageS3         BinlogS3         `yaml:"s3"`
+	BinlogStorageAzure      BinlogAzure      `yaml:"azure"`
+	BinlogStorageFilesystem BinlogFilesystem `yaml:"filesystem"`
 }
 
 func (c Config) storage(ctx context.Context) (storage.Storage, error) {
 	var binlogStorage storage.Storage
 	switch c.StorageType {
 	case "s3":
-		bucket, prefix, err := getBucketAndPrefix(c.BinlogStorageS3.BucketURL)
+		bucket, prefix, region, err := getBucketAndPrefix(c.BinlogStorageS3.BucketURL)
 		if err != nil {
 			return nil, errors.Wrap(err, "get bucket and prefix")
 		}
-		binlogStorage, err = storage.NewS3(ctx, c.BinlogStorageS3.Endpoint, c.BinlogStorageS3.AccessKeyID, c.BinlogStorageS3.AccessKey, bucket, prefix, c.BinlogStorageS3.Region, c.VerifyTLS)
+		if region == "" {
+			region = c.BinlogStorageS3.Region
+		} else if region != c.BinlogStorageS3.Region {
+			log.Printf("using region %s from the bucket URL instead of BINLOG_S3_REGION %s", region, c.BinlogStorageS3.Region)
+		}
+		var forcePathStyle *bool
+		if c.BinlogStorageS3.ForcePathStyle != "" {
+			v, err := strconv.ParseBool(c.BinlogStorageS3.ForcePathStyle)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse BINLOG_S3_FORCE_PATH_STYLE")
+			}
+			forcePathStyle = &v
+		}
+		binlogStorage, err = storage.NewS3WithOptions(ctx, &storage.S3Options{
+			Endpoint:        s3Endpoint(c.BinlogStorageS3.BucketURL, c.BinlogStorageS3.Endpoint),
+			AccessKeyID:     c.BinlogStorageS3.AccessKeyID,
+			SecretAccessKey: c.BinlogStorageS3.AccessKey,
+			BucketName:      bucket,
+			Prefix:          prefix,
+			Region:          region,
+			VerifyTLS:       c.VerifyTLS,
+			SessionToken:    c.BinlogStorageS3.SessionToken,
+			RoleARN:         c.BinlogStorageS3.RoleARN,
+			STSEndpoint:     c.BinlogStorageS3.STSEndpoint,
+			ForcePathStyle:  forcePathStyle,
+			DownloadParts:   c.BinlogStorageS3.DownloadParts,
+			CACert:          c.BinlogStorageS3.CACert,
+			SSE:             c.BinlogStorageS3.SSE,
+			SSEKMSKeyID:     c.BinlogStorageS3.SSEKMSKeyID,
+		})
 		if err != nil {
 			return nil, errors.Wrap(err, "new s3 storage")
 		}
 	case "azure":
-		var err error
-		container, prefix := getContainerAndPrefix(c.BinlogStorageAzure.ContainerPath)
-		binlogStorage, err = storage.NewAzure(c.BinlogStorageAzure.AccountName, c.BinlogStorageAzure.AccountKey, c.BinlogStorageAzure.Endpoint, container, prefix)
+		if c.BinlogStorageAzure.AccountKey == "" && c.BinlogStorageAzure.SASToken == "" && !c.BinlogStorageAzure.UseManagedIdentity {
+			return nil, errors.New("BINLOG_AZURE_ACCESS_KEY, BINLOG_AZURE_SAS_TOKEN or BINLOG_AZURE_USE_MANAGED_IDENTITY is required")
+		}
+		container, prefix, account, err := getContainerAndPrefix(c.BinlogStorageAzure.ContainerPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "get container and prefix")
+		}
+		if account == "" {
+			account = c.BinlogStorageAzure.AccountName
+		} else if account != c.BinlogStorageAzure.AccountName {
+			log.Printf("using storage account %s from the container URL instead of BINLOG_AZURE_STORAGE_ACCOUNT %s", account, c.BinlogStorageAzure.AccountName)
+		}
+		binlogStorage, err = storage.NewAzureWithOptions(ctx, &storage.AzureOptions{
+			StorageAccount:     account,
+			AccessKey:          c.BinlogStorageAzure.AccountKey,
+			SASToken:           c.BinlogStorageAzure.SASToken,
+			Endpoint:           c.BinlogStorageAzure.Endpoint,
+			Container:          container,
+			Prefix:             prefix,
+			UseManagedIdentity: c.BinlogStorageAzure.UseManagedIdentity,
+		})
 		if err != nil {
 			return nil, errors.Wrap(err, "new azure storage")
 		}
+	case "filesystem":
+		var err error
+		binlogStorage, err = storage.NewFilesystemWithOptions(&storage.FilesystemOptions{
+			Path:   c.BinlogStorageFilesystem.Path,
+			Prefix: c.BinlogStorageFilesystem.Prefix,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "new filesystem storage")
+		}
 	default:
 		return nil, errors.New("unknown STORAGE_TYPE")
 	}
-	return binlogStorage, nil
+	return storage.NewRateLimited(binlogStorage, c.MaxBytesPerSec), nil
 }
 
 type BinlogS3 struct {
-	Endpoint    string `env:"BINLOG_S3_ENDPOINT" envDefault:"s3.amazonaws.com"`
-	AccessKeyID string `env:"BINLOG_ACCESS_KEY_ID,required"`
-	AccessKey   string `env:"BINLOG_SECRET_ACCESS_KEY,required"`
-	Region      string `env:"BINLOG_S3_REGION,required"`
-	BucketURL   string `env:"BINLOG_S3_BUCKET_URL,required"`
+	Endpoint    string `env:"BINLOG_S3_ENDPOINT" envDefault:"s3.amazonaws.com" yaml:"endpoint"`
+	AccessKeyID string `env:"BINLOG_ACCESS_KEY_ID,required" yaml:"access_key_id"`
+	AccessKey   string `env:"BINLOG_SECRET_ACCESS_KEY,required" yaml:"secret_access_key"`
+	Region      string `env:"BINLOG_S3_REGION,required" yaml:"region"`
+	BucketURL   string `env:"BINLOG_S3_BUCKET_URL,required" yaml:"bucket_url"`
+
+	SessionToken string `env:"BINLOG_S3_SESSION_TOKEN" yaml:"session_token"`
+	RoleARN      string `env:"BINLOG_S3_ROLE_ARN" yaml:"role_arn"`
+	STSEndpoint  string `env:"BINLOG_S3_STS_ENDPOINT" yaml:"sts_endpoint"`
+	CACert       string `env:"BINLOG_S3_CA_CERT" yaml:"ca_cert"` // PEM content or a path to it
+
+	// ForcePathStyle is a bool, if it's empty path-style is used for all endpoints except AWS
+	ForcePathStyle string `env:"BINLOG_S3_FORCE_PATH_STYLE" yaml:"force_path_style"`
+
+	// DownloadParts is a number of concurrent ranged requests per large binlog
+	DownloadParts int `env:"BINLOG_S3_DOWNLOAD_PARTS" envDefault:"1" yaml:"download_parts"`
+
+	// SSE is a server-side encryption of written objects, AES256 (SSE-S3) or aws:kms (SSE-KMS).
+	// Reading binlogs doesn't need it, encrypted objects are decrypted by S3.
+	SSE         string `env:"BINLOG_S3_SSE" yaml:"sse"`
+	SSEKMSKeyID string `env:"BINLOG_S3_SSE_KMS_KEY_ID" yaml:"sse_kms_key_id"`
 }
 
 type BinlogAzure struct {
-	Endpoint      string `env:"BINLOG_AZURE_ENDPOINT,required"`
-	ContainerPath string `env:"BINLOG_AZURE_CONTAINER_PATH,required"`
-	StorageClass  string `env:"BINLOG_AZURE_STORAGE_CLASS"`
-	AccountName   string `env:"BINLOG_AZURE_STORAGE_ACCOUNT,required"`
-	AccountKey    string `env:"BINLOG_AZURE_ACCESS_KEY,required"`
+	Endpoint      string `env:"BINLOG_AZURE_ENDPOINT,required" yaml:"endpoint"`
+	ContainerPath string `env:"BINLOG_AZURE_CONTAINER_PATH,required" yaml:"container_path"`
+	StorageClass  string `env:"BINLOG_AZURE_STORAGE_CLASS" yaml:"storage_class"`
+	AccountName   string `env:"BINLOG_AZURE_STORAGE_ACCOUNT,required" yaml:"account_name"`
+	AccountKey    string `env:"BINLOG_AZURE_ACCESS_KEY" yaml:"account_key"`
+	SASToken      string `env:"BINLOG_AZURE_SAS_TOKEN" yaml:"sas_token"` // used when BINLOG_AZURE_ACCESS_KEY is empty
+
+	UseManagedIdentity bool `env:"BINLOG_AZURE_USE_MANAGED_IDENTITY" yaml:"use_managed_identity"` // used when BINLOG_AZURE_ACCESS_KEY and BINLOG_AZURE_SAS_TOKEN are empty
+}
+
+type BinlogFilesystem struct {
+	Path   string `env:"BINLOG_FILESYSTEM_PATH,required" yaml:"path"` // directory of the objects, e.g. a mounted volume
+	Prefix string `env:"BINLOG_FILESYSTEM_PREFIX" yaml:"prefix"`
 }
 
 func (c *Config) Verify() {
 	if len(c.BinlogStorageS3.Endpoint) == 0 {
-		c.BinlogStorageS3.Endpoint = "s3.amazonaws.com"
+		c.BinlogStorageS3.Endpoint = defaultS3Endpoint
+	}
+	if len(c.BinlogPrefix) == 0 {
+		c.BinlogPrefix = "binlog_"
+	}
+	if len(c.GTIDSetSuffix) == 0 {
+		c.GTIDSetSuffix = "-gtid-set"
 	}
 }
 
 type RecoverType string
 
+// New returns a recoverer of the config. Credentials of the config are redacted
+// from the log of New and the returned recoverer, and from their errors.
 func New(ctx context.Context, c Config) (*Recoverer, error) {
+	redactor := newRedactor(c.secrets())
+	defer redactLogs(redactor)()
+	r, err := newRecoverer(ctx, c)
+	if err != nil {
+		return nil, redactError(err, redactor)
+	}
+	r.redactor = redactor
+	return r, nil
+}
+
+func newRecoverer(ctx context.Context, c Config) (*Recoverer, error) {
 	c.Verify()
+	if err := c.applyStorageURL(); err != nil {
+		return nil, err
+	}
+
+	var binlogStorage storage.Storage
+	switch c.Source {
+	case "", SourceStorage:
+		c.SourceHost = ""
+	case SourceServer:
+		if c.SourceHost == "" {
+			return nil, errors.New("PITR_SOURCE_HOST is required with PITR_SOURCE=server")
+		}
+	default:
+		return nil, errors.Errorf("unknown PITR_SOURCE %s, expected storage or server", c.Source)
+	}
+	if c.SourceHost == "" || c.StorageType != "" {
+		var err error
+		binlogStorage, err = c.storage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "new binlog storage manager")
+		}
+		if c.MetricsAddr != "" {
+			binlogStorage = storage.NewMetered(binlogStorage)
+		}
+		binlogStorage, err = storage.NewDecompressing(binlogStorage, c.Compression)
+		if err != nil {
+			return nil, errors.Wrap(err, "PITR_COMPRESSION")
+		}
+	}
+
+	switch c.BinlogTimeoutPolicy {
+	case "", "abort":
+	case "skip":
+		if !c.BufferBinlogs {
+			// a timed out binlog can be skipped only if nothing of it was fed to mysql
+			log.Println("PITR_BINLOG_TIMEOUT_POLICY=skip enables PITR_BUFFER_BINLOGS")
+			c.BufferBinlogs = true
+		}
+	default:
+		return nil, errors.Errorf("unknown PITR_BINLOG_TIMEOUT_POLICY %s, expected abort or skip", c.BinlogTimeoutPolicy)
+	}
+
+	initSQL, err := getInitSQL(c.InitSQL, c.InitSQLFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse init sql")
+	}
+
+	// FromBackup recovery is Latest if neither PITR_DATE nor PITR_GTID is set
+	recoverType := RecoverType(c.RecoverType)
+	if recoverType == FromBackup {
+		recoverType = fromBackupType(c.RecoverTime, c.GTID)
+	}
+
+	switch c.GapPolicy {
+	case "", gapPolicyIgnore, gapPolicyFail:
+	case gapPolicyStop:
+		if recoverType != Latest {
+			return nil, errors.New("PITR_GAP_POLICY=stop is supported only with latest recovery type")
+		}
+	default:
+		return nil, errors.Errorf("unknown PITR_GAP_POLICY %s, expected ignore, fail or stop", c.GapPolicy)
+	}
+
+	gtidUUIDFilter := strings.ToLower(strings.TrimSpace(c.GTIDUUIDFilter))
+	if gtidUUIDFilter != "" {
+		if recoverType != Latest {
+			return nil, errors.New("PITR_GTID_UUID_FILTER is supported only with latest recovery type")
+		}
+		if !uuidRe.MatchString(gtidUUIDFilter) {
+			return nil, errors.Errorf("bad PITR_GTID_UUID_FILTER '%s', expected server uuid", c.GTIDUUIDFilter)
+		}
+	}
 
-	binlogStorage, err := c.storage(ctx)
+	parallelDatabases, err := getParallelDatabases(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "PITR_PARALLEL_DATABASES")
+	}
+	memberAddresses, err := pxc.ParseMemberAddresses(c.MemberAddresses)
+	if err != nil {
+		return nil, errors.Wrap(err, "PITR_MEMBER_ADDRESSES")
+	}
+	tables, err := getTables(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "PITR_TABLES")
+	}
+	if c.Lookback < 0 {
+		return nil, errors.Errorf("PITR_LOOKBACK %d is negative", c.Lookback)
+	}
+	sink, err := sinkFromConfig(c)
 	if err != nil {
-		return nil, errors.Wrap(err, "new binlog storage manager")
+		return nil, err
+	}
+	if sink != nil && len(parallelDatabases) > 0 {
+		// sessions of the databases would interleave their transactions in one stream
+		return nil, errors.New("PITR_PARALLEL_DATABASES can't be used with PITR_SINK other than mysql")
 	}
 
 	return &Recoverer{
 		storage:     binlogStorage,
 		recoverTime: c.RecoverTime,
 		host:        c.Host,
+		hosts:       c.Hosts,
 		user:        c.User,
 		pass:        c.Pass,
 		recoverType: RecoverType(c.RecoverType),
 		gtid:        c.GTID,
 		verifyTLS:   c.VerifyTLS,
+		rewriteDB:   c.RewriteDB,
+		disableRO:   c.DisableReadOnly,
+
+		bufferBinlogs:    c.BufferBinlogs,
+		binlogRetries:    c.BinlogRetries,
+		tmpDir:           c.TmpDir,
+		diskHeadroom:     c.DiskHeadroom,
+		binlogPrefix:     c.BinlogPrefix,
+		gtidSetSuffix:    c.GTIDSetSuffix,
+		stopBinlog:       c.BinlogFile,
+		stopPosition:     c.BinlogPos,
+		binlogCount:      c.BinlogCount,
+		checkpointFile:   c.CheckpointFile,
+		progressInterval: c.ProgressInterval,
+		metricsAddr:      c.MetricsAddr,
+		timeout:          c.Timeout,
+		keepUDF:          c.KeepUDF,
+		flushEngineLogs:  c.FlushEngineLogs,
+		backupGTID:       c.BackupGTID,
+		force:            c.Force,
+		binlogTimeout:    c.BinlogTimeout,
+		skipTimedOut:     c.BinlogTimeoutPolicy == "skip",
+
+		sidecarConcurrency: c.SidecarConcurrency,
+		auditLogFile:       c.AuditLog,
+		initSQL:            initSQL,
+		sourceHost:         c.SourceHost,
+		stdout:             c.Stdout,
+		snapshotDir:        c.SnapshotDir,
+		pipeBuffer:         c.PipeBuffer,
+		forceApply:         c.ForceApply,
+		selectionRetries:   c.SelectionRetries,
+		gtidUUIDFilter:     gtidUUIDFilter,
+		listRetries:        c.ListRetries,
+		listBackoff:        c.ListBackoff,
+		gapPolicy:          c.GapPolicy,
+		logUpload:          c.LogUpload,
+		parallelDatabases:  parallelDatabases,
+		skipDDL:            c.SkipDDL,
+		reconnectAttempts:  c.ReconnectAttempts,
+		reconnectBackoff:   c.ReconnectBackoff,
+		controlPort:        c.ControlPort,
+		memberAddresses:    memberAddresses,
+		replayPort:         c.ReplayPort,
+		lookback:           c.Lookback,
+		sink:               sink,
+		runner:             c.Runner,
+		clock:              c.Clock,
+		allowFutureDate:    c.AllowFutureDate,
+		allowOtherCluster:  c.AllowOtherCluster,
+		tables:             tables,
+		cacheDir:           c.CacheDir,
+		archiveID:          c.archiveID(),
+		stderr:             c.Stderr,
+
+		skipMalformedSidecars: c.SkipMalformedSidecars,
 	}, nil
 }
 
-func getContainerAndPrefix(s string) (string, string) {
-	container, prefix, _ := strings.Cut(s, "/")
+// azureHostSuffix is a suffix of Azure blob endpoint hosts "account.blob.core.windows.net"
+const azureHostSuffix = ".blob.core.windows.net"
+
+// getContainerAndPrefix parses "container/prefix", "azure://account/container/prefix"
+// and container URLs (e.g. SAS URLs copied from the portal), the query string is ignored.
+// The account is returned only if it's a part of the URL, otherwise it's empty.
+func getContainerAndPrefix(containerPath string) (container string, prefix string, account string, err error) {
+	u, err := url.Parse(containerPath)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "parse url")
+	}
+	path := u.Path
+	switch u.Scheme {
+	case "azure":
+		account = u.Host
+	case "https", "http":
+		if host := strings.ToLower(u.Hostname()); strings.HasSuffix(host, azureHostSuffix) {
+			account = strings.TrimSuffix(host, azureHostSuffix)
+		}
+	default:
+		path, _, _ = strings.Cut(containerPath, "?")
+	}
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")
+	container, prefix, _ = strings.Cut(path, "/")
 	if prefix != "" {
 		prefix += "/"
 	}
-	return container, prefix
+	if container == "" {
+		return "", "", "", errors.Errorf("can't get container name from %s", containerPath)
+	}
+	return container, prefix, account, nil
+}
+
+// uuidRe matches a lowercase server uuid
+var uuidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// awsHostRe matches AWS S3 hosts: virtual-hosted "bucket.s3.region.amazonaws.com",
+// legacy "bucket.s3-region.amazonaws.com" and path-style "s3.region.amazonaws.com"
+var awsHostRe = regexp.MustCompile(`^(?:(.+)\.)?s3(?:[.-]([a-z0-9-]+))?\.amazonaws\.com(?:\.cn)?$`)
+
+const (
+	defaultS3Endpoint = "s3.amazonaws.com"
+	// gcsEndpoint is the S3 compatible XML API of Google Cloud Storage, it's used for "gs://" bucket URLs
+	// with HMAC keys in BINLOG_ACCESS_KEY_ID and BINLOG_SECRET_ACCESS_KEY
+	gcsEndpoint = "storage.googleapis.com"
+)
+
+// s3Endpoint returns the endpoint of the bucket URL, gcsEndpoint for "gs://" URLs
+// unless BINLOG_S3_ENDPOINT is changed from the default
+func s3Endpoint(bucketURL, endpoint string) string {
+	if strings.HasPrefix(bucketURL, "gs://") && (endpoint == "" || endpoint == defaultS3Endpoint) {
+		return gcsEndpoint
+	}
+	return endpoint
 }
 
-func getBucketAndPrefix(bucketURL string) (bucket string, prefix string, err error) {
+// getBucketAndPrefix parses the bucket URL. The region is returned
+// only if it's a part of AWS hostname, otherwise it's empty.
+func getBucketAndPrefix(bucketURL string) (bucket string, prefix string, region string, err error) {
 	u, err := url.Parse(bucketURL)
 	if err != nil {
 		err = errors.Wrap(err, "parse url")
-		return bucket, prefix, err
+		return bucket, prefix, region, err
 	}
 	path := strings.TrimPrefix(strings.TrimSuffix(u.Path, "/"), "/")
 
-	if u.IsAbs() && u.Scheme == "s3" {
+	if u.IsAbs() && (u.Scheme == "s3" || u.Scheme == "gs") {
 		bucket = u.Host
 		prefix = path + "/"
-		return bucket, prefix, err
+		return bucket, prefix, region, err
+	}
+	if m := awsHostRe.FindStringSubmatch(strings.ToLower(u.Hostname())); u.IsAbs() && m != nil {
+		region = m[2]
+		if m[1] != "" {
+			bucket = m[1]
+			if path != "" {
+				prefix = path + "/"
+			}
+			return bucket, prefix, region, err
+		}
 	}
 	bucketArr := strings.Split(path, "/")
 	if len(bucketArr) > 1 {
@@ -145,10 +646,10 @@ func getBucketAndPrefix(bucketURL string) (bucket string, prefix string, err err
 	bucket = bucketArr[0]
 	if len(bucket) == 0 {
 		err = errors.Errorf("can't get bucket name from %s", bucketURL)
-		return bucket, prefix, err
+		return bucket, prefix, region, err
 	}
 
-	return bucket, prefix, err
+	return bucket, prefix, region, err
 }
 
 const (
@@ -156,19 +657,149 @@ const (
 	Date        RecoverType = "date"        // recover to exact date
 	Transaction RecoverType = "transaction" // recover to needed trunsaction
 	Skip        RecoverType = "skip"        // skip transactions
+	Position    RecoverType = "position"    // recover to the position in the binlog
+
+	StopBeforeGTID   RecoverType = "stop-before-gtid"  // recover everything before the transaction
+	LatestConsistent RecoverType = "latest-consistent" // recover to the last binlog before a GTID gap
+	Count            RecoverType = "count"             // recover the first PITR_BINLOG_COUNT binlogs after gtid_executed
+	FromBackup       RecoverType = "from-backup"       // recover from the backup of the backup manifest to PITR_DATE, PITR_GTID or the latest binlog
 )
 
 func (r *Recoverer) Run(ctx context.Context) error {
+	if r.logUpload {
+		return r.runWithLogUpload(ctx)
+	}
+	return r.redactedRun(ctx)
+}
+
+// redactedRun runs the recovery with credentials redacted from the log and the error
+func (r *Recoverer) redactedRun(ctx context.Context) error {
+	defer redactLogs(r.redactor)()
+	return redactError(r.run(ctx), r.redactor)
+}
+
+func (r *Recoverer) run(ctx context.Context) error {
+	if r.timeout > 0 {
+		// mysql and mysqlbinlog are started with this context, so they are killed on timeout
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	if r.metricsAddr != "" {
+		go func() {
+			log.Println("serving metrics on", r.metricsAddr)
+			if err := metrics.Serve(ctx, r.metricsAddr); err != nil {
+				log.Println("ERROR: serve metrics:", err)
+			}
+		}()
+	}
+
 	var err error
-	r.db, err = pxc.NewPXC(r.host, r.user, r.pass)
+	r.rewriteDBFlags, err = getRewriteDBFlags(r.rewriteDB)
 	if err != nil {
-		return errors.Wrapf(err, "new manager with host %s", r.host)
+		return errors.Wrap(err, "parse rewrite db")
+	}
+
+	if r.recoverType == "" {
+		return errors.New("PITR_RECOVERY_TYPE is required")
+	}
+	if r.recoverType == FromBackup {
+		err = r.resolveFromBackup(ctx)
+		if err != nil {
+			return errors.Wrap(err, "resolve backup")
+		}
+	}
+	if r.recoverType == Date {
+		err = r.checkRecoverTime()
+		if err != nil {
+			return errors.Wrap(err, "check date")
+		}
+	}
+	if r.recoverType == Position && (r.stopBinlog == "" || r.stopPosition <= 0) {
+		return errors.New("PITR_BINLOG_FILE and positive PITR_BINLOG_POS are required for position recovery")
+	}
+	if r.recoverType == Count && r.binlogCount <= 0 {
+		return errors.New("positive PITR_BINLOG_COUNT is required for count recovery")
+	}
+	if r.recoverType == Skip {
+		r.gtid, err = getSkipGTIDSet(r.gtid)
+		if err != nil {
+			return errors.Wrap(err, "parse transactions to skip")
+		}
 	}
 
-	r.startGTID, err = r.db.GetCurrentGTIDSet(ctx)
+	err = r.checkMysqlbinlog(ctx)
+	if err != nil {
+		return errors.Wrap(err, "check mysqlbinlog")
+	}
+
+	controlHost := r.host
+	if len(r.hosts) > 0 {
+		controlHost, err = r.controlHost(ctx)
+		if err != nil {
+			return errors.Wrap(err, "choose control host")
+		}
+	}
+
+	db, err := r.connectHost(controlHost)
+	if err != nil {
+		return errors.Wrapf(err, "new manager with host %s", controlHost)
+	}
+	r.db = db
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Println("ERROR: close connection:", err)
+		}
+	}()
+	err = r.connectTarget()
+	if err != nil {
+		return errors.Wrap(err, "connect replay target")
+	}
+
+	if r.sourceHost != "" {
+		source, err := pxc.NewPXCWithOptions(r.sourceHost, r.user, r.pass, r.connOptions())
+		if err != nil {
+			return errors.Wrapf(err, "new manager with source host %s", r.sourceHost)
+		}
+		r.source = source
+		log.Println("streaming binlogs from", r.sourceHost)
+		if !r.keepUDF {
+			defer r.dropSourceFunctions(ctx)
+		}
+	}
+
+	r.startGTID, err = r.targetDB().GetCurrentGTIDSet(ctx)
 	if err != nil {
 		return errors.Wrap(err, "get start GTID")
 	}
+	if r.fromBackup {
+		err = r.checkBackupRestored(ctx)
+		if err != nil {
+			return errors.Wrap(err, "check backup")
+		}
+	}
+
+	// the baseline is recorded before anything is changed on the server
+	r.snapshotFile, err = r.writeSnapshot(ctx)
+	if err != nil {
+		return errors.Wrap(err, "write pre-recovery snapshot")
+	}
+
+	if r.appliesToTarget() {
+		err = r.checkTargetReadOnly(ctx)
+		if err != nil {
+			return errors.Wrap(err, "check read only")
+		}
+	}
+
+	r.checkpoint, err = readCheckpoint(r.checkpointFile)
+	if err != nil {
+		return errors.Wrap(err, "read checkpoint")
+	}
+	if r.checkpoint.Binlog != "" {
+		log.Println("resuming recovery after", r.checkpoint.Binlog, "with gtid set", r.checkpoint.GTIDSet)
+	}
 
 	if r.recoverType == Transaction {
 		err = r.verifyTransactionInputGTID(ctx)
@@ -176,43 +807,158 @@ func (r *Recoverer) Run(ctx context.Context) error {
 			return errors.Wrap(err, "verify transaction num to restore")
 		}
 	}
+	if r.recoverType == StopBeforeGTID {
+		err = r.verifyStopGTID(ctx)
+		if err != nil {
+			return errors.Wrap(err, "verify transaction to stop before")
+		}
+	}
 
-	err = r.setBinlogs(ctx)
+	defer r.saveArchiveCache()
+	err = r.selectBinlogs(ctx)
+	if errors.Is(err, errCheckpointComplete) {
+		return r.finishCheckpoint(ctx, err)
+	}
 	if err != nil {
 		return errors.Wrap(err, "get binlog list")
 	}
 
+	err = r.checkCluster(ctx)
+	if err != nil {
+		return errors.Wrap(err, "check cluster identity")
+	}
+
+	if r.recoverType == LatestConsistent {
+		err = r.trimToConsistent(ctx)
+	} else {
+		err = r.checkGaps(ctx)
+	}
+	if err != nil {
+		return errors.Wrap(err, "check gtid continuity")
+	}
+
+	err = r.checkEncryptedBinlogs(ctx)
+	if err != nil {
+		return errors.Wrap(err, "check encrypted binlogs")
+	}
+
+	if r.recoverType == Position && !slices.Contains(r.binlogs, r.stopBinlog) {
+		return errors.Errorf("binlog %s is not found in the storage or it's before the backup", r.stopBinlog)
+	}
+	if r.recoverType == StopBeforeGTID {
+		err = r.setStopBeforeGTID(ctx)
+		if err != nil {
+			return errors.Wrap(err, "find transaction to stop before")
+		}
+	}
+
+	var largestSize int64
+	r.binlogsTotalSize, largestSize = r.binlogsSize(ctx)
+	log.Printf("%d binlogs selected, %d bytes total", len(r.binlogs), r.binlogsTotalSize)
+	if r.bufferBinlogs {
+		err = checkDiskSpace(r.tmpDir, int64(float64(largestSize)*r.diskHeadroom))
+		if err != nil {
+			return errors.Wrap(err, "check disk space")
+		}
+	}
+
 	switch r.recoverType {
 	case Skip:
-		r.recoverFlag = `--exclude-gtids="` + r.gtid + `"`
+		r.recoverFlags = []string{"--exclude-gtids=" + r.gtid}
 	case Transaction:
-		r.recoverFlag = `--exclude-gtids="` + r.gtidSet + `"`
+		r.recoverFlags = []string{"--exclude-gtids=" + r.gtidSet}
 	case Date:
-		r.recoverFlag = `--stop-datetime="` + r.recoverTime + `"`
-
-		const format = "2006-01-02 15:04:05"
-		endTime, err := time.Parse(format, r.recoverTime)
+		endTime, err := parseRecoverTime(r.recoverTime)
 		if err != nil {
 			return errors.Wrap(err, "parse date")
 		}
 		r.recoverEndTime = endTime
+		r.recoverFlags = []string{"--stop-datetime=" + endTime.Format(recoverTimeFormats[0])}
 	case Latest:
+		if r.gtidUUIDFilter != "" {
+			set := r.sourceGTIDSet()
+			log.Printf("replaying only transactions of %s: %s", r.gtidUUIDFilter, set)
+			r.recoverFlags = []string{"--include-gtids=" + set}
+		}
+	case LatestConsistent, Position, StopBeforeGTID, Count:
 	default:
-		return errors.New("wrong recover type")
+		return ErrWrongRecoverType
+	}
+
+	if len(r.parallelDatabases) > 0 {
+		log.Println("checking that transactions of the binlogs don't change several databases")
+		err = r.checkCrossDatabase(ctx)
+		if err != nil {
+			return errors.Wrap(err, "check parallel databases")
+		}
 	}
 
 	err = r.recover(ctx)
 	if err != nil {
-		return errors.Wrap(err, "recover")
+		return errors.Wrapf(err, "recover (gtid_executed before recovery %s is recorded in %s)", r.startGTID, r.snapshotFile)
 	}
 
 	return nil
 }
 
-func (r *Recoverer) recover(ctx context.Context) (err error) {
-	err = r.db.DropCollectorFunctions(ctx)
+var _ io.Closer = (*Recoverer)(nil)
+
+// Close closes the database connections. It's safe to call it several times.
+func (r *Recoverer) Close() error {
+	if r.source != nil {
+		err := r.source.Close()
+		r.source = nil
+		if err != nil {
+			return errors.Wrap(err, "close source database")
+		}
+	}
+	target := r.target
+	r.target = nil
+	if target != nil && target != r.db {
+		err := target.Close()
+		if err != nil {
+			return errors.Wrap(err, "close target database")
+		}
+	}
+	if r.db == nil {
+		return nil
+	}
+	err := r.db.Close()
+	r.db = nil
 	if err != nil {
-		return errors.Wrap(err, "drop collector funcs")
+		return errors.Wrap(err, "close database")
+	}
+	return nil
+}
+
+// connOptions returns settings of connections to the servers,
+// they're reconnected if a server restarts during the recovery
+func (r *Recoverer) connOptions() pxc.Options {
+	opts := pxc.DefaultOptions()
+	opts.ReconnectAttempts = r.reconnectAttempts
+	opts.ReconnectBackoff = r.reconnectBackoff
+	opts.Port = r.controlPort
+	return opts
+}
+
+func (r *Recoverer) recover(ctx context.Context) (err error) {
+	start := r.now()
+	defer func() {
+		metrics.RecoveryDuration.Set(r.now().Sub(start).Seconds())
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		metrics.Recoveries.With(result).Inc()
+	}()
+
+	// collector functions are created on demand, so they can be kept
+	// if the node is used for repeated operations
+	if !r.keepUDF {
+		err = r.targetDB().DropCollectorFunctions(ctx)
+		if err != nil {
+			return errors.Wrap(err, "drop collector funcs")
+		}
 	}
 
 	err = os.Setenv("MYSQL_PWD", r.pass)
@@ -220,97 +966,473 @@ func (r *Recoverer) recover(ctx context.Context) (err error) {
 		return errors.Wrap(err, "set mysql pwd env var")
 	}
 
-	mysqlStdin, binlogStdout := io.Pipe()
-	defer mysqlStdin.Close()
+	if r.forceApply {
+		log.Println("WARNING: PITR_FORCE_APPLY is set, failed statements are skipped")
+	}
+	databases := []string{""}
+	if len(r.parallelDatabases) > 0 {
+		databases = r.parallelDatabases
+		log.Printf("applying databases %s in parallel sessions", strings.Join(databases, ", "))
+	}
+	var sessions []*replaySession
+	defer func() {
+		for _, s := range sessions {
+			s.abort(err)
+		}
+	}()
+	sessions, err = r.startSessions(ctx, databases)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			// the checkpoint is written after each applied binlog,
+			// so a later run resumes after the last one committed
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = errors.Wrap(ctx.Err(), "recovery timed out")
+				return
+			}
+			err = errors.Wrap(ctx.Err(), "recovery cancelled")
+		}
+	}()
+
+	audit, err := openAuditLog(r.auditLogFile, r.now)
+	if err != nil {
+		return errors.Wrap(err, "open audit log")
+	}
+	defer audit.Close()
 
-	mysqlCmd := exec.CommandContext(ctx, "mysql", "-h", r.db.GetHost(), "-P", "33062", "-u", r.user)
-	log.Printf("Running %s", mysqlCmd.String())
-	mysqlCmd.Stdin = mysqlStdin
-	mysqlCmd.Stderr = os.Stderr
-	mysqlCmd.Stdout = os.Stdout
-	if err := mysqlCmd.Start(); err != nil {
-		return errors.Wrap(err, "start mysql")
+	prog := newProgress(r.binlogsTotalSize, r.now)
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	var lastTimestamps map[string]int64
+	if r.recoverType == Date && len(r.binlogs) > 0 {
+		lastTimestamps, err = r.binlogLastTimestamps(ctx)
+		if err != nil {
+			return err
+		}
+		// the name has the time of the first event
+		if start, err := nameTimestamp(r.binlogs[0]); err == nil {
+			prog.setTarget(start, r.recoverEndTime.Unix())
+		}
 	}
+	go prog.report(progressCtx, r.progressInterval)
 
+	// gtid_executed has the transactions of a binlog only after mysql applied all of it,
+	// so with checkpoints mysql is finished after each binlog and started again for the next one
+	checkpoints := r.checkpointFile != "" && r.appliesToTarget()
+	failed := 0
+	var firstFailed []string
+	udfWarned := false
 	for i, binlog := range r.binlogs {
+		if sessions == nil {
+			sessions, err = r.startSessions(ctx, databases)
+			if err != nil {
+				return err
+			}
+		}
 		remaining := len(r.binlogs) - i
 		log.Printf("working with %s, %d out of %d remaining\n", binlog, remaining, len(r.binlogs))
+		pastRecoverTime := false
+		// the binlog may have events after the recovery time if its last timestamp isn't known
+		mayBeCut := false
 		if r.recoverType == Date {
-			binlogArr := strings.Split(binlog, "_")
-			if len(binlogArr) < 2 {
-				return errors.New("get timestamp from binlog name")
-			}
-			binlogTime, err := strconv.ParseInt(binlogArr[1], 10, 64)
-			if err != nil {
-				return errors.Wrap(err, "get binlog time")
+			lastTs, ok := lastTimestamps[binlog]
+			mayBeCut = !ok
+			if ok {
+				// the binlog is cut by --stop-datetime, the next ones are not needed
+				pastRecoverTime = lastTs >= r.recoverEndTime.Unix()
+			} else {
+				// binlogs uploaded without the timestamp object
+				binlogTime, err := r.binlogTimestamp(ctx, binlog)
+				if errors.Is(err, pxc.ErrUDFMissing) {
+					// --stop-datetime still skips events after the recovery time,
+					// the binlog is only decoded needlessly
+					if !udfWarned {
+						log.Printf("WARNING: can't get binlog timestamps, applying all binlogs up to the recovery time: %v", err)
+						udfWarned = true
+					}
+				} else if err != nil {
+					return err
+				} else if binlogTime > r.recoverEndTime.Unix() {
+					log.Printf("Stopping at %s because it's after the recovery time (%d > %d)", binlog, binlogTime, r.recoverEndTime.Unix())
+					break
+				}
 			}
-			if binlogTime > r.recoverEndTime.Unix() {
-				log.Printf("Stopping at %s because it's after the recovery time (%d > %d)", binlog, binlogTime, r.recoverEndTime.Unix())
+		}
+
+		r.appliedBinlogs = append(r.appliedBinlogs, binlog)
+		metrics.CurrentBinlogIndex.Set(float64(i))
+
+		err = r.applyToSessions(ctx, binlog, sessions, prog)
+		if r.skipTimedOut && errors.Is(err, errBinlogTimeout) {
+			log.Printf("WARNING: skipping %s, its transactions are NOT applied: %v", binlog, err)
+			r.appliedBinlogs = r.appliedBinlogs[:len(r.appliedBinlogs)-1]
+			r.skippedBinlogs = append(r.skippedBinlogs, binlog)
+			err = nil
+			if pastRecoverTime || r.stopsAtPosition() && binlog == r.stopBinlog {
 				break
 			}
+			continue
 		}
-
-		binlogObj, err := r.storage.GetObject(ctx, binlog)
 		if err != nil {
-			return errors.Wrap(err, "get obj")
+			return errors.Wrapf(err, "apply %s", binlog)
+		}
+		if r.recoverType == Date {
+			r.cutBinlog = ""
+			if pastRecoverTime || mayBeCut {
+				r.cutBinlog = binlog
+			}
+		}
+		metrics.BinlogsApplied.Inc()
+		if err := audit.binlog(binlog, r.binlogSets[binlog]); err != nil {
+			return errors.Wrap(err, "write audit log")
+		}
+		if ts, ok := lastTimestamps[binlog]; ok {
+			prog.cover(ts)
 		}
 
-		cmd := exec.CommandContext(ctx, "sh", "-c", "mysqlbinlog --disable-log-bin "+r.recoverFlag+" -")
-		log.Printf("Running %s", cmd.String())
-		cmd.Stdin = binlogObj
-		cmd.Stdout = binlogStdout
-		cmd.Stderr = os.Stderr
-		err = cmd.Run()
-		if err != nil {
-			return errors.Wrapf(err, "run mysqlbinlog")
+		if checkpoints {
+			n, first, err := finishSessions(sessions)
+			if err != nil {
+				return err
+			}
+			sessions = nil
+			failed += n
+			firstFailed = append(firstFailed, first...)
+			gtidSet, err := r.targetDB().GetCurrentGTIDSet(ctx)
+			if err != nil {
+				return errors.Wrap(err, "get current GTID for checkpoint")
+			}
+			if err := writeCheckpoint(r.checkpointFile, checkpoint{Binlog: binlog, GTIDSet: gtidSet}); err != nil {
+				return errors.Wrap(err, "write checkpoint")
+			}
 		}
-	}
 
-	if err := binlogStdout.Close(); err != nil {
-		return errors.Wrap(err, "close binlog stdout")
+		if r.stopsAtPosition() && binlog == r.stopBinlog {
+			log.Printf("Stopping at %s position %d", binlog, r.stopPosition)
+			break
+		}
+		if pastRecoverTime {
+			log.Printf("Stopping after %s because its last event is not before the recovery time", binlog)
+			break
+		}
 	}
 
 	log.Printf("Waiting for mysql to finish")
 
-	if err := mysqlCmd.Wait(); err != nil {
-		return errors.Wrap(err, "wait mysql")
+	n, first, err := finishSessions(sessions)
+	if err != nil {
+		return err
+	}
+	failed += n
+	firstFailed = append(firstFailed, first...)
+	stopProgress()
+	prog.log("Recovery summary")
+	if failed > 0 {
+		log.Printf("WARNING: %d statements failed and were skipped because of PITR_FORCE_APPLY, the first of them:\n%s", failed, strings.Join(firstFailed, "\n"))
+	}
+	if len(r.skippedBinlogs) > 0 {
+		log.Printf("WARNING: %d binlogs were skipped because of PITR_BINLOG_TIMEOUT: %s", len(r.skippedBinlogs), strings.Join(r.skippedBinlogs, ", "))
 	}
 
-	log.Printf("Finished")
+	if len(r.parallelDatabases) > 0 {
+		if err := r.addParallelGTIDs(ctx); err != nil {
+			return errors.Wrap(err, "add gtid set of parallel sessions")
+		}
+	}
 
-	return nil
+	if !r.appliesToTarget() {
+		log.Printf("Finished, the decoded binlogs are written to %T, gtid_executed of the target isn't changed", r.sink)
+		return nil
+	}
+
+	if err := r.flushLogs(ctx); err != nil {
+		return errors.Wrap(err, "recovery is not guaranteed to be durable")
+	}
+
+	if err := r.verifyRecovery(ctx); err != nil {
+		return errors.Wrap(err, "verify recovery")
+	}
+
+	if audit != nil {
+		gtidSet, err := r.targetDB().GetCurrentGTIDSet(ctx)
+		if err != nil {
+			return errors.Wrap(err, "get current GTID for audit log")
+		}
+		if err := audit.finished(gtidSet); err != nil {
+			return errors.Wrap(err, "write audit log")
+		}
+	}
+
+	if err := removeCheckpoint(r.checkpointFile); err != nil {
+		return errors.Wrap(err, "remove checkpoint")
+	}
+
+	log.Printf("Finished, gtid_executed before recovery %s is recorded in %s", r.startGTID, r.snapshotFile)
+
+	return nil
+}
+
+var (
+	binlogMagic          = []byte{0xfe, 0x62, 0x69, 0x6e} // "\xfebin" plain binary log file header
+	encryptedBinlogMagic = []byte{0xfd, 0x62, 0x69, 0x6e} // "\xfdbin" encrypted binary log file header
+)
+
+// checkEncryptedBinlogs reads headers of the selected binlogs and fails
+// if any of them is encrypted, since mysqlbinlog can't decrypt binlog files
+// read from stdin. Binlogs uploaded by the collector are read from the server
+// with --read-from-remote-server, so they are already decrypted.
+func (r *Recoverer) checkEncryptedBinlogs(ctx context.Context) error {
+	if r.source != nil {
+		// the server decrypts binlogs read with --read-from-remote-server
+		return nil
+	}
+	for _, binlog := range r.binlogs {
+		versionCtx, err := r.withBinlogVersion(ctx, binlog)
+		if err != nil {
+			return errors.Wrap(err, "get binlog object version")
+		}
+		// only the header is requested, not the whole binlog
+		obj, err := r.storage.GetObject(storage.WithHead(versionCtx, int64(len(binlogMagic))), binlog)
+		if err != nil {
+			return errors.Wrapf(err, "get %s", binlog)
+		}
+		header := make([]byte, len(binlogMagic))
+		_, err = io.ReadFull(obj, header)
+		obj.Close()
+		if err != nil {
+			return errors.Wrapf(err, "read %s header", binlog)
+		}
+		if bytes.Equal(header, encryptedBinlogMagic) {
+			return errors.Errorf("binlog %s is encrypted: mysqlbinlog can't decrypt it without the keyring, collect binlogs with --read-from-remote-server from a server that has the keyring configured", binlog)
+		}
+		if !bytes.Equal(header, binlogMagic) {
+			log.Printf("WARNING: binlog %s has unexpected header %x", binlog, header)
+		}
+	}
+	return nil
+}
+
+// binlogsSize returns the total size of the selected binlogs and the size of the largest one.
+// Objects which size can't be determined are not counted.
+func (r *Recoverer) binlogsSize(ctx context.Context) (total int64, largest int64) {
+	if r.source != nil {
+		return 0, 0
+	}
+	for _, binlog := range r.binlogs {
+		size, err := r.binlogSize(ctx, binlog)
+		if err != nil {
+			log.Println("Can't get binlog object size. Name:", binlog, "error", err)
+			continue
+		}
+		total += size
+		if size > largest {
+			largest = size
+		}
+	}
+	return total, largest
+}
+
+// binlogSize returns the size of the binlog object, cached if the archive cache is used
+func (r *Recoverer) binlogSize(ctx context.Context, binlog string) (int64, error) {
+	version, err := r.binlogVersion(ctx, binlog)
+	if err != nil {
+		return 0, errors.Wrap(err, "get binlog object version")
+	}
+	if e, ok := r.cache.entry(binlog, version); ok && e.Size != nil {
+		return *e.Size, nil
+	}
+	info, err := r.storage.StatObject(storage.WithVersionID(ctx, version), binlog)
+	if err != nil {
+		return 0, err
+	}
+	r.cache.set(binlog, version, func(e *cachedBinlog) { e.Size = &info.Size })
+	return info.Size, nil
+}
+
+// archiveBehind returns transactions of gtidExecuted which aren't in the newest archived binlog,
+// starting from its first transaction of each source. Sources which aren't in the binlog are ignored.
+func archiveBehind(gtidExecuted, newestSet string) string {
+	executed := pxc.NewGTIDSet(gtidExecuted)
+	newest := pxc.NewGTIDSet(newestSet)
+	after := executed.From(newest)
+	delta := after.Subtract(newest)
+	return delta.Raw()
+}
+
+// hasSourceTransactions returns false if PITR_GTID_UUID_FILTER is set
+// and the gtid set has no transactions of the source
+func (r *Recoverer) hasSourceTransactions(gtidSet string) bool {
+	if r.gtidUUIDFilter == "" {
+		return true
+	}
+	set := pxc.NewGTIDSet(gtidSet)
+	filtered := set.Source(r.gtidUUIDFilter)
+	return !filtered.IsEmpty()
+}
+
+// sourceGTIDSet returns transactions of the PITR_GTID_UUID_FILTER source in the selected binlogs
+func (r *Recoverer) sourceGTIDSet() string {
+	var result pxc.GTIDSet
+	for _, binlog := range r.binlogs {
+		set := pxc.NewGTIDSet(r.binlogSets[binlog])
+		result = result.Union(set.Source(r.gtidUUIDFilter))
+	}
+	return result.Raw()
+}
+
+// selectBinlogs selects binlogs and checks that gtid_executed didn't change meanwhile.
+// The cluster keeps committing, so in Latest mode the selection is repeated
+// with the new gtid_executed up to selectionRetries times to pick up binlogs uploaded during it.
+// startGTID stays the gtid_executed recorded in the snapshot, the retries use selectionGTID.
+func (r *Recoverer) selectBinlogs(ctx context.Context) error {
+	r.selectionGTID = r.startGTID
+	for attempt := 1; ; attempt++ {
+		if err := r.setBinlogs(ctx); err != nil {
+			return err
+		}
+		currentGTID, err := r.targetDB().GetCurrentGTIDSet(ctx)
+		if err != nil {
+			return errors.Wrap(err, "get current GTID after binlog selection")
+		}
+		if sameGTIDSet(currentGTID, r.selectionGTID) {
+			return nil
+		}
+		if r.recoverType != Latest || attempt > r.selectionRetries {
+			log.Printf("WARNING: gtid_executed changed from %s to %s during binlog selection", r.selectionGTID, currentGTID)
+			return nil
+		}
+		log.Printf("gtid_executed changed from %s to %s during binlog selection, selecting binlogs again (retry %d of %d)",
+			r.selectionGTID, currentGTID, attempt, r.selectionRetries)
+		r.selectionGTID = currentGTID
+	}
+}
+
+// selectedGTID returns gtid_executed the binlogs are selected against
+func (r *Recoverer) selectedGTID() string {
+	if r.selectionGTID != "" {
+		return r.selectionGTID
+	}
+	return r.startGTID
 }
 
 func (r *Recoverer) setBinlogs(ctx context.Context) error {
-	list, err := r.storage.ListObjects(ctx, "binlog_")
+	executed := r.selectedGTID()
+	list, err := r.listBinlogs(ctx)
 	if err != nil {
-		return errors.Wrap(err, "list objects with prefix 'binlog_'")
+		return err
 	}
+	r.useArchiveCache(list)
 	reverse(list)
-	binlogs := []string{}
-	log.Println("current gtid set is", r.startGTID)
-	for _, binlog := range list {
-		if strings.Contains(binlog, "-gtid-set") {
+	candidates := []string{}
+	r.binlogVersions = make(map[string]string)
+	for _, name := range list {
+		if binlog, ok := strings.CutSuffix(name, versionIDSuffix); ok {
+			// the id is fetched when the binlog is needed
+			r.binlogVersions[binlog] = ""
 			continue
 		}
-		infoObj, err := r.storage.GetObject(ctx, binlog+"-gtid-set")
+		if !r.isSidecar(name) {
+			candidates = append(candidates, name)
+		}
+	}
+	if r.recoverType == Date && r.source == nil {
+		endTime, err := parseRecoverTime(r.recoverTime)
 		if err != nil {
-			log.Println("Can't get binlog object with gtid set. Name:", binlog, "error", err)
+			return errors.Wrap(err, "parse date")
+		}
+		total := len(candidates)
+		candidates = binlogsBeforeTime(candidates, endTime.Unix())
+		log.Printf("%d of %d binlogs are after the recovery time by name and not checked", total-len(candidates), total)
+	}
+	binlogs := []string{}
+	binlogSets := make(map[string]string)
+	skipped := 0
+	r.readManifest(ctx, candidates)
+	log.Println("current gtid set is", executed)
+	if executed == "" {
+		// nothing is in the backup, so every binlog is needed
+		log.Println("gtid_executed is empty, selecting binlogs from the beginning of the archive")
+	}
+	// sidecars are fetched concurrently, but evaluated in order of binlogs
+	sidecars := r.fetchSidecars(ctx, candidates)
+	defer sidecars.stop()
+	newestChecked := false
+	// binlogs left to scan after the oldest one overlapping startGTID, -1 until it's found
+	lookback := -1
+	for {
+		sc, ok := sidecars.next()
+		if !ok {
+			break
+		}
+		binlog := sc.binlog
+		if sc.getErr != nil {
+			// a missing gtid-set object is usually left by a crash between the uploads
+			log.Println("Can't get binlog object with gtid set. Name:", binlog, "error", sc.getErr)
 			continue
 		}
-		content, err := io.ReadAll(infoObj)
-		if err != nil {
-			return errors.Wrapf(err, "read %s gtid-set object", binlog)
+		if sc.err != nil {
+			return sc.err
 		}
-		binlogGTIDSet := string(content)
+		binlogGTIDSet := strings.TrimSpace(sc.gtidSet)
 		log.Println("checking current file", " name ", binlog, " gtid ", binlogGTIDSet)
 
+		if binlogGTIDSet == "" {
+			// the collector uploads an empty gtid set of a binlog written with gtid_mode=OFF
+			if nonGTIDSize := r.nonGTIDBinlog(ctx, binlog); nonGTIDSize > 0 {
+				if err := r.checkNonGTIDBinlog(binlog, nonGTIDSize); err != nil {
+					return err
+				}
+			}
+			// binlog without transactions can't overlap with any gtid set,
+			// so there's nothing to compare and it's always included
+			if r.recoverType == Transaction && len(r.gtidSet) == 0 || r.gtidUUIDFilter != "" || lookback >= 0 {
+				continue
+			}
+			log.Println("Binlog", binlog, "has empty gtid set")
+			binlogs = append(binlogs, binlog)
+			binlogSets[binlog] = binlogGTIDSet
+			continue
+		}
+
+		if !newestChecked {
+			newestChecked = true
+			if delta := archiveBehind(executed, binlogGTIDSet); delta != "" {
+				log.Printf("WARNING: the archive is behind the live cluster, gtid_executed has %s after the newest archived binlog %s with %s."+
+					" They are in the binlog which is still written and not uploaded yet", delta, binlog, binlogGTIDSet)
+			}
+		}
+
+		if lookback >= 0 {
+			applied, err := r.db.GTIDSubset(ctx, binlogGTIDSet, executed)
+			if err != nil {
+				skip, err := r.malformedSidecar(sc, binlogGTIDSet, err)
+				if skip {
+					continue
+				}
+				return errors.Wrapf(err, "check if '%s' is a subset of '%s'", binlogGTIDSet, executed)
+			}
+			if applied {
+				lookback--
+				if lookback == 0 {
+					break
+				}
+				continue
+			}
+			log.Printf("binlog %s older than the one overlapping gtid_executed has transactions missing in it, the archive isn't ordered by GTIDs", binlog)
+		}
+
 		if len(r.gtid) > 0 && r.recoverType == Transaction {
 			subResult, err := r.db.SubtractGTIDSet(ctx, binlogGTIDSet, r.gtid)
 			if err != nil {
+				skip, err := r.malformedSidecar(sc, binlogGTIDSet, err)
+				if skip {
+					continue
+				}
 				return errors.Wrapf(err, "check if '%s' is a subset of '%s", binlogGTIDSet, r.gtid)
 			}
-			if subResult != binlogGTIDSet {
+			if !sameGTIDSet(subResult, binlogGTIDSet) {
 				set, err := r.getExtendGTIDSet(ctx, binlogGTIDSet, r.gtid)
 				if err != nil {
 					return errors.Wrap(err, "get gtid set for extend")
@@ -322,36 +1444,433 @@ func (r *Recoverer) setBinlogs(ctx context.Context) error {
 			}
 		}
 
-		binlogs = append(binlogs, binlog)
-		subResult, err := r.db.SubtractGTIDSet(ctx, r.startGTID, binlogGTIDSet)
-		log.Println("Checking sub result", " binlog gtid ", binlogGTIDSet, " sub result ", subResult)
+		// the boundary is checked before the binlog is selected, so it's not selected
+		// if its gtid set is malformed
+		var subResult string
+		if executed != "" {
+			subResult, err = r.db.SubtractGTIDSet(ctx, executed, binlogGTIDSet)
+			log.Println("Checking sub result", " binlog gtid ", binlogGTIDSet, " sub result ", subResult)
+			if err != nil {
+				skip, err := r.malformedSidecar(sc, binlogGTIDSet, err)
+				if skip {
+					continue
+				}
+				return errors.Wrapf(err, "check if '%s' is a subset of '%s", executed, binlogGTIDSet)
+			}
+		}
+
+		covered, err := r.coveredByCheckpoint(ctx, binlogGTIDSet)
 		if err != nil {
-			return errors.Wrapf(err, "check if '%s' is a subset of '%s", r.startGTID, binlogGTIDSet)
+			skip, err := r.malformedSidecar(sc, binlogGTIDSet, err)
+			if skip {
+				continue
+			}
+			return errors.Wrapf(err, "check if '%s' is covered by checkpoint", binlog)
 		}
-		if subResult != r.startGTID {
-			break
+		if covered {
+			log.Println("Skipping binlog", binlog, "already applied according to checkpoint")
+			skipped++
+		} else if !r.hasSourceTransactions(binlogGTIDSet) {
+			log.Println("Skipping binlog", binlog, "without transactions of", r.gtidUUIDFilter)
+		} else {
+			binlogs = append(binlogs, binlog)
+			binlogSets[binlog] = binlogGTIDSet
+		}
+		if executed == "" {
+			continue
 		}
+		if !sameGTIDSet(subResult, executed) || lookback >= 0 {
+			if r.lookback == 0 {
+				break
+			}
+			// the boundary is confirmed if the next binlogs are already applied
+			lookback = r.lookback
+		}
+	}
+	if r.recoverType == Transaction && len(r.gtidSet) == 0 {
+		return errors.Wrapf(ErrNoBinlogs, "transaction %s is not in the archive", r.gtid)
+	}
+	if len(binlogs) == 0 && skipped > 0 {
+		return errors.Wrapf(errCheckpointComplete, "%d binlogs, checkpoint %s", skipped, r.checkpointFile)
 	}
 	if len(binlogs) == 0 {
-		return errors.Errorf("no objects for prefix binlog_ or with gtid=%s", r.gtid)
+		return errors.Wrapf(ErrNoBinlogs, "no objects for prefix %s or with gtid=%s", r.binlogPrefix, r.gtid)
 	}
 	reverse(binlogs)
+	binlogs, err = r.pruneApplied(ctx, binlogs, binlogSets)
+	if err != nil {
+		return errors.Wrap(err, "prune applied binlogs")
+	}
+	if r.recoverType == Count {
+		binlogs, err = r.firstBinlogs(ctx, binlogs, binlogSets)
+		if err != nil {
+			return err
+		}
+	}
 	r.binlogs = binlogs
+	r.binlogSets = binlogSets
 
 	return nil
 }
 
+// pruneApplied removes leading binlogs which transactions are all in gtid_executed,
+// so only binlogs with transactions missing on the target are replayed.
+// Pruning stops at a binlog without GTIDs, it may have data of gtid_mode=OFF.
+// The newest binlog is kept, so a recovery with nothing to apply still succeeds.
+func (r *Recoverer) pruneApplied(ctx context.Context, binlogs []string, sets map[string]string) ([]string, error) {
+	executed := r.selectedGTID()
+	if executed == "" {
+		return binlogs, nil
+	}
+	for len(binlogs) > 1 {
+		set := sets[binlogs[0]]
+		if set == "" {
+			break
+		}
+		applied, err := r.db.GTIDSubset(ctx, set, executed)
+		if err != nil {
+			return nil, errors.Wrapf(err, "check if '%s' is a subset of '%s'", set, executed)
+		}
+		if !applied {
+			break
+		}
+		log.Println("Skipping binlog", binlogs[0], "with transactions already in gtid_executed:", set)
+		delete(sets, binlogs[0])
+		binlogs = binlogs[1:]
+	}
+	return binlogs, nil
+}
+
+// firstBinlogs returns the first binlogCount of binlogs left by pruneApplied.
+// ErrNoBinlogs is returned if fewer binlogs have transactions missing on the target.
+func (r *Recoverer) firstBinlogs(ctx context.Context, binlogs []string, sets map[string]string) ([]string, error) {
+	available := len(binlogs)
+	executed := r.selectedGTID()
+	// the newest binlog is kept by pruneApplied even if it's applied
+	if set := sets[binlogs[0]]; available == 1 && executed != "" && set != "" {
+		applied, err := r.db.GTIDSubset(ctx, set, executed)
+		if err != nil {
+			return nil, errors.Wrapf(err, "check if '%s' is a subset of '%s'", set, executed)
+		}
+		if applied {
+			available = 0
+		}
+	}
+	if available < r.binlogCount {
+		return nil, errors.Wrapf(ErrNoBinlogs, "PITR_BINLOG_COUNT is %d, but only %d binlogs are after gtid_executed", r.binlogCount, available)
+	}
+	for _, binlog := range binlogs[r.binlogCount:] {
+		delete(sets, binlog)
+	}
+	return binlogs[:r.binlogCount], nil
+}
+
+// flushLogs makes the server flush its logs after the replay,
+// so the replayed transactions are durable before they're verified
+func (r *Recoverer) flushLogs(ctx context.Context) error {
+	return r.targetDB().FlushLogs(ctx, r.flushEngineLogs)
+}
+
+// verifyRecovery checks that gtid_executed on the restored node contains
+// every transaction that was expected to be applied from the binlogs.
+func (r *Recoverer) verifyRecovery(ctx context.Context) error {
+	currentGTID, err := r.targetDB().GetCurrentGTIDSet(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get current GTID")
+	}
+
+	appliedSet := currentGTID
+	if r.startGTID != "" {
+		appliedSet, err = r.db.SubtractGTIDSet(ctx, currentGTID, r.startGTID)
+		if err != nil {
+			return errors.Wrapf(err, "subtract '%s' from '%s'", r.startGTID, currentGTID)
+		}
+	}
+	log.Println("applied gtid set is", appliedSet)
+
+	verifyBinlogs := r.appliedBinlogs
+	switch r.recoverType {
+	case Transaction:
+	case Date:
+		// the binlog with the recovery time is cut by --stop-datetime, the other
+		// applied ones are expected completely. The last applied binlog isn't it
+		// if the one with the recovery time was skipped or the archive ends before it.
+		verifyBinlogs = slices.DeleteFunc(slices.Clone(verifyBinlogs), func(binlog string) bool {
+			return binlog == r.cutBinlog
+		})
+	default:
+		return nil
+	}
+
+	sets := []string{}
+	for _, binlog := range verifyBinlogs {
+		if set := r.binlogSets[binlog]; len(set) > 0 {
+			sets = append(sets, set)
+		}
+	}
+	if len(sets) == 0 {
+		log.Println("Recovery verification passed: no transactions expected")
+		return nil
+	}
+
+	expectedSet := strings.Join(sets, ",")
+	if r.recoverType == Transaction && len(r.gtidSet) > 0 {
+		expectedSet, err = r.db.SubtractGTIDSet(ctx, expectedSet, r.gtidSet)
+		if err != nil {
+			return errors.Wrapf(err, "subtract '%s' from '%s'", r.gtidSet, expectedSet)
+		}
+	}
+
+	missingSet, err := r.db.SubtractGTIDSet(ctx, expectedSet, currentGTID)
+	if err != nil {
+		return errors.Wrapf(err, "subtract '%s' from '%s'", currentGTID, expectedSet)
+	}
+	if len(missingSet) > 0 {
+		log.Println("Recovery verification failed: missing gtid set", missingSet)
+		return errors.Errorf("gtid_executed is missing transactions: %s", missingSet)
+	}
+	log.Println("Recovery verification passed")
+
+	return nil
+}
+
+// controlHostTimeout is a timeout for evaluating a single host when the control host is chosen
+const controlHostTimeout = 10 * time.Second
+
+// memberFilter evaluates cluster members, it's implemented by pxc.HostChecker
+type memberFilter interface {
+	FilterHealthyClusterMembers(ctx context.Context, hosts []string) ([]string, error)
+	Close() error
+}
+
+// controlHost returns the host for the control connection. It's the replay
+// target if it's a healthy member of r.hosts, otherwise the first healthy one.
+// The control connection is used for GTID set functions, the state of the target
+// is queried with the connection to it, see targetDB.
+func (r *Recoverer) controlHost(ctx context.Context) (string, error) {
+	members := r.members
+	if members == nil {
+		members = pxc.NewHostChecker(r.user, r.pass, controlHostTimeout, 0).WithPort(r.controlPort).WithMemberAddresses(r.memberAddresses)
+	}
+	defer members.Close()
+	healthy, err := members.FilterHealthyClusterMembers(ctx, r.hosts)
+	if err != nil {
+		return "", errors.Wrap(err, "filter healthy cluster members")
+	}
+	if len(healthy) == 0 {
+		return "", errors.Errorf("no healthy members in %s", strings.Join(r.hosts, ","))
+	}
+	if slices.Contains(healthy, r.host) {
+		return r.host, nil
+	}
+	log.Printf("%s is not a healthy member of %s, using %s for the control connection", r.host, strings.Join(r.hosts, ","), healthy[0])
+	return healthy[0], nil
+}
+
+// connectHost opens a connection to the host
+func (r *Recoverer) connectHost(host string) (database, error) {
+	if r.connect != nil {
+		return r.connect(host)
+	}
+	db, err := pxc.NewPXCWithOptions(host, r.user, r.pass, r.connOptions())
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// connectTarget connects the replay target,
+// the control connection is reused if it's connected to the target
+func (r *Recoverer) connectTarget() error {
+	if r.db.GetHost() == r.host {
+		r.target = r.db
+		return nil
+	}
+	target, err := r.connectHost(r.host)
+	if err != nil {
+		return errors.Wrapf(err, "new manager with host %s", r.host)
+	}
+	r.target = target
+	return nil
+}
+
+// targetDB returns the connection to the replay target. Its gtid_executed is
+// the baseline and the result of the recovery, so it's never read from another member.
+func (r *Recoverer) targetDB() database {
+	if r.target == nil {
+		return r.db
+	}
+	return r.target
+}
+
+// checkTargetReadOnly checks read only mode of the replay target
+func (r *Recoverer) checkTargetReadOnly(ctx context.Context) error {
+	return r.checkReadOnly(ctx, r.targetDB())
+}
+
+// checkReadOnly fails if the target doesn't accept writes,
+// unless the recoverer is allowed to turn read only mode off.
+func (r *Recoverer) checkReadOnly(ctx context.Context, db database) error {
+	readOnly, superReadOnly, err := db.IsReadOnly(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get read only state")
+	}
+	if !readOnly && !superReadOnly {
+		return nil
+	}
+	if !r.disableRO {
+		return errors.Errorf("target %s is read only (read_only=%t, super_read_only=%t): disable read only mode or set PITR_DISABLE_READ_ONLY=true before recovering", r.host, readOnly, superReadOnly)
+	}
+	log.Printf("disabling read only mode on %s (read_only=%t, super_read_only=%t)", r.host, readOnly, superReadOnly)
+	if err := db.DisableReadOnly(ctx); err != nil {
+		return errors.Wrap(err, "disable read only")
+	}
+	return nil
+}
+
+// finishCheckpoint finishes the recovery interrupted after it applied all the binlogs.
+// The checkpoint is removed if gtid_executed of the target still has its transactions.
+func (r *Recoverer) finishCheckpoint(ctx context.Context, complete error) error {
+	applied, err := r.db.GTIDSubset(ctx, r.checkpoint.GTIDSet, r.startGTID)
+	if err != nil {
+		return errors.Wrapf(err, "check if '%s' is a subset of '%s'", r.checkpoint.GTIDSet, r.startGTID)
+	}
+	if !applied {
+		return errors.Errorf("%v, but gtid_executed %s doesn't contain the checkpoint gtid set %s", complete, r.startGTID, r.checkpoint.GTIDSet)
+	}
+	if err := removeCheckpoint(r.checkpointFile); err != nil {
+		return errors.Wrap(err, "remove checkpoint")
+	}
+	log.Printf("Finished, nothing to apply: %v", complete)
+	return nil
+}
+
+// coveredByCheckpoint checks if all transactions of the binlog gtid set
+// were applied by the interrupted recovery
+func (r *Recoverer) coveredByCheckpoint(ctx context.Context, binlogGTIDSet string) (bool, error) {
+	if r.checkpoint.GTIDSet == "" || binlogGTIDSet == "" {
+		return false, nil
+	}
+	subResult, err := r.db.SubtractGTIDSet(ctx, binlogGTIDSet, r.checkpoint.GTIDSet)
+	if err != nil {
+		return false, errors.Wrapf(err, "subtract '%s' from '%s'", r.checkpoint.GTIDSet, binlogGTIDSet)
+	}
+	return subResult == "", nil
+}
+
+// sameGTIDSet reports whether a and b are the same GTID set ignoring its formatting.
+// Malformed sets are compared as strings.
+func sameGTIDSet(a, b string) bool {
+	na, errA := pxc.NormalizeGTIDSet(a)
+	nb, errB := pxc.NormalizeGTIDSet(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return na == nb
+}
+
+// parseTransactionGTID parses "uuid:N" or "uuid:M-N" and returns the source id
+// and the transaction number N to stop at
+func parseTransactionGTID(gtid string) (string, int64, error) {
+	gtidSplit := strings.Split(gtid, ":")
+	if len(gtidSplit) != 2 || len(gtidSplit[0]) == 0 {
+		return "", 0, errors.Wrapf(ErrBadGTIDFormat, "transaction '%s'", gtid)
+	}
+	startStr, endStr, isRange := strings.Cut(gtidSplit[1], "-")
+	end, err := strconv.ParseInt(endStr, 10, 64)
+	if !isRange {
+		end, err = strconv.ParseInt(startStr, 10, 64)
+	}
+	if err != nil || end < 1 {
+		return "", 0, errors.Wrapf(ErrBadGTIDFormat, "transaction number '%s'", gtid)
+	}
+	if isRange {
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 1 || start > end {
+			return "", 0, errors.Wrapf(ErrBadGTIDFormat, "transaction range '%s'", gtid)
+		}
+	}
+	return gtidSplit[0], end, nil
+}
+
+// getSkipGTIDSet parses comma or space separated "uuid:N" entries
+// and returns the merged gtid set for --exclude-gtids
+func getSkipGTIDSet(value string) (string, error) {
+	entries := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(entries) == 0 {
+		return "", errors.Wrap(ErrBadGTIDFormat, "PITR_GTID is empty")
+	}
+	var set pxc.GTIDSet
+	for _, entry := range entries {
+		if _, _, err := parseTransactionGTID(entry); err != nil {
+			return "", err
+		}
+		gtid, err := pxc.ParseGTIDSet(entry)
+		if err != nil {
+			return "", errors.Wrapf(err, "transaction '%s'", entry)
+		}
+		set = set.Union(gtid)
+	}
+	return set.Raw(), nil
+}
+
+// resolveRelativeGTID replaces PITR_GTID "+N" or "uuid:+N", meaning N transactions after
+// the backup, with the absolute transaction. Without the uuid the source with the most
+// transactions in gtid_executed is used. Other values are returned as is.
+func resolveRelativeGTID(gtid, startGTID string) (string, bool, error) {
+	gtid = strings.TrimSpace(gtid)
+	sourceID, offset := "", gtid
+	if i := strings.LastIndex(gtid, ":"); i >= 0 {
+		sourceID, offset = gtid[:i], gtid[i+1:]
+	}
+	if !strings.HasPrefix(offset, "+") {
+		return gtid, false, nil
+	}
+	n, err := strconv.ParseInt(offset[1:], 10, 64)
+	if err != nil || n < 1 {
+		return "", false, errors.Wrapf(ErrBadGTIDFormat, "relative transaction '%s', expected +N with positive N", gtid)
+	}
+	start := pxc.NewGTIDSet(startGTID)
+	if sourceID == "" {
+		sourceID = start.Primary()
+		if sourceID == "" {
+			return "", false, errors.Errorf("relative transaction '%s' requires the source uuid, gtid_executed is empty", gtid)
+		}
+	}
+	return fmt.Sprintf("%s:%d", sourceID, start.Last(sourceID)+n), true, nil
+}
+
+// verifyTransactionInputGTID validates PITR_GTID. If it's a range,
+// the upper bound is used as the transaction to stop at.
 func (r *Recoverer) verifyTransactionInputGTID(ctx context.Context) error {
-	gtidSplit := strings.Split(r.gtid, ":")
-	if len(gtidSplit) != 2 || strings.Contains(gtidSplit[1], "-") {
-		return errors.New("bad transaction num format")
+	gtid, relative, err := resolveRelativeGTID(r.gtid, r.startGTID)
+	if err != nil {
+		return err
+	}
+	if relative {
+		log.Printf("PITR_GTID %s is resolved to %s, gtid_executed is %s", r.gtid, gtid, r.startGTID)
+		r.gtid = gtid
+	}
+
+	sourceID, num, err := parseTransactionGTID(r.gtid)
+	if err != nil {
+		return err
+	}
+	r.gtid = fmt.Sprintf("%s:%d", sourceID, num)
+	if r.startGTID == "" {
+		return nil
 	}
 	subResult, err := r.db.SubtractGTIDSet(ctx, r.startGTID, r.gtid)
 	if err != nil {
 		return errors.Wrap(err, "transaction num is malformed or gtid subtract query exception occurred")
 	}
-	if subResult != r.startGTID {
-		return errors.New("can't restore to the transaction before backup")
+	if !sameGTIDSet(subResult, r.startGTID) {
+		if !r.force {
+			return errors.Wrap(ErrTargetBeforeBackup, "can't restore to the transaction before backup")
+		}
+		log.Printf("WARNING: transaction %s is before backup gtid set %s, proceeding because PITR_FORCE is set", r.gtid, r.startGTID)
 	}
 	return nil
 }
@@ -365,13 +1884,15 @@ func (r *Recoverer) getExtendGTIDSet(ctx context.Context, gtidSet, gtid string)
 		return "", errors.New("binlog contains multiple gtid records, can't exactly determine which to exclude")
 	}
 
-	gtidSplit := strings.Split(gtid, ":")
-	gtidNum, err := strconv.ParseInt(gtidSplit[1], 10, 64)
+	sourceID, gtidNum, err := parseTransactionGTID(gtid)
 	if err != nil {
 		return "", errors.Wrap(err, "parse gtid transaction num")
 	}
+	if gtidNum == 1 {
+		return gtidSet, nil
+	}
 
-	excludeSet, err := r.db.SubtractGTIDSet(ctx, gtidSet, fmt.Sprintf("%s:1-%v", gtidSplit[0], gtidNum-1))
+	excludeSet, err := r.db.SubtractGTIDSet(ctx, gtidSet, fmt.Sprintf("%s:1-%v", sourceID, gtidNum-1))
 	if err != nil {
 		return "", errors.Wrap(err, "failed to subtract gtid set")
 	}
@@ -379,6 +1900,159 @@ func (r *Recoverer) getExtendGTIDSet(ctx context.Context, gtidSet, gtid string)
 	return excludeSet, nil
 }
 
+// recoverTimeFormats are accepted PITR_DATE layouts, the first one is used for mysqlbinlog.
+// Zone abbreviations are ambiguous and time.Parse reads unknown ones as UTC,
+// so only numeric offsets are accepted, or an IANA zone after the time, see parseRecoverTime.
+var recoverTimeFormats = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseRecoverTime parses PITR_DATE trying each of recoverTimeFormats. A time without
+// an offset may be followed by an IANA zone, e.g. "2024-03-01 10:20:30 Europe/Berlin".
+// Times with a timezone are converted to UTC, fractional seconds are truncated.
+func parseRecoverTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	loc := time.UTC
+	if datetime, zone, ok := cutZoneName(value); ok {
+		l, err := time.LoadLocation(zone)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "load time zone of date '%s'", value)
+		}
+		value, loc = datetime, l
+	}
+	for _, format := range recoverTimeFormats {
+		t, err := time.ParseInLocation(format, value, loc)
+		if err == nil {
+			return t.UTC().Truncate(time.Second), nil
+		}
+	}
+	return time.Time{}, errors.Errorf("unknown date format '%s', accepted formats: %s, optionally followed by an IANA time zone", value, strings.Join(recoverTimeFormats, "; "))
+}
+
+// cutZoneName splits the trailing IANA zone name, e.g. Europe/Berlin or UTC,
+// from the date. Abbreviations like CET aren't zone names and aren't cut.
+func cutZoneName(value string) (string, string, bool) {
+	i := strings.LastIndexByte(value, ' ')
+	if i < 0 {
+		return value, "", false
+	}
+	zone := value[i+1:]
+	if zone != "UTC" && !strings.Contains(zone, "/") {
+		return value, "", false
+	}
+	return strings.TrimSpace(value[:i]), zone, true
+}
+
+// checkRecoverTime refuses PITR_DATE after the current time, no binlog is newer
+// than it and the recovery silently becomes the latest one
+func (r *Recoverer) checkRecoverTime() error {
+	endTime, err := parseRecoverTime(r.recoverTime)
+	if err != nil {
+		return errors.Wrap(err, "parse date")
+	}
+	now := r.now().UTC()
+	if !endTime.After(now) {
+		return nil
+	}
+	err = errors.Wrapf(ErrFutureDate, "date %s, current time %s", endTime.Format(recoverTimeFormats[0]), now.Format(recoverTimeFormats[0]))
+	if !r.allowFutureDate {
+		return err
+	}
+	log.Printf("WARNING: %v, all the binlogs are applied because PITR_ALLOW_FUTURE_DATE is set", err)
+	return nil
+}
+
+// stopsAtPosition returns true if the recovery stops at stopPosition of stopBinlog
+func (r *Recoverer) stopsAtPosition() bool {
+	return r.recoverType == Position || r.recoverType == StopBeforeGTID
+}
+
+// binlogArgs returns mysqlbinlog arguments for the binlog read from stdin or from the source server.
+// mysqlbinlog is started without a shell, so the values don't need quoting.
+func (r *Recoverer) binlogArgs(binlog string) []string {
+	args := []string{"--disable-log-bin"}
+	args = append(args, r.recoverFlags...)
+	args = append(args, r.rewriteDBFlags...)
+	if r.stopsAtPosition() && binlog == r.stopBinlog {
+		args = append(args, "--stop-position="+strconv.FormatInt(r.stopPosition, 10))
+	}
+	return append(args, r.readArgs(binlog)...)
+}
+
+// getRewriteDBFlags validates "old->new" pairs and returns
+// the corresponding --rewrite-db options for mysqlbinlog
+func getRewriteDBFlags(pairs []string) ([]string, error) {
+	var flags []string
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "->")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" || strings.Contains(to, "->") {
+			return nil, errors.Errorf("bad rewrite db format '%s', expected 'old->new'", pair)
+		}
+		flags = append(flags, "--rewrite-db="+from+"->"+to)
+	}
+	return flags, nil
+}
+
+// getInitSQL returns the statements and the statements of the file, one per line.
+// Each statement must be a single statement on a single line, a trailing ';' is optional.
+func getInitSQL(statements []string, file string) ([]string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", file)
+		}
+		statements = append(statements, strings.Split(string(data), "\n")...)
+	}
+	var result []string
+	for _, stmt := range statements {
+		stmt = strings.TrimSpace(stmt)
+		stmt = strings.TrimSpace(strings.TrimSuffix(stmt, ";"))
+		if stmt == "" {
+			continue
+		}
+		if strings.ContainsAny(stmt, "\r\n") || hasStatementSeparator(stmt) {
+			return nil, errors.Errorf("init statement '%s' must be a single statement on a single line", stmt)
+		}
+		result = append(result, stmt)
+	}
+	return result, nil
+}
+
+// hasStatementSeparator returns true if the statement has ';' outside of quoted strings and identifiers
+func hasStatementSeparator(stmt string) bool {
+	var quote rune
+	escaped := false
+	for _, c := range stmt {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\' && quote != '`':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ';':
+			return true
+		}
+	}
+	return false
+}
+
 func reverse(list []string) {
 	for i := len(list)/2 - 1; i >= 0; i-- {
 		opp := len(list) - 1 - i
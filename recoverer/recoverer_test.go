@@ -1,7 +1,30 @@
 package recoverer
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"mysql-pitr-helper/pxc"
+	"mysql-pitr-helper/storage"
+
+	"github.com/pierrec/lz4/v4"
 )
 
 func TestGetBucketAndPrefix(t *testing.T) {
@@ -9,6 +32,7 @@ func TestGetBucketAndPrefix(t *testing.T) {
 		address        string
 		expecteBucket  string
 		expectedPrefix string
+		expectedRegion string
 	}
 	cases := []testCase{
 		{
@@ -21,6 +45,11 @@ func TestGetBucketAndPrefix(t *testing.T) {
 			expecteBucket:  "operator-testing",
 			expectedPrefix: "test/",
 		},
+		{
+			address:        "gs://operator-testing/test",
+			expecteBucket:  "operator-testing",
+			expectedPrefix: "test/",
+		},
 		{
 			address:        "https://somedomain/operator-testing/test",
 			expecteBucket:  "operator-testing",
@@ -46,16 +75,3826 @@ func TestGetBucketAndPrefix(t *testing.T) {
 			expecteBucket:  "operator-testing",
 			expectedPrefix: "",
 		},
+		{
+			address:        "https://operator-testing.s3.eu-west-1.amazonaws.com/test",
+			expecteBucket:  "operator-testing",
+			expectedPrefix: "test/",
+			expectedRegion: "eu-west-1",
+		},
+		{
+			address:        "https://operator.testing.s3.eu-west-1.amazonaws.com/test/pitr/",
+			expecteBucket:  "operator.testing",
+			expectedPrefix: "test/pitr/",
+			expectedRegion: "eu-west-1",
+		},
+		{
+			address:        "https://operator-testing.s3-us-west-2.amazonaws.com",
+			expecteBucket:  "operator-testing",
+			expectedPrefix: "",
+			expectedRegion: "us-west-2",
+		},
+		{
+			address:        "https://operator-testing.s3.amazonaws.com/test",
+			expecteBucket:  "operator-testing",
+			expectedPrefix: "test/",
+		},
+		{
+			address:        "https://s3.eu-central-1.amazonaws.com/operator-testing/test",
+			expecteBucket:  "operator-testing",
+			expectedPrefix: "test/",
+			expectedRegion: "eu-central-1",
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.address, func(t *testing.T) {
-			bucket, prefix, err := getBucketAndPrefix(c.address)
+			bucket, prefix, region, err := getBucketAndPrefix(c.address)
 			if err != nil {
 				t.Errorf("get from '%s': %s", c.address, err.Error())
 			}
 			if bucket != c.expecteBucket || prefix != c.expectedPrefix {
 				t.Errorf("%s: bucket expect '%s', got '%s'; prefix expect '%s', got '%s'", c.address, c.expecteBucket, bucket, c.expectedPrefix, prefix)
 			}
+			if region != c.expectedRegion {
+				t.Errorf("%s: region expect '%s', got '%s'", c.address, c.expectedRegion, region)
+			}
+		})
+	}
+}
+
+func TestGetContainerAndPrefix(t *testing.T) {
+	type testCase struct {
+		path              string
+		expectedContainer string
+		expectedPrefix    string
+		expectedAccount   string
+	}
+	cases := []testCase{
+		{
+			path:              "binlogs",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "",
+		},
+		{
+			path:              "binlogs/pitr",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "pitr/",
+		},
+		{
+			path:              "binlogs/pitr/?sv=2022-11-02&sig=abc",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "pitr/",
+		},
+		{
+			path:              "https://account.blob.core.windows.net/binlogs/pitr?sv=2022-11-02&sig=abc",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "pitr/",
+			expectedAccount:   "account",
+		},
+		{
+			path:              "https://account.blob.core.windows.net/binlogs?sv=2022-11-02&sig=abc",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "",
+			expectedAccount:   "account",
+		},
+		{
+			path:              "https://Account.blob.core.windows.net/binlogs/pitr/cluster1/",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "pitr/cluster1/",
+			expectedAccount:   "account",
+		},
+		{
+			path:              "http://127.0.0.1:10000/binlogs/pitr",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "pitr/",
+		},
+		{
+			path:              "azure://account/binlogs/pitr",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "pitr/",
+			expectedAccount:   "account",
+		},
+		{
+			path:              "azure://account/binlogs",
+			expectedContainer: "binlogs",
+			expectedPrefix:    "",
+			expectedAccount:   "account",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			container, prefix, account, err := getContainerAndPrefix(c.path)
+			if err != nil {
+				t.Errorf("get from '%s': %s", c.path, err.Error())
+			}
+			if container != c.expectedContainer || prefix != c.expectedPrefix {
+				t.Errorf("%s: container expect '%s', got '%s'; prefix expect '%s', got '%s'", c.path, c.expectedContainer, container, c.expectedPrefix, prefix)
+			}
+			if account != c.expectedAccount {
+				t.Errorf("%s: account expect '%s', got '%s'", c.path, c.expectedAccount, account)
+			}
+		})
+	}
+
+	for _, path := range []string{"", "azure://account", "https://account.blob.core.windows.net/"} {
+		if _, _, _, err := getContainerAndPrefix(path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestGetRewriteDBFlags(t *testing.T) {
+	type testCase struct {
+		name          string
+		pairs         []string
+		expectedFlags []string
+		expectErr     bool
+	}
+	cases := []testCase{
+		{
+			name:          "empty",
+			pairs:         nil,
+			expectedFlags: nil,
+		},
+		{
+			name:          "single pair",
+			pairs:         []string{"shop->shop_staging"},
+			expectedFlags: []string{"--rewrite-db=shop->shop_staging"},
+		},
+		{
+			name:          "multiple pairs",
+			pairs:         []string{"shop->shop_staging", " users -> users_staging "},
+			expectedFlags: []string{"--rewrite-db=shop->shop_staging", "--rewrite-db=users->users_staging"},
+		},
+		{
+			name:      "missing arrow",
+			pairs:     []string{"shop"},
+			expectErr: true,
+		},
+		{
+			name:      "missing new name",
+			pairs:     []string{"shop->"},
+			expectErr: true,
+		},
+		{
+			name:      "chained",
+			pairs:     []string{"a->b->c"},
+			expectErr: true,
+		},
+		{
+			name:          "quote is passed as is",
+			pairs:         []string{"shop->x'"},
+			expectedFlags: []string{"--rewrite-db=shop->x'"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			flags, err := getRewriteDBFlags(c.pairs)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("%v: expected error, got flags %v", c.pairs, flags)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%v: %s", c.pairs, err.Error())
+			}
+			if !reflect.DeepEqual(flags, c.expectedFlags) {
+				t.Errorf("%v: flags expect %q, got %q", c.pairs, c.expectedFlags, flags)
+			}
+		})
+	}
+}
+
+func TestBinlogArgs(t *testing.T) {
+	type testCase struct {
+		name     string
+		r        Recoverer
+		binlog   string
+		expected []string
+	}
+	cases := []testCase{
+		{
+			name:     "latest",
+			r:        Recoverer{recoverType: Latest},
+			binlog:   "binlog_1700000001_a",
+			expected: []string{"--disable-log-bin", "-"},
+		},
+		{
+			name: "shell metacharacters are not interpreted",
+			r: Recoverer{
+				recoverType:    Skip,
+				recoverFlags:   []string{`--exclude-gtids=` + testUUID + `:5"; rm -rf / #`},
+				rewriteDBFlags: []string{"--rewrite-db=a->$(id)`x` 'b'"},
+			},
+			binlog: "binlog_1700000001_a",
+			expected: []string{
+				"--disable-log-bin",
+				`--exclude-gtids=` + testUUID + `:5"; rm -rf / #`,
+				"--rewrite-db=a->$(id)`x` 'b'",
+				"-",
+			},
+		},
+		{
+			name: "stop position only for the stop binlog",
+			r: Recoverer{
+				recoverType:  Position,
+				stopBinlog:   "binlog_1700000002_b",
+				stopPosition: 1234,
+			},
+			binlog:   "binlog_1700000002_b",
+			expected: []string{"--disable-log-bin", "--stop-position=1234", "-"},
+		},
+		{
+			name: "no stop position for other binlogs",
+			r: Recoverer{
+				recoverType:  Position,
+				stopBinlog:   "binlog_1700000002_b",
+				stopPosition: 1234,
+			},
+			binlog:   "binlog_1700000001_a",
+			expected: []string{"--disable-log-bin", "-"},
+		},
+		{
+			name: "source server",
+			r: Recoverer{
+				recoverType: Latest,
+				user:        "xtrabackup",
+				sourceHost:  "pxc-0",
+				source:      &fakeSource{},
+			},
+			binlog: "binlog.000002",
+			expected: []string{
+				"--disable-log-bin",
+				"--read-from-remote-server", "--host=pxc-0", "--port=33062", "--user=xtrabackup",
+				"binlog.000002",
+			},
+		},
+		{
+			name: "source server with control port",
+			r: Recoverer{
+				recoverType: Latest,
+				user:        "xtrabackup",
+				sourceHost:  "pxc-0",
+				source:      &fakeSource{},
+				controlPort: 3307,
+			},
+			binlog: "binlog.000002",
+			expected: []string{
+				"--disable-log-bin",
+				"--read-from-remote-server", "--host=pxc-0", "--port=3307", "--user=xtrabackup",
+				"binlog.000002",
+			},
+		},
+	}
+	for i := range cases {
+		c := &cases[i]
+		t.Run(c.name, func(t *testing.T) {
+			args := c.r.binlogArgs(c.binlog)
+			if !reflect.DeepEqual(args, c.expected) {
+				t.Errorf("expect %q, got %q", c.expected, args)
+			}
+		})
+	}
+}
+
+func TestParseRecoverTime(t *testing.T) {
+	type testCase struct {
+		value     string
+		expected  string
+		expectErr bool
+	}
+	cases := []testCase{
+		{value: "2024-03-01 10:20:30", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01 10:20:30.123456", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01T10:20:30Z", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01T10:20:30.5Z", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01T12:20:30+02:00", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01 12:20:30 +0200", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01T10:20:30", expected: "2024-03-01 10:20:30"},
+		{value: " 2024-03-01 10:20:30 ", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01 11:20:30 Europe/Berlin", expected: "2024-03-01 10:20:30"},
+		{value: "2024-07-01 12:20:30 Europe/Berlin", expected: "2024-07-01 10:20:30"},
+		{value: "2024-03-01 10:20:30 UTC", expected: "2024-03-01 10:20:30"},
+		{value: "2024-03-01 10:20:30 CET", expectErr: true},
+		{value: "2024-03-01 10:20:30 XYZ", expectErr: true},
+		{value: "2024-03-01 10:20:30 Mars/Olympus", expectErr: true},
+		{value: "01/03/2024 10:20", expectErr: true},
+		{value: "", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			got, err := parseRecoverTime(c.value)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("'%s': expected error, got %s", c.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("'%s': %s", c.value, err.Error())
+				return
+			}
+			if got.Format(recoverTimeFormats[0]) != c.expected {
+				t.Errorf("'%s': expect '%s', got '%s'", c.value, c.expected, got.Format(recoverTimeFormats[0]))
+			}
+		})
+	}
+}
+
+func TestMysqlbinlogEnv(t *testing.T) {
+	t.Setenv("TZ", "Europe/Berlin")
+	for _, r := range []*Recoverer{{}, {source: &fakeSource{}, pass: "secret"}} {
+		env := r.mysqlbinlogEnv()
+		// the last value of a duplicated variable is used
+		var tz string
+		for _, kv := range env {
+			if v, ok := strings.CutPrefix(kv, "TZ="); ok {
+				tz = v
+			}
+		}
+		if tz != "UTC" {
+			t.Errorf("expect mysqlbinlog in UTC, got TZ '%s'", tz)
+		}
+		if expected := r.source != nil; slices.Contains(env, "MYSQL_PWD=secret") != expected {
+			t.Errorf("expect password in environment %t", expected)
+		}
+	}
+}
+
+func TestCheckRecoverTime(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC)
+	type testCase struct {
+		recoverTime     string
+		allowFutureDate bool
+		expectErr       bool
+	}
+	cases := []testCase{
+		{recoverTime: "2023-11-14 21:59:59"},
+		{recoverTime: "2023-11-14 22:00:00"},
+		{recoverTime: "2023-11-14 22:00:01", expectErr: true},
+		{recoverTime: "2023-11-15T00:00:00+03:00"},
+		{recoverTime: "2024-01-01 00:00:00", allowFutureDate: true},
+	}
+	for _, c := range cases {
+		t.Run(c.recoverTime, func(t *testing.T) {
+			r := &Recoverer{recoverTime: c.recoverTime, allowFutureDate: c.allowFutureDate, clock: frozenClock(now)}
+			err := r.checkRecoverTime()
+			if c.expectErr != errors.Is(err, ErrFutureDate) {
+				t.Errorf("expect future date error %t, got '%v'", c.expectErr, err)
+			}
+		})
+	}
+}
+
+const testUUID = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+
+// fakeDB implements GTID set operations locally instead of querying MySQL
+type fakeDB struct {
+	gtidExecuted   string
+	serverUUID     string
+	emptySubtracts int // number of SubtractGTIDSet calls with an empty set
+	closed         int // number of Close calls
+	binlogs        []pxc.Binlog
+	malformed      string   // gtid set rejected by GTID functions as the server does
+	purged         []string // gtid sets added to gtid_purged
+	host           string   // host of the connection, localhost if empty
+	readOnly       bool
+	roDisabled     int // number of DisableReadOnly calls
+}
+
+// checkGTIDSets returns the error of the server for the malformed gtid set
+func (db *fakeDB) checkGTIDSets(sets ...string) error {
+	for _, set := range sets {
+		if db.malformed != "" && set == db.malformed {
+			return &pxc.GTIDFormatError{Set: set, Err: errors.New("Malformed GTID set specification")}
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) GetHost() string {
+	if db.host == "" {
+		return "localhost"
+	}
+	return db.host
+}
+
+func (db *fakeDB) GetCurrentGTIDSet(ctx context.Context) (string, error) {
+	return db.gtidExecuted, nil
+}
+
+func (db *fakeDB) GetServerUUID(ctx context.Context) (string, error) {
+	return db.serverUUID, nil
+}
+
+func (db *fakeDB) SubtractGTIDSet(ctx context.Context, set, subSet string) (string, error) {
+	if set == "" || subSet == "" {
+		db.emptySubtracts++
+	}
+	if err := db.checkGTIDSets(set, subSet); err != nil {
+		return "", err
+	}
+	s := pxc.NewGTIDSet(set)
+	result := s.Subtract(pxc.NewGTIDSet(subSet))
+	return result.Raw(), nil
+}
+
+func (db *fakeDB) GTIDSubset(ctx context.Context, set1, set2 string) (bool, error) {
+	if err := db.checkGTIDSets(set1, set2); err != nil {
+		return false, err
+	}
+	s := pxc.NewGTIDSet(set2)
+	return s.Contains(pxc.NewGTIDSet(set1))
+}
+
+func (db *fakeDB) AddGTIDPurged(ctx context.Context, set string) error {
+	executed := pxc.NewGTIDSet(db.gtidExecuted)
+	if rest := executed.Subtract(pxc.NewGTIDSet(set)); !rest.Equal(executed) {
+		return fmt.Errorf("gtid set %s intersects gtid_executed %s", set, db.gtidExecuted)
+	}
+	db.purged = append(db.purged, set)
+	union := executed.Union(pxc.NewGTIDSet(set))
+	db.gtidExecuted = union.Raw()
+	return nil
+}
+
+func (db *fakeDB) IsReadOnly(ctx context.Context) (bool, bool, error) {
+	return db.readOnly, false, nil
+}
+
+func (db *fakeDB) DisableReadOnly(ctx context.Context) error {
+	db.roDisabled++
+	db.readOnly = false
+	return nil
+}
+
+func (db *fakeDB) DropCollectorFunctions(ctx context.Context) error     { return nil }
+func (db *fakeDB) FlushLogs(ctx context.Context, engineLogs bool) error { return nil }
+
+func (db *fakeDB) ListBinLogs(ctx context.Context) ([]pxc.Binlog, error) {
+	return db.binlogs, nil
+}
+
+func (db *fakeDB) Close() error {
+	db.closed++
+	return nil
+}
+
+// fakeSource is a source server with binlogs and their gtid sets
+type fakeSource struct {
+	binlogs [][2]string
+	dropped int // number of DropCollectorFunctions calls
+}
+
+func (s *fakeSource) GetBinLogNamesList(ctx context.Context) ([]string, error) {
+	names := []string{}
+	for _, b := range s.binlogs {
+		names = append(names, b[0])
+	}
+	return names, nil
+}
+
+func (s *fakeSource) GetGTIDSet(ctx context.Context, binlogName string) (string, error) {
+	for _, b := range s.binlogs {
+		if b[0] == binlogName {
+			return b[1], nil
+		}
+	}
+	return "", nil
+}
+
+func (s *fakeSource) GetBinLogFirstTimestamp(ctx context.Context, binlog string) (string, error) {
+	return "1700000000", nil
+}
+
+func (s *fakeSource) GetBinLogLastTimestamp(ctx context.Context, binlog string) (string, error) {
+	return "1700000100", nil
+}
+
+func (s *fakeSource) DropCollectorFunctions(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.dropped++
+	return nil
+}
+
+func (s *fakeSource) Close() error { return nil }
+
+func TestDropSourceFunctions(t *testing.T) {
+	source := &fakeSource{}
+	r := &Recoverer{source: source, sourceHost: "pxc-0"}
+	// functions are dropped even if the recovery was canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.dropSourceFunctions(ctx)
+	if source.dropped != 1 {
+		t.Errorf("expect functions dropped once, got %d", source.dropped)
+	}
+}
+
+// newBinlogStorage returns storage with binlogs, their gtid-set and timestamp sidecars.
+// Sidecar isn't created if the gtid set is "-".
+func newBinlogStorage(binlogs [][2]string) storage.Storage {
+	objects := make(map[string][]byte)
+	for _, b := range binlogs {
+		objects[b[0]] = []byte("binlog content")
+		objects[b[0]+lastTimestampSuffix] = []byte("1700000000")
+		if b[1] != "-" {
+			objects[b[0]+"-gtid-set"] = []byte(b[1])
+		}
+	}
+	return storage.NewMemory(objects)
+}
+
+func TestSetBinlogs(t *testing.T) {
+	type testCase struct {
+		name            string
+		recoverType     RecoverType
+		gtid            string
+		startGTID       string
+		lookback        int
+		binlogCount     int
+		binlogs         [][2]string
+		expected        []string
+		expectedGTIDSet string
+		expectErr       bool
+	}
+	cases := []testCase{
+		{
+			name:        "prunes the leading binlog already in start gtid",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-10",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+				{"binlog_1700000003_c", testUUID + ":11-15"},
+				{"binlog_1700000004_d", testUUID + ":16-20"},
+			},
+			expected: []string{"binlog_1700000003_c", "binlog_1700000004_d"},
+		},
+		{
+			name:        "keeps the leading binlog partially in start gtid",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-10",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", testUUID + ":6-12"},
+				{"binlog_1700000003_c", testUUID + ":13-15"},
+			},
+			expected: []string{"binlog_1700000002_b", "binlog_1700000003_c"},
+		},
+		{
+			name:        "keeps the newest binlog if all are in start gtid",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-15",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-10"},
+				{"binlog_1700000002_b", testUUID + ":11-15"},
+			},
+			expected: []string{"binlog_1700000002_b"},
+		},
+		{
+			name:        "includes all binlogs if none overlap",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-3",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":4-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+			},
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		},
+		{
+			name:        "start gtid formatted differently",
+			recoverType: Latest,
+			startGTID:   strings.ToUpper(testUUID) + ":3:1-2\n",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":4-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+			},
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		},
+		{
+			name:        "skips binlogs without sidecar",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-10",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-10"},
+				{"binlog_1700000002_b", "-"},
+				{"binlog_1700000003_c", testUUID + ":11-15"},
+			},
+			expected: []string{"binlog_1700000003_c"},
+		},
+		{
+			name:        "transaction extends gtid set",
+			recoverType: Transaction,
+			gtid:        testUUID + ":13",
+			startGTID:   testUUID + ":1-10",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+				{"binlog_1700000003_c", testUUID + ":11-15"},
+				{"binlog_1700000004_d", testUUID + ":16-20"},
+			},
+			expected:        []string{"binlog_1700000003_c"},
+			expectedGTIDSet: testUUID + ":13-15",
+		},
+		{
+			name:        "includes empty binlogs interleaved with non-empty ones",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-10",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", ""},
+				{"binlog_1700000003_c", testUUID + ":6-10"},
+				{"binlog_1700000004_d", ""},
+				{"binlog_1700000005_e", "\n"},
+				{"binlog_1700000006_f", testUUID + ":11-15"},
+				{"binlog_1700000007_g", ""},
+			},
+			expected: []string{"binlog_1700000004_d", "binlog_1700000005_e", "binlog_1700000006_f", "binlog_1700000007_g"},
+		},
+		{
+			name:        "transaction skips empty binlogs after the transaction",
+			recoverType: Transaction,
+			gtid:        testUUID + ":13",
+			startGTID:   testUUID + ":1-10",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":6-10"},
+				{"binlog_1700000002_b", ""},
+				{"binlog_1700000003_c", testUUID + ":11-15"},
+				{"binlog_1700000004_d", ""},
+			},
+			expected:        []string{"binlog_1700000002_b", "binlog_1700000003_c"},
+			expectedGTIDSet: testUUID + ":13-15",
+		},
+		{
+			name:        "empty start gtid selects the whole archive",
+			recoverType: Latest,
+			startGTID:   "",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", ""},
+				{"binlog_1700000003_c", testUUID + ":6-10"},
+				{"binlog_1700000004_d", testUUID + ":11-15"},
+			},
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c", "binlog_1700000004_d"},
+		},
+		{
+			name:        "empty start gtid transaction",
+			recoverType: Transaction,
+			gtid:        testUUID + ":8",
+			startGTID:   "",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+				{"binlog_1700000003_c", testUUID + ":11-15"},
+			},
+			expected:        []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+			expectedGTIDSet: testUUID + ":8-10",
+		},
+		{
+			name:        "transaction is not in the archive",
+			recoverType: Transaction,
+			gtid:        testUUID + ":25",
+			startGTID:   testUUID + ":1-10",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":6-10"},
+				{"binlog_1700000002_b", testUUID + ":11-15"},
+			},
+			expectErr: true,
+		},
+		{
+			name:        "lookback finds an older binlog archived out of order",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-10",
+			lookback:    2,
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":11-12"},
+				{"binlog_1700000002_b", testUUID + ":1-5"},
+				{"binlog_1700000003_c", testUUID + ":6-10"},
+				{"binlog_1700000004_d", testUUID + ":13-15"},
+			},
+			expected: []string{"binlog_1700000001_a", "binlog_1700000003_c", "binlog_1700000004_d"},
+		},
+		{
+			name:        "lookback stops after applied binlogs",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-10",
+			lookback:    1,
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":11-12"},
+				{"binlog_1700000002_b", testUUID + ":1-3"},
+				{"binlog_1700000003_c", testUUID + ":4-5"},
+				{"binlog_1700000004_d", testUUID + ":6-10"},
+				{"binlog_1700000005_e", testUUID + ":13-15"},
+			},
+			expected: []string{"binlog_1700000005_e"},
+		},
+		{
+			name:        "no binlogs",
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-10",
+			binlogs:     [][2]string{},
+			expectErr:   true,
+		},
+		{
+			name:        "count",
+			recoverType: Count,
+			startGTID:   testUUID + ":1-7",
+			binlogCount: 2,
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+				{"binlog_1700000003_c", testUUID + ":11-15"},
+				{"binlog_1700000004_d", testUUID + ":16-20"},
+			},
+			expected: []string{"binlog_1700000002_b", "binlog_1700000003_c"},
+		},
+		{
+			name:        "count is larger than the archive",
+			recoverType: Count,
+			startGTID:   testUUID + ":1-10",
+			binlogCount: 3,
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+				{"binlog_1700000003_c", testUUID + ":11-15"},
+				{"binlog_1700000004_d", testUUID + ":16-20"},
+			},
+			expectErr: true,
+		},
+		{
+			name:        "count with all binlogs applied",
+			recoverType: Count,
+			startGTID:   testUUID + ":1-10",
+			binlogCount: 1,
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := &fakeDB{gtidExecuted: c.startGTID}
+			r := &Recoverer{
+				db:          db,
+				storage:     newBinlogStorage(c.binlogs),
+				recoverType: c.recoverType,
+				gtid:        c.gtid,
+				startGTID:   c.startGTID,
+				lookback:    c.lookback,
+				binlogCount: c.binlogCount,
+
+				binlogPrefix:       "binlog_",
+				gtidSetSuffix:      "-gtid-set",
+				sidecarConcurrency: 3,
+			}
+			err := r.setBinlogs(context.Background())
+			if c.expectErr {
+				if !errors.Is(err, ErrNoBinlogs) {
+					t.Errorf("expected ErrNoBinlogs, got binlogs %v, error %v", r.binlogs, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("set binlogs: %s", err.Error())
+			}
+			if !reflect.DeepEqual(r.binlogs, c.expected) {
+				t.Errorf("binlogs expect %v, got %v", c.expected, r.binlogs)
+			}
+			if r.gtidSet != c.expectedGTIDSet {
+				t.Errorf("gtid set expect '%s', got '%s'", c.expectedGTIDSet, r.gtidSet)
+			}
+			if db.emptySubtracts != 0 {
+				t.Errorf("expect no GTID subtraction with empty sets, got %d", db.emptySubtracts)
+			}
+		})
+	}
+}
+
+func TestParseTransactionGTID(t *testing.T) {
+	type testCase struct {
+		gtid        string
+		expectedNum int64
+		expectErr   bool
+	}
+	cases := []testCase{
+		{gtid: testUUID + ":15", expectedNum: 15},
+		{gtid: testUUID + ":1-5000", expectedNum: 5000},
+		{gtid: testUUID + ":7-7", expectedNum: 7},
+		{gtid: testUUID + ":5-", expectErr: true},
+		{gtid: testUUID + ":-5", expectErr: true},
+		{gtid: testUUID + ":10-2", expectErr: true},
+		{gtid: testUUID + ":0", expectErr: true},
+		{gtid: testUUID + ":abc", expectErr: true},
+		{gtid: testUUID, expectErr: true},
+		{gtid: testUUID + ":1:5", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.gtid, func(t *testing.T) {
+			sourceID, num, err := parseTransactionGTID(c.gtid)
+			if c.expectErr {
+				if !errors.Is(err, ErrBadGTIDFormat) {
+					t.Errorf("%s: expected ErrBadGTIDFormat, got %s:%d, error %v", c.gtid, sourceID, num, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s: %s", c.gtid, err.Error())
+			}
+			if sourceID != testUUID || num != c.expectedNum {
+				t.Errorf("%s: expect %s:%d, got %s:%d", c.gtid, testUUID, c.expectedNum, sourceID, num)
+			}
+		})
+	}
+}
+
+func TestGetSkipGTIDSet(t *testing.T) {
+	const otherUUID = "9f0ab5ee-c7b6-11ee-a1f0-0242ac120002"
+	type testCase struct {
+		name      string
+		gtid      string
+		expected  string
+		expectErr bool
+	}
+	cases := []testCase{
+		{name: "one", gtid: testUUID + ":15", expected: testUUID + ":15"},
+		{name: "two sources", gtid: otherUUID + ":3, " + testUUID + ":15", expected: testUUID + ":15," + otherUUID + ":3"},
+		{name: "merged", gtid: testUUID + ":15 " + testUUID + ":16,,", expected: testUUID + ":15-16"},
+		{name: "malformed entry", gtid: testUUID + ":15," + testUUID, expectErr: true},
+		{name: "empty", gtid: " , ", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			set, err := getSkipGTIDSet(c.gtid)
+			if c.expectErr {
+				if !errors.Is(err, ErrBadGTIDFormat) {
+					t.Errorf("%s: expected ErrBadGTIDFormat, got '%s', error %v", c.gtid, set, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %s", c.gtid, err.Error())
+			}
+			if set != c.expected {
+				t.Errorf("%s: expect '%s', got '%s'", c.gtid, c.expected, set)
+			}
+		})
+	}
+}
+
+func TestVerifyTransactionInputGTID(t *testing.T) {
+	type testCase struct {
+		gtid         string
+		force        bool
+		expectedGTID string
+		expectErr    bool
+		expectedErr  error
+	}
+	cases := []testCase{
+		{gtid: testUUID + ":15", expectedGTID: testUUID + ":15"},
+		{gtid: testUUID + ":12-15", expectedGTID: testUUID + ":15"},
+		{gtid: testUUID + ":5", expectErr: true, expectedErr: ErrTargetBeforeBackup},
+		{gtid: testUUID + ":5", force: true, expectedGTID: testUUID + ":5"},
+		{gtid: testUUID + ":15-", expectErr: true, expectedErr: ErrBadGTIDFormat},
+		{gtid: testUUID + ":15-", force: true, expectErr: true, expectedErr: ErrBadGTIDFormat},
+		{gtid: "+5", expectedGTID: testUUID + ":15"},
+		{gtid: testUUID + ":+2", expectedGTID: testUUID + ":12"},
+		{gtid: "+0", expectErr: true, expectedErr: ErrBadGTIDFormat},
+		{gtid: "+a", expectErr: true, expectedErr: ErrBadGTIDFormat},
+	}
+	for _, c := range cases {
+		t.Run(c.gtid, func(t *testing.T) {
+			r := &Recoverer{
+				db:        &fakeDB{},
+				gtid:      c.gtid,
+				startGTID: testUUID + ":1-10",
+				force:     c.force,
+			}
+			err := r.verifyTransactionInputGTID(context.Background())
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("%s: expected error", c.gtid)
+				}
+				if c.expectedErr != nil && !errors.Is(err, c.expectedErr) {
+					t.Errorf("%s: expect error '%v', got '%v'", c.gtid, c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s: %s", c.gtid, err.Error())
+			}
+			if r.gtid != c.expectedGTID {
+				t.Errorf("%s: gtid expect '%s', got '%s'", c.gtid, c.expectedGTID, r.gtid)
+			}
+		})
+	}
+}
+
+func TestGTIDPosition(t *testing.T) {
+	output := `# at 4
+#240101 10:00:00 server id 1  end_log_pos 126 CRC32 0x1  Start: binlog v 4
+# at 126
+#240101 10:00:01 server id 1  end_log_pos 206 CRC32 0x2  GTID	last_committed=0	sequence_number=1
+SET @@SESSION.GTID_NEXT= '` + testUUID + `:5'/*!*/;
+# at 206
+BEGIN
+/*!*/;
+# at 290
+#240101 10:00:02 server id 1  end_log_pos 370 CRC32 0x3  GTID	last_committed=1	sequence_number=2
+SET @@SESSION.GTID_NEXT= '` + strings.ToUpper(testUUID) + `:6'/*!*/;
+# at 370
+COMMIT/*!*/;
+`
+	type testCase struct {
+		gtid          string
+		expectedPos   int64
+		expectedFound bool
+	}
+	cases := []testCase{
+		{gtid: testUUID + ":5", expectedPos: 126, expectedFound: true},
+		{gtid: testUUID + ":6", expectedPos: 290, expectedFound: true},
+		{gtid: testUUID + ":7"},
+	}
+	for _, c := range cases {
+		t.Run(c.gtid, func(t *testing.T) {
+			pos, found, err := gtidPosition(strings.NewReader(output), c.gtid)
+			if err != nil {
+				t.Fatalf("%s: %s", c.gtid, err.Error())
+			}
+			if found != c.expectedFound || pos != c.expectedPos {
+				t.Errorf("%s: expect %d %t, got %d %t", c.gtid, c.expectedPos, c.expectedFound, pos, found)
+			}
+		})
+	}
+}
+
+func TestVerifyBackups(t *testing.T) {
+	type testCase struct {
+		name       string
+		current    string
+		backupGTID string
+		binlogs    [][2]string
+		compressed bool // sidecars are <binlog>-gtid-set.lz4 of <binlog>.lz4
+		expectErr  bool
+	}
+	cases := []testCase{
+		{
+			name:    "complete",
+			current: testUUID + ":1-20",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":5-10"},
+				{"binlog_1700000002_b", ""},
+				{"binlog_1700000003_c", testUUID + ":11-20"},
+			},
+		},
+		{
+			name:    "gap between binlogs",
+			current: testUUID + ":1-20",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":5-10"},
+				{"binlog_1700000003_c", testUUID + ":13-20"},
+			},
+			expectErr: true,
+		},
+		{
+			name:    "behind the cluster",
+			current: testUUID + ":1-25",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":5-20"},
+			},
+			expectErr: true,
+		},
+		{
+			name:       "gap after the backup",
+			current:    testUUID + ":1-20",
+			backupGTID: testUUID + ":1-3",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":5-20"},
+			},
+			expectErr: true,
+		},
+		{
+			name:      "no binlogs",
+			current:   testUUID + ":1-20",
+			binlogs:   [][2]string{},
+			expectErr: true,
+		},
+		{
+			name:    "source without archived binlogs",
+			current: testUUID + ":1-20,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-3",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":5-20"},
+			},
+			expectErr: true,
+		},
+		{
+			name:    "compressed sidecars",
+			current: testUUID + ":1-20",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":5-10"},
+				{"binlog_1700000003_c", testUUID + ":11-20"},
+			},
+			compressed: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newBinlogStorage(c.binlogs)
+			if c.compressed {
+				objects := make(map[string][]byte)
+				for _, b := range c.binlogs {
+					objects[b[0]+".lz4"] = lz4Compress(t, []byte("binlog content"))
+					objects[b[0]+"-gtid-set.lz4"] = lz4Compress(t, []byte(b[1]))
+				}
+				var err error
+				s, err = storage.NewDecompressing(storage.NewMemory(objects), storage.CompressionLZ4)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &Recoverer{
+				db:         &fakeDB{gtidExecuted: c.current},
+				storage:    s,
+				backupGTID: c.backupGTID,
+
+				binlogPrefix:  "binlog_",
+				gtidSetSuffix: "-gtid-set",
+			}
+			err := r.VerifyBackups(context.Background())
+			if c.expectErr && err == nil {
+				t.Errorf("expected error")
+			}
+			if !c.expectErr && err != nil {
+				t.Errorf("verify backups: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	db := &fakeDB{}
+	r := &Recoverer{db: db}
+	for i := 0; i < 2; i++ {
+		if err := r.Close(); err != nil {
+			t.Fatalf("close %d: %s", i, err.Error())
+		}
+	}
+	if db.closed != 1 {
+		t.Errorf("expect 1 close of the database, got %d", db.closed)
+	}
+}
+
+func TestTrimToConsistent(t *testing.T) {
+	type testCase struct {
+		name      string
+		startGTID string
+		binlogs   [][2]string
+		expected  []string
+		expectErr bool
+	}
+	cases := []testCase{
+		{
+			name:      "no gaps",
+			startGTID: testUUID + ":1-3",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				{"binlog_1700000002_b", ""},
+				{"binlog_1700000003_c", testUUID + ":6-10"},
+			},
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"},
+		},
+		{
+			name:      "stops before gap",
+			startGTID: testUUID + ":1-3",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":4-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10"},
+				{"binlog_1700000003_c", testUUID + ":16-20"},
+				{"binlog_1700000004_d", testUUID + ":21-25"},
+			},
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		},
+		{
+			name:      "gap in the backup is ignored",
+			startGTID: testUUID + ":1-3:5-8",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":9-10"},
+			},
+			expected: []string{"binlog_1700000001_a"},
+		},
+		{
+			name:      "gap after the backup",
+			startGTID: testUUID + ":1-3",
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":6-10"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Recoverer{
+				db:         &fakeDB{gtidExecuted: c.startGTID},
+				startGTID:  c.startGTID,
+				binlogSets: make(map[string]string),
+			}
+			for _, b := range c.binlogs {
+				r.binlogs = append(r.binlogs, b[0])
+				r.binlogSets[b[0]] = b[1]
+			}
+			err := r.trimToConsistent(context.Background())
+			if c.expectErr {
+				if !errors.Is(err, ErrNoBinlogs) {
+					t.Errorf("expected ErrNoBinlogs, got binlogs %v, error %v", r.binlogs, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("trim to consistent: %s", err.Error())
+			}
+			if !reflect.DeepEqual(r.binlogs, c.expected) {
+				t.Errorf("binlogs expect %v, got %v", c.expected, r.binlogs)
+			}
+		})
+	}
+}
+
+func TestCheckGaps(t *testing.T) {
+	const otherUUID = "b9e1dd9c-7528-11ee-8a6c-0242ac120002"
+	startGTID := testUUID + ":1-3"
+	withGap := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":4-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+		{"binlog_1700000003_c", testUUID + ":16-20"},
+	}
+	type testCase struct {
+		name      string
+		policy    string
+		startGTID string // startGTID is used if empty
+		binlogs   [][2]string
+		expected  []string
+		expectErr string
+	}
+	cases := []testCase{
+		{
+			name:     "ignored",
+			policy:   gapPolicyIgnore,
+			binlogs:  withGap,
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"},
+		},
+		{
+			name:      "fail",
+			policy:    gapPolicyFail,
+			binlogs:   withGap,
+			expectErr: "between binlog_1700000002_b and binlog_1700000003_c, missing " + testUUID + ":11-15: GTID gap in the binlogs",
+		},
+		{
+			name:     "fail without gaps",
+			policy:   gapPolicyFail,
+			binlogs:  withGap[:2],
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		},
+		{
+			name:     "stop",
+			policy:   gapPolicyStop,
+			binlogs:  withGap,
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		},
+		{
+			name:   "new source after its first transaction",
+			policy: gapPolicyFail,
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":4-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10," + otherUUID + ":7-9"},
+			},
+			expectErr: "between binlog_1700000001_a and binlog_1700000002_b, missing " + otherUUID + ":1-6: GTID gap in the binlogs",
+		},
+		{
+			name:   "new source",
+			policy: gapPolicyFail,
+			binlogs: [][2]string{
+				{"binlog_1700000001_a", testUUID + ":4-5"},
+				{"binlog_1700000002_b", testUUID + ":6-10," + otherUUID + ":1-3"},
+			},
+			expected: []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		},
+		{
+			name:      "source of the backup after its first transaction",
+			policy:    gapPolicyFail,
+			startGTID: startGTID + "," + otherUUID + ":5-10",
+			binlogs:   [][2]string{{"binlog_1700000001_a", testUUID + ":4-5," + otherUUID + ":11-12"}},
+			expected:  []string{"binlog_1700000001_a"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			startGTID := startGTID
+			if c.startGTID != "" {
+				startGTID = c.startGTID
+			}
+			r := &Recoverer{
+				db:         &fakeDB{gtidExecuted: startGTID},
+				startGTID:  startGTID,
+				gapPolicy:  c.policy,
+				binlogSets: make(map[string]string),
+			}
+			for _, b := range c.binlogs {
+				r.binlogs = append(r.binlogs, b[0])
+				r.binlogSets[b[0]] = b[1]
+			}
+			err := r.checkGaps(context.Background())
+			if c.expectErr != "" {
+				if !errors.Is(err, ErrGTIDGap) || err.Error() != c.expectErr {
+					t.Errorf("expect '%s', got %v", c.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("check gaps: %s", err.Error())
+			}
+			if !reflect.DeepEqual(r.binlogs, c.expected) {
+				t.Errorf("binlogs expect %v, got %v", c.expected, r.binlogs)
+			}
+		})
+	}
+}
+
+// putFailingStorage fails every upload
+type putFailingStorage struct {
+	storage.Storage
+}
+
+func (s *putFailingStorage) PutObject(ctx context.Context, name string, data io.Reader, size int64) error {
+	return errors.New("access denied")
+}
+
+func TestRunLogUpload(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	st := storage.NewMemory(nil)
+	r := &Recoverer{storage: st, logUpload: true, clock: frozenClock(now)}
+	err := r.Run(context.Background())
+	if err == nil {
+		t.Fatal("expected error without recovery type")
+	}
+	obj, getErr := st.GetObject(context.Background(), "recovery_20240301T102030Z.log")
+	if getErr != nil {
+		t.Fatalf("get recovery log: %s", getErr.Error())
+	}
+	data, getErr := io.ReadAll(obj)
+	if getErr != nil {
+		t.Fatal(getErr)
+	}
+	if expected := "recovery failed: " + err.Error(); !strings.Contains(string(data), expected) {
+		t.Errorf("expect '%s' in '%s'", expected, data)
+	}
+
+	// failed upload doesn't replace the recovery error
+	r = &Recoverer{storage: &putFailingStorage{st}, logUpload: true, clock: frozenClock(now)}
+	if uploadErr := r.Run(context.Background()); uploadErr == nil || uploadErr.Error() != err.Error() {
+		t.Errorf("expect '%s', got %v", err.Error(), uploadErr)
+	}
+}
+
+// frozenClock is a Clock which always returns the same time
+type frozenClock time.Time
+
+func (c frozenClock) Now() time.Time { return time.Time(c) }
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	now := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	audit, err := openAuditLog(path, frozenClock(now).Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.binlog("binlog_1700000001_a", testUUID+":1-5"); err != nil {
+		t.Fatal(err)
+	}
+	// entries are synced as they're written
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e auditEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("unmarshal '%s': %s", data, err.Error())
+	}
+	if e.Binlog != "binlog_1700000001_a" || e.GTIDSet != testUUID+":1-5" || !e.Time.Equal(now) {
+		t.Errorf("unexpected entry '%s'", data)
+	}
+	if err := audit.finished(testUUID + ":1-5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Errorf("expect 2 entries, got %d", len(lines))
+	}
+
+	none, err := openAuditLog("", time.Now)
+	if err != nil || none != nil {
+		t.Errorf("expect no audit log for empty path, got %v, %v", none, err)
+	}
+	if err := none.binlog("binlog", ""); err != nil {
+		t.Errorf("write to disabled audit log: %s", err.Error())
+	}
+}
+
+func TestSetBinlogsFromServer(t *testing.T) {
+	startGTID := testUUID + ":1-7"
+	r := &Recoverer{
+		db:          &fakeDB{gtidExecuted: startGTID},
+		recoverType: Latest,
+		startGTID:   startGTID,
+		sourceHost:  "pxc-0",
+		source: &fakeSource{binlogs: [][2]string{
+			{"binlog.000001", testUUID + ":1-5"},
+			{"binlog.000002", testUUID + ":6-10"},
+			{"binlog.000003", testUUID + ":11-15"},
+		}},
+		gtidSetSuffix: "-gtid-set",
+	}
+	if err := r.setBinlogs(context.Background()); err != nil {
+		t.Fatalf("set binlogs: %s", err.Error())
+	}
+	expected := []string{"binlog.000002", "binlog.000003"}
+	if !reflect.DeepEqual(r.binlogs, expected) {
+		t.Errorf("binlogs expect %v, got %v", expected, r.binlogs)
+	}
+}
+
+func TestIsSidecar(t *testing.T) {
+	r := &Recoverer{gtidSetSuffix: "-gtid-set"}
+	cases := map[string]bool{
+		"binlog_1700000001_a":                    false,
+		"binlog_1700000001_a.lz4":                false,
+		"binlog_1700000001_a-gtid-set":           true,
+		"binlog_1700000001_a-gtid-set.lz4":       true,
+		"binlog_1700000001_a-last-timestamp":     true,
+		"binlog_1700000001_a-last-timestamp.lz4": true,
+		"binlog_1700000001_a-gtid-set-old":       false,
+	}
+	for name, expected := range cases {
+		if got := r.isSidecar(name); got != expected {
+			t.Errorf("%s: expect %t, got %t", name, expected, got)
+		}
+	}
+}
+
+func TestSetBinlogsVersionIDs(t *testing.T) {
+	st := newBinlogStorage([][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+	})
+	if err := st.PutObject(context.Background(), "binlog_1700000002_b"+versionIDSuffix, strings.NewReader("v1"), 2); err != nil {
+		t.Fatal(err)
+	}
+	r := &Recoverer{
+		db:          &fakeDB{},
+		storage:     st,
+		recoverType: Latest,
+		startGTID:   testUUID + ":1-3",
+
+		binlogPrefix:  "binlog_",
+		gtidSetSuffix: "-gtid-set",
+	}
+	if err := r.setBinlogs(context.Background()); err != nil {
+		t.Fatalf("set binlogs: %s", err.Error())
+	}
+	expected := []string{"binlog_1700000001_a", "binlog_1700000002_b"}
+	if !reflect.DeepEqual(r.binlogs, expected) {
+		t.Errorf("binlogs expect %v, got %v", expected, r.binlogs)
+	}
+	if _, err := r.withBinlogVersion(context.Background(), "binlog_1700000002_b"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.binlogVersions, map[string]string{"binlog_1700000002_b": "v1"}) {
+		t.Errorf("expect version of binlog_1700000002_b, got %v", r.binlogVersions)
+	}
+}
+
+func TestSetBinlogsGTIDUUIDFilter(t *testing.T) {
+	const otherUUID = "9f0ab5ee-c7b6-11ee-a1f0-0242ac120002"
+	r := &Recoverer{
+		db: &fakeDB{},
+		storage: newBinlogStorage([][2]string{
+			{"binlog_1700000001_a", otherUUID + ":1-100"},
+			{"binlog_1700000002_b", otherUUID + ":101-120," + testUUID + ":1-5"},
+			{"binlog_1700000003_c", ""},
+			{"binlog_1700000004_d", otherUUID + ":121-130"},
+			{"binlog_1700000005_e", testUUID + ":6-10"},
+		}),
+		recoverType:    Latest,
+		gtidUUIDFilter: testUUID,
+
+		binlogPrefix:  "binlog_",
+		gtidSetSuffix: "-gtid-set",
+	}
+	if err := r.setBinlogs(context.Background()); err != nil {
+		t.Fatalf("set binlogs: %s", err.Error())
+	}
+	expected := []string{"binlog_1700000002_b", "binlog_1700000005_e"}
+	if !reflect.DeepEqual(r.binlogs, expected) {
+		t.Errorf("binlogs expect %v, got %v", expected, r.binlogs)
+	}
+	expectedSet := testUUID + ":1-10"
+	if got := r.sourceGTIDSet(); got != expectedSet {
+		t.Errorf("expect '%s', got '%s'", expectedSet, got)
+	}
+}
+
+func TestArchiveBehind(t *testing.T) {
+	const otherUUID = "9f0ab5ee-c7b6-11ee-a1f0-0242ac120002"
+	type testCase struct {
+		name         string
+		gtidExecuted string
+		newest       string
+		expected     string
+	}
+	cases := []testCase{
+		{
+			name:         "archive is up to date",
+			gtidExecuted: testUUID + ":1-90",
+			newest:       testUUID + ":81-90",
+			expected:     "",
+		},
+		{
+			name:         "archive is behind",
+			gtidExecuted: testUUID + ":1-100," + otherUUID + ":1-5",
+			newest:       testUUID + ":81-90",
+			expected:     testUUID + ":91-100",
+		},
+		{
+			name:         "backup before the archive",
+			gtidExecuted: testUUID + ":1-50",
+			newest:       testUUID + ":81-90",
+			expected:     "",
+		},
+		{
+			name:         "empty gtid_executed",
+			gtidExecuted: "",
+			newest:       testUUID + ":81-90",
+			expected:     "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := archiveBehind(c.gtidExecuted, c.newest); got != c.expected {
+				t.Errorf("expect '%s', got '%s'", c.expected, got)
+			}
+		})
+	}
+}
+
+// racingDB returns the next gtid set on every GetCurrentGTIDSet call, the last one is repeated
+type racingDB struct {
+	fakeDB
+	sets  []string
+	reads int
+}
+
+func (db *racingDB) GetCurrentGTIDSet(ctx context.Context) (string, error) {
+	set := db.sets[min(db.reads, len(db.sets)-1)]
+	db.reads++
+	return set, nil
+}
+
+func TestSelectBinlogsRetry(t *testing.T) {
+	type testCase struct {
+		name        string
+		recoverType RecoverType
+		retries     int
+		sets        []string
+		expected    []string
+		expectGTID  string
+	}
+	cases := []testCase{
+		{
+			name:        "gtid_executed didn't change",
+			recoverType: Latest,
+			retries:     1,
+			sets:        []string{testUUID + ":1-10"},
+			expected:    []string{"binlog_1700000003_c"},
+			expectGTID:  testUUID + ":1-10",
+		},
+		{
+			name:        "selected again",
+			recoverType: Latest,
+			retries:     1,
+			sets:        []string{testUUID + ":1-10", testUUID + ":1-15"},
+			expected:    []string{"binlog_1700000003_c"},
+			expectGTID:  testUUID + ":1-15",
+		},
+		{
+			name:        "retries exhausted",
+			recoverType: Latest,
+			retries:     1,
+			sets:        []string{testUUID + ":1-4", testUUID + ":1-10", testUUID + ":1-15"},
+			expected:    []string{"binlog_1700000003_c"},
+			expectGTID:  testUUID + ":1-10",
+		},
+		{
+			name:        "retries disabled",
+			recoverType: Latest,
+			sets:        []string{testUUID + ":1-10", testUUID + ":1-15"},
+			expected:    []string{"binlog_1700000003_c"},
+			expectGTID:  testUUID + ":1-10",
+		},
+		{
+			name:        "not latest",
+			recoverType: Skip,
+			retries:     1,
+			sets:        []string{testUUID + ":1-10", testUUID + ":1-15"},
+			expected:    []string{"binlog_1700000003_c"},
+			expectGTID:  testUUID + ":1-10",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := &racingDB{sets: c.sets, reads: 1}
+			r := &Recoverer{
+				db: db,
+				storage: newBinlogStorage([][2]string{
+					{"binlog_1700000001_a", testUUID + ":1-5"},
+					{"binlog_1700000002_b", testUUID + ":6-10"},
+					{"binlog_1700000003_c", testUUID + ":11-15"},
+				}),
+				recoverType:      c.recoverType,
+				startGTID:        c.sets[0],
+				selectionRetries: c.retries,
+
+				binlogPrefix:  "binlog_",
+				gtidSetSuffix: "-gtid-set",
+			}
+			if err := r.selectBinlogs(context.Background()); err != nil {
+				t.Fatalf("select binlogs: %s", err.Error())
+			}
+			if !reflect.DeepEqual(r.binlogs, c.expected) {
+				t.Errorf("binlogs expect %v, got %v", c.expected, r.binlogs)
+			}
+			if r.selectionGTID != c.expectGTID {
+				t.Errorf("expect selection gtid '%s', got '%s'", c.expectGTID, r.selectionGTID)
+			}
+			if r.startGTID != c.sets[0] {
+				t.Errorf("expect start gtid '%s' to be kept, got '%s'", c.sets[0], r.startGTID)
+			}
+		})
+	}
+}
+
+// countingStorage records names of the objects requested with GetObject
+type countingStorage struct {
+	storage.Storage
+	mu   sync.Mutex
+	gets []string
+}
+
+func (s *countingStorage) GetObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	s.gets = append(s.gets, name)
+	s.mu.Unlock()
+	return s.Storage.GetObject(ctx, name)
+}
+
+// throttledStorage fails listings after returning the first objects until failures are exhausted
+type throttledStorage struct {
+	storage.Storage
+	failures int // number of listings which fail
+	limit    int // number of objects returned by a failed listing
+	listings int
+}
+
+func (s *throttledStorage) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	s.listings++
+	list, err := s.Storage.ListObjects(ctx, prefix)
+	if err != nil || s.failures == 0 {
+		return list, err
+	}
+	s.failures--
+	return list[:min(s.limit, len(list))], errors.New("SlowDown: please reduce your request rate")
+}
+
+func TestListObjectsResume(t *testing.T) {
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+		{"binlog_1700000003_c", testUUID + ":11-15"},
+	}
+	all, err := newBinlogStorage(binlogs).ListObjects(context.Background(), "binlog_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type testCase struct {
+		name      string
+		failures  int
+		retries   int
+		expectErr bool
+	}
+	cases := []testCase{
+		{name: "no failures", retries: 3},
+		{name: "resumed", failures: 2, retries: 3},
+		{name: "retries exhausted", failures: 4, retries: 3, expectErr: true},
+		{name: "retries disabled", failures: 1, expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := &throttledStorage{Storage: newBinlogStorage(binlogs), failures: c.failures, limit: 2}
+			r := &Recoverer{
+				storage:      st,
+				binlogPrefix: "binlog_",
+				listRetries:  c.retries,
+				listBackoff:  time.Millisecond,
+			}
+			list, err := r.listBinlogs(context.Background())
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", list)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(list, all) {
+				t.Errorf("expect %v, got %v", all, list)
+			}
+			if st.listings != c.failures+1 {
+				t.Errorf("expect %d listings, got %d", c.failures+1, st.listings)
+			}
+		})
+	}
+}
+
+func TestSetBinlogsManifest(t *testing.T) {
+	st := &countingStorage{Storage: newBinlogStorage([][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+		{"binlog_1700000003_c", testUUID + ":11-15"},
+	})}
+	// the newest binlog was uploaded before the manifest was updated
+	m := storage.NewGTIDSetManifest()
+	m.Binlogs["binlog_1700000001_a"] = testUUID + ":1-5"
+	m.Binlogs["binlog_1700000002_b"] = testUUID + ":6-10"
+	if err := storage.WriteGTIDSetManifest(context.Background(), st, m); err != nil {
+		t.Fatal(err)
+	}
+	r := &Recoverer{
+		db:          &fakeDB{},
+		storage:     st,
+		recoverType: Latest,
+		startGTID:   testUUID + ":1-3",
+
+		binlogPrefix:  "binlog_",
+		gtidSetSuffix: "-gtid-set",
+	}
+	if err := r.setBinlogs(context.Background()); err != nil {
+		t.Fatalf("set binlogs: %s", err.Error())
+	}
+	expected := []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"}
+	if !reflect.DeepEqual(r.binlogs, expected) {
+		t.Errorf("binlogs expect %v, got %v", expected, r.binlogs)
+	}
+	expectedGets := []string{storage.GTIDSetManifestName, "binlog_1700000003_c-gtid-set"}
+	if !reflect.DeepEqual(st.gets, expectedGets) {
+		t.Errorf("expect requests %v, got %v", expectedGets, st.gets)
+	}
+}
+
+func TestSetBinlogsArchiveCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st := &countingStorage{Storage: newBinlogStorage([][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+	})}
+	recoverer := func() *Recoverer {
+		st.gets = nil
+		return &Recoverer{
+			db:          &fakeDB{},
+			storage:     st,
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-3",
+			cacheDir:    dir,
+			archiveID:   "test",
+
+			binlogPrefix:  "binlog_",
+			gtidSetSuffix: "-gtid-set",
+		}
+	}
+	selectBinlogs := func(r *Recoverer, expected []string) {
+		t.Helper()
+		if err := r.setBinlogs(ctx); err != nil {
+			t.Fatalf("set binlogs: %s", err.Error())
+		}
+		if !reflect.DeepEqual(r.binlogs, expected) {
+			t.Errorf("binlogs expect %v, got %v", expected, r.binlogs)
+		}
+		if total, _ := r.binlogsSize(ctx); total != int64(len(expected)*len("binlog content")) {
+			t.Errorf("expect size %d, got %d", len(expected)*len("binlog content"), total)
+		}
+		r.saveArchiveCache()
+	}
+
+	r := recoverer()
+	selectBinlogs(r, []string{"binlog_1700000001_a", "binlog_1700000002_b"})
+	expectedGets := []string{storage.GTIDSetManifestName, "binlog_1700000002_b-gtid-set", "binlog_1700000001_a-gtid-set"}
+	if !reflect.DeepEqual(st.gets, expectedGets) {
+		t.Errorf("expect requests %v, got %v", expectedGets, st.gets)
+	}
+
+	r = recoverer()
+	selectBinlogs(r, []string{"binlog_1700000001_a", "binlog_1700000002_b"})
+	expectedGets = []string{storage.GTIDSetManifestName}
+	if !reflect.DeepEqual(st.gets, expectedGets) {
+		t.Errorf("expect requests %v, got %v", expectedGets, st.gets)
+	}
+	if r.cache.changed {
+		t.Error("expect the cache not to be changed")
+	}
+
+	// only the sidecar of the new binlog is read after the listing is changed
+	if err := st.PutObject(ctx, "binlog_1700000003_c", strings.NewReader("binlog content"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.PutObject(ctx, "binlog_1700000003_c-gtid-set", strings.NewReader(testUUID+":11-15"), -1); err != nil {
+		t.Fatal(err)
+	}
+	r = recoverer()
+	selectBinlogs(r, []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"})
+	expectedGets = []string{storage.GTIDSetManifestName, "binlog_1700000003_c-gtid-set"}
+	if !reflect.DeepEqual(st.gets, expectedGets) {
+		t.Errorf("expect requests %v, got %v", expectedGets, st.gets)
+	}
+
+	// the binlog uploaded again to a versioned bucket has a new version id, its sidecar is read again
+	if err := st.PutObject(ctx, "binlog_1700000002_b"+versionIDSuffix, strings.NewReader("v2"), -1); err != nil {
+		t.Fatal(err)
+	}
+	r = recoverer()
+	selectBinlogs(r, []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"})
+	expectedGets = []string{storage.GTIDSetManifestName, "binlog_1700000002_b" + versionIDSuffix, "binlog_1700000002_b-gtid-set"}
+	if !reflect.DeepEqual(st.gets, expectedGets) {
+		t.Errorf("expect requests %v, got %v", expectedGets, st.gets)
+	}
+	if e := r.cache.Binlogs["binlog_1700000002_b"]; e.Version != "v2" || e.Size == nil {
+		t.Errorf("expect cached size of version v2, got %+v", e)
+	}
+
+	r = recoverer()
+	selectBinlogs(r, []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"})
+	expectedGets = []string{storage.GTIDSetManifestName, "binlog_1700000002_b" + versionIDSuffix}
+	if !reflect.DeepEqual(st.gets, expectedGets) {
+		t.Errorf("expect requests %v, got %v", expectedGets, st.gets)
+	}
+}
+
+func TestCheckCluster(t *testing.T) {
+	const otherUUID = "b9e1dd9c-7528-11ee-8a6c-0242ac120002"
+	const targetUUID = "c6b8c2a4-7528-11ee-8a6c-0242ac120003"
+	const backupUUID = "d3a5f0e2-7528-11ee-8a6c-0242ac120004"
+	type testCase struct {
+		name          string
+		binlogSet     string
+		manifestUUIDs []string
+		startGTID     string
+		serverUUID    string
+		force         bool
+		allow         bool
+		expectErr     bool
+	}
+	cases := []testCase{
+		{
+			name:       "restored from the backup",
+			binlogSet:  testUUID + ":6-10",
+			startGTID:  testUUID + ":1-5",
+			serverUUID: targetUUID,
+		},
+		{
+			name:          "recorded server uuid",
+			binlogSet:     otherUUID + ":1-10",
+			manifestUUIDs: []string{strings.ToUpper(targetUUID)},
+			startGTID:     backupUUID + ":1-5",
+			serverUUID:    targetUUID,
+		},
+		{
+			name:       "another cluster",
+			binlogSet:  otherUUID + ":1-10",
+			startGTID:  testUUID + ":1-5",
+			serverUUID: targetUUID,
+			expectErr:  true,
+		},
+		{
+			name:       "another cluster allowed",
+			binlogSet:  otherUUID + ":1-10",
+			startGTID:  testUUID + ":1-5",
+			serverUUID: targetUUID,
+			allow:      true,
+		},
+		{
+			name:       "another cluster with force",
+			binlogSet:  otherUUID + ":1-10",
+			startGTID:  testUUID + ":1-5",
+			serverUUID: targetUUID,
+			force:      true,
+		},
+		{
+			// nothing is restored, the target has only its own server uuid
+			name:       "empty gtid_executed",
+			binlogSet:  otherUUID + ":1-10",
+			serverUUID: targetUUID,
+		},
+		{
+			name:       "binlogs without gtid sets",
+			startGTID:  testUUID + ":1-5",
+			serverUUID: targetUUID,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Recoverer{
+				db:                &fakeDB{serverUUID: c.serverUUID},
+				binlogs:           []string{"binlog_1700000001_a"},
+				binlogSets:        map[string]string{"binlog_1700000001_a": c.binlogSet},
+				manifestUUIDs:     c.manifestUUIDs,
+				startGTID:         c.startGTID,
+				force:             c.force,
+				allowOtherCluster: c.allow,
+			}
+			err := r.checkCluster(context.Background())
+			if c.expectErr != errors.Is(err, ErrWrongCluster) {
+				t.Errorf("expect wrong cluster error %t, got '%v'", c.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestSetBinlogsNonGTID(t *testing.T) {
+	type testCase struct {
+		name        string
+		recoverType RecoverType
+		gtid        string
+		expected    []string
+		expectErr   bool
+	}
+	cases := []testCase{
+		{
+			name:        "latest applies binlogs without gtid set in order",
+			recoverType: Latest,
+			expected:    []string{"binlog_1700000001_a", "binlog_1700000003_c", "binlog_1700000004_d", "binlog_1700000005_e"},
+		},
+		{
+			name:        "skip requires gtids",
+			recoverType: Skip,
+			gtid:        testUUID + ":7",
+			expectErr:   true,
+		},
+		{
+			name:        "transaction requires gtids before the target",
+			recoverType: Transaction,
+			gtid:        testUUID + ":7",
+			expectErr:   true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := newBinlogStorage([][2]string{
+				{"binlog_1700000001_a", testUUID + ":1-5"},
+				// the gtid-set object isn't uploaded, it's skipped whatever its size
+				{"binlog_1700000002_b", "-"},
+				// written with gtid_mode=OFF
+				{"binlog_1700000003_c", ""},
+				// has only the header events
+				{"binlog_1700000004_d", ""},
+				{"binlog_1700000005_e", testUUID + ":6-10"},
+			})
+			events := bytes.Repeat([]byte("x"), nonGTIDBinlogMinSize)
+			for _, binlog := range []string{"binlog_1700000002_b", "binlog_1700000003_c"} {
+				if err := st.PutObject(context.Background(), binlog, bytes.NewReader(events), int64(len(events))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &Recoverer{
+				db:          &fakeDB{},
+				storage:     st,
+				recoverType: c.recoverType,
+				gtid:        c.gtid,
+				startGTID:   testUUID + ":1-3",
+
+				binlogPrefix:  "binlog_",
+				gtidSetSuffix: "-gtid-set",
+			}
+			err := r.setBinlogs(context.Background())
+			if c.expectErr {
+				if !errors.Is(err, ErrNonGTIDBinlog) {
+					t.Fatalf("expect ErrNonGTIDBinlog, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("set binlogs: %s", err.Error())
+			}
+			if !reflect.DeepEqual(r.binlogs, c.expected) {
+				t.Errorf("binlogs expect %v, got %v", c.expected, r.binlogs)
+			}
+		})
+	}
+}
+
+func TestSetBinlogsDate(t *testing.T) {
+	st := &countingStorage{Storage: newBinlogStorage([][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+		{"binlog_1700000100_c", testUUID + ":11-15"},
+		{"binlog_1700000200_d", testUUID + ":16-20"},
+	})}
+	r := &Recoverer{
+		db:          &fakeDB{},
+		storage:     st,
+		recoverType: Date,
+		// 1700000050
+		recoverTime: "2023-11-14 22:14:10",
+		startGTID:   testUUID + ":1-7",
+
+		binlogPrefix:       "binlog_",
+		gtidSetSuffix:      "-gtid-set",
+		sidecarConcurrency: 1,
+	}
+	if err := r.setBinlogs(context.Background()); err != nil {
+		t.Fatalf("set binlogs: %s", err.Error())
+	}
+	expected := []string{"binlog_1700000002_b"}
+	if !reflect.DeepEqual(r.binlogs, expected) {
+		t.Errorf("binlogs expect %v, got %v", expected, r.binlogs)
+	}
+	for _, name := range st.gets {
+		if strings.HasPrefix(name, "binlog_1700000100_c") || strings.HasPrefix(name, "binlog_1700000200_d") {
+			t.Errorf("expect no requests of binlogs after the recovery time, got %s", name)
+		}
+	}
+}
+
+// fakeRunner records the commands and runs the simulated process of their name
+type fakeRunner struct {
+	mu        sync.Mutex
+	commands  []*fakeCommand
+	processes map[string]func(spec CommandSpec) error
+}
+
+func (f *fakeRunner) Command(ctx context.Context, spec CommandSpec) Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := &fakeCommand{spec: spec, process: f.processes[spec.Name], done: make(chan error, 1)}
+	f.commands = append(f.commands, c)
+	return c
+}
+
+type fakeCommand struct {
+	spec    CommandSpec
+	process func(spec CommandSpec) error
+	done    chan error
+	killed  atomic.Bool
+}
+
+func (c *fakeCommand) Start() error {
+	go func() { c.done <- c.process(c.spec) }()
+	return nil
+}
+
+func (c *fakeCommand) Wait() error { return <-c.done }
+
+func (c *fakeCommand) Kill() error {
+	c.killed.Store(true)
+	return nil
+}
+
+func (c *fakeCommand) String() string { return c.spec.Name + " " + strings.Join(c.spec.Args, " ") }
+
+func TestRecoverCommands(t *testing.T) {
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+	}
+	type testCase struct {
+		name        string
+		failBinlog  int // number of mysqlbinlog run which fails, none if 0
+		expectInput string
+	}
+	cases := []testCase{
+		{
+			name:        "applied in order",
+			expectInput: "-- 1\nbinlog content-- 2\nbinlog content",
+		},
+		{
+			name:       "mysqlbinlog fails",
+			failBinlog: 2,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			input := new(bytes.Buffer)
+			decoded := 0
+			runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+				"mysql": func(spec CommandSpec) error {
+					_, err := io.Copy(input, spec.Stdin)
+					return err
+				},
+				"mysqlbinlog": func(spec CommandSpec) error {
+					decoded++
+					if decoded == c.failBinlog {
+						return errors.New("corrupted binlog")
+					}
+					fmt.Fprintf(spec.Stdout, "-- %d\n", decoded)
+					_, err := io.Copy(spec.Stdout, spec.Stdin)
+					return err
+				},
+			}}
+			r := &Recoverer{
+				db:           &fakeDB{gtidExecuted: testUUID + ":1-10"},
+				storage:      newBinlogStorage(binlogs),
+				host:         "pxc-0",
+				user:         "recoverer",
+				recoverType:  Skip,
+				recoverFlags: []string{"--exclude-gtids=" + testUUID + ":7"},
+				binlogs:      []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+				binlogSets:   map[string]string{"binlog_1700000001_a": testUUID + ":1-5", "binlog_1700000002_b": testUUID + ":6-10"},
+				runner:       runner,
+			}
+			err := r.recover(context.Background())
+
+			if len(runner.commands) != 3 {
+				t.Fatalf("expect mysql and 2 mysqlbinlog commands, got %d", len(runner.commands))
+			}
+			mysql := runner.commands[0]
+			if got := mysql.String(); got != "mysql -h pxc-0 -P 33062 -u recoverer" {
+				t.Errorf("expect mysql to be started first, got '%s'", got)
+			}
+			for _, cmd := range runner.commands[1:] {
+				expected := "mysqlbinlog --disable-log-bin --exclude-gtids=" + testUUID + ":7 -"
+				if got := cmd.String(); got != expected {
+					t.Errorf("expect '%s', got '%s'", expected, got)
+				}
+			}
+
+			if c.failBinlog == 0 {
+				if err != nil {
+					t.Fatalf("recover: %s", err.Error())
+				}
+				if input.String() != c.expectInput {
+					t.Errorf("expect mysql input '%s', got '%s'", c.expectInput, input.String())
+				}
+				if mysql.killed.Load() {
+					t.Error("expect mysql to finish without kill")
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error from failed mysqlbinlog")
+			}
+			if !mysql.killed.Load() {
+				t.Error("expect mysql to be killed")
+			}
+		})
+	}
+}
+
+func TestDatabaseScanner(t *testing.T) {
+	gtid := func(n int) string {
+		return fmt.Sprintf("SET @@SESSION.GTID_NEXT= '%s:%d'/*!*/;\n", testUUID, n)
+	}
+	query := func(db, stmt string) string {
+		out := "#231114 22:13:20 server id 1  end_log_pos 100\tQuery\tthread_id=8\texec_time=0\terror_code=0\n"
+		if db != "" {
+			out += "use `" + db + "`/*!*/;\n"
+		}
+		return out + "SET TIMESTAMP=1700000000/*!*/;\n" + stmt + "\n/*!*/;\n"
+	}
+	tableMap := func(db string) string {
+		return "#231114 22:13:20 server id 1  end_log_pos 200\tTable_map: `" + db + "`.`t` mapped to number 90\n"
+	}
+	type testCase struct {
+		name      string
+		output    string
+		expectErr error
+	}
+	cases := []testCase{
+		{
+			name:   "row events of one database",
+			output: gtid(1) + query("other", "BEGIN") + tableMap("a") + tableMap("a") + gtid(2) + query("", "BEGIN") + tableMap("b"),
+		},
+		{
+			name:      "row events of two databases",
+			output:    gtid(1) + query("a", "BEGIN") + tableMap("a") + tableMap("b"),
+			expectErr: ErrCrossDatabase,
+		},
+		{
+			name:      "row events of a parallel and another database",
+			output:    gtid(1) + query("a", "BEGIN") + tableMap("a") + tableMap("c"),
+			expectErr: ErrCrossDatabase,
+		},
+		{
+			name:      "row events of not parallel databases",
+			output:    gtid(1) + query("c", "BEGIN") + tableMap("c") + tableMap("d"),
+			expectErr: ErrUnlistedDatabase,
+		},
+		{
+			name:      "statement of a not parallel database",
+			output:    gtid(1) + query("c", "BEGIN") + query("", "INSERT INTO t VALUES (1)"),
+			expectErr: ErrUnlistedDatabase,
+		},
+		{
+			name:      "statement without the default database",
+			output:    gtid(1) + query("", "CREATE USER u"),
+			expectErr: ErrUnlistedDatabase,
+		},
+		{
+			name:   "empty transaction",
+			output: gtid(1) + query("", "BEGIN") + query("", "COMMIT"),
+		},
+		{
+			name:   "statements of one database in separate transactions",
+			output: gtid(1) + query("a", "BEGIN") + query("", "INSERT INTO t VALUES (1)") + gtid(2) + query("b", "BEGIN") + query("", "INSERT INTO t VALUES (2)"),
+		},
+		{
+			name:      "statements with the default database changed",
+			output:    gtid(1) + query("a", "BEGIN") + query("", "INSERT INTO t VALUES (1)") + query("b", "INSERT INTO t VALUES (2)"),
+			expectErr: ErrCrossDatabase,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scanner := &databaseScanner{databases: []string{"a", "b"}, binlog: "binlog_1700000001_a"}
+			out := newLineFilter(scanner)
+			if _, err := io.WriteString(out, c.output); err != nil {
+				t.Fatal(err)
+			}
+			if err := out.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			scanner.endTransaction()
+			if c.expectErr == nil && scanner.err != nil || !errors.Is(scanner.err, c.expectErr) {
+				t.Errorf("expect error %v, got %v", c.expectErr, scanner.err)
+			}
+		})
+	}
+}
+
+func TestRecoverParallelDatabases(t *testing.T) {
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+	}
+	var mu sync.Mutex
+	inputs := make(map[string]*bytes.Buffer)
+	runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+		"mysql": func(spec CommandSpec) error {
+			input := new(bytes.Buffer)
+			_, err := io.Copy(input, spec.Stdin)
+			mu.Lock()
+			defer mu.Unlock()
+			// the first line is written by mysqlbinlog of the session
+			db, _, _ := strings.Cut(input.String(), "\n")
+			inputs[db] = input
+			return err
+		},
+		"mysqlbinlog": func(spec CommandSpec) error {
+			db, _ := strings.CutPrefix(spec.Args[0], "--database=")
+			fmt.Fprintf(spec.Stdout, "%s\n", db)
+			_, err := io.Copy(spec.Stdout, spec.Stdin)
+			return err
+		},
+	}}
+	r := &Recoverer{
+		db:                &fakeDB{gtidExecuted: testUUID + ":1-10"},
+		storage:           newBinlogStorage(binlogs),
+		host:              "pxc-0",
+		user:              "recoverer",
+		recoverType:       Latest,
+		binlogs:           []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		binlogSets:        map[string]string{"binlog_1700000001_a": testUUID + ":1-5", "binlog_1700000002_b": testUUID + ":6-10"},
+		parallelDatabases: []string{"a", "b"},
+		replayPort:        3306,
+		runner:            runner,
+	}
+	if err := r.recover(context.Background()); err != nil {
+		t.Fatalf("recover: %s", err.Error())
+	}
+	// a mysql session per database and a mysqlbinlog per database and binlog
+	if len(runner.commands) != 6 {
+		t.Fatalf("expect 6 commands, got %d", len(runner.commands))
+	}
+	for _, cmd := range runner.commands {
+		if cmd.spec.Name == "mysql" && cmd.String() != "mysql -h pxc-0 -P 3306 -u recoverer" {
+			t.Errorf("expect mysql to use the replay port, got '%s'", cmd.String())
+		}
+	}
+	for _, db := range []string{"a", "b"} {
+		expected := db + "\nbinlog content" + db + "\nbinlog content"
+		input, ok := inputs[db]
+		if !ok {
+			t.Errorf("expect a session of %s", db)
+			continue
+		}
+		if input.String() != expected {
+			t.Errorf("expect mysql input '%s', got '%s'", expected, input.String())
+		}
+	}
+}
+
+// TestRecoverParallelDatabasesGTIDs simulates the server applying the sessions: a transaction
+// is skipped if its GTID is already committed, e.g. by another session which got it first
+func TestRecoverParallelDatabasesGTIDs(t *testing.T) {
+	// transactions of each binlog and their databases
+	transactions := map[string][][2]string{
+		"binlog_1700000001_a": {{testUUID + ":1", "a"}, {testUUID + ":2", "b"}},
+		"binlog_1700000002_b": {{testUUID + ":3", "a"}, {testUUID + ":4", "b"}},
+	}
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-2"},
+		{"binlog_1700000002_b", testUUID + ":3-4"},
+	}
+	storage := newBinlogStorage(binlogs)
+
+	var mu sync.Mutex
+	committed := map[string]bool{}
+	rows := []string{}
+	// apply executes a statement of a session as the server does
+	apply := func(gtid *string, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if m := gtidNextRe.FindStringSubmatch(line); m != nil {
+			*gtid = m[1]
+		} else if row, ok := strings.CutPrefix(line, "INSERT "); ok && !committed[*gtid] {
+			rows = append(rows, row)
+		} else if line == "COMMIT" && *gtid != "" {
+			committed[*gtid] = true
+			*gtid = ""
+		}
+	}
+	// the session of b commits all the transactions before the session of a starts
+	bDone := make(chan struct{})
+	runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+		"mysql": func(spec CommandSpec) error {
+			in := bufio.NewReader(spec.Stdin)
+			session, err := in.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if session == "-- a\n" {
+				// the input is applied after the session of b committed
+				data, err := io.ReadAll(in)
+				if err != nil {
+					return err
+				}
+				<-bDone
+				in = bufio.NewReader(bytes.NewReader(data))
+			}
+			scanner := bufio.NewScanner(in)
+			gtid, commits := "", 0
+			for scanner.Scan() {
+				apply(&gtid, scanner.Text())
+				if scanner.Text() != "COMMIT" {
+					continue
+				}
+				// the input of b is closed after the session of a finishes,
+				// so b is done when it committed every transaction
+				if commits++; session == "-- b\n" && commits == 4 {
+					close(bDone)
+				}
+			}
+			return scanner.Err()
+		},
+		"mysqlbinlog": func(spec CommandSpec) error {
+			db, _ := strings.CutPrefix(spec.Args[0], "--database=")
+			skipGTIDs := slices.Contains(spec.Args, "--skip-gtids")
+			data, err := io.ReadAll(spec.Stdin)
+			if err != nil {
+				return err
+			}
+			// the content of the binlog object is its name in the test storage
+			fmt.Fprintf(spec.Stdout, "-- %s\n", db)
+			for _, trx := range transactions[string(data)] {
+				if !skipGTIDs {
+					fmt.Fprintf(spec.Stdout, "SET @@SESSION.GTID_NEXT= '%s'/*!*/;\n", trx[0])
+				}
+				if trx[1] == db {
+					fmt.Fprintf(spec.Stdout, "INSERT %s %s\n", db, trx[0])
+				}
+				fmt.Fprintf(spec.Stdout, "COMMIT\n")
+			}
+			return nil
+		},
+	}}
+	for _, b := range binlogs {
+		if err := storage.PutObject(context.Background(), b[0], strings.NewReader(b[0]), int64(len(b[0]))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := &fakeDB{}
+	r := &Recoverer{
+		db:                db,
+		storage:           storage,
+		host:              "pxc-0",
+		user:              "recoverer",
+		recoverType:       Latest,
+		binlogs:           []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		binlogSets:        map[string]string{"binlog_1700000001_a": testUUID + ":1-2", "binlog_1700000002_b": testUUID + ":3-4"},
+		parallelDatabases: []string{"a", "b"},
+		runner:            runner,
+	}
+	if err := r.recover(context.Background()); err != nil {
+		t.Fatalf("recover: %s", err.Error())
+	}
+	expected := []string{"a " + testUUID + ":1", "a " + testUUID + ":3", "b " + testUUID + ":2", "b " + testUUID + ":4"}
+	slices.Sort(rows)
+	if !slices.Equal(rows, expected) {
+		t.Errorf("expect rows %v, got %v", expected, rows)
+	}
+	if expected := []string{testUUID + ":1-4"}; !slices.Equal(db.purged, expected) {
+		t.Errorf("expect %v added to gtid_purged, got %v", expected, db.purged)
+	}
+}
+
+func TestRecoverCheckpoint(t *testing.T) {
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-2"},
+		{"binlog_1700000002_b", testUUID + ":3-4"},
+	}
+	storage := newBinlogStorage(binlogs)
+	for _, b := range binlogs {
+		if err := storage.PutObject(context.Background(), b[0], strings.NewReader(b[0]), int64(len(b[0]))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := &fakeDB{}
+	runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+		// transactions are committed when mysql reads the end of its input
+		"mysql": func(spec CommandSpec) error {
+			scanner := bufio.NewScanner(spec.Stdin)
+			executed := pxc.NewGTIDSet(db.gtidExecuted)
+			for scanner.Scan() {
+				if m := gtidNextRe.FindStringSubmatch(scanner.Text()); m != nil {
+					executed = executed.Union(pxc.NewGTIDSet(m[1]))
+				}
+			}
+			db.gtidExecuted = executed.Raw()
+			return scanner.Err()
+		},
+		"mysqlbinlog": func(spec CommandSpec) error {
+			data, err := io.ReadAll(spec.Stdin)
+			if err != nil {
+				return err
+			}
+			// the content of the binlog object is its name in the test storage
+			if string(data) == "binlog_1700000002_b" {
+				return errors.New("corrupted binlog")
+			}
+			for _, gtid := range []string{testUUID + ":1", testUUID + ":2"} {
+				fmt.Fprintf(spec.Stdout, "SET @@SESSION.GTID_NEXT= '%s'/*!*/;\nCOMMIT\n", gtid)
+			}
+			return nil
+		},
+	}}
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint")
+	r := &Recoverer{
+		db:             db,
+		storage:        storage,
+		host:           "pxc-0",
+		user:           "recoverer",
+		recoverType:    Latest,
+		binlogs:        []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		binlogSets:     map[string]string{"binlog_1700000001_a": testUUID + ":1-2", "binlog_1700000002_b": testUUID + ":3-4"},
+		checkpointFile: checkpointFile,
+		runner:         runner,
+	}
+	if err := r.recover(context.Background()); err == nil {
+		t.Fatal("expect recovery of the corrupted binlog to fail")
+	}
+	cp, err := readCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := checkpoint{Binlog: "binlog_1700000001_a", GTIDSet: testUUID + ":1-2"}
+	if cp != expected {
+		t.Errorf("expect checkpoint %+v, got %+v", expected, cp)
+	}
+}
+
+func TestCheckpointComplete(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint")
+	cp := checkpoint{Binlog: "binlog_1700000002_b", GTIDSet: testUUID + ":1-4"}
+	if err := writeCheckpoint(checkpointFile, cp); err != nil {
+		t.Fatal(err)
+	}
+	r := &Recoverer{
+		db: &fakeDB{},
+		storage: newBinlogStorage([][2]string{
+			{"binlog_1700000001_a", testUUID + ":1-2"},
+			{"binlog_1700000002_b", testUUID + ":3-4"},
+		}),
+		recoverType:    Latest,
+		startGTID:      testUUID + ":1-2",
+		checkpoint:     cp,
+		checkpointFile: checkpointFile,
+
+		binlogPrefix:  "binlog_",
+		gtidSetSuffix: "-gtid-set",
+	}
+	err := r.selectBinlogs(context.Background())
+	if !errors.Is(err, errCheckpointComplete) {
+		t.Fatalf("expect complete checkpoint, got %v", err)
+	}
+	// the target was restored again after the interrupted recovery
+	if err := r.finishCheckpoint(context.Background(), err); err == nil {
+		t.Error("expect error if gtid_executed doesn't contain the checkpoint")
+	}
+
+	r.startGTID = testUUID + ":1-4"
+	if err := r.finishCheckpoint(context.Background(), err); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(checkpointFile); !os.IsNotExist(err) {
+		t.Errorf("expect checkpoint to be removed, got %v", err)
+	}
+}
+
+func TestRecoverWriterSink(t *testing.T) {
+	runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+		"mysqlbinlog": func(spec CommandSpec) error {
+			_, err := io.Copy(spec.Stdout, spec.Stdin)
+			return err
+		},
+	}}
+	out := new(bytes.Buffer)
+	r := &Recoverer{
+		// gtid_executed isn't changed by the sink, so the recovery isn't verified with it
+		db:          &fakeDB{gtidExecuted: testUUID + ":1-3"},
+		storage:     newBinlogStorage([][2]string{{"binlog_1700000001_a", testUUID + ":4-5"}, {"binlog_1700000002_b", testUUID + ":6-10"}}),
+		recoverType: Latest,
+		startGTID:   testUUID + ":1-3",
+		binlogs:     []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+		binlogSets:  map[string]string{"binlog_1700000001_a": testUUID + ":4-5", "binlog_1700000002_b": testUUID + ":6-10"},
+		initSQL:     []string{"SET SESSION sql_log_bin=0"},
+		sink:        &WriterSink{W: out},
+		runner:      runner,
+	}
+	if err := r.recover(context.Background()); err != nil {
+		t.Fatalf("recover: %s", err.Error())
+	}
+	for _, cmd := range runner.commands {
+		if cmd.spec.Name == "mysql" {
+			t.Errorf("expect no mysql with the writer sink, got '%s'", cmd.String())
+		}
+	}
+	expected := "SET SESSION sql_log_bin=0;\nbinlog contentbinlog content"
+	if out.String() != expected {
+		t.Errorf("expect '%s', got '%s'", expected, out.String())
+	}
+}
+
+func TestDDLFilter(t *testing.T) {
+	header := "/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;\n" +
+		"DELIMITER /*!*/;\n" +
+		"# at 4\n"
+	footer := "SET @@SESSION.GTID_NEXT= 'AUTOMATIC' /* added by mysqlbinlog */ /*!*/;\n" +
+		"DELIMITER ;\n" +
+		"# End of log file\n" +
+		"/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=0*/;\n"
+	query := func(stmt string) string {
+		return "SET @@SESSION.GTID_NEXT= '" + testUUID + ":1'/*!*/;\n" +
+			"#231114 22:13:20 server id 1  end_log_pos 100\tQuery\tthread_id=8\n" +
+			"use `pitr`/*!*/;\n" +
+			"SET TIMESTAMP=1700000000/*!*/;\n" +
+			stmt + "\n/*!*/;\n"
+	}
+	row := "BEGIN\n/*!*/;\n" +
+		"BINLOG '\nAAAA\nBBBB\n'/*!*/;\n" +
+		"COMMIT/*!*/;\n"
+	empty := "BEGIN\n/*!*/;\nCOMMIT\n/*!*/;\n"
+	type testCase struct {
+		name            string
+		output          string
+		expected        string
+		expectedDropped int
+	}
+	cases := []testCase{
+		{
+			name:     "dml",
+			output:   header + query("INSERT INTO t VALUES (1)") + row + footer,
+			expected: header + query("INSERT INTO t VALUES (1)") + row + footer,
+		},
+		{
+			name:   "ddl",
+			output: header + query("CREATE TABLE t (\n  id int\n)") + query("/* app */ alter table t add c int") + query("DROP TABLE t") + row + footer,
+			expected: header + strings.ReplaceAll(query("x"), "x\n/*!*/;\n", empty) + strings.ReplaceAll(query("x"), "x\n/*!*/;\n", empty) +
+				strings.ReplaceAll(query("x"), "x\n/*!*/;\n", empty) + row + footer,
+			expectedDropped: 3,
+		},
+		{
+			name:     "ddl keyword in a name",
+			output:   header + query("INSERT INTO created VALUES (1)") + query("DROPPED") + footer,
+			expected: header + query("INSERT INTO created VALUES (1)") + query("DROPPED") + footer,
+		},
+		{
+			name:   "account management and temporary tables",
+			output: header + query("CREATE USER 'app'@'%' IDENTIFIED BY 'secret'") + query("DROP USER 'app'@'%'") + query("CREATE TEMPORARY TABLE tmp (id int)") + footer,
+			expected: header + strings.ReplaceAll(query("x"), "x\n/*!*/;\n", empty) + strings.ReplaceAll(query("x"), "x\n/*!*/;\n", empty) +
+				strings.ReplaceAll(query("x"), "x\n/*!*/;\n", empty) + footer,
+			expectedDropped: 3,
+		},
+		{
+			// the empty transaction implicitly commits the INSERT, UPDATE is applied with autocommit
+			name: "ddl inside a transaction",
+			output: header + "BEGIN\n/*!*/;\n" + "INSERT INTO t VALUES (1)\n/*!*/;\n" + "CREATE TEMPORARY TABLE tmp (id int)\n/*!*/;\n" +
+				"UPDATE t SET id=2\n/*!*/;\n" + "COMMIT/*!*/;\n" + footer,
+			expected: header + "BEGIN\n/*!*/;\n" + "INSERT INTO t VALUES (1)\n/*!*/;\n" + empty +
+				"UPDATE t SET id=2\n/*!*/;\n" + "COMMIT/*!*/;\n" + footer,
+			expectedDropped: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			filter := &ddlFilter{w: out}
+			lines := newLineFilter(filter)
+			if _, err := io.WriteString(lines, c.output); err != nil {
+				t.Fatal(err)
+			}
+			if err := lines.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := filter.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != c.expected {
+				t.Errorf("expect '%s', got '%s'", c.expected, out.String())
+			}
+			if filter.dropped != c.expectedDropped {
+				t.Errorf("expect %d dropped statements, got %d", c.expectedDropped, filter.dropped)
+			}
+		})
+	}
+}
+
+func TestTableFilter(t *testing.T) {
+	header := "DELIMITER /*!*/;\n" +
+		"# at 4\n" +
+		"#231114 22:13:20 server id 1  end_log_pos 126 CRC32 0x1a2b3c4d \tStart: binlog v 4\n" +
+		"BINLOG '\nFDESC\n'/*!*/;\n"
+	footer := "DELIMITER ;\n# End of log file\n"
+	row := func(tables ...string) string {
+		var maps string
+		for i, table := range tables {
+			db, name, _ := strings.Cut(table, ".")
+			maps += fmt.Sprintf("#231114 22:13:20 server id 1  end_log_pos 200 CRC32 0x1a2b3c4d \tTable_map: `%s`.`%s` mapped to number %d\n", db, name, 90+i)
+		}
+		return "BEGIN\n/*!*/;\n" + maps +
+			"#231114 22:13:20 server id 1  end_log_pos 260 CRC32 0x1a2b3c4d \tWrite_rows: table id 90 flags: STMT_END_F\n" +
+			"BINLOG '\nAAAA\nBBBB\n'/*!*/;\n" +
+			"COMMIT/*!*/;\n"
+	}
+	dropped := "BEGIN\n/*!*/;\n" +
+		"#231114 22:13:20 server id 1  end_log_pos 200 CRC32 0x1a2b3c4d \tTable_map: `shop`.`carts` mapped to number 90\n" +
+		"#231114 22:13:20 server id 1  end_log_pos 260 CRC32 0x1a2b3c4d \tWrite_rows: table id 90 flags: STMT_END_F\n" +
+		"COMMIT/*!*/;\n"
+	query := "#231114 22:13:20 server id 1  end_log_pos 100\tQuery\tthread_id=8\n" +
+		"use `shop`/*!*/;\n" +
+		"SET TIMESTAMP=1700000000/*!*/;\n"
+	type testCase struct {
+		name            string
+		output          string
+		expected        string
+		expectedDropped int
+		expectedErr     error
+	}
+	cases := []testCase{
+		{
+			name:     "allowed tables",
+			output:   header + row("shop.orders") + row("shop.order_items", "shop.orders") + footer,
+			expected: header + row("shop.orders") + row("shop.order_items", "shop.orders") + footer,
+		},
+		{
+			name:            "other tables",
+			output:          header + row("shop.carts") + row("shop.orders") + footer,
+			expected:        header + dropped + row("shop.orders") + footer,
+			expectedDropped: 1,
+		},
+		{
+			name:     "ddl",
+			output:   header + query + "CREATE TABLE carts (\n  id int\n)\n/*!*/;\n" + footer,
+			expected: header + query + "CREATE TABLE carts (\n  id int\n)\n/*!*/;\n" + footer,
+		},
+		{
+			name:        "statement-based dml",
+			output:      header + query + "BEGIN\n/*!*/;\n" + query + "INSERT INTO orders VALUES (1)\n/*!*/;\n" + footer,
+			expectedErr: ErrStatementBinlog,
+		},
+		{
+			name:        "allowed and other tables in a statement",
+			output:      header + row("shop.orders", "shop.carts") + footer,
+			expectedErr: ErrCrossTable,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			filter := newTableFilter(out, []string{"shop.orders", "shop.order_items"})
+			lines := newLineFilter(filter)
+			_, err := io.WriteString(lines, c.output)
+			if err == nil {
+				err = lines.Flush()
+			}
+			if c.expectedErr != nil {
+				if !errors.Is(err, c.expectedErr) {
+					t.Errorf("expect error '%v', got '%v'", c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := filter.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != c.expected {
+				t.Errorf("expect '%s', got '%s'", c.expected, out.String())
+			}
+			if filter.dropped != c.expectedDropped {
+				t.Errorf("expect %d dropped statements, got %d", c.expectedDropped, filter.dropped)
+			}
+		})
+	}
+}
+
+func TestRecoverDateFrozenClock(t *testing.T) {
+	st := newBinlogStorage([][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+		{"binlog_1700000003_c", testUUID + ":11-15"},
+	})
+	lastTimestamps := map[string]string{
+		"binlog_1700000001_a": "1700000050",
+		"binlog_1700000002_b": "1700000150",
+		"binlog_1700000003_c": "1700000250",
+	}
+	for binlog, ts := range lastTimestamps {
+		if err := st.PutObject(context.Background(), binlog+lastTimestampSuffix, strings.NewReader(ts), int64(len(ts))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+		"mysql": func(spec CommandSpec) error {
+			_, err := io.Copy(io.Discard, spec.Stdin)
+			return err
+		},
+		"mysqlbinlog": func(spec CommandSpec) error {
+			_, err := io.Copy(spec.Stdout, spec.Stdin)
+			return err
+		},
+	}}
+	now := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	r := &Recoverer{
+		db:             &fakeDB{},
+		storage:        st,
+		host:           "pxc-0",
+		user:           "recoverer",
+		recoverType:    Date,
+		recoverEndTime: time.Unix(1700000100, 0),
+		recoverFlags:   []string{"--stop-datetime=2023-11-14 22:15:00"},
+		binlogs:        []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"},
+		auditLogFile:   auditFile,
+		runner:         runner,
+		clock:          frozenClock(now),
+	}
+	if err := r.recover(context.Background()); err != nil {
+		t.Fatalf("recover: %s", err.Error())
+	}
+	// the binlog with the recovery time is the last one applied
+	expected := []string{"binlog_1700000001_a", "binlog_1700000002_b"}
+	if !reflect.DeepEqual(r.appliedBinlogs, expected) {
+		t.Errorf("expect %q to be applied, got %q", expected, r.appliedBinlogs)
+	}
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e auditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("unmarshal '%s': %s", line, err.Error())
+		}
+		if !e.Time.Equal(now) {
+			t.Errorf("expect entry at %s, got '%s'", now, line)
+		}
+	}
+}
+
+func TestProgressTime(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	p := newProgress(100, frozenClock(now).Now)
+	if got := p.timeProgress(time.Minute); got != "" {
+		t.Errorf("expect no progress in time without the target, got '%s'", got)
+	}
+
+	p.setTarget(1700000000, 1700000400)
+	p.cover(1700000100)
+	expected := ", covering 2023-11-14 22:15:00 of target 2023-11-14 22:20:00 (25.0%), ETA 30s"
+	if got := p.timeProgress(10 * time.Second); got != expected {
+		t.Errorf("expect '%s', got '%s'", expected, got)
+	}
+	// the last binlog has events after the recovery time
+	p.cover(1700000500)
+	expected = ", covering 2023-11-14 22:20:00 of target 2023-11-14 22:20:00 (100.0%), ETA 0s"
+	if got := p.timeProgress(10 * time.Second); got != expected {
+		t.Errorf("expect '%s', got '%s'", expected, got)
+	}
+}
+
+// lz4Compress returns data as an LZ4 frame
+func lz4Compress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := lz4.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProgressCompressed(t *testing.T) {
+	content := bytes.Repeat([]byte("binlog content "), 1000)
+	compressed := lz4Compress(t, content)
+	s, err := storage.NewDecompressing(storage.NewMemory(map[string][]byte{"binlog_1.lz4": compressed}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.GetObject(context.Background(), "binlog_1.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	p := newProgress(int64(len(compressed)), time.Now)
+	got, err := io.ReadAll(p.reader(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("unexpected decompressed content")
+	}
+	if applied := p.applied.Load(); applied != p.total {
+		t.Errorf("expect %d compressed bytes applied, got %d", p.total, applied)
+	}
+}
+
+// udfMissingSource is a source server without binlog_utils_udf installed
+type udfMissingSource struct {
+	fakeSource
+}
+
+func (s *udfMissingSource) GetBinLogFirstTimestamp(ctx context.Context, binlog string) (string, error) {
+	return "", &pxc.UDFMissingError{Host: "pxc-0", Function: "get_first_record_timestamp_by_binlog", Err: errors.New("Can't open shared library")}
+}
+
+func (s *udfMissingSource) GetBinLogLastTimestamp(ctx context.Context, binlog string) (string, error) {
+	return "", &pxc.UDFMissingError{Host: "pxc-0", Function: "get_last_record_timestamp_by_binlog", Err: errors.New("Can't open shared library")}
+}
+
+func TestRecoverDateWithoutUDF(t *testing.T) {
+	runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+		"mysql": func(spec CommandSpec) error {
+			_, err := io.Copy(io.Discard, spec.Stdin)
+			return err
+		},
+		"mysqlbinlog": func(spec CommandSpec) error { return nil },
+	}}
+	r := &Recoverer{
+		db:             &fakeDB{},
+		source:         &udfMissingSource{},
+		sourceHost:     "pxc-0",
+		host:           "pxc-1",
+		user:           "recoverer",
+		recoverType:    Date,
+		recoverEndTime: time.Unix(1700000050, 0),
+		recoverFlags:   []string{"--stop-datetime=2023-11-14 22:14:10"},
+		binlogs:        []string{"binlog.000001", "binlog.000002"},
+		runner:         runner,
+	}
+	if err := r.recover(context.Background()); err != nil {
+		t.Fatalf("recover: %s", err.Error())
+	}
+	// without timestamps the binlogs are cut by --stop-datetime only
+	if !reflect.DeepEqual(r.appliedBinlogs, r.binlogs) {
+		t.Errorf("expect %q to be applied, got %q", r.binlogs, r.appliedBinlogs)
+	}
+}
+
+func TestRecoverBinlogTimeout(t *testing.T) {
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+		{"binlog_1700000003_c", testUUID + ":11-15"},
+	}
+	storage := newBinlogStorage(binlogs)
+	for _, b := range binlogs {
+		if err := storage.PutObject(context.Background(), b[0], strings.NewReader(b[0]), int64(len(b[0]))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	decoded := map[string]int{}
+	runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+		"mysql": func(spec CommandSpec) error {
+			_, err := io.Copy(io.Discard, spec.Stdin)
+			return err
+		},
+		"mysqlbinlog": func(spec CommandSpec) error {
+			data, err := io.ReadAll(spec.Stdin)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			decoded[string(data)]++
+			mu.Unlock()
+			if string(data) == "binlog_1700000002_b" {
+				// it's killed after PITR_BINLOG_TIMEOUT
+				time.Sleep(100 * time.Millisecond)
+				return errors.New("signal: killed")
+			}
+			return nil
+		},
+	}}
+	r := &Recoverer{
+		db:            &fakeDB{},
+		storage:       storage,
+		host:          "pxc-0",
+		user:          "recoverer",
+		recoverType:   Latest,
+		binlogs:       []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"},
+		bufferBinlogs: true,
+		binlogRetries: 3,
+		binlogTimeout: 10 * time.Millisecond,
+		skipTimedOut:  true,
+		tmpDir:        t.TempDir(),
+		runner:        runner,
+	}
+	if err := r.recover(context.Background()); err != nil {
+		t.Fatalf("recover: %s", err.Error())
+	}
+	if expected := []string{"binlog_1700000001_a", "binlog_1700000003_c"}; !slices.Equal(r.appliedBinlogs, expected) {
+		t.Errorf("expect %q to be applied, got %q", expected, r.appliedBinlogs)
+	}
+	if expected := []string{"binlog_1700000002_b"}; !slices.Equal(r.skippedBinlogs, expected) {
+		t.Errorf("expect %q to be skipped, got %q", expected, r.skippedBinlogs)
+	}
+	// a timed out binlog isn't retried
+	if n := decoded["binlog_1700000002_b"]; n != 1 {
+		t.Errorf("expect timed out binlog to be decoded once, got %d", n)
+	}
+}
+
+func TestApplyBinlogBuffered(t *testing.T) {
+	for _, binlog := range []string{"binlog_1700000001_a", "archive/binlog_1700000001_a"} {
+		t.Run(binlog, func(t *testing.T) {
+			storage := newBinlogStorage([][2]string{{binlog, testUUID + ":1-5"}})
+			runner := &fakeRunner{processes: map[string]func(spec CommandSpec) error{
+				"mysqlbinlog": func(spec CommandSpec) error {
+					_, err := io.Copy(spec.Stdout, spec.Stdin)
+					return err
+				},
+			}}
+			tmpDir := t.TempDir()
+			r := &Recoverer{
+				storage:       storage,
+				bufferBinlogs: true,
+				tmpDir:        tmpDir,
+				runner:        runner,
+			}
+			var out bytes.Buffer
+			if err := r.applyBinlog(context.Background(), binlog, &out, newProgress(0, time.Now)); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != "binlog content" {
+				t.Errorf("expect decoded binlog content, got %q", out.String())
+			}
+			left, err := os.ReadDir(tmpDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 0 {
+				t.Errorf("expect temp files to be removed, got %d", len(left))
+			}
+		})
+	}
+}
+
+func TestVerifyRecoveryDate(t *testing.T) {
+	type testCase struct {
+		name      string
+		current   string
+		cutBinlog string
+		expectErr bool
+	}
+	cases := []testCase{
+		{
+			name:      "last binlog cut",
+			current:   testUUID + ":1-12",
+			cutBinlog: "binlog_1700000003_c",
+		},
+		// the binlog with the recovery time was skipped, the last applied one is complete
+		{
+			name:      "last binlog complete",
+			current:   testUUID + ":1-12",
+			expectErr: true,
+		},
+		{
+			name:    "all applied",
+			current: testUUID + ":1-15",
+		},
+		{
+			name:      "binlog before the cut one is missing",
+			current:   testUUID + ":1-5,11-12",
+			cutBinlog: "binlog_1700000003_c",
+			expectErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Recoverer{
+				db:             &fakeDB{gtidExecuted: c.current},
+				recoverType:    Date,
+				appliedBinlogs: []string{"binlog_1700000001_a", "binlog_1700000002_b", "binlog_1700000003_c"},
+				binlogSets: map[string]string{
+					"binlog_1700000001_a": testUUID + ":1-5",
+					"binlog_1700000002_b": testUUID + ":6-10",
+					"binlog_1700000003_c": testUUID + ":11-15",
+				},
+				cutBinlog: c.cutBinlog,
+			}
+			err := r.verifyRecovery(context.Background())
+			if c.expectErr != (err != nil) {
+				t.Errorf("expect error %t, got %v", c.expectErr, err)
+			}
+		})
+	}
+}
+
+// writeScript creates an executable shell script with the name in dir
+func writeScript(t *testing.T, dir, name, script string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverMysqlbinlogFails(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "mysql.pid")
+	// mysql ignores its stdin, so it exits only if it's killed
+	writeScript(t, dir, "mysql", "echo $$ > "+pidFile+"\nexec sleep 30\n")
+	// mysqlbinlog fails in the middle of the output after mysql is started
+	writeScript(t, dir, "mysqlbinlog", "while [ ! -s "+pidFile+" ]; do sleep 0.01; done\nhead -c 4 > /dev/null\necho 'BEGIN;'\nexit 1\n")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", testUUID + ":6-10"},
+	}
+	r := &Recoverer{
+		db:          &fakeDB{},
+		storage:     newBinlogStorage(binlogs),
+		host:        "localhost",
+		recoverType: Latest,
+		binlogs:     []string{"binlog_1700000001_a", "binlog_1700000002_b"},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- r.recover(context.Background()) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected error from failed mysqlbinlog")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("recover hangs after mysqlbinlog failure")
+	}
+	if !reflect.DeepEqual(r.appliedBinlogs, []string{"binlog_1700000001_a"}) {
+		t.Errorf("expect to stop at the first binlog, got %v", r.appliedBinlogs)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the process is reaped, so it doesn't exist anymore
+	if err := syscall.Kill(pid, 0); !errors.Is(err, syscall.ESRCH) {
+		t.Errorf("expect mysql process %d to be reaped, got %v", pid, err)
+	}
+}
+
+func TestRecoverForceApply(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "mysql.args")
+	writeScript(t, dir, "mysqlbinlog", "cat\n")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	binlogs := [][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+	}
+	type testCase struct {
+		name       string
+		forceApply bool
+		exit       string // the end of the mysql script
+		expectErr  bool
+	}
+	duplicate := "echo 'ERROR 1062 (23000) at line 3: Duplicate entry' >&2\n"
+	cases := []testCase{
+		{name: "strict", exit: "exit 1", expectErr: true},
+		{name: "force", forceApply: true, exit: "exit 1"},
+		// the rest of the input isn't applied after the client errors
+		{name: "force lost connection", forceApply: true, exit: "echo 'ERROR 2013 (HY000) at line 9: Lost connection to MySQL server during query' >&2\nexit 1", expectErr: true},
+		{name: "force killed", forceApply: true, exit: "kill -9 $$", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			writeScript(t, dir, "mysql", "echo \"$@\" > "+argsFile+"\ncat > /dev/null\n"+duplicate+c.exit+"\n")
+			stderr := new(bytes.Buffer)
+			r := &Recoverer{
+				db:          &fakeDB{gtidExecuted: testUUID + ":1-5"},
+				storage:     newBinlogStorage(binlogs),
+				host:        "localhost",
+				recoverType: Latest,
+				binlogs:     []string{"binlog_1700000001_a"},
+				binlogSets:  map[string]string{"binlog_1700000001_a": testUUID + ":1-5"},
+				stderr:      stderr,
+				forceApply:  c.forceApply,
+			}
+			err := r.recover(context.Background())
+			if c.expectErr && err == nil {
+				t.Error("expected error from failed statement")
+			}
+			if !c.expectErr && err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+			if !strings.Contains(stderr.String(), "Duplicate entry") {
+				t.Errorf("expect mysql error in stderr, got '%s'", stderr.String())
+			}
+			args, err := os.ReadFile(argsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(args), "--force"); got != c.forceApply {
+				t.Errorf("expect --force %t, got args '%s'", c.forceApply, strings.TrimSpace(string(args)))
+			}
+		})
+	}
+}
+
+func TestGetInitSQL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "init.sql")
+	if err := os.WriteFile(file, []byte("SET SESSION time_zone='+00:00';\n\nSET FOREIGN_KEY_CHECKS=0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	type testCase struct {
+		name       string
+		statements []string
+		file       string
+		expected   []string
+		expectErr  bool
+	}
+	cases := []testCase{
+		{
+			name:       "statements",
+			statements: []string{"SET SESSION sql_log_bin=0", " SET FOREIGN_KEY_CHECKS=0; ", ""},
+			expected:   []string{"SET SESSION sql_log_bin=0", "SET FOREIGN_KEY_CHECKS=0"},
+		},
+		{
+			name:       "file",
+			statements: []string{"SET SESSION sql_log_bin=0"},
+			file:       file,
+			expected:   []string{"SET SESSION sql_log_bin=0", "SET SESSION time_zone='+00:00'", "SET FOREIGN_KEY_CHECKS=0"},
+		},
+		{
+			name:       "multiline",
+			statements: []string{"SET SESSION\nsql_log_bin=0"},
+			expectErr:  true,
+		},
+		{
+			name:       "several statements",
+			statements: []string{"SET a=1; SET b=2"},
+			expectErr:  true,
+		},
+		{
+			name:       "quoted separator",
+			statements: []string{`SET @a='x;y'`, "SET @b=\"it\\\";s\";", "SELECT 1 AS `a;b`"},
+			expected:   []string{`SET @a='x;y'`, "SET @b=\"it\\\";s\"", "SELECT 1 AS `a;b`"},
+		},
+		{
+			name:       "separator after quoted string",
+			statements: []string{`SET @a='x'; SET @b='y'`},
+			expectErr:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := getInitSQL(c.statements, c.file)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("expect %q, got %q", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestBinlogLastTimestamp(t *testing.T) {
+	r := &Recoverer{storage: storage.NewMemory(map[string][]byte{
+		"binlog_1700000001_a":                       []byte("binlog content"),
+		"binlog_1700000001_a" + lastTimestampSuffix: []byte("1700000050\n"),
+		"binlog_1700000002_b":                       []byte("binlog content"),
+		"binlog_1700000003_c":                       []byte("binlog content"),
+		"binlog_1700000003_c" + lastTimestampSuffix: []byte("yesterday"),
+	})}
+	type testCase struct {
+		binlog    string
+		expected  int64
+		found     bool
+		expectErr bool
+	}
+	cases := []testCase{
+		{binlog: "binlog_1700000001_a", expected: 1700000050, found: true},
+		{binlog: "binlog_1700000002_b"},
+		{binlog: "binlog_1700000003_c", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.binlog, func(t *testing.T) {
+			ts, found, err := r.binlogLastTimestamp(context.Background(), c.binlog)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got %d", ts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ts != c.expected || found != c.found {
+				t.Errorf("expect %d %t, got %d %t", c.expected, c.found, ts, found)
+			}
+		})
+	}
+}
+
+func TestLineFilter(t *testing.T) {
+	var out bytes.Buffer
+	f := newLineFilter(&out, pxc.UsingPassErrorMessage)
+	// writes are split in the middle of the lines
+	input := "ERROR 1062: duplicate\n" + pxc.UsingPassErrorMessage + "\r\nWARNING: something\nno newline"
+	for _, chunk := range []string{input[:10], input[10:40], input[40:]} {
+		if _, err := f.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "ERROR 1062: duplicate\nWARNING: something\n"
+	if out.String() != expected {
+		t.Errorf("expect '%s', got '%s'", expected, out.String())
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected += "no newline"
+	if out.String() != expected {
+		t.Errorf("expect '%s', got '%s'", expected, out.String())
+	}
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	r := &Recoverer{
+		db: &fakeDB{binlogs: []pxc.Binlog{
+			{Name: "binlog.000001", Size: 1024, Encrypted: "No"},
+			{Name: "binlog.000002", Size: 157, Encrypted: "No"},
+		}},
+		startGTID:   testUUID + ":1-10",
+		snapshotDir: t.TempDir(),
+	}
+	path, err := r.writeSnapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != r.snapshotDir {
+		t.Errorf("expect snapshot in '%s', got '%s'", r.snapshotDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("unmarshal '%s': %s", data, err.Error())
+	}
+	if s.GTIDExecuted != r.startGTID {
+		t.Errorf("expect '%s', got '%s'", r.startGTID, s.GTIDExecuted)
+	}
+	if len(s.Binlogs) != 2 || s.Binlogs[1].Name != "binlog.000002" || s.Binlogs[1].Size != 157 {
+		t.Errorf("unexpected binlogs %v", s.Binlogs)
+	}
+}
+
+// BenchmarkPipeWriter feeds a pipe with 32 KiB writes, the size of the chunks
+// os/exec copies mysqlbinlog stdout and io.Copy copies buffered binlogs with,
+// with and without PITR_PIPE_BUFFER
+func BenchmarkPipeWriter(b *testing.B) {
+	chunk := bytes.Repeat([]byte("x"), 32<<10)
+	const total = 64 << 20
+	for _, size := range []int{0, 1 << 20} {
+		b.Run("buffer="+strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(total)
+			for i := 0; i < b.N; i++ {
+				pr, pw := io.Pipe()
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					// nolint:errcheck
+					io.Copy(io.Discard, pr)
+				}()
+				out := newPipeWriter(pw, size)
+				for n := 0; n < total; n += len(chunk) {
+					if _, err := out.Write(chunk); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := out.Flush(); err != nil {
+					b.Fatal(err)
+				}
+				pw.Close()
+				<-done
+			}
+		})
+	}
+}
+
+func TestCheckMysqlbinlogCapabilities(t *testing.T) {
+	type testCase struct {
+		name        string
+		output      string
+		help        string
+		recoverType RecoverType
+		rewriteDB   bool
+		expectErr   string
+	}
+	cases := []testCase{
+		{
+			name:        "percona 8.0",
+			output:      "mysqlbinlog  Ver 8.0.36-28 for Linux on x86_64 (Percona Server (GPL), Release 28, Revision 47601f19)",
+			recoverType: Transaction,
+			rewriteDB:   true,
+		},
+		{
+			name:        "old client without rewrite db",
+			output:      "mysqlbinlog  Ver 5.6.51 for Linux on x86_64",
+			recoverType: Date,
+			rewriteDB:   true,
+			expectErr:   "mysqlbinlog version 5.6.51 doesn't support --rewrite-db, version 5.7.1 or newer is required",
+		},
+		{
+			name:        "legacy client with flag",
+			output:      "mysqlbinlog Ver 3.4 for linux-glibc2.12 at x86_64",
+			help:        "  --disable-log-bin   Disable binary log.\n  --exclude-gtids=name\n",
+			recoverType: Skip,
+		},
+		{
+			name:        "mariadb client",
+			output:      "mysqlbinlog Ver 3.4 for debian-linux-gnu at x86_64",
+			help:        "  --disable-log-bin   Disable binary log.\n",
+			recoverType: Skip,
+			expectErr:   "mysqlbinlog version 3.4.0 doesn't support --exclude-gtids",
+		},
+		{
+			name:      "unknown output",
+			output:    "mysqlbinlog: unknown option",
+			expectErr: "unrecognized mysqlbinlog version 'mysqlbinlog: unknown option'",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Recoverer{recoverType: c.recoverType}
+			if c.rewriteDB {
+				r.rewriteDBFlags = []string{"--rewrite-db=a->b"}
+			}
+			v, err := parseMysqlbinlogVersion(c.output)
+			if err == nil {
+				err = checkCapabilities(v, c.help, r.requiredCapabilities())
+			}
+			if c.expectErr == "" && err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+			if c.expectErr != "" && (err == nil || err.Error() != c.expectErr) {
+				t.Errorf("expect error '%s', got '%v'", c.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestStorageTypeFromURL(t *testing.T) {
+	type testCase struct {
+		url          string
+		expectedType string
+		expectErr    bool
+	}
+	cases := []testCase{
+		{url: "s3://bucket/prefix", expectedType: "s3"},
+		{url: "https://bucket.s3.us-east-1.amazonaws.com/prefix", expectedType: "s3"},
+		{url: "https://s3.amazonaws.com/bucket/prefix", expectedType: "s3"},
+		{url: "azure://account/container/prefix", expectedType: "azure"},
+		{url: "https://account.blob.core.windows.net/container/prefix", expectedType: "azure"},
+		{url: "file:///mnt/binlogs", expectedType: "filesystem"},
+		{url: "/mnt/binlogs", expectedType: "filesystem"},
+		{url: "https://minio.local:9000/bucket", expectErr: true},
+		{url: "gs://bucket/prefix", expectedType: "s3"},
+		{url: "bucket/prefix", expectErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			storageType, err := storageTypeFromURL(c.url)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("expect error, got type '%s'", storageType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storageType != c.expectedType {
+				t.Errorf("expect '%s', got '%s'", c.expectedType, storageType)
+			}
+		})
+	}
+}
+
+func TestS3Endpoint(t *testing.T) {
+	type testCase struct {
+		bucketURL string
+		endpoint  string
+		expected  string
+	}
+	cases := []testCase{
+		{bucketURL: "s3://bucket/prefix", endpoint: defaultS3Endpoint, expected: defaultS3Endpoint},
+		{bucketURL: "gs://bucket/prefix", endpoint: defaultS3Endpoint, expected: gcsEndpoint},
+		{bucketURL: "gs://bucket/prefix", expected: gcsEndpoint},
+		{bucketURL: "gs://bucket/prefix", endpoint: "https://gcs.local", expected: "https://gcs.local"},
+	}
+	for _, c := range cases {
+		if got := s3Endpoint(c.bucketURL, c.endpoint); got != c.expected {
+			t.Errorf("%s with endpoint '%s': expect '%s', got '%s'", c.bucketURL, c.endpoint, c.expected, got)
+		}
+	}
+}
+
+func TestLoadEnvStorageURL(t *testing.T) {
+	t.Setenv("HOST", "pxc-0")
+	t.Setenv("USER", "recoverer")
+	t.Setenv("PASS", "secret")
+	t.Setenv("BINLOG_ACCESS_KEY_ID", "id")
+	t.Setenv("BINLOG_SECRET_ACCESS_KEY", "key")
+	t.Setenv("BINLOG_S3_REGION", "us-east-1")
+	t.Setenv("BINLOG_S3_BUCKET_URL", "")
+
+	t.Run("inferred", func(t *testing.T) {
+		t.Setenv("STORAGE_TYPE", "")
+		t.Setenv("PITR_STORAGE_URL", "s3://bucket/prefix")
+		cfg, err := LoadEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.StorageType != "s3" || cfg.BinlogStorageS3.BucketURL != "s3://bucket/prefix" {
+			t.Errorf("expect s3 storage with the bucket URL, got type '%s', bucket '%s'", cfg.StorageType, cfg.BinlogStorageS3.BucketURL)
+		}
+	})
+	t.Run("explicit type", func(t *testing.T) {
+		// an S3 compatible endpoint doesn't tell the storage type
+		t.Setenv("STORAGE_TYPE", "s3")
+		t.Setenv("PITR_STORAGE_URL", "https://minio.local:9000/bucket")
+		cfg, err := LoadEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BinlogStorageS3.BucketURL != "https://minio.local:9000/bucket" {
+			t.Errorf("expect the bucket URL from PITR_STORAGE_URL, got '%s'", cfg.BinlogStorageS3.BucketURL)
+		}
+	})
+	t.Run("ambiguous", func(t *testing.T) {
+		t.Setenv("STORAGE_TYPE", "")
+		t.Setenv("PITR_STORAGE_URL", "https://minio.local:9000/bucket")
+		if _, err := LoadEnv(); err == nil {
+			t.Error("expect error for the ambiguous URL")
+		}
+	})
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"yaml": `
+host: pxc-0
+user: recoverer
+pass: secret
+recover_type: date
+recover_time: "2024-01-02 03:04:05"
+rewrite_db: ["a->b", "c->d"]
+storage_type: s3
+s3:
+  access_key_id: id
+  secret_access_key: key
+  region: us-east-1
+  bucket_url: bucket/prefix
+`,
+		"json": `{"host": "pxc-0", "user": "recoverer", "pass": "secret", "recover_type": "date",
+"recover_time": "2024-01-02 03:04:05", "rewrite_db": ["a->b", "c->d"], "storage_type": "s3",
+"s3": {"access_key_id": "id", "secret_access_key": "key", "region": "us-east-1", "bucket_url": "bucket/prefix"}}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"HOST", "USER", "PASS", "PITR_RECOVERY_TYPE", "BINLOG_S3_REGION", "PITR_BINLOG_PREFIX"} {
+				t.Setenv(key, "")
+			}
+			t.Setenv("PITR_DATE", "2024-05-06 07:08:09")
+			t.Setenv("PITR_PIPE_BUFFER", "1024")
+
+			path := filepath.Join(dir, "config."+name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Host != "pxc-0" || cfg.RecoverType != "date" || cfg.BinlogStorageS3.BucketURL != "bucket/prefix" {
+				t.Errorf("expect values from the file, got host '%s', type '%s', bucket '%s'", cfg.Host, cfg.RecoverType, cfg.BinlogStorageS3.BucketURL)
+			}
+			if !reflect.DeepEqual(cfg.RewriteDB, []string{"a->b", "c->d"}) {
+				t.Errorf("expect rewrite db from the file, got %v", cfg.RewriteDB)
+			}
+			if cfg.RecoverTime != "2024-05-06 07:08:09" || cfg.PipeBuffer != 1024 {
+				t.Errorf("expect env to win, got date '%s', pipe buffer %d", cfg.RecoverTime, cfg.PipeBuffer)
+			}
+			if cfg.BinlogPrefix != "binlog_" || cfg.BinlogStorageS3.Endpoint != "s3.amazonaws.com" || cfg.ProgressInterval != 30*time.Second {
+				t.Errorf("expect defaults, got prefix '%s', endpoint '%s', progress interval %s", cfg.BinlogPrefix, cfg.BinlogStorageS3.Endpoint, cfg.ProgressInterval)
+			}
+		})
+	}
+
+	t.Run("missing required", func(t *testing.T) {
+		t.Setenv("BINLOG_S3_REGION", "")
+		path := filepath.Join(dir, "incomplete.yaml")
+		if err := os.WriteFile(path, []byte("host: pxc-0\nuser: u\npass: p\nstorage_type: s3\ns3:\n  bucket_url: b\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfigFile(path)
+		expected := "BINLOG_ACCESS_KEY_ID or access_key_id in the config file is required"
+		if err == nil || err.Error() != expected {
+			t.Errorf("expect error '%s', got '%v'", expected, err)
+		}
+	})
+}
+
+func TestResolveFromBackup(t *testing.T) {
+	manifest := []byte(`{"gtid": "` + testUUID + `:1-5", "timestamp": "2023-11-14T22:00:00Z"}`)
+	type testCase struct {
+		name         string
+		manifest     []byte
+		backupGTID   string
+		recoverTime  string
+		gtid         string
+		expectedType RecoverType
+		expectedGTID string
+		expectedErr  error
+		expectErr    bool
+	}
+	cases := []testCase{
+		{
+			name:         "latest",
+			manifest:     manifest,
+			expectedType: Latest,
+			expectedGTID: testUUID + ":1-5",
+		},
+		{
+			name:         "date after the backup",
+			manifest:     manifest,
+			recoverTime:  "2023-11-14 22:30:00",
+			expectedType: Date,
+			expectedGTID: testUUID + ":1-5",
+		},
+		{
+			name:        "date before the backup",
+			manifest:    manifest,
+			recoverTime: "2023-11-14 21:30:00",
+			expectErr:   true,
+			expectedErr: ErrTargetBeforeBackup,
+		},
+		{
+			name:         "transaction",
+			manifest:     manifest,
+			gtid:         testUUID + ":8",
+			expectedType: Transaction,
+			expectedGTID: testUUID + ":1-5",
+		},
+		{
+			name:         "no manifest",
+			backupGTID:   testUUID + ":1-3",
+			recoverTime:  "2023-11-14 21:30:00",
+			expectedType: Date,
+			expectedGTID: testUUID + ":1-3",
+		},
+		{
+			name:      "no manifest and backup gtid",
+			expectErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			objects := map[string][]byte{}
+			if c.manifest != nil {
+				objects[storage.BackupManifestName] = c.manifest
+			}
+			r := &Recoverer{
+				storage:     storage.NewMemory(objects),
+				recoverType: FromBackup,
+				backupGTID:  c.backupGTID,
+				recoverTime: c.recoverTime,
+				gtid:        c.gtid,
+			}
+			err := r.resolveFromBackup(context.Background())
+			if c.expectErr {
+				if err == nil || c.expectedErr != nil && !errors.Is(err, c.expectedErr) {
+					t.Errorf("expect error '%v', got '%v'", c.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.recoverType != c.expectedType {
+				t.Errorf("expect '%s', got '%s'", c.expectedType, r.recoverType)
+			}
+			if r.backupGTID != c.expectedGTID {
+				t.Errorf("expect '%s', got '%s'", c.expectedGTID, r.backupGTID)
+			}
+		})
+	}
+
+	t.Run("backup isn't restored", func(t *testing.T) {
+		r := &Recoverer{db: &fakeDB{}, backupGTID: testUUID + ":1-5", startGTID: testUUID + ":1-3"}
+		if err := r.checkBackupRestored(context.Background()); !errors.Is(err, ErrBackupNotRestored) {
+			t.Errorf("expect error '%v', got '%v'", ErrBackupNotRestored, err)
+		}
+		r.startGTID = testUUID + ":1-7"
+		if err := r.checkBackupRestored(context.Background()); err != nil {
+			t.Errorf("expect no error, got '%v'", err)
+		}
+	})
+}
+
+func TestRedactSecrets(t *testing.T) {
+	c := Config{
+		Pass:               "db-pass-1",
+		BinlogStorageS3:    BinlogS3{AccessKey: "s3-secret-key", SessionToken: "s3-session"},
+		BinlogStorageAzure: BinlogAzure{AccountKey: "azure-key", SASToken: "?sv=2022-11-02&sig=abc%2Bdef%3D"},
+	}
+	secrets := []string{"db-pass-1", "s3-secret-key", "s3-session", "azure-key", "sv=2022-11-02&sig=abc%2Bdef%3D", "abc+def=", "abc%2Bdef%3D"}
+	r := &Recoverer{redactor: newRedactor(c.secrets())}
+
+	logs := new(bytes.Buffer)
+	prev := log.Writer()
+	log.SetOutput(logs)
+	defer log.SetOutput(prev)
+
+	restore := redactLogs(r.redactor)
+	for _, s := range secrets {
+		log.Printf("Running mysql --password=%s", s)
+	}
+	log.Printf("GET https://account.blob.core.windows.net/c?%s: 403", strings.TrimPrefix(c.BinlogStorageAzure.SASToken, "?"))
+	restore()
+
+	stderr := new(bytes.Buffer)
+	r.stderr = stderr
+	out := r.subprocessStderr()
+	fmt.Fprintf(out, "ERROR 1045 (28000): Access denied for user with db-pass-1\n")
+	if err := out.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := redactError(fmt.Errorf("list with s3-secret-key: %w", ErrNoBinlogs), r.redactor)
+	if !errors.Is(err, ErrNoBinlogs) {
+		t.Errorf("expect error '%v', got '%v'", ErrNoBinlogs, err)
+	}
+
+	for name, output := range map[string]string{"log": logs.String(), "stderr": stderr.String(), "error": err.Error()} {
+		for _, s := range secrets {
+			if strings.Contains(output, s) {
+				t.Errorf("%s contains secret '%s': %s", name, s, output)
+			}
+		}
+		if !strings.Contains(output, redactedText) {
+			t.Errorf("%s: expect '%s', got '%s'", name, redactedText, output)
+		}
+	}
+}
+
+func TestSetBinlogsMalformedSidecar(t *testing.T) {
+	ctx := context.Background()
+	const malformed = "garbage\x00"
+	st := newBinlogStorage([][2]string{
+		{"binlog_1700000001_a", testUUID + ":1-5"},
+		{"binlog_1700000002_b", malformed},
+		{"binlog_1700000003_c", testUUID + ":11-15"},
+	})
+	recoverer := func(skip bool) *Recoverer {
+		return &Recoverer{
+			db:          &fakeDB{malformed: malformed},
+			storage:     st,
+			recoverType: Latest,
+			startGTID:   testUUID + ":1-3",
+
+			binlogPrefix:          "binlog_",
+			gtidSetSuffix:         "-gtid-set",
+			skipMalformedSidecars: skip,
+		}
+	}
+
+	err := recoverer(false).setBinlogs(ctx)
+	var sErr *MalformedSidecarError
+	if !errors.As(err, &sErr) {
+		t.Fatalf("expect MalformedSidecarError, got '%v'", err)
+	}
+	if sErr.Binlog != "binlog_1700000002_b" || sErr.Sidecar != "binlog_1700000002_b-gtid-set" {
+		t.Errorf("expect binlog_1700000002_b and its sidecar, got '%s' and '%s'", sErr.Binlog, sErr.Sidecar)
+	}
+	if !errors.Is(err, ErrBadGTIDFormat) {
+		t.Error("expect error to match ErrBadGTIDFormat")
+	}
+
+	r := recoverer(true)
+	if err := r.setBinlogs(ctx); err != nil {
+		t.Fatalf("set binlogs: %s", err.Error())
+	}
+	expected := []string{"binlog_1700000001_a", "binlog_1700000003_c"}
+	if !reflect.DeepEqual(r.binlogs, expected) {
+		t.Errorf("binlogs expect %v, got %v", expected, r.binlogs)
+	}
+}
+
+// fakeMembers reports the healthy members of the cluster
+type fakeMembers struct {
+	healthy []string
+	err     error
+	closed  int
+}
+
+func (m *fakeMembers) FilterHealthyClusterMembers(ctx context.Context, hosts []string) ([]string, error) {
+	return m.healthy, m.err
+}
+
+func (m *fakeMembers) Close() error {
+	m.closed++
+	return nil
+}
+
+func TestControlHost(t *testing.T) {
+	type testCase struct {
+		healthy   []string
+		err       error
+		expected  string
+		expectErr bool
+	}
+	tests := map[string]testCase{
+		"target healthy": {
+			healthy:  []string{"pxc-0", "pxc-1"},
+			expected: "pxc-1",
+		},
+		"target unhealthy": {
+			healthy:  []string{"pxc-2", "pxc-0"},
+			expected: "pxc-2",
+		},
+		"no healthy members": {
+			expectErr: true,
+		},
+		"filter error": {
+			err:       errors.New("connection refused"),
+			expectErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			members := &fakeMembers{healthy: tc.healthy, err: tc.err}
+			r := &Recoverer{
+				host:    "pxc-1",
+				hosts:   []string{"pxc-0", "pxc-1", "pxc-2"},
+				members: members,
+			}
+			host, err := r.controlHost(context.Background())
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expect error, got host %s", host)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if host != tc.expected {
+				t.Errorf("expect host '%s', got '%s'", tc.expected, host)
+			}
+			if members.closed != 1 {
+				t.Errorf("expect member filter to be closed once, got %d", members.closed)
+			}
+		})
+	}
+}
+
+func TestCheckTargetReadOnly(t *testing.T) {
+	type testCase struct {
+		controlHost string
+		readOnly    bool
+		disableRO   bool
+		expectErr   bool
+	}
+	tests := map[string]testCase{
+		"writable target":                  {controlHost: "pxc-1"},
+		"read only target":                 {controlHost: "pxc-1", readOnly: true, expectErr: true},
+		"disable read only":                {controlHost: "pxc-1", readOnly: true, disableRO: true},
+		"read only target via other host":  {controlHost: "pxc-0", readOnly: true, expectErr: true},
+		"disable read only via other host": {controlHost: "pxc-0", readOnly: true, disableRO: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			control := &fakeDB{host: tc.controlHost}
+			target := control
+			var connected []string
+			r := &Recoverer{
+				host:      "pxc-1",
+				db:        control,
+				disableRO: tc.disableRO,
+				connect: func(host string) (database, error) {
+					connected = append(connected, host)
+					return &fakeDB{host: host}, nil
+				},
+			}
+			if err := r.connectTarget(); err != nil {
+				t.Fatal(err)
+			}
+			if tc.controlHost != r.host {
+				if !slices.Equal(connected, []string{r.host}) {
+					t.Fatalf("expect connection to %s, got %v", r.host, connected)
+				}
+				target = r.target.(*fakeDB)
+			} else if len(connected) != 0 || r.target != r.db {
+				t.Fatalf("expect control connection to be reused, got connections to %v", connected)
+			}
+			target.readOnly = tc.readOnly
+
+			err := r.checkTargetReadOnly(context.Background())
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("expect error %t, got %v", tc.expectErr, err)
+			}
+			expectDisabled := 0
+			if tc.readOnly && tc.disableRO {
+				expectDisabled = 1
+			}
+			if target.roDisabled != expectDisabled {
+				t.Errorf("expect %d DisableReadOnly calls on the target, got %d", expectDisabled, target.roDisabled)
+			}
+			if target != control && control.roDisabled != 0 {
+				t.Error("read only mode is disabled on the control host")
+			}
+
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if target != control && target.closed != 1 {
+				t.Errorf("expect target connection to be closed once, got %d", target.closed)
+			}
 		})
 	}
 }
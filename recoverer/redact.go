@@ -0,0 +1,99 @@
+package recoverer
+
+import (
+	"io"
+	"log"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// redactedText replaces credentials in the log, subprocess output and errors
+const redactedText = "***"
+
+// secrets returns credentials of the config which must not be logged
+func (c Config) secrets() []string {
+	secrets := []string{c.Pass, c.BinlogStorageS3.AccessKey, c.BinlogStorageS3.SessionToken, c.BinlogStorageAzure.AccountKey}
+	if token := c.BinlogStorageAzure.SASToken; token != "" {
+		secrets = append(secrets, token)
+		// request URLs may contain the signature without the rest of the token
+		if q, err := url.ParseQuery(strings.TrimPrefix(token, "?")); err == nil && q.Get("sig") != "" {
+			secrets = append(secrets, q.Get("sig"), url.QueryEscape(q.Get("sig")))
+		}
+	}
+	return secrets
+}
+
+// newRedactor returns a replacer of secrets with redactedText, nil if there are no secrets.
+// Longer secrets are replaced first, so a secret containing another one isn't partially kept.
+func newRedactor(secrets []string) *strings.Replacer {
+	var unique []string
+	for _, s := range secrets {
+		if s != "" && !slices.Contains(unique, s) {
+			unique = append(unique, s)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+	sort.Slice(unique, func(i, j int) bool { return len(unique[i]) > len(unique[j]) })
+	pairs := make([]string, 0, len(unique)*2)
+	for _, s := range unique {
+		pairs = append(pairs, s, redactedText)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// redactingWriter replaces secrets in everything written to w
+type redactingWriter struct {
+	w        io.Writer
+	replacer *strings.Replacer
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, r.replacer.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactWriter returns w which output is redacted by replacer, w itself if replacer is nil
+func redactWriter(w io.Writer, replacer *strings.Replacer) io.Writer {
+	if replacer == nil {
+		return w
+	}
+	return &redactingWriter{w: w, replacer: replacer}
+}
+
+// redactLogs redacts output of the standard logger until the returned function is called
+func redactLogs(replacer *strings.Replacer) (restore func()) {
+	if replacer == nil {
+		return func() {}
+	}
+	prev := log.Writer()
+	log.SetOutput(redactWriter(prev, replacer))
+	return func() { log.SetOutput(prev) }
+}
+
+// redactedError is an error with secrets replaced in its message.
+// errors.Is and errors.As see the original error.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactError returns err with secrets redacted by replacer
+func redactError(err error, replacer *strings.Replacer) error {
+	if err == nil || replacer == nil {
+		return err
+	}
+	msg := replacer.Replace(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, msg: msg}
+}
@@ -0,0 +1,98 @@
+package recoverer
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// replaySession is a stream of the sink which receives decoded binlogs written to out
+type replaySession struct {
+	database string // only events of the database are applied, all if empty
+	stream   SinkStream
+	out      flushWriter
+	errors   *errorCounter // failed statements of mysql, none for other sinks
+	finished bool
+}
+
+// startSession opens a stream of the sink for the session
+func (r *Recoverer) startSession(ctx context.Context, database string) (*replaySession, error) {
+	stream, err := r.applySink().Open(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	s := &replaySession{database: database, stream: stream, errors: &errorCounter{}}
+	if m, ok := stream.(*mysqlStream); ok {
+		s.errors = m.errors
+	}
+	s.out = newPipeWriter(stream, r.pipeBuffer)
+	return s, nil
+}
+
+// startSessions starts a session for each database and runs PITR_INIT_SQL in them
+func (r *Recoverer) startSessions(ctx context.Context, databases []string) ([]*replaySession, error) {
+	sessions := make([]*replaySession, 0, len(databases))
+	for _, database := range databases {
+		s, err := r.startSession(ctx, database)
+		if err != nil {
+			for _, s := range sessions {
+				s.abort(err)
+			}
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if len(r.initSQL) > 0 {
+		// mysql runs them in the same session as the binlogs
+		log.Printf("Running %d init statements", len(r.initSQL))
+		for _, s := range sessions {
+			if _, err := io.WriteString(s.out, strings.Join(r.initSQL, ";\n")+";\n"); err != nil {
+				for _, s := range sessions {
+					s.abort(err)
+				}
+				return nil, errors.Wrap(err, "write init sql to mysql")
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// finishSessions finishes the sessions and returns the number of failed
+// statements skipped by PITR_FORCE_APPLY and the first of them
+func finishSessions(sessions []*replaySession) (int, []string, error) {
+	failed := 0
+	var first []string
+	for _, s := range sessions {
+		if err := s.finish(); err != nil {
+			if s.database != "" {
+				return 0, nil, errors.Wrapf(err, "wait mysql applying %s", s.database)
+			}
+			return 0, nil, errors.Wrap(err, "wait mysql")
+		}
+		failed += s.errors.count
+		first = append(first, s.errors.first...)
+	}
+	return failed, first, nil
+}
+
+// finish writes the rest of the output to the stream and waits for the sink to process it
+func (s *replaySession) finish() error {
+	if err := s.out.Flush(); err != nil {
+		return errors.Wrap(err, "flush binlog stdout")
+	}
+	err := s.stream.Close()
+	s.finished = true
+	return err
+}
+
+// abort stops the sink if the session isn't finished
+func (s *replaySession) abort(err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.stream.Abort(err)
+}
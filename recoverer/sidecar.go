@@ -0,0 +1,314 @@
+package recoverer
+
+import (
+	"context"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mysql-pitr-helper/pxc"
+	"mysql-pitr-helper/storage"
+
+	"github.com/pkg/errors"
+)
+
+// lastTimestampSuffix is a name suffix of objects with unix time of the last event of a binlog
+const lastTimestampSuffix = "-last-timestamp"
+
+// versionIDSuffix is a name suffix of objects with the version id of a binlog,
+// they're uploaded only to buckets with versioning enabled
+const versionIDSuffix = "-version-id"
+
+// isSidecar returns true if the object is a gtid set or a timestamp object of a binlog.
+// Sidecars of compressed binlogs may have the compression suffix too, see fetchSidecar.
+func (r *Recoverer) isSidecar(name string) bool {
+	name, _ = storage.TrimCompressionSuffix(name)
+	return strings.HasSuffix(name, r.gtidSetSuffix) || strings.HasSuffix(name, lastTimestampSuffix)
+}
+
+// sidecar is a gtid set object of a binlog
+type sidecar struct {
+	binlog  string
+	gtidSet string
+	object  string // where the gtid set is read from, empty for binlogs of a server
+	getErr  error  // the object can't be fetched
+	err     error  // the object can't be read
+}
+
+// MalformedSidecarError is returned if the server rejects the gtid set of a binlog as malformed
+type MalformedSidecarError struct {
+	Binlog  string
+	Sidecar string // the object with the gtid set, empty for binlogs of a server
+	Err     error
+}
+
+func (e *MalformedSidecarError) Error() string {
+	if e.Sidecar == "" {
+		return "malformed gtid set of binlog " + e.Binlog + ": " + e.Err.Error()
+	}
+	return "malformed gtid set of binlog " + e.Binlog + " in " + e.Sidecar + ": " + e.Err.Error()
+}
+
+func (e *MalformedSidecarError) Unwrap() error {
+	return e.Err
+}
+
+// malformedSidecar checks err of a GTID function called with the gtid set of sc.
+// MalformedSidecarError is returned if the set is malformed, or skip is true with
+// PITR_SKIP_MALFORMED_SIDECARS. Other errors are returned as is.
+func (r *Recoverer) malformedSidecar(sc sidecar, gtidSet string, err error) (skip bool, _ error) {
+	var fErr *pxc.GTIDFormatError
+	if !errors.As(err, &fErr) || fErr.Set != gtidSet {
+		return false, err
+	}
+	err = &MalformedSidecarError{Binlog: sc.binlog, Sidecar: sc.object, Err: err}
+	if !r.skipMalformedSidecars {
+		return false, err
+	}
+	log.Printf("WARNING: skipping binlog %s because PITR_SKIP_MALFORMED_SIDECARS is set: %v", sc.binlog, err)
+	return true, nil
+}
+
+// sidecarFetcher fetches sidecars concurrently and returns them in order of binlogs
+type sidecarFetcher struct {
+	cancel  context.CancelFunc
+	pending chan chan sidecar
+	wg      sync.WaitGroup
+}
+
+// fetchSidecars starts fetching gtid set sidecars of binlogs with up to
+// r.sidecarConcurrency requests at the same time. stop() must be called
+// when the sidecars are not needed anymore.
+func (r *Recoverer) fetchSidecars(ctx context.Context, binlogs []string) *sidecarFetcher {
+	concurrency := max(r.sidecarConcurrency, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	f := &sidecarFetcher{
+		cancel:  cancel,
+		pending: make(chan chan sidecar, concurrency),
+	}
+	sem := make(chan struct{}, concurrency)
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer close(f.pending)
+		for _, binlog := range binlogs {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			res := make(chan sidecar, 1)
+			select {
+			case f.pending <- res:
+			case <-ctx.Done():
+				<-sem
+				return
+			}
+			f.wg.Add(1)
+			go func() {
+				defer f.wg.Done()
+				defer func() { <-sem }()
+				res <- r.fetchSidecar(ctx, binlog)
+			}()
+		}
+	}()
+	return f
+}
+
+func (r *Recoverer) fetchSidecar(ctx context.Context, binlog string) sidecar {
+	sc := sidecar{binlog: binlog}
+	if r.source != nil {
+		sc.gtidSet, sc.getErr = r.source.GetGTIDSet(ctx, binlog)
+		return sc
+	}
+	if set, ok := r.manifestSets[binlog]; ok {
+		sc.gtidSet, sc.object = set, storage.GTIDSetManifestName
+		return sc
+	}
+	version, err := r.cacheVersion(ctx, binlog)
+	if err != nil {
+		sc.err = errors.Wrapf(err, "get %s version id", binlog)
+		return sc
+	}
+	if e, ok := r.cache.entry(binlog, version); ok && e.GTIDSet != nil {
+		sc.gtidSet, sc.object = *e.GTIDSet, r.cache.file
+		return sc
+	}
+	sc.object = binlog + r.gtidSetSuffix
+	obj, err := r.storage.GetObject(ctx, sc.object)
+	if name, ext := storage.TrimCompressionSuffix(binlog); ext != "" && errors.Is(err, storage.ErrObjectNotFound) {
+		// sidecar of a compressed binlog may be compressed with the same codec
+		sc.object = name + r.gtidSetSuffix + ext
+		obj, err = r.storage.GetObject(ctx, sc.object)
+	}
+	if err != nil {
+		sc.getErr = err
+		return sc
+	}
+	defer obj.Close()
+	content, err := io.ReadAll(obj)
+	if err != nil {
+		sc.err = errors.Wrapf(err, "read %s gtid-set object", binlog)
+		return sc
+	}
+	sc.gtidSet = string(content)
+	r.cache.set(binlog, version, func(e *cachedBinlog) { e.GTIDSet = &sc.gtidSet })
+	return sc
+}
+
+// readManifest reads gtid sets of binlogs from the manifest uploaded by the collector.
+// Binlogs missing in it, e.g. uploaded before the manifest was enabled, use their gtid-set objects.
+func (r *Recoverer) readManifest(ctx context.Context, binlogs []string) {
+	r.manifestSets = nil
+	r.manifestUUIDs = nil
+	if r.source != nil {
+		return
+	}
+	m, err := storage.ReadGTIDSetManifest(ctx, r.storage)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return
+	}
+	if err != nil {
+		log.Println("WARNING: reading gtid-set objects of binlogs instead of the manifest:", err)
+		return
+	}
+	r.manifestSets = m.Binlogs
+	r.manifestUUIDs = m.ServerUUIDs
+	missing := 0
+	for _, binlog := range binlogs {
+		if _, ok := m.Binlogs[binlog]; !ok {
+			missing++
+		}
+	}
+	log.Printf("using gtid sets of %d binlogs from %s, %d binlogs are not in it", len(binlogs)-missing, storage.GTIDSetManifestName, missing)
+}
+
+// binlogLastTimestamp returns unix time of the last event of the binlog,
+// false is returned if the binlog has no timestamp object
+func (r *Recoverer) binlogLastTimestamp(ctx context.Context, binlog string) (int64, bool, error) {
+	var content, version string
+	if r.source != nil {
+		ts, err := r.source.GetBinLogLastTimestamp(ctx, binlog)
+		if errors.Is(err, pxc.ErrUDFMissing) {
+			// the caller falls back to the first timestamp
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "get %s last timestamp", binlog)
+		}
+		content = ts
+	} else {
+		var err error
+		version, err = r.cacheVersion(ctx, binlog)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "get %s version id", binlog)
+		}
+		if e, ok := r.cache.entry(binlog, version); ok && e.LastTS != nil {
+			return *e.LastTS, true, nil
+		}
+		obj, err := r.storage.GetObject(ctx, binlog+lastTimestampSuffix)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "get %s timestamp object", binlog)
+		}
+		defer obj.Close()
+		data, err := io.ReadAll(obj)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "read %s timestamp object", binlog)
+		}
+		content = string(data)
+	}
+	ts, err := strconv.ParseInt(strings.TrimSpace(content), 10, 64)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "parse %s last timestamp", binlog)
+	}
+	if r.source == nil {
+		r.cache.set(binlog, version, func(e *cachedBinlog) { e.LastTS = &ts })
+	}
+	return ts, true, nil
+}
+
+// binlogLastTimestamps returns unix time of the last event of the selected binlogs,
+// binlogs without the timestamp object are missing in the result
+func (r *Recoverer) binlogLastTimestamps(ctx context.Context) (map[string]int64, error) {
+	timestamps := make(map[string]int64, len(r.binlogs))
+	for _, binlog := range r.binlogs {
+		ts, ok, err := r.binlogLastTimestamp(ctx, binlog)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			timestamps[binlog] = ts
+		}
+	}
+	return timestamps, nil
+}
+
+// next returns the next sidecar in order of binlogs, false if there are no more
+func (f *sidecarFetcher) next() (sidecar, bool) {
+	res, ok := <-f.pending
+	if !ok {
+		return sidecar{}, false
+	}
+	return <-res, true
+}
+
+// stop cancels fetching of the rest sidecars and waits for running requests
+func (f *sidecarFetcher) stop() {
+	f.cancel()
+	for range f.pending {
+	}
+	f.wg.Wait()
+}
+
+// withBinlogVersion returns ctx which selects the binlog version recorded by the collector,
+// so the binlog matches its gtid set even if it was uploaded again.
+// ctx is returned as is if there is no version id object of the binlog.
+func (r *Recoverer) withBinlogVersion(ctx context.Context, binlog string) (context.Context, error) {
+	id, err := r.binlogVersion(ctx, binlog)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return ctx, nil
+	}
+	return storage.WithVersionID(ctx, id), nil
+}
+
+// binlogVersion returns the binlog version recorded by the collector,
+// empty if there is no version id object of the binlog
+func (r *Recoverer) binlogVersion(ctx context.Context, binlog string) (string, error) {
+	r.versionsMu.Lock()
+	id, ok := r.binlogVersions[binlog]
+	r.versionsMu.Unlock()
+	if !ok || id != "" {
+		return id, nil
+	}
+	obj, err := r.storage.GetObject(ctx, binlog+versionIDSuffix)
+	if err != nil {
+		return "", errors.Wrapf(err, "get %s version id object", binlog)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s version id object", binlog)
+	}
+	id = strings.TrimSpace(string(data))
+	r.versionsMu.Lock()
+	r.binlogVersions[binlog] = id
+	r.versionsMu.Unlock()
+	return id, nil
+}
+
+// cacheVersion returns the binlog version the archive cache entries of the binlog are kept for.
+// It's fetched only if the cache is used.
+func (r *Recoverer) cacheVersion(ctx context.Context, binlog string) (string, error) {
+	if r.cache == nil {
+		return "", nil
+	}
+	return r.binlogVersion(ctx, binlog)
+}
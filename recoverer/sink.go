@@ -0,0 +1,184 @@
+package recoverer
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ApplySink is a consumer of the decoded binlogs. The recovery opens a stream
+// per session, writes mysqlbinlog output to it and closes it after the last binlog.
+type ApplySink interface {
+	// Open returns a stream receiving events of the database, of all databases if it's empty
+	Open(ctx context.Context, database string) (SinkStream, error)
+}
+
+// SinkStream receives the decoded binlogs of a session
+type SinkStream interface {
+	io.Writer
+	// Close waits for the consumer to process everything written to the stream
+	Close() error
+	// Abort stops the consumer, the written data may be partially processed
+	Abort(err error)
+}
+
+// MySQLSink applies the decoded binlogs with the mysql client connected to the replay target.
+// It's used if Config.ApplySink is nil and PITR_SINK is mysql.
+type MySQLSink struct {
+	r *Recoverer
+}
+
+// Open starts mysql reading from the returned stream
+func (m *MySQLSink) Open(ctx context.Context, database string) (SinkStream, error) {
+	r := m.r
+	s := &mysqlStream{force: r.forceApply}
+	s.stdin, s.pipe = io.Pipe()
+
+	mysqlArgs := []string{"-h", r.host, "-P", strconv.Itoa(portOrAdmin(r.replayPort)), "-u", r.user}
+	if r.forceApply {
+		mysqlArgs = append(mysqlArgs, "--force")
+	}
+	s.stderr = r.subprocessStderr()
+	s.errors = &errorCounter{w: s.stderr.w}
+	s.stderr.w = s.errors
+	s.cmd = r.command(ctx, CommandSpec{
+		Name:   "mysql",
+		Args:   mysqlArgs,
+		Stdin:  s.stdin,
+		Stdout: r.subprocessStdout(),
+		Stderr: s.stderr,
+	})
+	log.Printf("Running %s", s.cmd.String())
+	if err := s.cmd.Start(); err != nil {
+		s.stdin.Close()
+		return nil, errors.Wrap(err, "start mysql")
+	}
+	return s, nil
+}
+
+// mysqlStream is a mysql process applying the data written to its stdin
+type mysqlStream struct {
+	cmd    Command
+	stdin  *io.PipeReader
+	pipe   *io.PipeWriter
+	stderr *lineFilter
+	errors *errorCounter
+	force  bool // failed statements don't fail the stream
+}
+
+func (s *mysqlStream) Write(p []byte) (int, error) {
+	return s.pipe.Write(p)
+}
+
+// Close closes the input of mysql and waits for it to apply the rest of it
+func (s *mysqlStream) Close() error {
+	if err := s.pipe.Close(); err != nil {
+		return errors.Wrap(err, "close binlog stdout")
+	}
+	err := s.cmd.Wait()
+	s.stdin.Close()
+	// nolint:errcheck
+	s.stderr.Flush()
+	if err == nil || !s.force {
+		return err
+	}
+	if s.errors.client != "" {
+		return errors.Wrapf(err, "mysql client failed: %s", s.errors.client)
+	}
+	var exitErr *exec.ExitError
+	if s.errors.count > 0 && errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// with --force mysql exits with status 1 if any statement failed,
+		// a signal or another status means it didn't read the whole input
+		return nil
+	}
+	return err
+}
+
+// Abort kills mysql rather than feeding it EOF, so a partially written transaction
+// isn't committed, and reaps it so it isn't left behind
+func (s *mysqlStream) Abort(err error) {
+	// no error handling because the process may be already finished
+	// and CloseWithError() always return nil error
+	// nolint:errcheck
+	s.cmd.Kill()
+	// nolint:errcheck
+	s.pipe.CloseWithError(err)
+	// nolint:errcheck
+	s.cmd.Wait()
+	s.stdin.Close()
+	// nolint:errcheck
+	s.stderr.Flush()
+}
+
+// WriterSink writes the decoded binlogs to W, e.g. to pipe them to a CDC processor.
+// The target isn't changed, so the recovery isn't verified with its gtid_executed.
+type WriterSink struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// NewStdoutSink returns a WriterSink writing to os.Stdout
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{W: os.Stdout}
+}
+
+// Open returns a stream writing to W, the stream doesn't close W
+func (w *WriterSink) Open(ctx context.Context, database string) (SinkStream, error) {
+	return &writerStream{sink: w}, nil
+}
+
+type writerStream struct {
+	sink *WriterSink
+}
+
+func (s *writerStream) Write(p []byte) (int, error) {
+	s.sink.mu.Lock()
+	defer s.sink.mu.Unlock()
+	return s.sink.W.Write(p)
+}
+
+func (s *writerStream) Close() error { return nil }
+
+func (s *writerStream) Abort(err error) {}
+
+// sinkFromConfig returns the sink of PITR_SINK, nil for mysql
+func sinkFromConfig(c Config) (ApplySink, error) {
+	if c.ApplySink != nil {
+		return c.ApplySink, nil
+	}
+	switch c.Sink {
+	case "", "mysql":
+		return nil, nil
+	case "stdout":
+		if c.Stdout != nil {
+			return &WriterSink{W: c.Stdout}, nil
+		}
+		return NewStdoutSink(), nil
+	default:
+		return nil, errors.Errorf("unknown PITR_SINK %s, expected mysql or stdout", c.Sink)
+	}
+}
+
+// applySink returns the configured sink, MySQLSink if it's not set
+func (r *Recoverer) applySink() ApplySink {
+	if r.sink == nil {
+		return &MySQLSink{r: r}
+	}
+	return r.sink
+}
+
+// appliesToTarget returns true if the binlogs are applied to the replay target
+func (r *Recoverer) appliesToTarget() bool {
+	if r.sink == nil {
+		return true
+	}
+	_, ok := r.sink.(*MySQLSink)
+	return ok
+}
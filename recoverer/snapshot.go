@@ -0,0 +1,60 @@
+package recoverer
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// snapshot is the server state before recovery, it's the baseline
+// to compare with if the recovery goes wrong
+type snapshot struct {
+	Time         time.Time        `json:"time"`
+	Host         string           `json:"host"`
+	GTIDExecuted string           `json:"gtid_executed"`
+	Binlogs      []snapshotBinlog `json:"binlogs"`
+}
+
+type snapshotBinlog struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Encrypted string `json:"encrypted"`
+}
+
+// writeSnapshot records r.startGTID and binary logs of the server
+// to a timestamped file in the snapshot directory and returns its path
+func (r *Recoverer) writeSnapshot(ctx context.Context) (string, error) {
+	binlogs, err := r.targetDB().ListBinLogs(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "list binary logs")
+	}
+	s := snapshot{
+		Time:         r.now().UTC(),
+		Host:         r.targetDB().GetHost(),
+		GTIDExecuted: r.startGTID,
+		Binlogs:      make([]snapshotBinlog, 0, len(binlogs)),
+	}
+	for _, b := range binlogs {
+		s.Binlogs = append(s.Binlogs, snapshotBinlog{Name: b.Name, Size: b.Size, Encrypted: b.Encrypted})
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "marshal snapshot")
+	}
+
+	dir := r.snapshotDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, "pitr-snapshot-"+s.Time.Format("20060102T150405Z")+".json")
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		return "", errors.Wrapf(err, "write %s", path)
+	}
+	log.Printf("pre-recovery gtid_executed %s and %d binary logs are recorded in %s", s.GTIDExecuted, len(s.Binlogs), path)
+	return path, nil
+}
@@ -0,0 +1,182 @@
+package recoverer
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysql-pitr-helper/pxc"
+	"mysql-pitr-helper/storage"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SourceStorage = "storage" // binlogs are downloaded from the storage
+	SourceServer  = "server"  // binlogs are streamed from a live server with mysqlbinlog --read-from-remote-server
+)
+
+// binlogServer is a set of pxc.PXC methods used to read binlogs from a live server
+type binlogServer interface {
+	GetBinLogNamesList(ctx context.Context) ([]string, error)
+	GetGTIDSet(ctx context.Context, binlogName string) (string, error)
+	GetBinLogFirstTimestamp(ctx context.Context, binlog string) (string, error)
+	GetBinLogLastTimestamp(ctx context.Context, binlog string) (string, error)
+	DropCollectorFunctions(ctx context.Context) error
+	Close() error
+}
+
+// sourceCleanupTimeout limits dropping of the functions on the source server, so it doesn't delay the exit
+const sourceCleanupTimeout = 30 * time.Second
+
+// dropSourceFunctions drops binlog_utils_udf functions created on the source server
+// to read the binlogs. It's a live server, so they aren't left on it even if the recovery failed.
+func (r *Recoverer) dropSourceFunctions(ctx context.Context) {
+	if r.source == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sourceCleanupTimeout)
+	defer cancel()
+	if err := r.source.DropCollectorFunctions(ctx); err != nil {
+		log.Printf("WARNING: drop collector functions on %s: %v", r.sourceHost, err)
+	}
+}
+
+// listBinlogs returns names of the binlogs in the storage or on the source server
+func (r *Recoverer) listBinlogs(ctx context.Context) ([]string, error) {
+	if r.source != nil {
+		list, err := r.source.GetBinLogNamesList(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list binlogs on %s", r.sourceHost)
+		}
+		return list, nil
+	}
+	return r.listObjects(ctx, r.binlogPrefix)
+}
+
+// listObjects lists objects with the prefix. A failed listing is resumed
+// after the last listed name up to listRetries times with exponential backoff.
+func (r *Recoverer) listObjects(ctx context.Context, prefix string) ([]string, error) {
+	var list []string
+	for attempt := 0; ; attempt++ {
+		startAfter := ""
+		if len(list) > 0 {
+			startAfter = list[len(list)-1]
+		}
+		page, err := r.storage.ListObjects(storage.WithStartAfter(ctx, startAfter), prefix)
+		for _, name := range page {
+			// storages ignoring startAfter list the names again
+			if name > startAfter {
+				list = append(list, name)
+			}
+		}
+		if err == nil {
+			return list, nil
+		}
+		if attempt >= r.listRetries || ctx.Err() != nil {
+			return nil, errors.Wrapf(err, "list objects with prefix '%s'", prefix)
+		}
+		delay := r.listBackoff << attempt
+		log.Printf("listing objects failed after %d objects, resuming in %s, retry %d of %d: %v", len(list), delay, attempt+1, r.listRetries, err)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, errors.Wrapf(ctx.Err(), "list objects with prefix '%s'", prefix)
+		case <-t.C:
+		}
+	}
+}
+
+// binlogReader returns the binlog content mysqlbinlog reads from stdin.
+// nil is returned if mysqlbinlog reads the binlog from the source server.
+func (r *Recoverer) binlogReader(ctx context.Context, binlog string) (io.ReadCloser, error) {
+	if r.source != nil {
+		return nil, nil
+	}
+	ctx, err := r.withBinlogVersion(ctx, binlog)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := r.storage.GetObject(ctx, binlog)
+	if err != nil {
+		return nil, errors.Wrap(err, "get obj")
+	}
+	return obj, nil
+}
+
+// portOrAdmin returns the port, pxc.AdminPort if it's 0
+func portOrAdmin(port int) int {
+	if port == 0 {
+		return pxc.AdminPort
+	}
+	return port
+}
+
+// readArgs returns mysqlbinlog arguments which select the binlog to read
+func (r *Recoverer) readArgs(binlog string) []string {
+	if r.source == nil {
+		return []string{"-"}
+	}
+	return []string{"--read-from-remote-server", "--host=" + r.sourceHost, "--port=" + strconv.Itoa(portOrAdmin(r.controlPort)), "--user=" + r.user, binlog}
+}
+
+// mysqlbinlogEnv returns environment of mysqlbinlog. It runs in UTC, --stop-datetime
+// is formatted in UTC and mysqlbinlog reads it in its local time zone.
+// The password is passed if it reads from the source server.
+func (r *Recoverer) mysqlbinlogEnv() []string {
+	env := append(os.Environ(), "TZ=UTC")
+	if r.source != nil {
+		env = append(env, "MYSQL_PWD="+r.pass)
+	}
+	return env
+}
+
+// binlogTimestamp returns unix time of the first event of the binlog.
+// Object names contain it, binlogs on the source server are queried.
+func (r *Recoverer) binlogTimestamp(ctx context.Context, binlog string) (int64, error) {
+	if r.source != nil {
+		ts, err := r.source.GetBinLogFirstTimestamp(ctx, binlog)
+		if err != nil {
+			return 0, errors.Wrapf(err, "get %s first timestamp", binlog)
+		}
+		t, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse %s first timestamp", binlog)
+		}
+		return t, nil
+	}
+	return nameTimestamp(binlog)
+}
+
+// nameTimestamp returns the timestamp of binlog_<timestamp>_<hash> object name
+func nameTimestamp(binlog string) (int64, error) {
+	binlogArr := strings.Split(binlog, "_")
+	if len(binlogArr) < 2 {
+		return 0, errors.New("get timestamp from binlog name")
+	}
+	t, err := strconv.ParseInt(binlogArr[1], 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "get binlog time")
+	}
+	return t, nil
+}
+
+// binlogsBeforeTime returns the binlogs except the ones which name timestamp is after end.
+// The name contains the time of the first event, so none of their events are applied
+// in the Date mode and their sidecars don't need to be fetched.
+// Binlogs without a timestamp in the name are kept.
+func binlogsBeforeTime(binlogs []string, end int64) []string {
+	kept := make([]string, 0, len(binlogs))
+	for _, binlog := range binlogs {
+		if ts, err := nameTimestamp(binlog); err == nil && ts > end {
+			continue
+		}
+		kept = append(kept, binlog)
+	}
+	return kept
+}
@@ -0,0 +1,146 @@
+package recoverer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// verifyStopGTID checks that PITR_GTID is a single transaction which isn't applied yet
+func (r *Recoverer) verifyStopGTID(ctx context.Context) error {
+	if _, interval, _ := strings.Cut(r.gtid, ":"); strings.Contains(interval, "-") {
+		return errors.Wrap(ErrBadGTIDFormat, "a single transaction is expected, not a range")
+	}
+	sourceID, num, err := parseTransactionGTID(r.gtid)
+	if err != nil {
+		return err
+	}
+	r.gtid = strings.ToLower(sourceID) + ":" + strconv.FormatInt(num, 10)
+	if r.startGTID == "" {
+		return nil
+	}
+	subResult, err := r.db.SubtractGTIDSet(ctx, r.gtid, r.startGTID)
+	if err != nil {
+		return errors.Wrapf(err, "subtract '%s' from '%s'", r.startGTID, r.gtid)
+	}
+	if subResult == "" {
+		return errors.Wrapf(ErrTargetBeforeBackup, "transaction %s is already applied", r.gtid)
+	}
+	return nil
+}
+
+// setStopBeforeGTID finds the binlog with the target transaction and the position
+// of its GTID event, so the recovery stops right before the transaction
+func (r *Recoverer) setStopBeforeGTID(ctx context.Context) error {
+	for _, binlog := range r.binlogs {
+		set := r.binlogSets[binlog]
+		if set == "" {
+			continue
+		}
+		subResult, err := r.db.SubtractGTIDSet(ctx, r.gtid, set)
+		if err != nil {
+			return errors.Wrapf(err, "subtract '%s' from '%s'", set, r.gtid)
+		}
+		if subResult != "" {
+			continue
+		}
+
+		pos, err := r.findGTIDPosition(ctx, binlog)
+		if err != nil {
+			return errors.Wrapf(err, "find %s in %s", r.gtid, binlog)
+		}
+		log.Printf("Found %s in %s at position %d", r.gtid, binlog, pos)
+		r.stopBinlog = binlog
+		r.stopPosition = pos
+		return nil
+	}
+	return errors.Errorf("transaction %s is not found in the binlogs", r.gtid)
+}
+
+// findGTIDPosition decodes the binlog and returns the position of the GTID event of r.gtid
+func (r *Recoverer) findGTIDPosition(ctx context.Context, binlog string) (int64, error) {
+	binlogObj, err := r.binlogReader(ctx, binlog)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stderr := r.subprocessStderr()
+	// nolint:errcheck
+	defer stderr.Flush()
+	out, stdout := io.Pipe()
+	spec := CommandSpec{
+		Name:   "mysqlbinlog",
+		Args:   r.readArgs(binlog),
+		Env:    r.mysqlbinlogEnv(),
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if binlogObj != nil {
+		defer binlogObj.Close()
+		spec.Stdin = binlogObj
+	}
+	cmd := r.command(ctx, spec)
+	if err := cmd.Start(); err != nil {
+		return 0, errors.Wrap(err, "start mysqlbinlog")
+	}
+	waitErrs := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		// nolint:errcheck
+		stdout.Close()
+		waitErrs <- err
+	}()
+
+	pos, found, err := gtidPosition(out, r.gtid)
+	if found {
+		// the rest of the output isn't needed
+		cancel()
+	}
+	// nolint:errcheck
+	io.Copy(io.Discard, out)
+	waitErr := <-waitErrs
+	if err != nil {
+		return 0, errors.Wrap(err, "read mysqlbinlog output")
+	}
+	if found {
+		return pos, nil
+	}
+	if waitErr != nil {
+		return 0, errors.Wrap(waitErr, "run mysqlbinlog")
+	}
+	return 0, errors.New("gtid event is not found")
+}
+
+// gtidPosition scans mysqlbinlog output and returns the position
+// of the event which sets GTID_NEXT to gtid
+func gtidPosition(r io.Reader, gtid string) (int64, bool, error) {
+	br := bufio.NewReader(r)
+	var pos int64
+	for {
+		line, err := br.ReadString('\n')
+		if strings.HasPrefix(line, "# at ") {
+			p, perr := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "# at ")), 10, 64)
+			if perr == nil {
+				pos = p
+			}
+		} else if _, next, ok := strings.Cut(line, "GTID_NEXT= '"); ok {
+			next, _, _ = strings.Cut(next, "'")
+			if strings.EqualFold(next, gtid) {
+				return pos, true, nil
+			}
+		}
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package recoverer
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// dmlStatements are the first keywords of statements which are logged as rows with binlog_format=ROW
+var dmlStatements = [][]byte{[]byte("INSERT"), []byte("UPDATE"), []byte("DELETE"), []byte("REPLACE"), []byte("LOAD")}
+
+// tableNameRe matches the header of a Table_map event with the database and the table, e.g.
+// #231114 22:13:20 server id 1  end_log_pos 100 CRC32 0x9d6a3c1f 	Table_map: `pitr`.`orders` mapped to number 90
+var tableNameRe = regexp.MustCompile("\tTable_map: `((?:[^`]|``)+)`\\.`((?:[^`]|``)+)` mapped to number")
+
+// row events of a statement are written by mysqlbinlog as a single BINLOG statement
+// after the headers of its Table_map and row events
+var (
+	binlogStatementStart = []byte("BINLOG '")
+	binlogStatementEnd   = []byte("'" + string(statementDelimiter))
+)
+
+// tableFilter is a writer which drops row events of tables not in PITR_TABLES from
+// mysqlbinlog output written to w. It expects a line per write, as lineFilter does.
+//
+// The tables of a BINLOG statement are taken from the Table_map headers before it, the
+// statement is dropped if none of them is allowed. It can't be split, so a statement
+// changing allowed tables with other ones, e.g. with a trigger or a multi-table UPDATE,
+// fails the recovery. It works only with binlog_format=ROW: a DML statement logged as is
+// fails the recovery too. DDL statements are replayed as is, PITR_SKIP_DDL drops them.
+type tableFilter struct {
+	w       io.Writer
+	allowed map[string]bool // "db.table" names
+	mapped  []string        // tables of the Table_map headers since the last BINLOG statement
+	binlog  []byte          // lines of the current BINLOG statement
+	inStmt  bool            // the current line continues a statement
+	dropped int
+}
+
+func newTableFilter(w io.Writer, tables []string) *tableFilter {
+	f := &tableFilter{w: w, allowed: make(map[string]bool, len(tables))}
+	for _, t := range tables {
+		f.allowed[t] = true
+	}
+	return f
+}
+
+func (f *tableFilter) Write(line []byte) (int, error) {
+	trimmed := bytes.TrimRight(line, "\r\n")
+	if f.binlog != nil {
+		f.binlog = append(f.binlog, line...)
+		if !bytes.Equal(trimmed, binlogStatementEnd) {
+			return len(line), nil
+		}
+		if err := f.writeBinlog(); err != nil {
+			return 0, err
+		}
+		return len(line), nil
+	}
+	switch {
+	case f.inStmt:
+	case bytes.Equal(trimmed, binlogStatementStart):
+		f.binlog = append([]byte{}, line...)
+		return len(line), nil
+	case bytes.HasPrefix(trimmed, []byte("#")):
+		if m := tableNameRe.FindSubmatch(trimmed); m != nil {
+			f.mapped = append(f.mapped, unquoteName(m[1])+"."+unquoteName(m[2]))
+		}
+	case len(bytes.TrimSpace(trimmed)) > 0:
+		if startsWithKeyword(trimmed, dmlStatements) {
+			return 0, errors.Wrapf(ErrStatementBinlog, "%.100s", trimmed)
+		}
+		f.inStmt = true
+	}
+	if f.inStmt && bytes.HasSuffix(trimmed, statementDelimiter) {
+		f.inStmt = false
+	}
+	return f.w.Write(line)
+}
+
+// writeBinlog writes or drops the current BINLOG statement by the tables mapped before it.
+// A statement without tables, e.g. the format description event, is written.
+func (f *tableFilter) writeBinlog() error {
+	stmt, mapped := f.binlog, f.mapped
+	f.binlog, f.mapped = nil, nil
+	var allowed, other []string
+	for _, t := range mapped {
+		if f.allowed[t] {
+			allowed = append(allowed, t)
+		} else {
+			other = append(other, t)
+		}
+	}
+	if len(other) > 0 {
+		if len(allowed) > 0 {
+			return errors.Wrapf(ErrCrossTable, "tables %s and %s", strings.Join(allowed, ","), strings.Join(other, ","))
+		}
+		f.dropped++
+		return nil
+	}
+	for _, line := range bytes.SplitAfter(stmt, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if _, err := f.w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the last BINLOG statement if it isn't terminated
+func (f *tableFilter) Flush() error {
+	stmt := f.binlog
+	f.binlog = nil
+	if len(stmt) == 0 {
+		return nil
+	}
+	_, err := f.w.Write(stmt)
+	return err
+}
+
+// unquoteName unescapes backticks of a quoted identifier
+func unquoteName(name []byte) string {
+	return strings.ReplaceAll(string(name), "``", "`")
+}
+
+// getTables returns "db.table" names of PITR_TABLES
+func getTables(c Config) ([]string, error) {
+	var tables []string
+	for _, t := range c.Tables {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		db, table, ok := strings.Cut(t, ".")
+		if !ok || db == "" || table == "" {
+			return nil, errors.Errorf("bad table %q, expected db.table", t)
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
@@ -0,0 +1,115 @@
+package recoverer
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+
+	"mysql-pitr-helper/pxc"
+	"mysql-pitr-helper/storage"
+
+	"github.com/pkg/errors"
+)
+
+// VerifyBackups checks that the binlogs in the storage cover all transactions
+// of the live cluster since the full backup. It never applies anything.
+// If PITR_BACKUP_GTID is not set, transactions of each source before its first
+// archived transaction are expected to be in the full backup, sources without
+// archived transactions are reported as missing.
+func (r *Recoverer) VerifyBackups(ctx context.Context) error {
+	defer redactLogs(r.redactor)()
+	return redactError(r.verifyBackups(ctx), r.redactor)
+}
+
+func (r *Recoverer) verifyBackups(ctx context.Context) error {
+	if r.storage == nil {
+		return errors.New("STORAGE_TYPE is required to verify backups")
+	}
+	if r.db == nil {
+		db, err := pxc.NewPXCWithOptions(r.host, r.user, r.pass, r.connOptions())
+		if err != nil {
+			return errors.Wrapf(err, "new manager with host %s", r.host)
+		}
+		r.db = db
+	}
+
+	archived, err := r.archivedGTIDSet(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get archived gtid set")
+	}
+	if archived == "" {
+		return errors.Errorf("no binlogs with gtid sets for prefix %s", r.binlogPrefix)
+	}
+
+	current, err := r.db.GetCurrentGTIDSet(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get current GTID")
+	}
+	log.Println("current gtid set is", current)
+
+	missing, err := r.db.SubtractGTIDSet(ctx, current, archived)
+	if err != nil {
+		return errors.Wrapf(err, "subtract archived gtid set from '%s'", current)
+	}
+	if r.backupGTID != "" {
+		missing, err = r.db.SubtractGTIDSet(ctx, missing, r.backupGTID)
+		if err != nil {
+			return errors.Wrapf(err, "subtract '%s' from '%s'", r.backupGTID, missing)
+		}
+	} else {
+		m := pxc.NewGTIDSet(missing)
+		a := pxc.NewGTIDSet(archived)
+		f := m.From(a)
+		// From omits sources without archived transactions, none of them is covered
+		archivedUUIDs := a.UUIDs()
+		for _, uuid := range m.UUIDs() {
+			if !slices.Contains(archivedUUIDs, uuid) {
+				f = f.Union(m.Source(uuid))
+			}
+		}
+		missing = f.Raw()
+	}
+
+	if missing != "" {
+		return errors.Errorf("binlogs are missing transactions %s", missing)
+	}
+	log.Println("Backup verification passed: binlogs cover the current gtid set", current)
+
+	return nil
+}
+
+// archivedGTIDSet returns gtid sets of all binlogs in the storage joined together
+func (r *Recoverer) archivedGTIDSet(ctx context.Context) (string, error) {
+	list, err := r.listObjects(ctx, r.binlogPrefix)
+	if err != nil {
+		return "", err
+	}
+	binlogs := []string{}
+	for _, name := range list {
+		// sidecars of compressed binlogs may be compressed too, see fetchSidecar
+		name, ext := storage.TrimCompressionSuffix(name)
+		if binlog, ok := strings.CutSuffix(name, r.gtidSetSuffix); ok {
+			binlogs = append(binlogs, binlog+ext)
+		}
+	}
+	sidecars := r.fetchSidecars(ctx, binlogs)
+	defer sidecars.stop()
+	sets := []string{}
+	for {
+		sc, ok := sidecars.next()
+		if !ok {
+			break
+		}
+		if sc.getErr != nil {
+			return "", errors.Wrapf(sc.getErr, "get %s gtid-set object", sc.binlog)
+		}
+		if sc.err != nil {
+			return "", sc.err
+		}
+		if set := strings.TrimSpace(sc.gtidSet); set != "" {
+			sets = append(sets, set)
+		}
+	}
+	return strings.Join(sets, ","), nil
+}
@@ -0,0 +1,133 @@
+package recoverer
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// binlogClientVersion is a version reported by mysqlbinlog --version
+type binlogClientVersion struct {
+	major, minor, patch int
+}
+
+func (v binlogClientVersion) String() string {
+	return strconv.Itoa(v.major) + "." + strconv.Itoa(v.minor) + "." + strconv.Itoa(v.patch)
+}
+
+func (v binlogClientVersion) atLeast(o binlogClientVersion) bool {
+	if v.major != o.major {
+		return v.major > o.major
+	}
+	if v.minor != o.minor {
+		return v.minor > o.minor
+	}
+	return v.patch >= o.patch
+}
+
+// legacy returns true if the version is the binlog format version (3.x).
+// mysqlbinlog before 8.0 and MariaDB clients report it instead of the server version,
+// so the supported flags can't be derived from it.
+func (v binlogClientVersion) legacy() bool {
+	return v.major < 5
+}
+
+var binlogVersionRe = regexp.MustCompile(`Ver\s+(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseMysqlbinlogVersion parses output of mysqlbinlog --version, e.g.
+// "mysqlbinlog  Ver 8.0.36-28 for Linux on x86_64 (Percona Server (GPL), Release 28, Revision 47601f19)"
+func parseMysqlbinlogVersion(out string) (binlogClientVersion, error) {
+	m := binlogVersionRe.FindStringSubmatch(out)
+	if m == nil {
+		return binlogClientVersion{}, errors.Errorf("unrecognized mysqlbinlog version '%s'", strings.TrimSpace(out))
+	}
+	var v binlogClientVersion
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.patch, _ = strconv.Atoi(m[3])
+	}
+	return v, nil
+}
+
+// binlogCapability is a mysqlbinlog flag and the first version supporting it
+type binlogCapability struct {
+	flag  string
+	since binlogClientVersion
+}
+
+var (
+	capDisableLogBin    = binlogCapability{flag: "--disable-log-bin", since: binlogClientVersion{5, 0, 0}}
+	capExcludeGTIDs     = binlogCapability{flag: "--exclude-gtids", since: binlogClientVersion{5, 6, 5}}
+	capIncludeGTIDs     = binlogCapability{flag: "--include-gtids", since: binlogClientVersion{5, 6, 5}}
+	capStopDatetime     = binlogCapability{flag: "--stop-datetime", since: binlogClientVersion{5, 0, 0}}
+	capStopPosition     = binlogCapability{flag: "--stop-position", since: binlogClientVersion{5, 0, 0}}
+	capRewriteDB        = binlogCapability{flag: "--rewrite-db", since: binlogClientVersion{5, 7, 1}}
+	capReadRemoteServer = binlogCapability{flag: "--read-from-remote-server", since: binlogClientVersion{5, 0, 0}}
+)
+
+// requiredCapabilities returns mysqlbinlog flags used by the recovery
+func (r *Recoverer) requiredCapabilities() []binlogCapability {
+	caps := []binlogCapability{capDisableLogBin}
+	switch r.recoverType {
+	case Skip, Transaction:
+		caps = append(caps, capExcludeGTIDs)
+	case Date:
+		caps = append(caps, capStopDatetime)
+	case Position, StopBeforeGTID:
+		caps = append(caps, capStopPosition)
+	}
+	if r.gtidUUIDFilter != "" {
+		caps = append(caps, capIncludeGTIDs)
+	}
+	if len(r.rewriteDBFlags) > 0 {
+		caps = append(caps, capRewriteDB)
+	}
+	if r.sourceHost != "" {
+		caps = append(caps, capReadRemoteServer)
+	}
+	return caps
+}
+
+// checkMysqlbinlog verifies that the installed mysqlbinlog supports the flags the recovery needs
+func (r *Recoverer) checkMysqlbinlog(ctx context.Context) error {
+	out, err := r.commandOutput(ctx, "mysqlbinlog", "--version")
+	if err != nil {
+		return errors.Wrapf(err, "run mysqlbinlog --version: %s", out)
+	}
+	v, err := parseMysqlbinlogVersion(out)
+	if err != nil {
+		return err
+	}
+	log.Println("mysqlbinlog version", v)
+
+	help := ""
+	if v.legacy() {
+		help, err = r.commandOutput(ctx, "mysqlbinlog", "--help")
+		if err != nil {
+			return errors.Wrapf(err, "run mysqlbinlog --help: %s", help)
+		}
+	}
+	return checkCapabilities(v, help, r.requiredCapabilities())
+}
+
+// checkCapabilities returns an error naming the first flag the version doesn't support.
+// The flags of legacy versions are looked up in the help output.
+func checkCapabilities(v binlogClientVersion, help string, caps []binlogCapability) error {
+	for _, c := range caps {
+		if v.legacy() {
+			if !strings.Contains(help, c.flag) {
+				return errors.Errorf("mysqlbinlog version %s doesn't support %s", v, c.flag)
+			}
+			continue
+		}
+		if !v.atLeast(c.since) {
+			return errors.Errorf("mysqlbinlog version %s doesn't support %s, version %s or newer is required", v, c.flag, c.since)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	CompressionNone = "none" // objects are not compressed
+	CompressionLZ4  = "lz4"  // LZ4 framed objects may have no suffix
+)
+
+// lz4Suffix is a name suffix of LZ4 framed objects
+const lz4Suffix = ".lz4"
+
+// lz4Magic starts an LZ4 frame
+var lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+
+// CompressedReader is implemented by readers of decompressed objects
+type CompressedReader interface {
+	// CompressedBytes returns the number of bytes of the stored object read so far
+	CompressedBytes() int64
+}
+
+// Decompressing is a Storage which decompresses objects returned by GetObject
+type Decompressing struct {
+	Storage
+	codec string
+}
+
+// NewDecompressing wraps s to decompress objects. Objects with a compression suffix
+// are decompressed, objects without it are returned as is. With CompressionLZ4
+// objects without the suffix are decompressed too if they start with an LZ4 frame,
+// so plain gtid-set and timestamp objects next to them are still readable.
+func NewDecompressing(s Storage, codec string) (Storage, error) {
+	switch codec {
+	case "", CompressionLZ4:
+	case CompressionNone:
+		return s, nil
+	default:
+		return nil, errors.Errorf("unknown compression %s", codec)
+	}
+	return &Decompressing{Storage: s, codec: codec}, nil
+}
+
+// GetObject return decompressed content by given object name
+// The head of a compressed object is decompressed from the whole stored object, see WithHead.
+func (d *Decompressing) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	if _, ext := TrimCompressionSuffix(objectName); ext != "" {
+		obj, err := d.Storage.GetObject(WithHead(ctx, 0), objectName)
+		if err != nil {
+			return nil, err
+		}
+		return newDecompressedReader(obj, obj), nil
+	}
+	obj, err := d.Storage.GetObject(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	if d.codec != CompressionLZ4 {
+		return obj, nil
+	}
+	br := bufio.NewReader(obj)
+	header, err := br.Peek(len(lz4Magic))
+	if err != nil && err != io.EOF {
+		obj.Close()
+		return nil, errors.Wrapf(err, "read %s header", objectName)
+	}
+	if bytes.Equal(header, lz4Magic) {
+		if headFrom(ctx) > 0 {
+			// only the head of the stored object is requested, it's not enough to decompress
+			obj.Close()
+			obj, err = d.Storage.GetObject(WithHead(ctx, 0), objectName)
+			if err != nil {
+				return nil, err
+			}
+			return newDecompressedReader(obj, obj), nil
+		}
+		return newDecompressedReader(br, obj), nil
+	}
+	return &bufferedObject{Reader: br, c: obj}, nil
+}
+
+// bufferedObject is an object read through the buffer used to check its header
+type bufferedObject struct {
+	*bufio.Reader
+	c io.Closer
+}
+
+func (b *bufferedObject) Close() error {
+	return b.c.Close()
+}
+
+type decompressedReader struct {
+	r   io.Reader
+	c   io.Closer
+	src *countingSource
+}
+
+// countingSource counts compressed bytes read by the decompressor
+type countingSource struct {
+	r io.Reader
+	n int64
+}
+
+func (s *countingSource) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	return n, err
+}
+
+func newDecompressedReader(r io.Reader, c io.Closer) *decompressedReader {
+	src := &countingSource{r: r}
+	return &decompressedReader{r: lz4.NewReader(src), c: c, src: src}
+}
+
+func (d *decompressedReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = errors.Wrap(err, "decompress")
+	}
+	return n, err
+}
+
+func (d *decompressedReader) Close() error {
+	return d.c.Close()
+}
+
+func (d *decompressedReader) CompressedBytes() int64 {
+	return d.src.n
+}
+
+// TrimCompressionSuffix returns the object name without the compression suffix
+func TrimCompressionSuffix(name string) (string, string) {
+	if strings.HasSuffix(name, lz4Suffix) {
+		return strings.TrimSuffix(name, lz4Suffix), lz4Suffix
+	}
+	return name, ""
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+func TestDecompressing(t *testing.T) {
+	content := []byte("binlog content")
+	var compressed bytes.Buffer
+	w := lz4.NewWriter(&compressed)
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name   string
+		codec  string
+		object string
+		data   []byte
+	}
+	cases := []testCase{
+		{name: "suffix", object: "binlog_1.lz4", data: compressed.Bytes()},
+		{name: "no suffix", object: "binlog_1", data: content},
+		{name: "explicit codec", codec: CompressionLZ4, object: "binlog_1", data: compressed.Bytes()},
+		{name: "explicit codec plain object", codec: CompressionLZ4, object: "binlog_1-gtid-set", data: content},
+		{name: "explicit codec suffix", codec: CompressionLZ4, object: "binlog_1.lz4", data: compressed.Bytes()},
+		{name: "none", codec: CompressionNone, object: "binlog_1.lz4", data: content},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := NewDecompressing(NewMemory(map[string][]byte{c.object: c.data}), c.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj, err := s.GetObject(context.Background(), c.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer obj.Close()
+			got, err := io.ReadAll(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("expect '%s', got '%s'", content, got)
+			}
+			if cr, ok := obj.(CompressedReader); ok && cr.CompressedBytes() != int64(len(c.data)) {
+				t.Errorf("expect %d compressed bytes read, got %d", len(c.data), cr.CompressedBytes())
+			}
+		})
+	}
+
+	if _, err := NewDecompressing(NewMemory(nil), "zip"); err == nil {
+		t.Errorf("expected error for unknown codec")
+	}
+}
+
+func TestDecompressingHead(t *testing.T) {
+	content := []byte("binlog content")
+	var compressed bytes.Buffer
+	w := lz4.NewWriter(&compressed)
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name     string
+		object   string
+		data     []byte
+		expected []byte
+	}
+	cases := []testCase{
+		{name: "plain object", object: "binlog_1", data: content, expected: content[:6]},
+		{name: "suffix", object: "binlog_1.lz4", data: compressed.Bytes(), expected: content},
+		{name: "no suffix", object: "binlog_1", data: compressed.Bytes(), expected: content},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := NewDecompressing(NewMemory(map[string][]byte{c.object: c.data}), CompressionLZ4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// compressed objects are decompressed whole, their head isn't enough
+			obj, err := s.GetObject(WithHead(context.Background(), 6), c.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer obj.Close()
+			got, err := io.ReadAll(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, c.expected) {
+				t.Errorf("expect '%s', got '%s'", c.expected, got)
+			}
+		})
+	}
+}
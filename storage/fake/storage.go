@@ -28,6 +28,10 @@ func (c *FakeStorageClient) GetObject(ctx context.Context, objectName string) (i
 	return nil, nil
 }
 
+func (c *FakeStorageClient) StatObject(ctx context.Context, objectName string) (storage.ObjectInfo, error) {
+	return storage.ObjectInfo{Name: objectName}, nil
+}
+
 func (c *FakeStorageClient) PutObject(ctx context.Context, name string, data io.Reader, size int64) error {
 	return nil
 }
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// tmpFilePrefix is a name prefix of files written by Filesystem.PutObject before they're renamed,
+// they aren't listed as objects
+const tmpFilePrefix = ".pitr-tmp-"
+
+// Filesystem is a Storage which keeps objects as files under the root directory,
+// e.g. on a mounted volume. Slashes in object names are directories.
+type Filesystem struct {
+	mu     sync.RWMutex
+	root   string
+	prefix string
+}
+
+// NewFilesystem returns new Filesystem storage, the root directory is created if it doesn't exist
+func NewFilesystem(root, prefix string) (*Filesystem, error) {
+	if root == "" {
+		return nil, errors.New("root directory is required")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "create %s", root)
+	}
+	return &Filesystem{root: root, prefix: normalizePrefix(prefix)}, nil
+}
+
+// NewFilesystemWithOptions returns new Filesystem storage with the options
+func NewFilesystemWithOptions(opts *FilesystemOptions) (*Filesystem, error) {
+	return NewFilesystem(opts.Path, opts.Prefix)
+}
+
+// path returns the file of the object, names escaping the root directory are refused
+func (f *Filesystem) path(name string) (string, error) {
+	f.mu.RLock()
+	key := objectKey(f.prefix, name)
+	f.mu.RUnlock()
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", errors.Errorf("bad object name %s", key)
+	}
+	return filepath.Join(f.root, filepath.FromSlash(key)), nil
+}
+
+func (f *Filesystem) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	path, err := f.path(objectName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", path)
+	}
+	return file, nil
+}
+
+func (f *Filesystem) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	path, err := f.path(objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.IsDir() {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, errors.Wrapf(err, "stat %s", path)
+	}
+	return ObjectInfo{Name: objectName, Size: info.Size()}, nil
+}
+
+// PutObject writes the object to a temp file and renames it,
+// so readers never see a partially written object
+func (f *Filesystem) PutObject(ctx context.Context, name string, data io.Reader, _ int64) error {
+	path, err := f.path(name)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrapf(err, "create %s", dir)
+	}
+	tmp, err := os.CreateTemp(dir, tmpFilePrefix+"*")
+	if err != nil {
+		return errors.Wrapf(err, "create temp file for %s", name)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "write object %s", name)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrapf(err, "rename object %s", name)
+	}
+	return nil
+}
+
+// ListObjects returns names of objects with given prefix in lexicographical order
+func (f *Filesystem) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	f.mu.RLock()
+	storagePrefix := f.prefix
+	f.mu.RUnlock()
+	keyPrefix := objectKey(storagePrefix, prefix)
+	startAfter := startAfterFrom(ctx)
+
+	list := []string{}
+	err := filepath.WalkDir(f.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tmpFilePrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(f.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		name := strings.TrimPrefix(key, storagePrefix)
+		if strings.HasPrefix(key, keyPrefix) && name > startAfter {
+			list = append(list, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list %s", f.root)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (f *Filesystem) DeleteObject(ctx context.Context, objectName string) error {
+	path, err := f.path(objectName)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrObjectNotFound
+	}
+	if err != nil {
+		return errors.Wrapf(err, "remove %s", path)
+	}
+	return nil
+}
+
+func (f *Filesystem) SetPrefix(prefix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prefix = normalizePrefix(prefix)
+}
+
+func (f *Filesystem) GetPrefix() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.prefix
+}
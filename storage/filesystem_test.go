@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPutObjectRoundTrip(t *testing.T) {
+	type testCase struct {
+		name    string
+		storage func(t *testing.T) Storage
+	}
+	cases := []testCase{
+		{
+			name:    "memory",
+			storage: func(t *testing.T) Storage { return NewMemory(nil) },
+		},
+		{
+			name: "filesystem",
+			storage: func(t *testing.T) Storage {
+				fs, err := NewFilesystem(t.TempDir(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return fs
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := c.storage(t)
+			s.SetPrefix("/pitr//cluster1")
+
+			objects := map[string]string{
+				"binlog_1700000001_a":          "binlog content",
+				"binlog_1700000001_a-gtid-set": "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5",
+				"nested/recovery.log":          "recovery log",
+			}
+			for name, content := range objects {
+				// the size isn't known to the backends up front
+				if err := s.PutObject(ctx, name, strings.NewReader(content), -1); err != nil {
+					t.Fatalf("put %s: %s", name, err.Error())
+				}
+			}
+			// an existing object is replaced
+			if err := s.PutObject(ctx, "binlog_1700000001_a", strings.NewReader("new content"), 11); err != nil {
+				t.Fatal(err)
+			}
+			objects["binlog_1700000001_a"] = "new content"
+
+			for name, content := range objects {
+				obj, err := s.GetObject(ctx, name)
+				if err != nil {
+					t.Fatalf("get %s: %s", name, err.Error())
+				}
+				data, err := io.ReadAll(obj)
+				obj.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != content {
+					t.Errorf("%s: expect '%s', got '%s'", name, content, data)
+				}
+				info, err := s.StatObject(ctx, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size != int64(len(content)) {
+					t.Errorf("%s: expect size %d, got %d", name, len(content), info.Size)
+				}
+			}
+
+			list, err := s.ListObjects(ctx, "binlog_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{"binlog_1700000001_a", "binlog_1700000001_a-gtid-set"}
+			if !reflect.DeepEqual(list, expected) {
+				t.Errorf("expect %v, got %v", expected, list)
+			}
+
+			if err := s.DeleteObject(ctx, "binlog_1700000001_a"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.GetObject(ctx, "binlog_1700000001_a"); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("expect not found error, got %v", err)
+			}
+			if _, err := s.StatObject(ctx, "binlog_1700000001_a"); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("expect not found error, got %v", err)
+			}
+			if err := s.DeleteObject(ctx, "binlog_1700000001_a"); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("expect not found error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFilesystemBadName(t *testing.T) {
+	fs, err := NewFilesystem(t.TempDir(), "pitr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.PutObject(context.Background(), "../../escaped", strings.NewReader("data"), 4); err == nil {
+		t.Error("expect error for the name outside of the root directory")
+	}
+}
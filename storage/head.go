@@ -0,0 +1,17 @@
+package storage
+
+import "context"
+
+type headKey struct{}
+
+// WithHead returns a context which makes GetObject request only the first n bytes of the object,
+// e.g. to check its header without downloading it. Storages which can't request a part
+// of an object return it whole, so do decompressed objects. It's ignored if n is not positive.
+func WithHead(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, headKey{}, n)
+}
+
+func headFrom(ctx context.Context) int64 {
+	n, _ := ctx.Value(headKey{}).(int64)
+	return n
+}
@@ -0,0 +1,18 @@
+package storage
+
+import "strings"
+
+// normalizePrefix returns the prefix with single slashes between its segments,
+// without the leading slash and with the trailing one. Empty prefix stays empty.
+func normalizePrefix(prefix string) string {
+	segments := strings.FieldsFunc(prefix, func(r rune) bool { return r == '/' })
+	if len(segments) == 0 {
+		return ""
+	}
+	return strings.Join(segments, "/") + "/"
+}
+
+// objectKey returns the key of the object name under the normalized prefix
+func objectKey(prefix, name string) string {
+	return prefix + strings.TrimLeft(name, "/")
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizePrefix(t *testing.T) {
+	type testCase struct {
+		prefix   string
+		expected string
+	}
+	cases := []testCase{
+		{prefix: "", expected: ""},
+		{prefix: "/", expected: ""},
+		{prefix: "pitr", expected: "pitr/"},
+		{prefix: "pitr/", expected: "pitr/"},
+		{prefix: "/pitr/cluster1", expected: "pitr/cluster1/"},
+		{prefix: "pitr//cluster1///binlogs/", expected: "pitr/cluster1/binlogs/"},
+	}
+	for _, c := range cases {
+		t.Run(c.prefix, func(t *testing.T) {
+			if got := normalizePrefix(c.prefix); got != c.expected {
+				t.Errorf("expect '%s', got '%s'", c.expected, got)
+			}
+		})
+	}
+}
+
+// fakeS3 serves path-style bucket listing and object downloads of a single bucket
+func fakeS3(t *testing.T, bucket string, objects map[string]string) *httptest.Server {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+		ETag         string
+	}
+	type listResult struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		IsTruncated bool
+		Contents    []content
+	}
+	lastModified := time.Unix(1700000000, 0).UTC()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/"+bucket), "/")
+		if key == "" {
+			if req.Method == http.MethodHead {
+				return
+			}
+			prefix := req.URL.Query().Get("prefix")
+			marker := req.URL.Query().Get("marker")
+			res := listResult{Name: bucket, Prefix: prefix}
+			keys := []string{}
+			for k := range objects {
+				if strings.HasPrefix(k, prefix) && k > marker {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				res.Contents = append(res.Contents, content{Key: k, Size: len(objects[k]), LastModified: lastModified.Format(time.RFC3339), ETag: `"etag"`})
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			if err := xml.NewEncoder(w).Encode(res); err != nil {
+				t.Errorf("encode list: %s", err.Error())
+			}
+			return
+		}
+		data, ok := objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message><Key>`+key+`</Key></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		if req.Method != http.MethodHead {
+			io.WriteString(w, data)
+		}
+	}))
+}
+
+func TestS3PrefixedKeys(t *testing.T) {
+	objects := map[string]string{
+		"pitr/cluster1/binlog_1700000001_a":          "binlog a",
+		"pitr/cluster1/binlog_1700000001_a-gtid-set": "gtid set a",
+		"pitr/cluster1/binlog_1700000002_b":          "binlog b",
+		"pitr/cluster10/binlog_1700000003_c":         "other cluster",
+	}
+	srv := fakeS3(t, "operator-testing", objects)
+	defer srv.Close()
+
+	forcePathStyle := true
+	for _, prefix := range []string{"pitr/cluster1/", "/pitr//cluster1", "pitr/cluster1"} {
+		t.Run(prefix, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := NewS3WithOptions(ctx, &S3Options{
+				Endpoint:        srv.URL,
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+				BucketName:      "operator-testing",
+				Prefix:          prefix,
+				Region:          "us-east-1",
+				ForcePathStyle:  &forcePathStyle,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			list, err := s.ListObjects(ctx, "binlog_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{"binlog_1700000001_a", "binlog_1700000001_a-gtid-set", "binlog_1700000002_b"}
+			if strings.Join(list, ",") != strings.Join(expected, ",") {
+				t.Fatalf("expect %v, got %v", expected, list)
+			}
+			for _, name := range list {
+				obj, err := s.GetObject(ctx, name)
+				if err != nil {
+					t.Fatalf("get %s: %s", name, err.Error())
+				}
+				data, err := io.ReadAll(obj)
+				obj.Close()
+				if err != nil {
+					t.Fatalf("read %s: %s", name, err.Error())
+				}
+				if want := objects["pitr/cluster1/"+name]; string(data) != want {
+					t.Errorf("%s: expect '%s', got '%s'", name, want, data)
+				}
+			}
+		})
+	}
+}
+
+func TestListStartAfter(t *testing.T) {
+	objects := map[string]string{
+		"pitr/binlog_1700000001_a":          "binlog a",
+		"pitr/binlog_1700000001_a-gtid-set": "gtid set a",
+		"pitr/binlog_1700000002_b":          "binlog b",
+	}
+	srv := fakeS3(t, "operator-testing", objects)
+	defer srv.Close()
+
+	ctx := context.Background()
+	forcePathStyle := true
+	s3, err := NewS3WithOptions(ctx, &S3Options{
+		Endpoint:        srv.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		BucketName:      "operator-testing",
+		Prefix:          "pitr",
+		Region:          "us-east-1",
+		ForcePathStyle:  &forcePathStyle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := NewMemory(map[string][]byte{})
+	memory.SetPrefix("pitr")
+	for name, data := range objects {
+		if err := memory.PutObject(ctx, strings.TrimPrefix(name, "pitr/"), strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, s := range map[string]Storage{"s3": s3, "memory": memory} {
+		t.Run(name, func(t *testing.T) {
+			list, err := s.ListObjects(WithStartAfter(ctx, "binlog_1700000001_a"), "binlog_")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{"binlog_1700000001_a-gtid-set", "binlog_1700000002_b"}
+			if strings.Join(list, ",") != strings.Join(expected, ",") {
+				t.Errorf("expect %v, got %v", expected, list)
+			}
+		})
+	}
+}
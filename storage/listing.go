@@ -0,0 +1,17 @@
+package storage
+
+import "context"
+
+type startAfterKey struct{}
+
+// WithStartAfter returns a context which makes ListObjects return only objects with names
+// greater than startAfter, so a listing which failed midway can be resumed after the last listed name.
+// An empty startAfter lists all objects.
+func WithStartAfter(ctx context.Context, startAfter string) context.Context {
+	return context.WithValue(ctx, startAfterKey{}, startAfter)
+}
+
+func startAfterFrom(ctx context.Context) string {
+	s, _ := ctx.Value(startAfterKey{}).(string)
+	return s
+}
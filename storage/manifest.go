@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GTIDSetManifestName is the name of the object with gtid sets of all uploaded binlogs
+const GTIDSetManifestName = "gtid-set-manifest.json"
+
+// gtidSetManifestVersion is the version of the manifest format written by WriteGTIDSetManifest
+const gtidSetManifestVersion = 1
+
+// GTIDSetManifest maps binlog object names to their gtid sets. The collector keeps it
+// next to the gtid-set objects, so the recovery reads one object instead of one per binlog:
+//
+//	{"version": 1, "binlogs": {"binlog_1700000001_<md5>": "uuid:1-5"}, "server_uuids": ["uuid"]}
+type GTIDSetManifest struct {
+	Version int               `json:"version"`
+	Binlogs map[string]string `json:"binlogs"`
+	// ServerUUIDs are server_uuid of the cluster members the binlogs were collected from,
+	// the recovery checks them to refuse an archive of another cluster
+	ServerUUIDs []string `json:"server_uuids,omitempty"`
+}
+
+// NewGTIDSetManifest returns an empty manifest
+func NewGTIDSetManifest() *GTIDSetManifest {
+	return &GTIDSetManifest{Version: gtidSetManifestVersion, Binlogs: make(map[string]string)}
+}
+
+// AddServerUUID records the server uuid if it isn't recorded yet
+func (m *GTIDSetManifest) AddServerUUID(uuid string) {
+	uuid = strings.ToLower(uuid)
+	if uuid != "" && !slices.Contains(m.ServerUUIDs, uuid) {
+		m.ServerUUIDs = append(m.ServerUUIDs, uuid)
+	}
+}
+
+// ReadGTIDSetManifest reads the manifest from the storage,
+// ErrObjectNotFound is returned if there is no manifest
+func ReadGTIDSetManifest(ctx context.Context, s Storage) (*GTIDSetManifest, error) {
+	obj, err := s.GetObject(ctx, GTIDSetManifestName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", GTIDSetManifestName)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", GTIDSetManifestName)
+	}
+	m := NewGTIDSetManifest()
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", GTIDSetManifestName)
+	}
+	if m.Version != gtidSetManifestVersion {
+		return nil, errors.Errorf("unsupported %s version %d", GTIDSetManifestName, m.Version)
+	}
+	if m.Binlogs == nil {
+		m.Binlogs = make(map[string]string)
+	}
+	return m, nil
+}
+
+// WriteGTIDSetManifest replaces the manifest in the storage
+func WriteGTIDSetManifest(ctx context.Context, s Storage, m *GTIDSetManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s", GTIDSetManifestName)
+	}
+	err = s.PutObject(ctx, GTIDSetManifestName, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return errors.Wrapf(err, "put %s", GTIDSetManifestName)
+	}
+	return nil
+}
+
+// BackupManifestName is the name of the object with the GTID set and the time of the last full backup.
+// It's written by the backup tool next to the binlogs.
+const BackupManifestName = "backup-manifest.json"
+
+// BackupManifest describes the last full backup:
+//
+//	{"gtid": "uuid:1-100", "timestamp": "2024-03-01T10:20:30Z"}
+type BackupManifest struct {
+	GTID      string    `json:"gtid"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReadBackupManifest reads the backup manifest from the storage,
+// ErrObjectNotFound is returned if there is no manifest
+func ReadBackupManifest(ctx context.Context, s Storage) (*BackupManifest, error) {
+	obj, err := s.GetObject(ctx, BackupManifestName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", BackupManifestName)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", BackupManifestName)
+	}
+	m := &BackupManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", BackupManifestName)
+	}
+	if m.GTID == "" {
+		return nil, errors.Errorf("no gtid in %s", BackupManifestName)
+	}
+	return m, nil
+}
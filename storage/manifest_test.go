@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGTIDSetManifest(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory(nil)
+	if _, err := ReadGTIDSetManifest(ctx, s); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expect not found error, got %v", err)
+	}
+
+	m := NewGTIDSetManifest()
+	m.Binlogs["binlog_1700000001_a"] = "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"
+	if err := WriteGTIDSetManifest(ctx, s, m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadGTIDSetManifest(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("expect %v, got %v", m, got)
+	}
+
+	type testCase struct {
+		name    string
+		content string
+	}
+	cases := []testCase{
+		{name: "malformed", content: "{"},
+		{name: "unknown version", content: `{"version": 2, "binlogs": {}}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewMemory(map[string][]byte{GTIDSetManifestName: []byte(c.content)})
+			if _, err := ReadGTIDSetManifest(ctx, s); err == nil || errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("expect parse error, got %v", err)
+			}
+		})
+	}
+}
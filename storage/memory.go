@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Memory is a Storage which keeps objects in memory, it's intended for tests
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+	prefix  string
+}
+
+// NewMemory return new Memory storage with given objects
+func NewMemory(objects map[string][]byte) *Memory {
+	m := &Memory{
+		objects: make(map[string][]byte, len(objects)),
+	}
+	for k, v := range objects {
+		m.objects[k] = v
+	}
+	return m
+}
+
+func (m *Memory) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.objects[objectKey(m.prefix, objectName)]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	if n := headFrom(ctx); n > 0 && n < int64(len(data)) {
+		data = data[:n]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *Memory) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.objects[objectKey(m.prefix, objectName)]
+	if !ok {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	return ObjectInfo{Name: objectName, Size: int64(len(data))}, nil
+}
+
+func (m *Memory) PutObject(ctx context.Context, name string, data io.Reader, size int64) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return errors.Wrapf(err, "read object %s", name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[objectKey(m.prefix, name)] = content
+	return nil
+}
+
+// ListObjects returns names of objects with given prefix in lexicographical order
+func (m *Memory) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	startAfter := startAfterFrom(ctx)
+	list := []string{}
+	for k := range m.objects {
+		name := strings.TrimPrefix(k, m.prefix)
+		if strings.HasPrefix(k, objectKey(m.prefix, prefix)) && name > startAfter {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (m *Memory) DeleteObject(ctx context.Context, objectName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[objectKey(m.prefix, objectName)]; !ok {
+		return ErrObjectNotFound
+	}
+	delete(m.objects, objectKey(m.prefix, objectName))
+	return nil
+}
+
+func (m *Memory) SetPrefix(prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prefix = normalizePrefix(prefix)
+}
+
+func (m *Memory) GetPrefix() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.prefix
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"mysql-pitr-helper/metrics"
+)
+
+// Metered is a Storage which counts bytes read from objects
+// returned by GetObject in metrics.BytesDownloaded
+type Metered struct {
+	Storage
+}
+
+// NewMetered wraps s to export download metrics
+func NewMetered(s Storage) Storage {
+	return &Metered{Storage: s}
+}
+
+// GetObject return content by given object name counting downloaded bytes
+func (m *Metered) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	obj, err := m.Storage.GetObject(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredReader{r: obj}, nil
+}
+
+type meteredReader struct {
+	r io.ReadCloser
+}
+
+func (m *meteredReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	metrics.BytesDownloaded.Add(int64(n))
+	return n, err
+}
+
+func (m *meteredReader) Close() error {
+	return m.r.Close()
+}
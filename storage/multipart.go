@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// multipartChunkSize is a size of a single ranged request of the multipart download.
+// Objects smaller than two chunks are downloaded in a single stream.
+const multipartChunkSize = 8 << 20
+
+// rangeFetchFunc returns length bytes of the object starting at offset
+type rangeFetchFunc func(ctx context.Context, offset, length int64) ([]byte, error)
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// multipartReader downloads an object in byte ranges with up to parts
+// concurrent requests and returns the ranges sequentially in order
+type multipartReader struct {
+	cancel  context.CancelFunc
+	results chan chan chunkResult
+	current []byte
+	err     error
+}
+
+// newMultipartReader starts downloading size bytes using fetch, first is the already fetched first chunk
+func newMultipartReader(ctx context.Context, size int64, parts int, first []byte, fetch rangeFetchFunc) *multipartReader {
+	ctx, cancel := context.WithCancel(ctx)
+	m := &multipartReader{
+		cancel:  cancel,
+		results: make(chan chan chunkResult, max(parts-1, 1)),
+		current: first,
+	}
+	go func() {
+		defer close(m.results)
+		for offset := int64(len(first)); offset < size; offset += multipartChunkSize {
+			length := min(int64(multipartChunkSize), size-offset)
+			res := make(chan chunkResult, 1)
+			select {
+			case m.results <- res:
+			case <-ctx.Done():
+				return
+			}
+			go func(offset, length int64) {
+				data, err := fetch(ctx, offset, length)
+				if err == nil && int64(len(data)) != length {
+					err = errors.Errorf("got %d bytes of range %d-%d", len(data), offset, offset+length-1)
+				}
+				res <- chunkResult{data: data, err: err}
+			}(offset, length)
+		}
+	}()
+	return m
+}
+
+func (m *multipartReader) Read(p []byte) (int, error) {
+	for len(m.current) == 0 {
+		if m.err != nil {
+			return 0, m.err
+		}
+		res, ok := <-m.results
+		if !ok {
+			m.err = io.EOF
+			continue
+		}
+		r := <-res
+		if r.err != nil {
+			m.err = errors.Wrap(r.err, "download range")
+			continue
+		}
+		m.current = r.data
+	}
+	n := copy(p, m.current)
+	m.current = m.current[n:]
+	return n, nil
+}
+
+// Close stops running downloads
+func (m *multipartReader) Close() error {
+	m.cancel()
+	for range m.results {
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestMultipartReader(t *testing.T) {
+	type testCase struct {
+		name  string
+		size  int64
+		parts int
+	}
+	cases := []testCase{
+		{name: "exact chunks", size: 4 * multipartChunkSize, parts: 3},
+		{name: "partial last chunk", size: 3*multipartChunkSize + 123, parts: 2},
+		{name: "more parts than chunks", size: 2 * multipartChunkSize, parts: 8},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := make([]byte, c.size)
+			for i := range data {
+				data[i] = byte(i % 251)
+			}
+			fetch := func(ctx context.Context, offset, length int64) ([]byte, error) {
+				return data[offset : offset+length], nil
+			}
+			r := newMultipartReader(context.Background(), c.size, c.parts, data[:multipartChunkSize], fetch)
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("expect %d bytes in order, got %d bytes", len(data), len(got))
+			}
+		})
+	}
+
+	t.Run("range error", func(t *testing.T) {
+		size := int64(3 * multipartChunkSize)
+		first := make([]byte, multipartChunkSize)
+		fetch := func(ctx context.Context, offset, length int64) ([]byte, error) {
+			if offset == 2*multipartChunkSize {
+				return nil, errors.New("connection reset")
+			}
+			return make([]byte, length), nil
+		}
+		r := newMultipartReader(context.Background(), size, 2, first, fetch)
+		defer r.Close()
+		n, err := io.Copy(io.Discard, r)
+		if err == nil {
+			t.Errorf("expected error")
+		}
+		if n != 2*multipartChunkSize {
+			t.Errorf("expect %d bytes before error, got %d", 2*multipartChunkSize, n)
+		}
+	})
+}
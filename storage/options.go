@@ -22,6 +22,14 @@ type S3Options struct {
 	Prefix          string
 	Region          string
 	VerifyTLS       bool
+	SessionToken    string // optional session token for temporary credentials
+	RoleARN         string // optional role to assume via STS
+	STSEndpoint     string // optional STS endpoint, AWS STS is used by default
+	ForcePathStyle  *bool  // path-style addressing, if nil it's used for all endpoints except AWS
+	DownloadParts   int    // concurrent ranged requests per large object, single stream if less than 2
+	CACert          string // optional CA certificate bundle, PEM content or a path to it
+	SSE             string // server-side encryption of written objects: AES256 (SSE-S3) or aws:kms (SSE-KMS)
+	SSEKMSKeyID     string // optional KMS key of SSE-KMS, the bucket default key is used if empty
 }
 
 func (o *S3Options) Type() BackupStorageType {
@@ -33,11 +41,25 @@ var _ = Options(new(AzureOptions))
 type AzureOptions struct {
 	StorageAccount string
 	AccessKey      string
+	SASToken       string
 	Endpoint       string
 	Container      string
 	Prefix         string
+
+	UseManagedIdentity bool // authenticate with DefaultAzureCredential if AccessKey is empty
 }
 
 func (o *AzureOptions) Type() BackupStorageType {
 	return BackupStorageAzure
 }
+
+var _ = Options(new(FilesystemOptions))
+
+type FilesystemOptions struct {
+	Path   string // root directory of the objects
+	Prefix string
+}
+
+func (o *FilesystemOptions) Type() BackupStorageType {
+	return BackupStorageFilesystem
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimited is a Storage which limits the aggregate download rate
+// of all objects returned by GetObject
+type RateLimited struct {
+	Storage
+	limiter *rateLimiter
+}
+
+// NewRateLimited wraps s so reads from objects returned by GetObject
+// don't exceed bytesPerSec in total. s is returned as is if bytesPerSec is not positive.
+func NewRateLimited(s Storage, bytesPerSec int64) Storage {
+	if bytesPerSec <= 0 {
+		return s
+	}
+	return &RateLimited{
+		Storage: s,
+		limiter: newRateLimiter(bytesPerSec),
+	}
+}
+
+// GetObject return content by given object name limited by download rate
+func (r *RateLimited) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	obj, err := r.Storage.GetObject(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{ctx: ctx, r: obj, limiter: r.limiter}, nil
+}
+
+// rateLimiter is a token bucket shared between readers
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens (bytes) per second
+	burst  float64 // maximum number of tokens
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens and blocks until they are available.
+// Tokens are reserved immediately, so concurrent callers wait in turn.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.ReadCloser
+	limiter *rateLimiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if max := int(l.limiter.burst); len(p) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.limiter.wait(l.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	return l.r.Close()
+}
@@ -3,25 +3,42 @@ package storage
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"path"
+	"os"
 	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/pkg/errors"
 )
 
 var ErrObjectNotFound = errors.New("object not found")
 
+// ObjectInfo is a metadata of stored object
+type ObjectInfo struct {
+	Name      string
+	Size      int64
+	VersionID string // empty if the bucket isn't versioned
+}
+
 type Storage interface {
 	GetObject(ctx context.Context, objectName string) (io.ReadCloser, error)
+	StatObject(ctx context.Context, objectName string) (ObjectInfo, error)
+	// PutObject stores data as the object, an existing object is replaced.
+	// size may be -1 if it's unknown.
 	PutObject(ctx context.Context, name string, data io.Reader, size int64) error
+	// ListObjects returns names of objects with the prefix in lexicographical order.
+	// If the listing fails midway, the names listed so far are returned with the error.
 	ListObjects(ctx context.Context, prefix string) ([]string, error)
 	DeleteObject(ctx context.Context, objectName string) error
 	SetPrefix(prefix string)
@@ -37,26 +54,48 @@ func NewClient(ctx context.Context, opts Options) (Storage, error) {
 		if !ok {
 			return nil, errors.New("invalid options type")
 		}
-		return NewS3(ctx, opts.Endpoint, opts.AccessKeyID, opts.SecretAccessKey, opts.BucketName, opts.Prefix, opts.Region, opts.VerifyTLS)
+		return NewS3WithOptions(ctx, opts)
 	case BackupStorageAzure:
 		opts, ok := opts.(*AzureOptions)
 		if !ok {
 			return nil, errors.New("invalid options type")
 		}
-		return NewAzure(opts.StorageAccount, opts.AccessKey, opts.Endpoint, opts.Container, opts.Prefix)
+		return NewAzureWithOptions(ctx, opts)
+	case BackupStorageFilesystem:
+		opts, ok := opts.(*FilesystemOptions)
+		if !ok {
+			return nil, errors.New("invalid options type")
+		}
+		return NewFilesystemWithOptions(opts)
 	}
 	return nil, errors.New("invalid storage type")
 }
 
 // S3 is a type for working with S3 storages
 type S3 struct {
-	client     *minio.Client // minio client for work with storage
-	bucketName string        // S3 bucket name where binlogs will be stored
-	prefix     string        // prefix for S3 requests
+	client     *minio.Client      // minio client for work with storage
+	bucketName string             // S3 bucket name where binlogs will be stored
+	prefix     string             // prefix for S3 requests
+	parts      int                // number of concurrent ranged requests for large objects
+	sse        encrypt.ServerSide // server-side encryption of written objects, nil if not configured
 }
 
 // NewS3 return new Manager, useSSL using ssl for connection with storage
 func NewS3(ctx context.Context, endpoint, accessKeyID, secretAccessKey, bucketName, prefix, region string, verifyTLS bool) (Storage, error) {
+	return NewS3WithOptions(ctx, &S3Options{
+		Endpoint:        endpoint,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		BucketName:      bucketName,
+		Prefix:          prefix,
+		Region:          region,
+		VerifyTLS:       verifyTLS,
+	})
+}
+
+// NewS3WithOptions return new Manager configured with the given options
+func NewS3WithOptions(ctx context.Context, opts *S3Options) (Storage, error) {
+	endpoint, region, bucketName, prefix := opts.Endpoint, opts.Region, opts.BucketName, opts.Prefix
 	if endpoint == "" {
 		endpoint = "https://s3.amazonaws.com"
 		// We can't use default endpoint if region is not us-east-1
@@ -67,15 +106,32 @@ func NewS3(ctx context.Context, endpoint, accessKeyID, secretAccessKey, bucketNa
 	}
 	useSSL := strings.Contains(endpoint, "https")
 	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
-	transport := http.DefaultTransport
-	transport.(*http.Transport).TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: !verifyTLS,
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: !opts.VerifyTLS,
+	}
+	if opts.CACert != "" {
+		pool, err := caCertPool(opts.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "load CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	sse, err := serverSideEncryption(opts.SSE, opts.SSEKMSKeyID)
+	if err != nil {
+		return nil, errors.Wrap(err, "server-side encryption")
+	}
+	creds, err := s3Credentials(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "get credentials")
 	}
 	minioClient, err := minio.New(strings.TrimRight(endpoint, "/"), &minio.Options{
-		Creds:     credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
-		Secure:    useSSL,
-		Region:    region,
-		Transport: transport,
+		Creds:        creds,
+		Secure:       useSSL,
+		Region:       region,
+		Transport:    transport,
+		BucketLookup: bucketLookup(endpoint, opts.ForcePathStyle),
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "new minio client")
@@ -95,14 +151,110 @@ func NewS3(ctx context.Context, endpoint, accessKeyID, secretAccessKey, bucketNa
 	return &S3{
 		client:     minioClient,
 		bucketName: bucketName,
-		prefix:     prefix,
+		prefix:     normalizePrefix(prefix),
+		parts:      opts.DownloadParts,
+		sse:        sse,
 	}, nil
 }
 
-// GetObject return content by given object name
+// serverSideEncryption returns the encryption applied to written objects.
+// SSE-KMS is used if only the KMS key is set, nil is returned if neither is set.
+func serverSideEncryption(sse, kmsKeyID string) (encrypt.ServerSide, error) {
+	switch sse {
+	case "":
+		if kmsKeyID == "" {
+			return nil, nil
+		}
+		return encrypt.NewSSEKMS(kmsKeyID, nil)
+	case "AES256":
+		if kmsKeyID != "" {
+			return nil, errors.New("KMS key is set but encryption is AES256")
+		}
+		return encrypt.NewSSE(), nil
+	case "aws:kms":
+		return encrypt.NewSSEKMS(kmsKeyID, nil)
+	}
+	return nil, errors.Errorf("unknown encryption %s, expect AES256 or aws:kms", sse)
+}
+
+// caCertPool returns the system cert pool with the given CA certificates added.
+// caCert is either PEM content or a path to a PEM file.
+func caCertPool(caCert string) (*x509.CertPool, error) {
+	pem := []byte(caCert)
+	if !strings.Contains(caCert, "-----BEGIN") {
+		var err error
+		pem, err = os.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", caCert)
+		}
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no valid certificates found")
+	}
+	return pool, nil
+}
+
+// bucketLookup returns path-style lookup if it's forced or if the endpoint
+// is not AWS (e.g. MinIO or Ceph) and the style is not set explicitly
+func bucketLookup(endpoint string, forcePathStyle *bool) minio.BucketLookupType {
+	pathStyle := !strings.HasSuffix(strings.TrimRight(endpoint, "/"), "amazonaws.com")
+	if forcePathStyle != nil {
+		pathStyle = *forcePathStyle
+	}
+	if pathStyle {
+		return minio.BucketLookupPath
+	}
+	return minio.BucketLookupDNS
+}
+
+// s3Credentials returns static credentials, optionally with a session token,
+// or temporary credentials obtained through STS AssumeRole if the role ARN is set
+func s3Credentials(opts *S3Options) (*credentials.Credentials, error) {
+	if opts.RoleARN == "" {
+		return credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken), nil
+	}
+
+	stsEndpoint := opts.STSEndpoint
+	if stsEndpoint == "" {
+		stsEndpoint = "https://sts.amazonaws.com"
+		if opts.Region != "" && opts.Region != "us-east-1" {
+			stsEndpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", opts.Region)
+		}
+	}
+	creds, err := credentials.NewSTSAssumeRole(stsEndpoint, credentials.STSAssumeRoleOptions{
+		AccessKey:       opts.AccessKeyID,
+		SecretKey:       opts.SecretAccessKey,
+		SessionToken:    opts.SessionToken,
+		Location:        opts.Region,
+		RoleARN:         opts.RoleARN,
+		RoleSessionName: "mysql-pitr-helper",
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "assume role %s", opts.RoleARN)
+	}
+
+	return creds, nil
+}
+
+// GetObject return content by given object name.
+// Large objects are downloaded in concurrent ranged requests if download parts are configured.
 func (s *S3) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
-	objPath := path.Join(s.prefix, objectName)
-	oldObj, err := s.client.GetObject(ctx, s.bucketName, objPath, minio.GetObjectOptions{})
+	objPath := objectKey(s.prefix, objectName)
+	if n := headFrom(ctx); n > 0 {
+		return s.getObjectHead(ctx, objPath, n)
+	}
+	if s.parts > 1 {
+		obj, err := s.getObjectMultipart(ctx, objPath)
+		if err != nil || obj != nil {
+			return obj, err
+		}
+	}
+
+	oldObj, err := s.client.GetObject(ctx, s.bucketName, objPath, minio.GetObjectOptions{VersionID: versionIDFrom(ctx)})
 	if err != nil {
 		return nil, errors.Wrapf(err, "get object %s", objPath)
 	}
@@ -110,7 +262,7 @@ func (s *S3) GetObject(ctx context.Context, objectName string) (io.ReadCloser, e
 	// minio client returns error only on Read() method, so we need to call it to see if object exists
 	_, err = oldObj.Read([]byte{})
 	if err != nil {
-		if minio.ToErrorResponse(errors.Cause(err)).Code == "NoSuchKey" {
+		if isNotFound(err) {
 			return nil, ErrObjectNotFound
 		}
 		return nil, errors.Wrapf(err, "read object %s", objPath)
@@ -124,10 +276,98 @@ func (s *S3) GetObject(ctx context.Context, objectName string) (io.ReadCloser, e
 	return oldObj, nil
 }
 
+// getObjectHead returns the first n bytes of the object requested with a ranged GET
+func (s *S3) getObjectHead(ctx context.Context, objPath string, n int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{VersionID: versionIDFrom(ctx)}
+	if err := opts.SetRange(0, n-1); err != nil {
+		return nil, errors.Wrap(err, "set range")
+	}
+	obj, err := s.client.GetObject(ctx, s.bucketName, objPath, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get object %s", objPath)
+	}
+	// the request is sent on the first Read(), it isn't repeated with Seek() like the whole object
+	_, err = obj.Read([]byte{})
+	if err != nil {
+		obj.Close()
+		if isNotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, errors.Wrapf(err, "read object %s", objPath)
+	}
+	return obj, nil
+}
+
+// getObjectMultipart returns nil reader if the object is too small
+// for multipart download or the server ignores the requested range
+func (s *S3) getObjectMultipart(ctx context.Context, objPath string) (io.ReadCloser, error) {
+	info, err := s.client.StatObject(ctx, s.bucketName, objPath, minio.StatObjectOptions{VersionID: versionIDFrom(ctx)})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, errors.Wrapf(err, "stat object %s", objPath)
+	}
+	if info.Size < 2*multipartChunkSize {
+		return nil, nil
+	}
+
+	fetch := func(ctx context.Context, offset, length int64) ([]byte, error) {
+		opts := minio.GetObjectOptions{}
+		// the same version is requested so the object doesn't change between the ranges
+		opts.VersionID = info.VersionID
+		if err := opts.SetRange(offset, offset+length-1); err != nil {
+			return nil, errors.Wrap(err, "set range")
+		}
+		obj, err := s.client.GetObject(ctx, s.bucketName, objPath, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get object %s", objPath)
+		}
+		defer obj.Close()
+		// read one more byte to detect a server which returns the whole object
+		data, err := io.ReadAll(io.LimitReader(obj, length+1))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read object %s", objPath)
+		}
+		return data, nil
+	}
+
+	first, err := fetch(ctx, 0, multipartChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(first) != multipartChunkSize {
+		log.Printf("range requests are not supported for %s, using single stream download", objPath)
+		return nil, nil
+	}
+
+	return newMultipartReader(ctx, info.Size, s.parts, first, fetch), nil
+}
+
+// StatObject returns metadata of the object with given name
+func (s *S3) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	objPath := objectKey(s.prefix, objectName)
+	info, err := s.client.StatObject(ctx, s.bucketName, objPath, minio.StatObjectOptions{VersionID: versionIDFrom(ctx)})
+	if err != nil {
+		if isNotFound(err) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, errors.Wrapf(err, "stat object %s", objPath)
+	}
+
+	return ObjectInfo{Name: objectName, Size: info.Size, VersionID: normalizeVersionID(info.VersionID)}, nil
+}
+
+// isNotFound returns true if the object or its requested version doesn't exist
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(errors.Cause(err)).Code
+	return code == "NoSuchKey" || code == "NoSuchVersion"
+}
+
 // PutObject puts new object to storage with given name and content
 func (s *S3) PutObject(ctx context.Context, name string, data io.Reader, size int64) error {
-	objPath := path.Join(s.prefix, name)
-	_, err := s.client.PutObject(ctx, s.bucketName, objPath, data, size, minio.PutObjectOptions{})
+	objPath := objectKey(s.prefix, name)
+	_, err := s.client.PutObject(ctx, s.bucketName, objPath, data, size, minio.PutObjectOptions{ServerSideEncryption: s.sse})
 	if err != nil {
 		return errors.Wrapf(err, "put object %s", objPath)
 	}
@@ -139,7 +379,11 @@ func (s *S3) ListObjects(ctx context.Context, prefix string) ([]string, error) {
 	opts := minio.ListObjectsOptions{
 		UseV1:     true,
 		Recursive: true,
-		Prefix:    s.prefix + prefix,
+		Prefix:    objectKey(s.prefix, prefix),
+	}
+	if startAfter := startAfterFrom(ctx); startAfter != "" {
+		// it's sent as the marker of V1 listing
+		opts.StartAfter = objectKey(s.prefix, startAfter)
 	}
 	list := []string{}
 
@@ -154,18 +398,19 @@ func (s *S3) ListObjects(ctx context.Context, prefix string) ([]string, error) {
 		}
 		if object.Err != nil {
 			err = errors.Wrapf(object.Err, "list object %s", object.Key)
+			continue
 		}
 		list = append(list, strings.TrimPrefix(object.Key, s.prefix))
 	}
 	if err != nil {
-		return nil, err
+		return list, err
 	}
 
 	return list, nil
 }
 
 func (s *S3) SetPrefix(prefix string) {
-	s.prefix = prefix
+	s.prefix = normalizePrefix(prefix)
 }
 
 func (s *S3) GetPrefix() string {
@@ -173,7 +418,7 @@ func (s *S3) GetPrefix() string {
 }
 
 func (s *S3) DeleteObject(ctx context.Context, objectName string) error {
-	objPath := path.Join(s.prefix, objectName)
+	objPath := objectKey(s.prefix, objectName)
 	err := s.client.RemoveObject(ctx, s.bucketName, objPath, minio.RemoveObjectOptions{})
 	if err != nil {
 		if minio.ToErrorResponse(errors.Cause(err)).Code == "NoSuchKey" {
@@ -184,6 +429,9 @@ func (s *S3) DeleteObject(ctx context.Context, objectName string) error {
 	return nil
 }
 
+// azureStorageScope is the scope of tokens for Azure Blob storage requests
+const azureStorageScope = "https://storage.azure.com/.default"
+
 // Azure is a type for working with Azure Blob storages
 type Azure struct {
 	client    *azblob.Client // azure client for work with storage
@@ -191,29 +439,80 @@ type Azure struct {
 	prefix    string
 }
 
-func NewAzure(storageAccount, accessKey, endpoint, container, prefix string) (Storage, error) {
-	credential, err := azblob.NewSharedKeyCredential(storageAccount, accessKey)
-	if err != nil {
-		return nil, errors.Wrap(err, "new credentials")
+// NewAzure return new Azure Blob storage. The client is authenticated with the SAS token
+// if the access key is empty, otherwise the shared key credential is used.
+func NewAzure(ctx context.Context, storageAccount, accessKey, sasToken, endpoint, container, prefix string) (Storage, error) {
+	return NewAzureWithOptions(ctx, &AzureOptions{
+		StorageAccount: storageAccount,
+		AccessKey:      accessKey,
+		SASToken:       sasToken,
+		Endpoint:       endpoint,
+		Container:      container,
+		Prefix:         prefix,
+	})
+}
+
+// NewAzureWithOptions return new Azure Blob storage configured with the given options.
+// If the access key is empty, the client is authenticated with the SAS token
+// or, if UseManagedIdentity is set, with DefaultAzureCredential (managed or workload identity).
+// Otherwise the shared key credential is used.
+// The managed identity token is fetched with ctx, so a slow token endpoint doesn't outlive it.
+func NewAzureWithOptions(ctx context.Context, opts *AzureOptions) (Storage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "new azure storage")
 	}
+	endpoint, container, prefix := opts.Endpoint, opts.Container, opts.Prefix
 	if endpoint == "" {
-		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", storageAccount)
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", opts.StorageAccount)
 	}
-	cli, err := azblob.NewClientWithSharedKeyCredential(endpoint, credential, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "new client")
+
+	var cli *azblob.Client
+	var err error
+	switch {
+	case opts.AccessKey == "" && opts.SASToken != "":
+		sasURL := strings.TrimRight(endpoint, "?") + "?" + strings.TrimPrefix(opts.SASToken, "?")
+		cli, err = azblob.NewClientWithNoCredential(sasURL, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "new client with sas token")
+		}
+	case opts.AccessKey == "" && opts.UseManagedIdentity:
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "new default azure credential")
+		}
+		_, err = credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureStorageScope}})
+		if err != nil {
+			return nil, errors.Wrap(err, "get token of default azure credential")
+		}
+		cli, err = azblob.NewClient(endpoint, credential, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "new client with default azure credential")
+		}
+	default:
+		credential, err := azblob.NewSharedKeyCredential(opts.StorageAccount, opts.AccessKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "new credentials")
+		}
+		cli, err = azblob.NewClientWithSharedKeyCredential(endpoint, credential, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "new client")
+		}
 	}
 
 	return &Azure{
 		client:    cli,
 		container: container,
-		prefix:    prefix,
+		prefix:    normalizePrefix(prefix),
 	}, nil
 }
 
 func (a *Azure) GetObject(ctx context.Context, name string) (io.ReadCloser, error) {
-	objPath := path.Join(a.prefix, name)
-	resp, err := a.client.DownloadStream(ctx, a.container, objPath, &azblob.DownloadStreamOptions{})
+	objPath := objectKey(a.prefix, name)
+	opts := &azblob.DownloadStreamOptions{}
+	if n := headFrom(ctx); n > 0 {
+		opts.Range = azblob.HTTPRange{Count: n}
+	}
+	resp, err := a.client.DownloadStream(ctx, a.container, objPath, opts)
 	if err != nil {
 		if bloberror.HasCode(errors.Cause(err), bloberror.BlobNotFound) {
 			return nil, ErrObjectNotFound
@@ -223,8 +522,25 @@ func (a *Azure) GetObject(ctx context.Context, name string) (io.ReadCloser, erro
 	return resp.Body, nil
 }
 
+func (a *Azure) StatObject(ctx context.Context, name string) (ObjectInfo, error) {
+	objPath := objectKey(a.prefix, name)
+	blobClient := a.client.ServiceClient().NewContainerClient(a.container).NewBlobClient(objPath)
+	resp, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(errors.Cause(err), bloberror.BlobNotFound) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, errors.Wrapf(err, "get properties: %s", objPath)
+	}
+	info := ObjectInfo{Name: name}
+	if resp.ContentLength != nil {
+		info.Size = *resp.ContentLength
+	}
+	return info, nil
+}
+
 func (a *Azure) PutObject(ctx context.Context, name string, data io.Reader, _ int64) error {
-	objPath := path.Join(a.prefix, name)
+	objPath := objectKey(a.prefix, name)
 	_, err := a.client.UploadStream(ctx, a.container, objPath, data, nil)
 	if err != nil {
 		return errors.Wrapf(err, "upload stream: %s", objPath)
@@ -233,20 +549,25 @@ func (a *Azure) PutObject(ctx context.Context, name string, data io.Reader, _ in
 }
 
 func (a *Azure) ListObjects(ctx context.Context, prefix string) ([]string, error) {
-	listPrefix := path.Join(a.prefix, prefix)
+	listPrefix := objectKey(a.prefix, prefix)
 	pg := a.client.NewListBlobsFlatPager(a.container, &container.ListBlobsFlatOptions{
 		Prefix: &listPrefix,
 	})
+	// the pager marker is opaque, so the listing is resumed by skipping names
+	startAfter := startAfterFrom(ctx)
 	var blobs []string
 	for pg.More() {
 		resp, err := pg.NextPage(ctx)
 		if err != nil {
-			return nil, errors.Wrapf(err, "next page: %s", prefix)
+			return blobs, errors.Wrapf(err, "next page: %s", prefix)
 		}
 		if resp.Segment != nil {
 			for _, item := range resp.Segment.BlobItems {
 				if item != nil && item.Name != nil {
 					name := strings.TrimPrefix(*item.Name, a.prefix)
+					if name <= startAfter {
+						continue
+					}
 					blobs = append(blobs, name)
 				}
 			}
@@ -256,7 +577,7 @@ func (a *Azure) ListObjects(ctx context.Context, prefix string) ([]string, error
 }
 
 func (a *Azure) SetPrefix(prefix string) {
-	a.prefix = prefix
+	a.prefix = normalizePrefix(prefix)
 }
 
 func (a *Azure) GetPrefix() string {
@@ -264,7 +585,7 @@ func (a *Azure) GetPrefix() string {
 }
 
 func (a *Azure) DeleteObject(ctx context.Context, objectName string) error {
-	objPath := path.Join(a.prefix, objectName)
+	objPath := objectKey(a.prefix, objectName)
 	_, err := a.client.DeleteBlob(ctx, a.container, objPath, nil)
 	if err != nil {
 		if bloberror.HasCode(errors.Cause(err), bloberror.BlobNotFound) {
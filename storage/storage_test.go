@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestS3PutObjectEncryption(t *testing.T) {
+	type testCase struct {
+		sse      string
+		kmsKeyID string
+		expected map[string]string
+	}
+	tests := map[string]testCase{
+		"none": {
+			expected: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "",
+			},
+		},
+		"sse-s3": {
+			sse: "AES256",
+			expected: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "AES256",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "",
+			},
+		},
+		"sse-kms": {
+			sse:      "aws:kms",
+			kmsKeyID: "key-1",
+			expected: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "key-1",
+			},
+		},
+		"kms key only": {
+			kmsKeyID: "key-1",
+			expected: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "key-1",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var put http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Method == http.MethodPut {
+					io.Copy(io.Discard, req.Body)
+					mu.Lock()
+					put = req.Header.Clone()
+					mu.Unlock()
+					w.Header().Set("ETag", `"etag"`)
+				}
+			}))
+			defer srv.Close()
+
+			ctx := context.Background()
+			forcePathStyle := true
+			s, err := NewS3WithOptions(ctx, &S3Options{
+				Endpoint:        srv.URL,
+				AccessKeyID:     "key",
+				SecretAccessKey: "secret",
+				BucketName:      "operator-testing",
+				Region:          "us-east-1",
+				ForcePathStyle:  &forcePathStyle,
+				SSE:             tc.sse,
+				SSEKMSKeyID:     tc.kmsKeyID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.PutObject(ctx, "checkpoint", strings.NewReader("data"), 4); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if put == nil {
+				t.Fatal("no PUT request")
+			}
+			for header, want := range tc.expected {
+				if got := put.Get(header); got != want {
+					t.Errorf("%s: expect '%s', got '%s'", header, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestS3InvalidEncryption(t *testing.T) {
+	for _, opts := range []S3Options{
+		{SSE: "aes"},
+		{SSE: "AES256", SSEKMSKeyID: "key-1"},
+	} {
+		if _, err := serverSideEncryption(opts.SSE, opts.SSEKMSKeyID); err == nil {
+			t.Errorf("expect error for SSE '%s' and KMS key '%s'", opts.SSE, opts.SSEKMSKeyID)
+		}
+	}
+}
+
+func TestS3GetObjectHead(t *testing.T) {
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || req.URL.Path == "/operator-testing/" {
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, req.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("Content-Range", "bytes 0-3/1048576")
+		w.Header().Set("Content-Length", "4")
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", "Tue, 14 Nov 2023 22:13:20 GMT")
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, "\xfebin")
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	forcePathStyle := true
+	s, err := NewS3WithOptions(ctx, &S3Options{
+		Endpoint:        srv.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		BucketName:      "operator-testing",
+		Region:          "us-east-1",
+		ForcePathStyle:  &forcePathStyle,
+		DownloadParts:   4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.GetObject(WithHead(ctx, 4), "binlog_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "\xfebin" {
+		t.Errorf("expect the binlog header, got %q", data)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 1 || ranges[0] != "bytes=0-3" {
+		t.Errorf("expect one request of bytes=0-3, got %q", ranges)
+	}
+}
@@ -0,0 +1,26 @@
+package storage
+
+import "context"
+
+type versionIDKey struct{}
+
+// WithVersionID returns a context which makes GetObject and StatObject return
+// the version of the object instead of the latest one.
+// Storages without versioning ignore it, so does an empty versionID.
+func WithVersionID(ctx context.Context, versionID string) context.Context {
+	return context.WithValue(ctx, versionIDKey{}, versionID)
+}
+
+func versionIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(versionIDKey{}).(string)
+	return id
+}
+
+// normalizeVersionID returns empty string for objects in a non-versioned bucket.
+// S3 reports "null" version id if versioning is suspended or was never enabled.
+func normalizeVersionID(id string) string {
+	if id == "null" {
+		return ""
+	}
+	return id
+}
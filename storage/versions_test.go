@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVersionedS3 serves objects of a path-style bucket with versioning enabled,
+// versions of each object are ordered from the oldest to the latest
+func fakeVersionedS3(t *testing.T, bucket string, objects map[string][][2]string) *httptest.Server {
+	lastModified := time.Unix(1700000000, 0).UTC()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/"+bucket), "/")
+		if key == "" {
+			// bucket lookup
+			return
+		}
+		versions := objects[key]
+		id := req.URL.Query().Get("versionId")
+		var found *[2]string
+		for i := range versions {
+			if id == "" && i == len(versions)-1 || versions[i][0] == id {
+				found = &versions[i]
+			}
+		}
+		if found == nil {
+			code := "NoSuchKey"
+			if id != "" {
+				code = "NoSuchVersion"
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>`+code+`</Code><Message>not found</Message><Key>`+key+`</Key></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(found[1])))
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("x-amz-version-id", found[0])
+		if req.Method != http.MethodHead {
+			io.WriteString(w, found[1])
+		}
+	}))
+}
+
+func TestS3Versions(t *testing.T) {
+	srv := fakeVersionedS3(t, "operator-testing", map[string][][2]string{
+		"binlog_1700000001_a":          {{"v1", "first upload"}, {"v2", "second upload"}},
+		"binlog_1700000001_a-gtid-set": {{"v3", "gtid set"}},
+	})
+	defer srv.Close()
+
+	ctx := context.Background()
+	forcePathStyle := true
+	s, err := NewS3WithOptions(ctx, &S3Options{
+		Endpoint:        srv.URL,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		BucketName:      "operator-testing",
+		Region:          "us-east-1",
+		ForcePathStyle:  &forcePathStyle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name      string
+		versionID string
+		expected  string
+	}
+	cases := []testCase{
+		{name: "latest", expected: "second upload"},
+		{name: "recorded version", versionID: "v1", expected: "first upload"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vctx := WithVersionID(ctx, c.versionID)
+			obj, err := s.GetObject(vctx, "binlog_1700000001_a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(obj)
+			obj.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != c.expected {
+				t.Errorf("expect '%s', got '%s'", c.expected, data)
+			}
+			info, err := s.StatObject(vctx, "binlog_1700000001_a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size != int64(len(c.expected)) || c.versionID != "" && info.VersionID != c.versionID {
+				t.Errorf("expect size %d and version '%s', got %d and '%s'", len(c.expected), c.versionID, info.Size, info.VersionID)
+			}
+		})
+	}
+
+	if _, err := s.GetObject(WithVersionID(ctx, "v9"), "binlog_1700000001_a"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("expect ErrObjectNotFound for unknown version, got %v", err)
+	}
+}